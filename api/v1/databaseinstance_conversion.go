@@ -0,0 +1,358 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+const (
+	// V2SpecAnnotation 保存转换为 v1 时无法用 v1 表示的完整 v2 spec（例如 networking、结构化的备份保留策略），
+	// v1 客户端读取后再写回时，据此恢复 v1 中不存在的字段
+	V2SpecAnnotation = "apps.leqiutong.xyz/v2-spec"
+
+	// V1SpecAnnotation 保存转换为 v2 时无法用 v2 表示的 v1 字段原值（无法解析的资源数量和 backupPolicy.retention），
+	// 转换回 v1 时据此恢复，保证 v1 客户端读到的仍是自己写入的值
+	V1SpecAnnotation = "apps.leqiutong.xyz/v1-spec"
+)
+
+// UnconvertibleFields 是 V1SpecAnnotation 中保存的内容，只记录转换为 v2 时丢失的 v1 字段
+type UnconvertibleFields struct {
+	Storage   string    `json:"storage,omitempty"`
+	Resources Resources `json:"resources,omitempty"`
+	Retention string    `json:"retention,omitempty"`
+}
+
+// ConvertTo 将 v1 的 DatabaseInstance 转换为中心版本 v2
+func (src *DatabaseInstance) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v2.DatabaseInstance)
+	if !ok {
+		return fmt.Errorf("expected a v2 DatabaseInstance but got %T", dstRaw)
+	}
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	// 以上一次转换时保存的 v2 spec 为基础，再覆盖 v1 能够表示的字段
+	if raw, ok := dst.Annotations[V2SpecAnnotation]; ok {
+		if err := json.Unmarshal([]byte(raw), &dst.Spec); err != nil {
+			return fmt.Errorf("decode annotation %s: %w", V2SpecAnnotation, err)
+		}
+		delete(dst.Annotations, V2SpecAnnotation)
+	}
+
+	var lost UnconvertibleFields
+	spec := &dst.Spec
+	spec.Engine.Type = v2.EngineType(src.Spec.DatabaseType)
+	spec.Engine.Version = src.Spec.Version
+	spec.Engine.Image = src.Spec.Image
+
+	spec.Storage.Size = nil
+	if src.Spec.Storage != "" {
+		if size, err := resource.ParseQuantity(src.Spec.Storage); err == nil {
+			spec.Storage.Size = &size
+		} else {
+			lost.Storage = src.Spec.Storage
+		}
+	}
+	spec.Storage.StorageClassName = src.Spec.StorageClassName
+	spec.Storage.AccessModes = src.Spec.StorageAccessModes
+
+	spec.Resources.Requests = overlayResourceList(spec.Resources.Requests, src.Spec.Resources.Requests, &lost.Resources.Requests)
+	spec.Resources.Limits = overlayResourceList(spec.Resources.Limits, src.Spec.Resources.Limits, &lost.Resources.Limits)
+
+	spec.Credentials = convertCredentialsTo(src.Spec.Credentials)
+
+	spec.Backup.Enabled = src.Spec.BackupPolicy.Enabled
+	spec.Backup.Schedule = src.Spec.BackupPolicy.Schedule
+	spec.Backup.Image = src.Spec.BackupPolicy.BackupImage
+	switch retention, ok := retentionFromV1(src.Spec.BackupPolicy.Retention); {
+	case src.Spec.BackupPolicy.Retention == "":
+		// v1 中为空既可能是清除了保留策略，也可能是 v2 的保留策略无法用 v1 表示，只在后一种情况下保留
+		if retentionToV1(spec.Backup.Retention) != "" {
+			spec.Backup.Retention = nil
+		}
+	case ok:
+		spec.Backup.Retention = retention
+	default:
+		lost.Retention = src.Spec.BackupPolicy.Retention
+	}
+
+	spec.Topology.Replicas = src.Spec.Replicas
+	spec.DeletionPolicy = v2.DeletionPolicy(src.Spec.DeletionPolicy)
+
+	delete(dst.Annotations, V1SpecAnnotation)
+	if lost != (UnconvertibleFields{}) {
+		raw, err := json.Marshal(lost)
+		if err != nil {
+			return err
+		}
+		setAnnotation(&dst.ObjectMeta.Annotations, V1SpecAnnotation, string(raw))
+	}
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+
+	dst.Status = v2.DatabaseInstanceStatus{
+		Phase:              v2.DatabaseInstancePhase(src.Status.Phase),
+		Message:            src.Status.Message,
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Replicas:           src.Status.Replicas,
+		ReadyReplicas:      src.Status.ReadyReplicas,
+		LastUpdated:        src.Status.LastUpdated,
+		Conditions:         src.Status.Conditions,
+	}
+	if src.Status.Credentials != nil {
+		dst.Status.Credentials = &v2.CredentialsStatus{
+			LastRotated:          src.Status.Credentials.LastRotated,
+			OldPasswordExpiresAt: src.Status.Credentials.OldPasswordExpiresAt,
+		}
+	}
+	return nil
+}
+
+// ConvertFrom 将中心版本 v2 的 DatabaseInstance 转换为 v1
+func (dst *DatabaseInstance) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v2.DatabaseInstance)
+	if !ok {
+		return fmt.Errorf("expected a v2 DatabaseInstance but got %T", srcRaw)
+	}
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	lost, err := UnconvertibleFieldsOf(dst)
+	if err != nil {
+		return err
+	}
+	delete(dst.Annotations, V1SpecAnnotation)
+
+	spec := src.Spec
+	dst.Spec = DatabaseInstanceSpec{
+		DatabaseType:       string(spec.Engine.Type),
+		Version:            spec.Engine.Version,
+		Image:              spec.Engine.Image,
+		Storage:            lost.Storage,
+		StorageClassName:   spec.Storage.StorageClassName,
+		StorageAccessModes: spec.Storage.AccessModes,
+		Replicas:           spec.Topology.Replicas,
+		Resources: Resources{
+			Requests: resourceListFrom(spec.Resources.Requests, lost.Resources.Requests),
+			Limits:   resourceListFrom(spec.Resources.Limits, lost.Resources.Limits),
+		},
+		BackupPolicy: BackupPolicy{
+			Enabled:     spec.Backup.Enabled,
+			Schedule:    spec.Backup.Schedule,
+			Retention:   retentionToV1(spec.Backup.Retention),
+			BackupImage: spec.Backup.Image,
+		},
+		DeletionPolicy: DeletionPolicy(spec.DeletionPolicy),
+		Credentials:    convertCredentialsFrom(spec.Credentials),
+	}
+	if spec.Storage.Size != nil {
+		dst.Spec.Storage = spec.Storage.Size.String()
+	}
+	if lost.Retention != "" {
+		dst.Spec.BackupPolicy.Retention = lost.Retention
+	}
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	setAnnotation(&dst.ObjectMeta.Annotations, V2SpecAnnotation, string(raw))
+
+	dst.Status = DatabaseInstanceStatus{
+		Phase:              DatabaseInstancePhase(src.Status.Phase),
+		Message:            src.Status.Message,
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Replicas:           src.Status.Replicas,
+		ReadyReplicas:      src.Status.ReadyReplicas,
+		LastUpdated:        src.Status.LastUpdated,
+		Conditions:         src.Status.Conditions,
+	}
+	if src.Status.Credentials != nil {
+		dst.Status.Credentials = &CredentialsStatus{
+			LastRotated:          src.Status.Credentials.LastRotated,
+			OldPasswordExpiresAt: src.Status.Credentials.OldPasswordExpiresAt,
+		}
+	}
+	return nil
+}
+
+// UnconvertibleFieldsOf 返回 v1 对象转换为 v2 时丢失的字段，没有丢失任何字段时返回零值
+func UnconvertibleFieldsOf(obj metav1.Object) (UnconvertibleFields, error) {
+	var lost UnconvertibleFields
+	raw, ok := obj.GetAnnotations()[V1SpecAnnotation]
+	if !ok {
+		return lost, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &lost); err != nil {
+		return lost, fmt.Errorf("decode annotation %s: %w", V1SpecAnnotation, err)
+	}
+	return lost, nil
+}
+
+// overlayResourceList 用 v1 的内存、CPU 和临时存储覆盖 base 中的同名资源，保留 v1 无法表示的其他资源，
+// 无法解析的取值记录到 lost 中
+func overlayResourceList(base corev1.ResourceList, src ResourceRequests, lost *ResourceRequests) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	for name, quantity := range base {
+		list[name] = quantity
+	}
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceMemory:           src.Memory,
+		corev1.ResourceCPU:              src.CPU,
+		corev1.ResourceEphemeralStorage: src.EphemeralStorage,
+	} {
+		delete(list, name)
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			setResourceValue(lost, name, value)
+			continue
+		}
+		list[name] = quantity
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// resourceListFrom 将 v2 的资源列表转换为 v1 的字符串表示，v2 中不存在的资源使用 lost 中保存的原值
+func resourceListFrom(list corev1.ResourceList, lost ResourceRequests) ResourceRequests {
+	out := lost
+	for _, name := range []corev1.ResourceName{corev1.ResourceMemory, corev1.ResourceCPU, corev1.ResourceEphemeralStorage} {
+		if quantity, ok := list[name]; ok {
+			setResourceValue(&out, name, quantity.String())
+		}
+	}
+	return out
+}
+
+// setResourceValue 设置 v1 资源数量中与 name 对应的字段
+func setResourceValue(r *ResourceRequests, name corev1.ResourceName, value string) {
+	switch name {
+	case corev1.ResourceMemory:
+		r.Memory = value
+	case corev1.ResourceCPU:
+		r.CPU = value
+	case corev1.ResourceEphemeralStorage:
+		r.EphemeralStorage = value
+	}
+}
+
+// retentionFromV1 解析 v1 的 backupPolicy.retention：整数表示保留的备份数量，<N>d 或 Go 时长（例如 168h）表示最长保留时间
+func retentionFromV1(value string) (*v2.BackupRetention, bool) {
+	if keepLast, err := strconv.ParseInt(value, 10, 32); err == nil {
+		if keepLast < 1 {
+			return nil, false
+		}
+		n := int32(keepLast)
+		return &v2.BackupRetention{KeepLast: &n}, true
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return nil, false
+		}
+		return &v2.BackupRetention{MaxAge: &metav1.Duration{Duration: time.Duration(n) * 24 * time.Hour}}, true
+	}
+	maxAge, err := time.ParseDuration(value)
+	if err != nil || maxAge <= 0 {
+		return nil, false
+	}
+	return &v2.BackupRetention{MaxAge: &metav1.Duration{Duration: maxAge}}, true
+}
+
+// retentionToV1 返回 v2 保留策略在 v1 中的表示，只有单独设置的 keepLast 或 maxAge 可以表示，其他情况返回空字符串
+func retentionToV1(retention *v2.BackupRetention) string {
+	if retention == nil || retention.KeepDaily != nil || retention.KeepWeekly != nil || retention.KeepMonthly != nil {
+		return ""
+	}
+	switch {
+	case retention.KeepLast != nil && retention.MaxAge == nil:
+		return strconv.Itoa(int(*retention.KeepLast))
+	case retention.MaxAge != nil && retention.KeepLast == nil:
+		maxAge := retention.MaxAge.Duration
+		if maxAge%(24*time.Hour) == 0 {
+			return strconv.Itoa(int(maxAge/(24*time.Hour))) + "d"
+		}
+		return maxAge.String()
+	}
+	return ""
+}
+
+// convertCredentialsTo 将 v1 的凭据配置转换为 v2，两个版本的结构相同
+func convertCredentialsTo(src Credentials) v2.CredentialsSpec {
+	dst := v2.CredentialsSpec{PasswordLength: src.PasswordLength}
+	if src.ExistingSecretRef != nil {
+		dst.ExistingSecretRef = &v2.ExistingSecretRef{
+			Name:        src.ExistingSecretRef.Name,
+			UsernameKey: src.ExistingSecretRef.UsernameKey,
+			PasswordKey: src.ExistingSecretRef.PasswordKey,
+		}
+	}
+	if src.Rotation != nil {
+		dst.Rotation = &v2.CredentialRotation{
+			Interval:    src.Rotation.Interval,
+			Schedule:    src.Rotation.Schedule,
+			GracePeriod: src.Rotation.GracePeriod,
+		}
+	}
+	return dst
+}
+
+// convertCredentialsFrom 将 v2 的凭据配置转换为 v1
+func convertCredentialsFrom(src v2.CredentialsSpec) Credentials {
+	dst := Credentials{PasswordLength: src.PasswordLength}
+	if src.ExistingSecretRef != nil {
+		dst.ExistingSecretRef = &ExistingSecretRef{
+			Name:        src.ExistingSecretRef.Name,
+			UsernameKey: src.ExistingSecretRef.UsernameKey,
+			PasswordKey: src.ExistingSecretRef.PasswordKey,
+		}
+	}
+	if src.Rotation != nil {
+		dst.Rotation = &CredentialRotation{
+			Interval:    src.Rotation.Interval,
+			Schedule:    src.Rotation.Schedule,
+			GracePeriod: src.Rotation.GracePeriod,
+		}
+	}
+	return dst
+}
+
+// setAnnotation 设置注解，必要时初始化注解集合
+func setAnnotation(annotations *map[string]string, key, value string) {
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	(*annotations)[key] = value
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,16 +26,23 @@ import (
 
 // Resources 定义了数据库的资源请求和限制
 type Resources struct {
-	// Requests 定义了内存和 CPU 的资源请求
+	// Requests 定义了内存、CPU 和临时存储的资源请求
 	Requests ResourceRequests `json:"requests,omitempty"`
+
+	// Limits 定义了内存、CPU 和临时存储的资源限制，引擎的内存参数（如 innodb_buffer_pool_size、shared_buffers）按内存限制的比例推算
+	// +optional
+	Limits ResourceRequests `json:"limits,omitempty"`
 }
 
-// ResourceRequests 定义了数据库的内存和 CPU 请求
+// ResourceRequests 定义了数据库的内存、CPU 和临时存储的资源数量，取值为 Kubernetes 资源数量格式（例如 512Mi、500m）
 type ResourceRequests struct {
-	// Memory 表示内存请求
+	// Memory 表示内存数量
 	Memory string `json:"memory,omitempty"`
-	// CPU 表示 CPU 请求
+	// CPU 表示 CPU 数量
 	CPU string `json:"cpu,omitempty"`
+	// EphemeralStorage 表示临时存储数量
+	// +optional
+	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
 }
 
 // BackupPolicy 定义了备份策略配置
@@ -52,6 +60,69 @@ type BackupPolicy struct {
 	BackupImage string `json:"backupImage,omitempty"`
 }
 
+// Credentials 定义了实例凭据的管理方式
+type Credentials struct {
+	// ExistingSecretRef 引用用户自行创建的凭据 Secret，设置后 Operator 不再生成 <实例名>-secret，
+	// 也不会接管或删除被引用的 Secret（启用轮换时仍会把新密码写回该 Secret）
+	// +optional
+	ExistingSecretRef *ExistingSecretRef `json:"existingSecretRef,omitempty"`
+
+	// PasswordLength 表示 Operator 生成的密码长度，密码只包含大小写字母和数字，默认为 24
+	// +kubebuilder:validation:Minimum=12
+	// +kubebuilder:validation:Maximum=128
+	// +kubebuilder:default=24
+	// +optional
+	PasswordLength int32 `json:"passwordLength,omitempty"`
+
+	// Rotation 定义了管理员密码的定期轮换策略，未设置时不轮换
+	// +optional
+	Rotation *CredentialRotation `json:"rotation,omitempty"`
+}
+
+// ExistingSecretRef 引用同一命名空间下保存数据库凭据的 Secret
+type ExistingSecretRef struct {
+	// Name 是 Secret 的名称
+	Name string `json:"name"`
+
+	// UsernameKey 是用户名在 Secret 中的键名，未设置时使用数据库类型的默认键名（例如 mysql-user）
+	// 用户名是可选的，如果存在则必须是数据库的管理员用户（MySQL 为 root，PostgreSQL 为 postgres，OceanBase-CE 为 root@sys）
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+
+	// PasswordKey 是密码在 Secret 中的键名，未设置时使用数据库类型的默认键名（例如 mysql-password）
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// CredentialRotation 定义了管理员密码的定期轮换策略，interval 和 schedule 至少设置一个
+type CredentialRotation struct {
+	// Interval 表示两次轮换之间的间隔（例如 2160h 即 90 天），从上一次轮换（或实例创建）的时间开始计算
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Schedule 表示轮换的 cron 表达式（例如 "0 3 1 */3 *"），与 interval 同时设置时优先使用 schedule
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// GracePeriod 表示轮换后旧密码继续有效的时间，仅对支持双密码的引擎（MySQL）生效，默认为 1h
+	// +kubebuilder:default="1h"
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// DeletionPolicy 定义了删除 DatabaseInstance 时如何处理数据和备份
+// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete 删除实例的数据卷、凭据和备份
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain 保留数据卷、凭据和备份，并解除它们与实例的从属关系
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicySnapshot 先执行一次最终备份，成功后删除数据卷和凭据，保留备份
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
 // DatabaseInstanceSpec 定义了 DatabaseInstance 的期望状态
 type DatabaseInstanceSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - 定义集群的期望状态
@@ -63,9 +134,17 @@ type DatabaseInstanceSpec struct {
 	// Version 表示数据库的版本
 	Version string `json:"version,omitempty"`
 
-	// Storage 表示数据库的存储容量
+	// Storage 表示每个副本数据卷的存储容量（例如 10Gi），未设置时默认为 1Gi
 	Storage string `json:"storage,omitempty"`
 
+	// StorageClassName 表示数据卷使用的存储类，未设置时使用集群默认的存储类
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// StorageAccessModes 表示数据卷的访问模式，未设置时默认为 ReadWriteOnce
+	// +optional
+	StorageAccessModes []corev1.PersistentVolumeAccessMode `json:"storageAccessModes,omitempty"`
+
 	// Replicas 表示数据库副本的数量
 	Replicas int32 `json:"replicas,omitempty"`
 
@@ -77,48 +156,102 @@ type DatabaseInstanceSpec struct {
 
 	// Image 表示数据库的容器镜像，包括版本/标签
 	Image string `json:"image,omitempty"`
+
+	// DeletionPolicy 表示删除实例时如何处理数据和备份（Delete、Retain、Snapshot），默认为 Retain
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Credentials 定义了实例凭据的管理方式，例如管理员密码的定期轮换
+	// +optional
+	Credentials Credentials `json:"credentials,omitempty"`
+}
+
+// DatabaseInstancePhase 表示数据库实例所处的阶段
+type DatabaseInstancePhase string
+
+const (
+	// PhasePending 表示工作负载尚未创建，或副本尚未被调度
+	PhasePending DatabaseInstancePhase = "Pending"
+	// PhaseProvisioning 表示工作负载已创建，副本正在启动或滚动更新中
+	PhaseProvisioning DatabaseInstancePhase = "Provisioning"
+	// PhaseRunning 表示全部副本均已就绪且为最新版本
+	PhaseRunning DatabaseInstancePhase = "Running"
+	// PhaseDegraded 表示仍有副本可以提供服务，但部分副本异常
+	PhaseDegraded DatabaseInstancePhase = "Degraded"
+	// PhaseFailed 表示没有副本可以提供服务且存在无法自行恢复的错误，或 spec 配置无效
+	PhaseFailed DatabaseInstancePhase = "Failed"
+	// PhaseDeleting 表示实例正在按照 spec.deletionPolicy 执行删除流程
+	PhaseDeleting DatabaseInstancePhase = "Deleting"
+)
+
+// DatabaseInstance 的条件类型，遵循 metav1.Condition 的语义
+const (
+	// ConditionAvailable 表示至少有一个副本就绪，可以对外提供服务
+	ConditionAvailable = "Available"
+	// ConditionProgressing 表示实例正在创建、扩缩容或滚动更新
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded 表示实例存在异常的副本或无效的配置
+	ConditionDegraded = "Degraded"
+	// ConditionBackupHealthy 表示最近一次备份任务是否成功，仅在启用备份时设置
+	ConditionBackupHealthy = "BackupHealthy"
+	// ConditionTerminating 表示实例正在删除，Reason 为删除流程当前所处的步骤
+	ConditionTerminating = "Terminating"
+)
+
+// CredentialsStatus 记录实例凭据轮换的状态
+type CredentialsStatus struct {
+	// LastRotated 是最近一次成功轮换管理员密码的时间
+	// +optional
+	LastRotated *metav1.Time `json:"lastRotated,omitempty"`
+
+	// OldPasswordExpiresAt 是轮换前的旧密码被废弃的时间，为空表示没有仍然有效的旧密码
+	// +optional
+	OldPasswordExpiresAt *metav1.Time `json:"oldPasswordExpiresAt,omitempty"`
 }
 
 // DatabaseInstanceStatus 定义了 DatabaseInstance 资源被观察到的状态
 type DatabaseInstanceStatus struct {
-	// Phase 表示数据库实例当前所处的阶段（例如：Pending、Running、Failed）
-	Phase string `json:"phase,omitempty"`
+	// Phase 表示数据库实例当前所处的阶段（Pending、Provisioning、Running、Degraded、Failed）
+	Phase DatabaseInstancePhase `json:"phase,omitempty"`
 
 	// Message 表示相关状态的附加信息或错误消息
 	Message string `json:"message,omitempty"`
 
+	// ObservedGeneration 是计算本状态时所依据的 metadata.generation
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Replicas 表示期望的副本数量
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
 	// ReadyReplicas 表示当前已就绪的副本数量
 	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
 
-	// LastUpdated 是状态最后一次更新的时间戳
+	// LastUpdated 是状态最后一次发生变化的时间戳
 	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
 
-	// Conditions 记录数据库实例的条件或状态
-	Conditions []DatabaseInstanceCondition `json:"conditions,omitempty"`
-}
-
-// DatabaseInstanceCondition 表示数据库实例的特定方面的状态
-type DatabaseInstanceCondition struct {
-	// Type 条件的类型（例如：Ready、Available）
-	Type string `json:"type"`
-
-	// Status 条件的状态（例如：True、False）
-	Status string `json:"status"`
-
-	// LastTransitionTime 是条件从一个状态过渡到另一个状态的最后时间
-	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
-
-	// Reason 表示条件最后一次过渡的原因
-	Reason string `json:"reason"`
+	// Conditions 记录数据库实例的条件（Available、Progressing、Degraded、BackupHealthy）
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
-	// Message 表示相关条件的详细信息
-	Message string `json:"message"`
+	// Credentials 记录凭据轮换的状态
+	// +optional
+	Credentials *CredentialsStatus `json:"credentials,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:deprecatedversion:warning="apps.leqiutong.xyz/v1 DatabaseInstance is deprecated; use apps.leqiutong.xyz/v2"
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.databaseType`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
-// DatabaseInstance 是 databaseinstances API 的 Schema
+// DatabaseInstance 是 databaseinstances API 的 Schema，v1 已废弃，由 v2 通过转换 Webhook 提供兼容
 type DatabaseInstance struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseBackupSpec 定义了 DatabaseBackup 的期望状态，创建后不可修改
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type DatabaseBackupSpec struct {
+	// InstanceRef 引用同一命名空间下要备份的 DatabaseInstance
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+}
+
+// DatabaseBackupPhase 表示备份所处的阶段
+type DatabaseBackupPhase string
+
+const (
+	// BackupPhasePending 表示正在等待实例就绪或备份任务被调度
+	BackupPhasePending DatabaseBackupPhase = "Pending"
+	// BackupPhaseRunning 表示备份任务正在执行
+	BackupPhaseRunning DatabaseBackupPhase = "Running"
+	// BackupPhaseCompleted 表示备份成功完成
+	BackupPhaseCompleted DatabaseBackupPhase = "Completed"
+	// BackupPhaseFailed 表示备份任务执行失败
+	BackupPhaseFailed DatabaseBackupPhase = "Failed"
+)
+
+// DatabaseBackupStatus 定义了 DatabaseBackup 被观察到的状态
+type DatabaseBackupStatus struct {
+	// Phase 表示备份所处的阶段（Pending、Running、Completed、Failed）
+	// +optional
+	Phase DatabaseBackupPhase `json:"phase,omitempty"`
+
+	// Message 表示相关状态的附加信息或错误消息
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// JobName 是执行本次备份的 Job 名称
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// StartTime 是备份任务开始执行的时间
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime 是备份任务执行结束的时间
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Size 是备份文件的大小
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// Location 是备份文件的存储位置，格式为 pvc://<PVC 名称>/<卷内路径>
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Checksum 是备份文件的校验和，格式为 sha256:<十六进制摘要>
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// Encoding 记录备份文件的压缩和加密方式，未压缩且未加密时为空
+	// +optional
+	Encoding *BackupEncoding `json:"encoding,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceRef.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.status.size`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DatabaseBackup 是 databasebackups API 的 Schema，表示对一个 DatabaseInstance 的一次按需备份
+type DatabaseBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec 定义了 DatabaseBackup 的期望状态
+	Spec DatabaseBackupSpec `json:"spec,omitempty"`
+	// Status 定义了 DatabaseBackup 被观察到的状态
+	Status DatabaseBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseBackupList 包含 DatabaseBackup 的列表
+type DatabaseBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// Items 是 DatabaseBackup 的列表
+	Items []DatabaseBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseBackup{}, &DatabaseBackupList{})
+}
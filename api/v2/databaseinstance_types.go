@@ -0,0 +1,764 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EngineType 表示数据库引擎的类型
+// +kubebuilder:validation:Enum=mysql;postgres;oceanbase-ce
+type EngineType string
+
+const (
+	// EngineMySQL 表示 MySQL
+	EngineMySQL EngineType = "mysql"
+	// EnginePostgres 表示 PostgreSQL
+	EnginePostgres EngineType = "postgres"
+	// EngineOceanBase 表示 OceanBase 社区版
+	EngineOceanBase EngineType = "oceanbase-ce"
+)
+
+// EngineSpec 定义了数据库引擎及其镜像
+type EngineSpec struct {
+	// Type 表示数据库的类型
+	Type EngineType `json:"type"`
+
+	// Version 表示数据库的版本，未设置时使用引擎的默认版本
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Image 表示数据库的容器镜像（不含镜像仓库前缀），未设置时为 <type>:<version>
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// StorageSpec 定义了每个副本独占的数据卷
+type StorageSpec struct {
+	// Size 表示每个副本数据卷的容量，未设置时默认为 1Gi
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// StorageClassName 表示数据卷使用的存储类，未设置时使用集群默认的存储类
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// AccessModes 表示数据卷的访问模式，未设置时默认为 ReadWriteOnce
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// CredentialsSpec 定义了实例凭据的管理方式
+type CredentialsSpec struct {
+	// ExistingSecretRef 引用用户自行创建的凭据 Secret，设置后 Operator 不再生成 <实例名>-secret，
+	// 也不会修改、接管或删除被引用的 Secret，因此不能与 rotation 同时使用
+	// +optional
+	ExistingSecretRef *ExistingSecretRef `json:"existingSecretRef,omitempty"`
+
+	// PasswordLength 表示 Operator 生成的密码长度，密码只包含大小写字母和数字，默认为 24
+	// +kubebuilder:validation:Minimum=12
+	// +kubebuilder:validation:Maximum=128
+	// +kubebuilder:default=24
+	// +optional
+	PasswordLength int32 `json:"passwordLength,omitempty"`
+
+	// Rotation 定义了管理员密码的定期轮换策略，未设置时不轮换；只能用于 Operator 生成的凭据 Secret
+	// +optional
+	Rotation *CredentialRotation `json:"rotation,omitempty"`
+}
+
+// ExistingSecretRef 引用同一命名空间下保存数据库凭据的 Secret
+type ExistingSecretRef struct {
+	// Name 是 Secret 的名称
+	Name string `json:"name"`
+
+	// UsernameKey 是用户名在 Secret 中的键名，未设置时使用数据库类型的默认键名（例如 mysql-user）
+	// 用户名是可选的，如果存在则必须是数据库的管理员用户（MySQL 为 root，PostgreSQL 为 postgres，OceanBase-CE 为 root@sys）
+	// +optional
+	UsernameKey string `json:"usernameKey,omitempty"`
+
+	// PasswordKey 是密码在 Secret 中的键名，未设置时使用数据库类型的默认键名（例如 mysql-password）
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// CredentialRotation 定义了管理员密码的定期轮换策略，interval 和 schedule 至少设置一个
+type CredentialRotation struct {
+	// Interval 表示两次轮换之间的间隔（例如 2160h 即 90 天），从上一次轮换（或实例创建）的时间开始计算
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Schedule 表示轮换的 cron 表达式（例如 "0 3 1 */3 *"），与 interval 同时设置时优先使用 schedule
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// GracePeriod 表示轮换后旧密码继续有效的时间，仅对支持双密码的引擎（MySQL）生效，默认为 1h
+	// +kubebuilder:default="1h"
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// BackupSpec 定义了定时备份
+type BackupSpec struct {
+	// Enabled 指示是否启用定时备份
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Schedule 表示备份的 cron 表达式，启用备份时必须设置
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Image 表示备份任务使用的完整镜像名称，未设置时使用数据库镜像
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Retention 定义了备份的保留策略，未设置时保留最近的 7 个备份
+	// +optional
+	Retention *BackupRetention `json:"retention,omitempty"`
+
+	// Destination 定义了备份的存储位置，未设置时写入实例专属的备份卷 <实例名>-backup-pvc
+	// +optional
+	Destination *BackupDestination `json:"destination,omitempty"`
+
+	// Compression 配置备份文件的压缩，未设置时不压缩
+	// +optional
+	Compression *BackupCompression `json:"compression,omitempty"`
+
+	// Encryption 配置备份文件的客户端加密，备份在写入备份卷或上传到对象存储之前加密
+	// +optional
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+
+	// Verify 按计划将最新的定时备份导入临时的数据库实例并执行检查查询，验证备份确实可以恢复
+	// +optional
+	Verify *BackupVerification `json:"verify,omitempty"`
+
+	// Archive 持续归档 MySQL 的 binlog 或 PostgreSQL 的 WAL 并定期制作基础备份，
+	// 新实例可以通过 spec.bootstrap.pointInTime 从归档恢复到任意时间点；与定时备份相互独立
+	// +optional
+	Archive *BackupArchive `json:"archive,omitempty"`
+}
+
+// BackupArchive 描述了持续归档：数据库 Pod 中的 archiver 边车容器将写完的日志文件和基础备份上传到备份位置的 <实例名>/archive 目录，
+// 日志文件位于 logs、基础备份位于 base，压缩和加密使用 spec.backup 中相同的配置。目前只支持单副本的 MySQL 和 PostgreSQL 实例
+type BackupArchive struct {
+	// SwitchInterval 是强制切换日志文件的最长间隔，只有写完的日志文件才会被归档，因此它也是数据库故障时最多丢失的数据（RPO），默认为 5m
+	// +kubebuilder:default="5m"
+	// +optional
+	SwitchInterval *metav1.Duration `json:"switchInterval,omitempty"`
+
+	// BaseBackupInterval 是两次基础备份之间的间隔，恢复时从目标之前最近的基础备份开始重放日志，默认为 24h
+	// +kubebuilder:default="24h"
+	// +optional
+	BaseBackupInterval *metav1.Duration `json:"baseBackupInterval,omitempty"`
+
+	// BaseBackupRetention 是保留的基础备份数量，更早的基础备份以及只有它们才需要的日志文件会被删除，默认为 2
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=2
+	// +optional
+	BaseBackupRetention *int32 `json:"baseBackupRetention,omitempty"`
+}
+
+// BackupVerification 描述了定时备份的验证，验证任务在一个 Pod 中启动与实例相同镜像的临时数据库（数据写入 emptyDir），
+// 导入最新的备份并依次执行检查查询，结束后连同临时数据库一起删除
+type BackupVerification struct {
+	// Schedule 是验证的 cron 表达式（例如 "0 6 * * 0"），到期时还没有成功的定时备份则在第一个备份完成后验证
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Queries 是导入完成后依次执行的检查 SQL，每条都必须执行成功并至少返回一行，例如 SELECT 1 FROM app.orders LIMIT 1；
+	// 未设置时只验证备份可以完整导入
+	// +kubebuilder:validation:MaxItems=20
+	// +listType=atomic
+	// +optional
+	Queries []string `json:"queries,omitempty"`
+}
+
+// CompressionAlgorithm 是备份文件的压缩算法
+type CompressionAlgorithm string
+
+const (
+	// CompressionGzip 使用 gzip 压缩，备份文件的扩展名为 .gz
+	CompressionGzip CompressionAlgorithm = "gzip"
+	// CompressionZstd 使用 zstd 压缩，备份文件的扩展名为 .zst
+	CompressionZstd CompressionAlgorithm = "zstd"
+)
+
+// EncryptionAlgorithm 是备份文件的加密算法
+type EncryptionAlgorithm string
+
+// EncryptionAES256CBC 使用 openssl enc -aes-256-cbc 加密，密钥经过 PBKDF2 派生
+const EncryptionAES256CBC EncryptionAlgorithm = "aes-256-cbc"
+
+// BackupCompression 描述了备份文件的压缩方式，备份任务的镜像中需要包含对应的 gzip 或 zstd 命令
+// +kubebuilder:validation:XValidation:rule="!has(self.level) || self.algorithm != 'gzip' || self.level <= 9",message="gzip level must be between 1 and 9"
+type BackupCompression struct {
+	// Algorithm 是压缩算法（gzip、zstd）
+	// +kubebuilder:validation:Enum=gzip;zstd
+	Algorithm CompressionAlgorithm `json:"algorithm"`
+
+	// Level 是压缩级别，gzip 为 1-9，zstd 为 1-19，未设置时使用工具的默认级别
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=19
+	// +optional
+	Level *int32 `json:"level,omitempty"`
+}
+
+// BackupEncryption 描述了备份文件的客户端加密，备份任务的镜像中需要包含 openssl（1.1.1 及以上版本）
+// 更换密钥时应引用新的 Secret 或键，旧的备份仍然使用备份元数据中记录的原密钥恢复，因此原密钥需要保留
+// +kubebuilder:validation:XValidation:rule="self.keySecretRef.key.matches('^[-._a-zA-Z0-9]+$')",message="keySecretRef.key must be a valid Secret key"
+type BackupEncryption struct {
+	// KeySecretRef 引用同一命名空间下 Secret 中保存加密密钥的键，密钥应为足够长的随机字符串
+	KeySecretRef corev1.SecretKeySelector `json:"keySecretRef"`
+}
+
+// BackupDestination 描述了定时备份、按需备份和最终备份的存储位置，pvc 和 s3 最多只能设置一个
+// +kubebuilder:validation:XValidation:rule="!(has(self.pvc) && has(self.s3))",message="pvc and s3 are mutually exclusive"
+type BackupDestination struct {
+	// PVC 配置保存备份文件的备份卷
+	// +optional
+	PVC *PVCDestination `json:"pvc,omitempty"`
+
+	// S3 将备份以流式上传到 S3 兼容的对象存储（例如 MinIO），导出的数据不落本地磁盘
+	// +optional
+	S3 *S3Destination `json:"s3,omitempty"`
+}
+
+// PVCDestination 描述了保存备份文件的备份卷，未设置的字段使用 Operator 的默认值
+// 备份卷创建后只有扩容会生效，存储类和访问模式不会再被修改
+type PVCDestination struct {
+	// ClaimName 是多个实例共享的备份卷名称，不存在时由 Operator 创建，所有引用它的实例都被删除后才会被回收；
+	// 未设置时为每个实例创建专属的 <实例名>-backup-pvc
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
+
+	// StorageClassName 是备份卷的存储类，未设置时使用 Operator 的 --backup-storage-class，两者都未设置时使用集群默认的存储类
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Size 是备份卷的容量，未设置时使用 Operator 的 --backup-volume-size（默认 10Gi）
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// AccessModes 是备份卷的访问模式，默认为 ReadWriteOnce
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// S3Destination 描述了 S3 兼容的对象存储，备份对象的键为 <prefix>/<实例名>/<文件名>
+type S3Destination struct {
+	// Endpoint 是对象存储服务的地址，例如 https://s3.amazonaws.com 或 http://minio.minio.svc:9000
+	// +kubebuilder:validation:Pattern=`^https?://[^\s]+$`
+	Endpoint string `json:"endpoint"`
+
+	// Bucket 是存放备份的存储桶，需要事先创建
+	// +kubebuilder:validation:MinLength=3
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9.-]*[a-z0-9]$`
+	Bucket string `json:"bucket"`
+
+	// Prefix 是备份对象键的前缀，例如 prod/mysql
+	// 前缀会被拼接到备份任务的 shell 命令中，因此只允许字母、数字和 ._/- 字符
+	// +kubebuilder:validation:MaxLength=512
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._/-]*$`
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecretRef 引用同一命名空间下保存访问密钥的 Secret，键名为 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+
+	// ClientImage 是上传和下载备份使用的 MinIO 客户端（mc）镜像，未设置时使用 quay.io/minio/mc，内网环境可以替换为私有仓库中的镜像
+	// +optional
+	ClientImage string `json:"clientImage,omitempty"`
+}
+
+// BackupRetention 定义了定时备份的保留策略，每次备份成功后清理不再需要保留的备份
+// 同时设置多个条件时备份满足任一条件即被保留，最近一次成功的备份总会被保留
+// keepDaily、keepWeekly、keepMonthly 组合使用即为祖父-父-子（GFS）轮换
+// +kubebuilder:validation:XValidation:rule="has(self.keepLast) || has(self.maxAge) || has(self.keepDaily) || has(self.keepWeekly) || has(self.keepMonthly)",message="at least one retention rule must be set"
+type BackupRetention struct {
+	// KeepLast 表示保留最近的备份数量
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepLast *int32 `json:"keepLast,omitempty"`
+
+	// MaxAge 表示备份的最长保留时间（例如 168h 即 7 天）
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// KeepDaily 表示为最近多少个有备份的日期各保留当天最新的一个备份（按 UTC 计算）
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepDaily *int32 `json:"keepDaily,omitempty"`
+
+	// KeepWeekly 表示为最近多少个有备份的 ISO 周各保留该周最新的一个备份
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepWeekly *int32 `json:"keepWeekly,omitempty"`
+
+	// KeepMonthly 表示为最近多少个有备份的月份各保留该月最新的一个备份
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepMonthly *int32 `json:"keepMonthly,omitempty"`
+}
+
+// TopologySpec 定义了实例的副本拓扑
+type TopologySpec struct {
+	// Replicas 表示数据库副本的数量
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Replication 定义了多副本时的主从复制，仅对支持复制的引擎（MySQL、PostgreSQL）生效：replicas 大于 1 时一个副本为主库，
+	// 其余副本通过 MySQL 基于 GTID 的复制或 PostgreSQL 的流复制从主库同步数据并且只读
+	// +optional
+	Replication *ReplicationSpec `json:"replication,omitempty"`
+
+	// Primary 是期望的主库 Pod 名称（例如 "mydb-1"）。与 status.currentPrimary 不同时，Operator 在该副本追上主库后
+	// 执行计划内的主从切换：停止旧主库的写入，等待该副本应用完全部变更后将它提升为主库，不会丢失数据；
+	// 未设置时由 Operator 选择主库（新实例为第一个副本，故障转移后为新主库）
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	Primary string `json:"primary,omitempty"`
+}
+
+// ReplicationSpec 定义了主从复制的配置
+type ReplicationSpec struct {
+	// SemiSync 开启 MySQL 的半同步复制，主库提交事务前等待至少一个副本确认收到变更；未设置时为异步复制
+	// +optional
+	SemiSync *SemiSyncSpec `json:"semiSync,omitempty"`
+
+	// SynchronousStandbyNames 是 PostgreSQL 主库的 synchronous_standby_names，例如 ANY 1 (*)，
+	// 副本的名称为 Pod 名称（例如 "mydb-1"）；未设置时为异步复制
+	// +kubebuilder:validation:MaxLength=1024
+	// +optional
+	SynchronousStandbyNames string `json:"synchronousStandbyNames,omitempty"`
+
+	// FailoverDelay 是主库持续不可用多久之后自动故障转移，将复制进度最新的副本提升为主库，默认 30s；
+	// 应当大于数据库正常重启所需的时间，避免滚动更新时发生不必要的切换
+	// +kubebuilder:default="30s"
+	// +optional
+	FailoverDelay *metav1.Duration `json:"failoverDelay,omitempty"`
+}
+
+// SemiSyncSpec 定义了半同步复制的配置
+type SemiSyncSpec struct {
+	// Timeout 是主库等待副本确认的最长时间，超时后退化为异步复制，副本追上后自动恢复为半同步，默认 10s
+	// +kubebuilder:default="10s"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// NetworkingSpec 定义了实例对外提供服务的方式
+type NetworkingSpec struct {
+	// ServiceType 表示实例 Service 的类型，默认为 ClusterIP
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// ServiceAnnotations 表示添加到实例 Service 上的注解，例如云厂商负载均衡器的配置
+	// +optional
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+
+	// ReadService 为 true 时额外创建 <实例名>-r Service，选择全部已就绪的副本（包括主库），用于可以容忍复制延迟的只读查询
+	// +optional
+	ReadService bool `json:"readService,omitempty"`
+}
+
+// BootstrapSpec 定义了新实例的初始数据来源，fromBackup 和 pointInTime 最多只能设置一个
+// +kubebuilder:validation:XValidation:rule="!(has(self.fromBackup) && has(self.pointInTime))",message="fromBackup and pointInTime are mutually exclusive"
+type BootstrapSpec struct {
+	// FromBackup 表示实例首次运行后从备份导入数据，导入完成后不会再次执行
+	// +optional
+	FromBackup *BackupSource `json:"fromBackup,omitempty"`
+
+	// PointInTime 表示实例从另一个实例的持续归档恢复到指定的时间点，恢复在数据库首次启动之前由 init 容器完成
+	// +optional
+	PointInTime *PointInTimeSource `json:"pointInTime,omitempty"`
+}
+
+// PointInTimeSource 描述了按时间点恢复的源实例和恢复目标，targetTime、targetGTID 和 targetLSN 最多只能设置一个，
+// 都未设置时重放全部已归档的日志。恢复从目标之前最近的基础备份开始，恢复后的数据（包括管理员密码）与源实例在目标时间点一致
+// +kubebuilder:validation:XValidation:rule="[has(self.targetTime), has(self.targetGTID), has(self.targetLSN)].filter(x, x).size() <= 1",message="at most one of targetTime, targetGTID and targetLSN may be set"
+type PointInTimeSource struct {
+	// SourceInstance 是开启了 spec.backup.archive 的源实例名称，源实例可以已经被删除。
+	// 归档从本实例的备份位置中的 <sourceInstance>/archive 读取，因此 spec.backup.destination 需要与源实例使用同一个共享备份卷或对象存储
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`
+	SourceInstance string `json:"sourceInstance"`
+
+	// TargetTime 表示恢复到该时间点（含）之前提交的事务，精确到秒
+	// +optional
+	TargetTime *metav1.Time `json:"targetTime,omitempty"`
+
+	// TargetGTID 仅用于 MySQL，表示恢复到该事务（不含）之前，格式为 <server_uuid>:<序号>，
+	// 例如误执行的 DROP TABLE 的 GTID
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}:[1-9][0-9]{0,18}$`
+	// +optional
+	TargetGTID string `json:"targetGTID,omitempty"`
+
+	// TargetLSN 仅用于 PostgreSQL，表示恢复到该 WAL 位置（不含）之前，格式为 X/X，例如 0/3000060
+	// +kubebuilder:validation:Pattern=`^[0-9A-Fa-f]{1,8}/[0-9A-Fa-f]{1,8}$`
+	// +optional
+	TargetLSN string `json:"targetLSN,omitempty"`
+}
+
+// DeletionPolicy 定义了删除 DatabaseInstance 时如何处理数据和备份
+// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete 删除实例的数据卷、凭据和备份
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain 保留数据卷、凭据和备份，并解除它们与实例的从属关系
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicySnapshot 先执行一次最终备份，成功后删除数据卷和凭据，保留备份
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
+// DatabaseInstanceSpec 定义了 DatabaseInstance 的期望状态
+type DatabaseInstanceSpec struct {
+	// Engine 定义了数据库引擎、版本和镜像
+	Engine EngineSpec `json:"engine"`
+
+	// Storage 定义了每个副本的数据卷
+	// +optional
+	Storage StorageSpec `json:"storage,omitempty"`
+
+	// Resources 定义了数据库容器和备份任务的资源请求和限制，
+	// 引擎的内存参数（如 innodb_buffer_pool_size、shared_buffers）按内存限制（未设置时按内存请求）的比例推算
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Credentials 定义了实例凭据的管理方式
+	// +optional
+	Credentials CredentialsSpec `json:"credentials,omitempty"`
+
+	// Backup 定义了定时备份
+	// +optional
+	Backup BackupSpec `json:"backup,omitempty"`
+
+	// Topology 定义了实例的副本拓扑
+	// +optional
+	Topology TopologySpec `json:"topology,omitempty"`
+
+	// Networking 定义了实例对外提供服务的方式
+	// +optional
+	Networking NetworkingSpec `json:"networking,omitempty"`
+
+	// Bootstrap 定义了实例的初始数据来源，创建后不可修改
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// DeletionPolicy 表示删除实例时如何处理数据和备份（Delete、Retain、Snapshot），默认为 Retain
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// DatabaseInstancePhase 表示数据库实例所处的阶段
+type DatabaseInstancePhase string
+
+const (
+	// PhasePending 表示工作负载尚未创建，或副本尚未被调度
+	PhasePending DatabaseInstancePhase = "Pending"
+	// PhaseProvisioning 表示工作负载已创建，副本正在启动或滚动更新中
+	PhaseProvisioning DatabaseInstancePhase = "Provisioning"
+	// PhaseRunning 表示全部副本均已就绪且为最新版本
+	PhaseRunning DatabaseInstancePhase = "Running"
+	// PhaseDegraded 表示仍有副本可以提供服务，但部分副本异常
+	PhaseDegraded DatabaseInstancePhase = "Degraded"
+	// PhaseFailed 表示没有副本可以提供服务且存在无法自行恢复的错误，或 spec 配置无效
+	PhaseFailed DatabaseInstancePhase = "Failed"
+	// PhaseDeleting 表示实例正在按照 spec.deletionPolicy 执行删除流程
+	PhaseDeleting DatabaseInstancePhase = "Deleting"
+)
+
+// DatabaseInstance 的条件类型，遵循 metav1.Condition 的语义
+const (
+	// ConditionAvailable 表示至少有一个副本就绪，可以对外提供服务
+	ConditionAvailable = "Available"
+	// ConditionProgressing 表示实例正在创建、扩缩容或滚动更新
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded 表示实例存在异常的副本或无效的配置
+	ConditionDegraded = "Degraded"
+	// ConditionBackupHealthy 表示最近一次备份任务是否成功，仅在启用备份时设置
+	ConditionBackupHealthy = "BackupHealthy"
+	// ConditionBackupVolumeReady 表示备份卷是否已经绑定，仅在备份写入 PVC、且启用了定时备份或备份卷已经存在时设置
+	ConditionBackupVolumeReady = "BackupVolumeReady"
+	// ConditionTerminating 表示实例正在删除，Reason 为删除流程当前所处的步骤
+	ConditionTerminating = "Terminating"
+	// ConditionBootstrapped 表示 spec.bootstrap 指定的初始数据是否已导入，仅在设置 spec.bootstrap 时设置
+	ConditionBootstrapped = "Bootstrapped"
+	// ConditionReplicationHealthy 表示所有副本是否都在从主库复制，仅在开启主从复制时设置
+	ConditionReplicationHealthy = "ReplicationHealthy"
+	// ConditionMigrationRequired 表示实例仍由旧版本 Operator 创建的 Deployment 运行，需要用户迁移数据后删除该 Deployment
+	ConditionMigrationRequired = "MigrationRequired"
+)
+
+// CredentialsStatus 记录实例凭据轮换的状态
+type CredentialsStatus struct {
+	// LastRotated 是最近一次成功轮换管理员密码的时间
+	// +optional
+	LastRotated *metav1.Time `json:"lastRotated,omitempty"`
+
+	// OldPasswordExpiresAt 是轮换前的旧密码被废弃的时间，为空表示没有仍然有效的旧密码
+	// +optional
+	OldPasswordExpiresAt *metav1.Time `json:"oldPasswordExpiresAt,omitempty"`
+}
+
+// BackupArtifact 描述了一个定时备份生成的备份文件
+type BackupArtifact struct {
+	// JobName 是生成该备份的 Job 名称
+	JobName string `json:"jobName"`
+
+	// Location 是备份文件的存储位置，格式为 pvc://<PVC 名称>/<卷内路径>
+	Location string `json:"location"`
+
+	// Size 是备份文件的大小
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// Checksum 是备份文件的校验和，格式为 sha256:<十六进制摘要>
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+
+	// Encoding 记录备份文件的压缩和加密方式，未压缩且未加密时为空
+	// +optional
+	Encoding *BackupEncoding `json:"encoding,omitempty"`
+
+	// CompletionTime 是备份完成的时间
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// BackupEncoding 记录备份文件的压缩和加密方式，恢复时据此解密和解压
+type BackupEncoding struct {
+	// Compression 是备份文件的压缩算法，为空表示未压缩
+	// +optional
+	Compression CompressionAlgorithm `json:"compression,omitempty"`
+
+	// Encryption 是备份文件的加密算法，为空表示未加密
+	// +optional
+	Encryption EncryptionAlgorithm `json:"encryption,omitempty"`
+
+	// KeyID 标识加密使用的密钥，格式为 <Secret 名称>/<键>@<密钥 sha256 摘要的前 16 位>，恢复时使用同一个密钥解密
+	// +optional
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// BackupVerificationResult 记录一次备份验证的结果
+type BackupVerificationResult struct {
+	// Location 是被验证的备份的存储位置
+	Location string `json:"location"`
+
+	// Succeeded 表示备份是否成功导入且所有检查查询都已通过
+	Succeeded bool `json:"succeeded"`
+
+	// Message 说明验证的结果，失败时包含验证任务日志的末尾
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// StartTime 是验证任务开始执行的时间
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime 是验证任务执行结束的时间
+	CompletionTime metav1.Time `json:"completionTime"`
+}
+
+// BackupStatus 记录定时备份的状态
+type BackupStatus struct {
+	// Artifacts 是按照保留策略仍然保留的备份，最新的在前
+	// +listType=atomic
+	// +optional
+	Artifacts []BackupArtifact `json:"artifacts,omitempty"`
+
+	// LastVerified 是最近一次备份验证的结果
+	// +optional
+	LastVerified *BackupVerificationResult `json:"lastVerified,omitempty"`
+}
+
+// DatabaseInstanceStatus 定义了 DatabaseInstance 资源被观察到的状态
+type DatabaseInstanceStatus struct {
+	// Phase 表示数据库实例当前所处的阶段（Pending、Provisioning、Running、Degraded、Failed、Deleting）
+	// +optional
+	Phase DatabaseInstancePhase `json:"phase,omitempty"`
+
+	// Message 表示相关状态的附加信息或错误消息
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration 是计算本状态时所依据的 metadata.generation
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Replicas 表示期望的副本数量
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// ReadyReplicas 表示当前已就绪的副本数量
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// LastUpdated 是状态最后一次发生变化的时间戳
+	// +optional
+	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
+
+	// Conditions 记录数据库实例的条件（Available、Progressing、Degraded、BackupHealthy、BackupVolumeReady、Terminating、Bootstrapped、ReplicationHealthy、MigrationRequired）
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Credentials 记录凭据轮换的状态
+	// +optional
+	Credentials *CredentialsStatus `json:"credentials,omitempty"`
+
+	// Backup 记录定时备份的状态
+	// +optional
+	Backup *BackupStatus `json:"backup,omitempty"`
+
+	// CurrentPrimary 是当前主库的 Pod 名称，仅在开启主从复制时设置，实例的 Service 只选择该 Pod
+	// +optional
+	CurrentPrimary string `json:"currentPrimary,omitempty"`
+
+	// Replication 记录每个副本的复制状态和主库的健康状况，仅在开启主从复制时设置
+	// +optional
+	Replication *ReplicationStatus `json:"replication,omitempty"`
+
+	// Services 记录应用连接实例使用的 Service 地址，应用可以据此拆分读写而不需要知道 Pod 的名称
+	// +optional
+	Services *ServicesStatus `json:"services,omitempty"`
+
+	// Failovers 记录最近的主库切换，最新的在最后，最多保留 10 条
+	// +listType=atomic
+	// +optional
+	Failovers []FailoverRecord `json:"failovers,omitempty"`
+}
+
+// ReplicationStatus 记录主从复制的状态
+type ReplicationStatus struct {
+	// Replicas 记录每个副本的复制状态
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+
+	// PrimaryUnavailableSince 是 Operator 第一次发现主库不可用（Pod 不存在、未就绪或者无法执行命令）的时间，主库恢复后清除
+	// +optional
+	PrimaryUnavailableSince *metav1.Time `json:"primaryUnavailableSince,omitempty"`
+
+	// Demoted 是故障转移中被隔离的旧主库，它们可能包含没有复制到新主库的事务，重新加入时会丢弃这些数据并从新主库初始化，
+	// 开始从新主库复制后移除
+	// +listType=set
+	// +optional
+	Demoted []string `json:"demoted,omitempty"`
+}
+
+// ServicesStatus 记录按角色划分的 Service 地址，格式为 <Service 名称>.<命名空间>.svc
+type ServicesStatus struct {
+	// ReadWrite 是 <实例名>-rw Service 的地址，只选择主库；未开启主从复制时选择全部副本
+	// +optional
+	ReadWrite string `json:"readWrite,omitempty"`
+
+	// ReadOnly 是 <实例名>-ro Service 的地址，只选择正在从主库复制的副本，仅在开启主从复制时设置
+	// +optional
+	ReadOnly string `json:"readOnly,omitempty"`
+
+	// Read 是 <实例名>-r Service 的地址，选择全部已就绪的副本，仅在 spec.networking.readService 为 true 时设置
+	// +optional
+	Read string `json:"read,omitempty"`
+
+	// Port 是这些 Service 的数据库端口
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// FailoverRecord 记录一次主库切换
+type FailoverRecord struct {
+	// Time 是新主库提升完成的时间
+	Time metav1.Time `json:"time"`
+
+	// From 是原主库的 Pod 名称
+	From string `json:"from"`
+
+	// To 是新主库的 Pod 名称
+	To string `json:"to"`
+
+	// Reason 是切换的原因：PrimaryUnavailable（自动故障转移）或 Switchover（计划内的主从切换）
+	Reason string `json:"reason"`
+
+	// Message 描述切换的经过
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ReplicaStatus 记录一个副本的复制状态
+type ReplicaStatus struct {
+	// Name 是副本的 Pod 名称
+	Name string `json:"name"`
+
+	// Streaming 表示副本是否正在从主库接收并应用变更
+	Streaming bool `json:"streaming"`
+
+	// LagSeconds 是副本落后主库的秒数，没有在复制时为空
+	// +optional
+	LagSeconds *int64 `json:"lagSeconds,omitempty"`
+
+	// Message 说明副本尚未开始复制或复制出错的原因
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.engine.type`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.engine.version`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+// +kubebuilder:printcolumn:name="Primary",type=string,JSONPath=`.status.currentPrimary`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DatabaseInstance 是 databaseinstances API 的 Schema
+type DatabaseInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec 定义了 DatabaseInstance 的期望状态
+	Spec DatabaseInstanceSpec `json:"spec,omitempty"`
+	// Status 定义了 DatabaseInstance 资源的观察到的状态
+	Status DatabaseInstanceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseInstanceList 包含 DatabaseInstance 的列表
+type DatabaseInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// Items 是 DatabaseInstance 的列表
+	Items []DatabaseInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseInstance{}, &DatabaseInstanceList{})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupSource 描述了恢复使用的备份文件，backupName 和 path 必须且只能设置一个
+// +kubebuilder:validation:XValidation:rule="has(self.backupName) != has(self.path)",message="exactly one of backupName and path must be set"
+type BackupSource struct {
+	// BackupName 引用同一命名空间下已完成的 DatabaseBackup，恢复前会校验备份文件的校验和
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+
+	// Path 是备份文件在实例当前备份卷中的相对路径，例如定时备份写入的 mydb/mydb-20260101T020000Z.sql；
+	// 实例配置了 spec.backup.destination.s3 时是相对于存储桶和 prefix 的对象键
+	// 路径会被拼接到恢复任务的 shell 命令中，因此只允许字母、数字和 ._/- 字符
+	// +kubebuilder:validation:MaxLength=1024
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._/-]+$`
+	// +kubebuilder:validation:XValidation:rule="!self.startsWith('/') && !self.split('/').exists(s, s == '..')",message="path must be relative to the backup volume"
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// DatabaseRestoreSpec 定义了 DatabaseRestore 的期望状态，创建后不可修改
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type DatabaseRestoreSpec struct {
+	// InstanceRef 引用同一命名空间下要导入数据的 DatabaseInstance
+	InstanceRef corev1.LocalObjectReference `json:"instanceRef"`
+
+	// Source 是要导入的备份
+	Source BackupSource `json:"source"`
+}
+
+// DatabaseRestorePhase 表示恢复所处的阶段
+type DatabaseRestorePhase string
+
+const (
+	// RestorePhasePending 表示正在等待实例就绪、备份完成或恢复任务被调度
+	RestorePhasePending DatabaseRestorePhase = "Pending"
+	// RestorePhaseRunning 表示恢复任务正在导入数据
+	RestorePhaseRunning DatabaseRestorePhase = "Running"
+	// RestorePhaseCompleted 表示数据已成功导入
+	RestorePhaseCompleted DatabaseRestorePhase = "Completed"
+	// RestorePhaseFailed 表示恢复任务执行失败，或引用的备份无法使用
+	RestorePhaseFailed DatabaseRestorePhase = "Failed"
+)
+
+// DatabaseRestoreStatus 定义了 DatabaseRestore 被观察到的状态
+type DatabaseRestoreStatus struct {
+	// Phase 表示恢复所处的阶段（Pending、Running、Completed、Failed）
+	// +optional
+	Phase DatabaseRestorePhase `json:"phase,omitempty"`
+
+	// Message 表示相关状态的附加信息或错误消息
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// JobName 是执行本次恢复的 Job 名称
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// Location 是导入的备份文件的存储位置，格式为 pvc://<PVC 名称>/<卷内路径>
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// StartTime 是恢复任务开始执行的时间
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime 是恢复任务执行结束的时间
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.spec.instanceRef.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DatabaseRestore 是 databaserestores API 的 Schema，表示将一个备份导入 DatabaseInstance
+type DatabaseRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec 定义了 DatabaseRestore 的期望状态
+	Spec DatabaseRestoreSpec `json:"spec,omitempty"`
+	// Status 定义了 DatabaseRestore 被观察到的状态
+	Status DatabaseRestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseRestoreList 包含 DatabaseRestore 的列表
+type DatabaseRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// Items 是 DatabaseRestore 的列表
+	Items []DatabaseRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseRestore{}, &DatabaseRestoreList{})
+}
@@ -25,6 +25,7 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -36,7 +37,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
 	"github.com/cmjzzx/k8s-database-operator/internal/controller"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers"
+	webhookappsv1 "github.com/cmjzzx/k8s-database-operator/internal/webhook/v1"
+	webhookappsv2 "github.com/cmjzzx/k8s-database-operator/internal/webhook/v2"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,6 +54,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(appsv2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -58,6 +64,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var backupStorageClass string
+	var backupVolumeSize string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -69,6 +77,10 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&backupStorageClass, "backup-storage-class", "",
+		"The default storage class of backup volumes. Leave empty to use the cluster's default storage class.")
+	flag.StringVar(&backupVolumeSize, "backup-volume-size", helpers.DefaultBackupVolumeSize,
+		"The default size of backup volumes, overridden by spec.backup.destination.pvc.size.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -144,13 +156,54 @@ func main() {
 		os.Exit(1)
 	}
 
+	backupVolume := helpers.BackupVolumeDefaults{StorageClassName: backupStorageClass}
+	if backupVolume.Size, err = resource.ParseQuantity(backupVolumeSize); err != nil {
+		setupLog.Error(err, "invalid --backup-volume-size")
+		os.Exit(1)
+	}
+
+	executor, err := helpers.NewPodExecutor(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create pod executor")
+		os.Exit(1)
+	}
+
 	if err = (&controller.DatabaseInstanceReconciler{
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		Executor:     executor,
+		Recorder:     mgr.GetEventRecorderFor("databaseinstance-controller"),
+		BackupVolume: backupVolume,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseInstance")
+		os.Exit(1)
+	}
+	if err = (&controller.DatabaseBackupReconciler{
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		BackupVolume: backupVolume,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseBackup")
+		os.Exit(1)
+	}
+	if err = (&controller.DatabaseRestoreReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "DatabaseInstance")
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseRestore")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookappsv1.SetupDatabaseInstanceWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DatabaseInstance")
+			os.Exit(1)
+		}
+		if err = webhookappsv2.SetupDatabaseInstanceWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DatabaseInstance")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers"
+)
+
+// DatabaseBackupReconciler 负责执行 DatabaseBackup 描述的按需备份，并将备份结果记录到状态中
+type DatabaseBackupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// BackupVolume 是备份卷的默认存储类和容量，与 DatabaseInstanceReconciler 使用相同的配置
+	BackupVolume helpers.BackupVolumeDefaults
+}
+
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databasebackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databasebackups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databasebackups/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch
+
+// Reconcile 为 DatabaseBackup 创建一次性的备份 Job，并根据 Job 的执行情况更新备份状态
+// 备份进入 Completed 或 Failed 阶段后不再处理，需要重新备份时创建新的 DatabaseBackup
+func (r *DatabaseBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var backup databasev2.DatabaseBackup
+	if err := r.Get(ctx, req.NamespacedName, &backup); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "获取 DatabaseBackup 失败", "资源名称", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	if backup.Status.Phase == databasev2.BackupPhaseCompleted || backup.Status.Phase == databasev2.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// 获取被备份的实例，实例可能稍后才创建，因此定期重试
+	var dbInstance databasev2.DatabaseInstance
+	instanceKey := client.ObjectKey{Name: backup.Spec.InstanceRef.Name, Namespace: backup.Namespace}
+	if err := r.Get(ctx, instanceKey, &dbInstance); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "获取 DatabaseInstance 失败", "资源名称", instanceKey)
+			return ctrl.Result{}, err
+		}
+		return r.markPending(ctx, &backup, "实例 "+instanceKey.Name+" 不存在")
+	}
+
+	// 备份归属于实例，实例被删除时一并清理
+	if !metav1.IsControlledBy(&backup, &dbInstance) {
+		if err := ctrl.SetControllerReference(&dbInstance, &backup, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, &backup); err != nil {
+			logger.Error(err, "设置 DatabaseBackup 的 OwnerReference 失败")
+			return ctrl.Result{}, err
+		}
+	}
+
+	eng, err := engine.Get(string(dbInstance.Spec.Engine.Type))
+	if err != nil {
+		status := backup.Status
+		status.Phase = databasev2.BackupPhaseFailed
+		status.Message = err.Error()
+		return ctrl.Result{}, helpers.UpdateDatabaseBackupStatus(ctx, r.Client, &backup, &status)
+	}
+
+	// Job 尚未创建时，等待实例运行后再开始备份
+	var job batchv1.Job
+	jobKey := client.ObjectKey{Name: helpers.BackupJobName(backup.Name), Namespace: backup.Namespace}
+	if err := r.Get(ctx, jobKey, &job); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "获取备份 Job 失败", "资源名称", jobKey)
+			return ctrl.Result{}, err
+		}
+		if dbInstance.Status.Phase != databasev2.PhaseRunning {
+			return r.markPending(ctx, &backup, "等待实例 "+dbInstance.Name+" 进入 Running 阶段")
+		}
+
+		resources, err := helpers.NewResourceRequirements(dbInstance.Spec.Resources)
+		if err != nil {
+			resources = corev1.ResourceRequirements{}
+		}
+		desired := helpers.NewBackupJob(backup.Name, dbInstance.Name, backup.Namespace, helpers.BackupImageName(&dbInstance),
+			resources, helpers.CredentialsSecretRef(&dbInstance, eng), eng, helpers.BackupTargetOf(&dbInstance), helpers.BackupCodecOf(&dbInstance))
+		if err := ctrl.SetControllerReference(&backup, desired, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := helpers.EnsureBackupVolume(ctx, r.Client, &dbInstance, r.BackupVolume); err != nil {
+			return ctrl.Result{}, err
+		}
+		created, err := helpers.EnsureBackupJob(ctx, r.Client, desired)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		job = *created
+	}
+
+	status, err := helpers.ObserveBackupJob(ctx, r.Client, &job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, helpers.UpdateDatabaseBackupStatus(ctx, r.Client, &backup, &status)
+}
+
+// markPending 将备份标记为 Pending 并定期重新调和
+func (r *DatabaseBackupReconciler) markPending(ctx context.Context, backup *databasev2.DatabaseBackup, message string) (ctrl.Result, error) {
+	status := backup.Status
+	status.Phase = databasev2.BackupPhasePending
+	status.Message = message
+	if err := helpers.UpdateDatabaseBackupStatus(ctx, r.Client, backup, &status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: statusRequeueInterval}, nil
+}
+
+// SetupWithManager 将控制器与 Manager 管理器进行配置和绑定，备份 Job 的状态变化会触发所属 DatabaseBackup 的调和
+func (r *DatabaseBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev2.DatabaseBackup{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers"
+)
+
+var _ = Describe("DatabaseBackup Controller", func() {
+	Context("When backing up an instance on demand", func() {
+		const instanceName = "backup-source"
+		const backupName = "before-migration"
+
+		ctx := context.Background()
+
+		instanceKey := types.NamespacedName{Name: instanceName, Namespace: "default"}
+		backupKey := types.NamespacedName{Name: backupName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("Creating the instance and the DatabaseBackup that references it")
+			Expect(k8sClient.Create(ctx, &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: "default"},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EnginePostgres, Version: "16"},
+				},
+			})).To(Succeed())
+			Expect(k8sClient.Create(ctx, &appsv2.DatabaseBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: backupName, Namespace: "default"},
+				Spec: appsv2.DatabaseBackupSpec{
+					InstanceRef: corev1.LocalObjectReference{Name: instanceName},
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			// envtest 中没有垃圾回收，需要手动清理
+			By("Cleanup the backup, its Job, the backup volume and the instance")
+			background := client.PropagationPolicy(metav1.DeletePropagationBackground)
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: backupName + "-job", Namespace: "default"},
+			}, background))).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: helpers.BackupClaimName(instanceName), Namespace: "default"},
+			}))).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &appsv2.DatabaseBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: backupName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: "default"},
+			})).To(Succeed())
+		})
+
+		It("should wait for the instance to run and then start a one-off Job", func() {
+			controllerReconciler := &DatabaseBackupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			By("Staying Pending while the instance is not Running")
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: backupKey})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(statusRequeueInterval))
+
+			instance := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, instance)).To(Succeed())
+			backup := &appsv2.DatabaseBackup{}
+			Expect(k8sClient.Get(ctx, backupKey, backup)).To(Succeed())
+			Expect(backup.Status.Phase).To(Equal(appsv2.BackupPhasePending))
+			Expect(metav1.IsControlledBy(backup, instance)).To(BeTrue())
+
+			By("Creating the backup Job once the instance is Running")
+			instance.Status.Phase = appsv2.PhaseRunning
+			Expect(k8sClient.Status().Update(ctx, instance)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: backupKey})
+			Expect(err).NotTo(HaveOccurred())
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: backupName + "-job", Namespace: "default"}, job)).To(Succeed())
+			Expect(metav1.IsControlledBy(job, backup)).To(BeTrue())
+			container := job.Spec.Template.Spec.Containers[0]
+			Expect(container.Image).To(Equal("registry.leqiutong.xyz/middleware/postgres:16"))
+			Expect(container.Command[2]).To(ContainSubstring("pg_dumpall"))
+			Expect(container.Command[2]).To(ContainSubstring("/backup/" + instanceName + "/" + backupName + ".sql"))
+
+			By("Mounting a backup volume that belongs to the instance")
+			pvc := &corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: instanceName + "-backup-pvc", Namespace: "default"}, pvc)).To(Succeed())
+			Expect(metav1.IsControlledBy(pvc, instance)).To(BeTrue())
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal(helpers.DefaultBackupVolumeSize))
+			Expect(job.Spec.Template.Spec.Volumes).To(HaveLen(1))
+			Expect(job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal(pvc.Name))
+
+			Expect(k8sClient.Get(ctx, backupKey, backup)).To(Succeed())
+			Expect(backup.Status.Phase).To(Equal(appsv2.BackupPhaseRunning))
+			Expect(backup.Status.JobName).To(Equal(job.Name))
+		})
+
+		It("should stream the dump to object storage when a destination is configured", func() {
+			controllerReconciler := &DatabaseBackupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			instance := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, instance)).To(Succeed())
+			instance.Spec.Backup.Destination = &appsv2.BackupDestination{S3: &appsv2.S3Destination{
+				Endpoint:             "http://minio.minio.svc:9000",
+				Bucket:               "backups",
+				Prefix:               "prod",
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: "minio-credentials"},
+			}}
+			Expect(k8sClient.Update(ctx, instance)).To(Succeed())
+			instance.Status.Phase = appsv2.PhaseRunning
+			Expect(k8sClient.Status().Update(ctx, instance)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: backupKey})
+			Expect(err).NotTo(HaveOccurred())
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: backupName + "-job", Namespace: "default"}, job)).To(Succeed())
+			podSpec := job.Spec.Template.Spec
+			Expect(podSpec.InitContainers).To(HaveLen(1))
+			Expect(podSpec.InitContainers[0].Image).To(Equal(helpers.DefaultS3ClientImage))
+			Expect(podSpec.Volumes).To(HaveLen(1))
+			Expect(podSpec.Volumes[0].EmptyDir).NotTo(BeNil())
+			container := podSpec.Containers[0]
+			Expect(container.Command[2]).To(ContainSubstring("key=prod/" + instanceName + "/" + backupName + ".sql"))
+			Expect(container.Command[2]).To(ContainSubstring("mc pipe"))
+			Expect(container.Command[2]).To(ContainSubstring("pg_dumpall"))
+			Expect(container.Command[2]).NotTo(ContainSubstring("/backup/"))
+			Expect(container.Env).To(ContainElement(HaveField("Name", "S3_SECRET_ACCESS_KEY")))
+		})
+
+		It("should compress and encrypt the dump before writing it to the backup volume", func() {
+			controllerReconciler := &DatabaseBackupReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			instance := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, instance)).To(Succeed())
+			instance.Spec.Backup.Compression = &appsv2.BackupCompression{Algorithm: appsv2.CompressionZstd, Level: ptr.To[int32](3)}
+			instance.Spec.Backup.Encryption = &appsv2.BackupEncryption{KeySecretRef: corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "backup-key"},
+				Key:                  "key",
+			}}
+			Expect(k8sClient.Update(ctx, instance)).To(Succeed())
+			instance.Status.Phase = appsv2.PhaseRunning
+			Expect(k8sClient.Status().Update(ctx, instance)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: backupKey})
+			Expect(err).NotTo(HaveOccurred())
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: backupName + "-job", Namespace: "default"}, job)).To(Succeed())
+			container := job.Spec.Template.Spec.Containers[0]
+			Expect(container.Command[2]).To(ContainSubstring("/backup/" + instanceName + "/" + backupName + ".sql.zst.enc"))
+			Expect(container.Command[2]).To(ContainSubstring("zstd -q -c -3"))
+			Expect(container.Command[2]).To(ContainSubstring("openssl enc -aes-256-cbc"))
+			Expect(container.Command[2]).To(ContainSubstring(`"keyID"`))
+			Expect(container.Env).To(ContainElement(And(
+				HaveField("Name", "BACKUP_ENCRYPTION_KEY"),
+				HaveField("ValueFrom.SecretKeyRef.Name", "backup-key"),
+			)))
+		})
+	})
+})
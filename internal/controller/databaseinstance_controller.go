@@ -18,24 +18,49 @@ package controller
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
 
+	k8sappsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	// 导入 Kubernetes 的 apps/v1
 	// 导入 Kubernetes 的 core/v1 包
 	// 错误处理
 	// 导入 metav1 包
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 
 	// 导入 intstr 包
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	databasev1 "github.com/cmjzzx/k8s-database-operator/api/v1"    // 导入 databasev1
+	databasev1 "github.com/cmjzzx/k8s-database-operator/api/v1"    // 导入 databasev1，用于读取 v1 转换时保留的字段
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"    // 导入 databasev2
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"  // 数据库引擎驱动
 	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers" // 辅助函数
 )
 
+const (
+	// statusRequeueInterval 是实例未处于 Running 阶段时重新计算状态的间隔
+	statusRequeueInterval = 15 * time.Second
+
+	// deletionRequeueInterval 是等待删除流程中的异步步骤（例如最终备份）完成时重新调和的间隔
+	deletionRequeueInterval = 10 * time.Second
+
+	// databaseInstanceFinalizer 保证实例在按照 spec.deletionPolicy 处理完数据和备份之前不会被真正删除
+	databaseInstanceFinalizer = "apps.leqiutong.xyz/finalizer"
+)
+
 // 定义 DatabaseInstanceReconciler 结构体，负责调节 DatabaseInstance 对象的状态
 type DatabaseInstanceReconciler struct {
 	// 通过嵌入 client.Client 类型，获得 Client 接口的所有方法
@@ -44,11 +69,28 @@ type DatabaseInstanceReconciler struct {
 	// Scheme 是 controller-runtime 提供的用于将资源对象的类型与其 JSON 或 YAML 表示之间进行映射的对象
 	// 在 Reconciler 中使用 Scheme 可确保正确处理资源的类型和转换
 	Scheme *runtime.Scheme
+	// Executor 用于在数据库容器中执行命令，例如轮换管理员密码；为 nil 时无法执行需要进入数据库的操作
+	Executor helpers.PodExecutor
+	// Recorder 用于在实例上记录故障转移等 Kubernetes 事件，为 nil 时不记录事件
+	Recorder record.EventRecorder
+	// BackupVolume 是备份卷的默认存储类和容量
+	BackupVolume helpers.BackupVolumeDefaults
 }
 
 // +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaseinstances,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaseinstances/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaseinstances/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaserestores,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services;secrets;persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,7 +106,7 @@ func (r *DatabaseInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	logger.Info("开始处理 Reconcile", "资源名称", req.NamespacedName)
 
 	// 获取当前的 DatabaseInstance 实例
-	var dbInstance databasev1.DatabaseInstance
+	var dbInstance databasev2.DatabaseInstance
 	if err := r.Get(ctx, req.NamespacedName, &dbInstance); err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("未找到 DatabaseInstance", "资源名称", req.NamespacedName)
@@ -74,74 +116,440 @@ func (r *DatabaseInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, err
 	}
 
+	// 实例正在被删除，按照删除策略处理数据和备份
+	if !dbInstance.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, &dbInstance)
+	}
+
+	// 添加 Finalizer，确保删除时能够先执行删除策略
+	if controllerutil.AddFinalizer(&dbInstance, databaseInstanceFinalizer) {
+		if err := r.Update(ctx, &dbInstance); err != nil {
+			logger.Error(err, "添加 Finalizer 失败")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 根据数据库类型获取引擎驱动，不支持的类型无法通过重试恢复，只记录到状态中
+	eng, err := engine.Get(string(dbInstance.Spec.Engine.Type))
+	if err != nil {
+		logger.Error(err, "不支持的数据库类型", "engine.type", dbInstance.Spec.Engine.Type)
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "UnsupportedDatabaseType", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// 生成镜像名称
-	image := helpers.GenerateImageName(dbInstance.Spec.Image, dbInstance.Spec.DatabaseType, dbInstance.Spec.Version)
+	image := helpers.GenerateImageName(dbInstance.Spec.Engine.Image, string(dbInstance.Spec.Engine.Type), dbInstance.Spec.Engine.Version)
 
 	// 提取参数
 	instanceName := dbInstance.Name
 	namespace := dbInstance.Namespace
-	replicas := dbInstance.Spec.Replicas
-	databaseType := dbInstance.Spec.DatabaseType
+	replicas := dbInstance.Spec.Topology.Replicas
+
+	// 处理凭据 Secret，数据库容器和备份任务都从中读取凭据
+	// 用户提供的 Secret 只做校验，Secret 可能稍后才创建，因此校验失败时定期重试
+	secret := helpers.CredentialsSecretRef(&dbInstance, eng)
+	if dbInstance.Spec.Credentials.ExistingSecretRef != nil {
+		if err := helpers.ValidateExistingSecret(ctx, r.Client, namespace, secret, eng); err != nil {
+			if !stderrors.Is(err, helpers.ErrInvalidCredentials) {
+				return ctrl.Result{}, err
+			}
+			logger.Error(err, "凭据 Secret 无效", "Secret.Name", secret.Name)
+			if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidCredentials", err.Error()); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: statusRequeueInterval}, nil
+		}
+	} else {
+		passwordLength := helpers.PasswordLength(dbInstance.Spec.Credentials)
+		if _, err := helpers.GetOrCreateSecret(ctx, r.Client, &dbInstance, namespace, secret, eng, passwordLength); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 通过 v1 写入、无法转换为 v2 的资源数量只保留在注解中，不能静默地回退为默认值
+	unconvertible, err := databasev1.UnconvertibleFieldsOf(&dbInstance)
+	if err == nil {
+		switch {
+		case unconvertible.Storage != "":
+			err = fmt.Errorf("invalid storage %q", unconvertible.Storage)
+		case unconvertible.Resources != (databasev1.Resources{}):
+			err = fmt.Errorf("invalid resources %+v", unconvertible.Resources)
+		}
+	}
+	if err != nil {
+		logger.Error(err, "v1 资源数量无效")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidV1Spec", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 解析每个副本的数据卷配置，spec.storage 无效时同样只记录到状态中
+	storage, err := helpers.NewStorageConfig(dbInstance.Spec)
+	if err != nil {
+		logger.Error(err, "数据卷配置无效", "storage.size", dbInstance.Spec.Storage.Size)
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidStorage", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 解析并校验 spec.resources，数据库容器和备份任务使用相同的资源请求和限制
+	resources, err := helpers.NewResourceRequirements(dbInstance.Spec.Resources)
+	if err != nil {
+		logger.Error(err, "资源配置无效")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidResources", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 解析管理员密码的轮换计划
+	rotation, err := helpers.NewRotationSchedule(dbInstance.Spec.Credentials)
+	if err != nil {
+		logger.Error(err, "凭据轮换配置无效")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidCredentialRotation", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
 
-	// 处理 Secret
-	secretName := instanceName + "-secret"
-	_, err := helpers.GetOrCreateSecret(ctx, r.Client, secretName, namespace, databaseType)
+	// 解析备份验证计划
+	verifySchedule, err := helpers.NewVerifySchedule(dbInstance.Spec.Backup)
 	if err != nil {
+		logger.Error(err, "备份验证配置无效")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidBackupVerification", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 解析持续归档配置
+	archive, err := helpers.NewArchiveConfig(dbInstance.Spec.Backup)
+	if err != nil {
+		logger.Error(err, "持续归档配置无效")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "InvalidArchive", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 创建或更新 Headless Service，为 StatefulSet 的副本提供稳定的网络标识
+	headlessService := helpers.NewHeadlessService(instanceName, namespace, eng)
+	if err := ctrl.SetControllerReference(&dbInstance, headlessService, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := helpers.EnsureService(ctx, r.Client, headlessService); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// 创建或更新 Deployment
-	deployment := helpers.NewDeployment(instanceName, namespace, image, replicas, databaseType)
-	if err := helpers.EnsureDeployment(ctx, r.Client, deployment); err != nil {
+	// 旧版本以 Deployment 部署实例，数据保存在共享的 NFS 目录中，用户迁移数据并删除 Deployment 之前不创建 StatefulSet
+	legacy, err := helpers.LegacyDeploymentActive(ctx, r.Client, &dbInstance)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if legacy {
+		return ctrl.Result{RequeueAfter: statusRequeueInterval}, nil
+	}
+
+	// 定时备份、持续归档和按时间点恢复写入或读取 PVC 时确保备份卷存在，数据库 Pod 可能需要挂载它
+	if helpers.BackupDestinationRequired(&dbInstance) {
+		if err := helpers.EnsureBackupVolume(ctx, r.Client, &dbInstance, r.BackupVolume); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
-	// 创建或更新 Service
-	service := helpers.NewService(instanceName, namespace, databaseType)
+	// 创建或更新 StatefulSet，按需添加持续归档的边车容器和按时间点恢复的 init 容器
+	statefulSet := helpers.NewStatefulSet(instanceName, namespace, image, replicas, storage, resources, secret, eng)
+	if archive != nil {
+		err = helpers.UseArchiving(statefulSet, &dbInstance, *archive, secret, eng)
+	}
+	if err == nil {
+		err = helpers.UsePointInTimeRecovery(statefulSet, &dbInstance, resources, eng)
+	}
+	if err != nil {
+		logger.Error(err, "无法开启持续归档或按时间点恢复")
+		if err := helpers.MarkDatabaseInstanceFailed(ctx, r.Client, &dbInstance, "UnsupportedArchive", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	// 副本数大于 1 且引擎支持复制时，为数据库容器追加复制参数
+	helpers.UseReplication(statefulSet, &dbInstance, eng)
+	if err := ctrl.SetControllerReference(&dbInstance, statefulSet, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := helpers.EnsureStatefulSet(ctx, r.Client, statefulSet); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 创建或更新 Service，开启复制时只选择主库
+	service := helpers.NewService(instanceName, namespace, dbInstance.Spec.Networking, eng)
+	helpers.UsePrimarySelector(service, &dbInstance, eng)
+	if err := ctrl.SetControllerReference(&dbInstance, service, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
 	if err := helpers.EnsureService(ctx, r.Client, service); err != nil {
 		return ctrl.Result{}, err
 	}
+	// 创建按角色划分的 Service（<实例名>-rw、-ro、-r），并在状态中记录它们的地址
+	if err := helpers.EnsureRoleServices(ctx, r.Client, &dbInstance, eng); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	// 创建或更新 CronJob
-	if dbInstance.Spec.BackupPolicy.Enabled {
-		// 获取备份镜像，如果 BackupPolicy 中指定了镜像，则使用它，否则使用 dbInstance.Spec.BackupImage
-		backupImage := dbInstance.Spec.BackupPolicy.BackupImage
-		if backupImage == "" {
-			backupImage = dbInstance.Spec.BackupImage // 如果 BackupImage 为空，则使用默认的备份镜像
-		}
-
+	if dbInstance.Spec.Backup.Enabled {
 		cronJob := helpers.NewCronJob(
-			instanceName+"-backup",
+			instanceName,
 			namespace,
-			backupImage, // 使用备份镜像
-			dbInstance.Spec.BackupPolicy.Schedule,
-			databaseType,
+			helpers.BackupImageName(&dbInstance), // 使用备份镜像
+			dbInstance.Spec.Backup.Schedule,
+			resources,
+			secret,
+			eng,
+			helpers.BackupTargetOf(&dbInstance),
+			helpers.BackupCodecOf(&dbInstance),
 		)
+		if err := ctrl.SetControllerReference(&dbInstance, cronJob, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
 		if err := helpers.EnsureCronJob(ctx, r.Client, cronJob); err != nil {
 			return ctrl.Result{}, err
 		}
+
+		// 记录成功的定时备份，并按照保留策略清理过期的备份
+		if err := helpers.EnforceBackupRetention(ctx, r.Client, &dbInstance, helpers.BackupImageName(&dbInstance)); err != nil {
+			logger.Error(err, "执行备份保留策略失败")
+			return ctrl.Result{}, err
+		}
 	} else {
 		// 如果备份策略未启用，确保没有存在的 CronJob
-		if err := helpers.DeleteCronJob(ctx, r.Client, instanceName+"-backup", namespace); err != nil {
+		if err := helpers.DeleteCronJob(ctx, r.Client, helpers.BackupCronJobName(instanceName), namespace); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	// 更新 DatabaseInstance 状态
+	// 按计划将最新的定时备份导入临时实例进行验证，未启用定时备份时停止验证
+	if !dbInstance.Spec.Backup.Enabled {
+		verifySchedule = nil
+	}
+	verifyAfter, err := helpers.VerifyBackups(ctx, r.Client, &dbInstance, verifySchedule, resources, secret, eng)
+	if err != nil {
+		logger.Error(err, "验证备份失败")
+		return ctrl.Result{}, err
+	}
+
+	// 到期时在数据库内轮换管理员密码，成功后再更新 Secret
+	rotateAfter, err := helpers.RotateCredentials(ctx, r.Client, r.Executor, &dbInstance, rotation, secret, eng)
+	if err != nil {
+		logger.Error(err, "轮换凭据失败")
+		return ctrl.Result{}, err
+	}
+
+	// 副本数大于 1 时配置主库和副本之间的复制，并记录各副本的复制状态
+	replicationAfter, err := helpers.EnsureReplication(ctx, r.Client, r.Executor, r.Recorder, &dbInstance, secret, eng)
+	if err != nil {
+		logger.Error(err, "配置复制失败")
+		return ctrl.Result{}, err
+	}
+
+	// 根据实际部署的子资源更新 DatabaseInstance 状态
 	if err := helpers.UpdateDatabaseInstanceStatus(ctx, r.Client, &dbInstance); err != nil {
 		logger.Error(err, "更新 DatabaseInstance 状态失败")
 		return ctrl.Result{}, err
 	}
 
-	// 返回 Reconcile 结果
+	// 新实例首次运行后从 spec.bootstrap.fromBackup 导入初始数据，或者记录按时间点恢复的结果
+	if err := helpers.EnsureBootstrap(ctx, r.Client, &dbInstance); err != nil {
+		logger.Error(err, "导入初始数据失败")
+		return ctrl.Result{}, err
+	}
+
+	// 凭据轮换、备份验证和复制检查取较早的到期时间
+	requeueAfter := rotateAfter
+	for _, after := range []time.Duration{verifyAfter, replicationAfter} {
+		if after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+			requeueAfter = after
+		}
+	}
+
+	// 实例尚未稳定运行时定期重新调和，以便及时反映副本的变化
+	if dbInstance.Status.Phase != databasev2.PhaseRunning && (requeueAfter == 0 || requeueAfter > statusRequeueInterval) {
+		return ctrl.Result{RequeueAfter: statusRequeueInterval}, nil
+	}
+
+	// 有待处理的凭据轮换、备份验证或复制检查时，在到期时重新调和
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reconcileDelete 按照 spec.deletionPolicy 处理实例的数据和备份，完成后移除 Finalizer
+//   - Delete：删除 StatefulSet 和数据卷 PVC，并通过 Job 删除实例的全部备份，其余子资源由垃圾回收清理
+//   - Retain：解除 PVC 和 Secret 与实例的从属关系，使它们在实例删除后被保留
+//   - Snapshot：先执行最终备份，成功后删除数据卷，仅保留备份卷
+func (r *DatabaseInstanceReconciler) reconcileDelete(ctx context.Context, dbInstance *databasev2.DatabaseInstance) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(dbInstance, databaseInstanceFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	policy := dbInstance.Spec.DeletionPolicy
+	if policy == "" {
+		policy = databasev2.DeletionPolicyRetain
+	}
+	logger.Info("开始执行删除策略", "deletionPolicy", policy)
+
+	switch policy {
+	case databasev2.DeletionPolicySnapshot:
+		done, err := r.takeFinalSnapshot(ctx, dbInstance)
+		if err != nil || !done {
+			return ctrl.Result{RequeueAfter: deletionRequeueInterval}, err
+		}
+		if err := helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "RemovingData", "最终备份已完成，正在删除数据卷"); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := helpers.DeleteDataVolumes(ctx, r.Client, dbInstance.Name, dbInstance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := helpers.DetachRetainedResources(ctx, r.Client, dbInstance, false); err != nil {
+			return ctrl.Result{}, err
+		}
+	case databasev2.DeletionPolicyDelete:
+		if err := helpers.DeleteDataVolumes(ctx, r.Client, dbInstance.Name, dbInstance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		}
+		done, err := r.removeBackups(ctx, dbInstance)
+		if err != nil || !done {
+			return ctrl.Result{RequeueAfter: deletionRequeueInterval}, err
+		}
+	default:
+		if err := helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "DetachingRetainedResources", "正在保留数据卷和凭据"); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := helpers.DetachRetainedResources(ctx, r.Client, dbInstance, true); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(dbInstance, databaseInstanceFinalizer)
+	if err := r.Update(ctx, dbInstance); err != nil {
+		logger.Error(err, "移除 Finalizer 失败")
+		return ctrl.Result{}, err
+	}
+	logger.Info("删除策略执行完成", "deletionPolicy", policy)
 	return ctrl.Result{}, nil
 }
 
+// takeFinalSnapshot 执行删除前的最终备份，返回备份是否已经成功完成
+// 备份失败时保留 Finalizer 并在状态中说明，可以修正问题后等待重试，或将删除策略改为 Delete/Retain 继续删除
+func (r *DatabaseInstanceReconciler) takeFinalSnapshot(ctx context.Context, dbInstance *databasev2.DatabaseInstance) (bool, error) {
+	eng, err := engine.Get(string(dbInstance.Spec.Engine.Type))
+	if err != nil {
+		// 不支持的数据库类型从未创建过工作负载，没有可以备份的数据
+		return true, nil
+	}
+	resources, err := helpers.NewResourceRequirements(dbInstance.Spec.Resources)
+	if err != nil {
+		resources = corev1.ResourceRequirements{}
+	}
+
+	job := helpers.NewFinalBackupJob(dbInstance.Name, dbInstance.Namespace, helpers.BackupImageName(dbInstance), resources, helpers.CredentialsSecretRef(dbInstance, eng), eng, helpers.BackupTargetOf(dbInstance), helpers.BackupCodecOf(dbInstance))
+	if err := ctrl.SetControllerReference(dbInstance, job, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := helpers.EnsureBackupVolume(ctx, r.Client, dbInstance, r.BackupVolume); err != nil {
+		return false, err
+	}
+	finished, succeeded, err := helpers.EnsureFinalBackupJob(ctx, r.Client, job)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case !finished:
+		return false, helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "FinalBackupRunning", "正在执行删除前的最终备份 "+job.Name)
+	case !succeeded:
+		return false, helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "FinalBackupFailed",
+			"最终备份 "+job.Name+" 执行失败，删除 Job 可重试，或将 deletionPolicy 改为 Delete/Retain 继续删除")
+	}
+	return true, nil
+}
+
+// removeBackups 删除实例在备份卷或对象存储中的全部备份，返回清理是否已经成功完成
+// 清理失败时保留 Finalizer 并在状态中说明，可以修正问题后删除 Job 重试，或将删除策略改为 Retain 继续删除
+func (r *DatabaseInstanceReconciler) removeBackups(ctx context.Context, dbInstance *databasev2.DatabaseInstance) (bool, error) {
+	target := helpers.BackupTargetOf(dbInstance)
+	if target.S3 == nil {
+		// 备份卷不存在时实例从未写入过备份，没有需要清理的内容
+		pvc := &corev1.PersistentVolumeClaim{}
+		err := r.Get(ctx, client.ObjectKey{Name: target.ClaimName, Namespace: dbInstance.Namespace}, pvc)
+		if errors.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	job, err := helpers.NewBackupCleanupJob(dbInstance.Name, dbInstance.Namespace, helpers.BackupImageName(dbInstance), target)
+	if err != nil {
+		return false, err
+	}
+	if err := ctrl.SetControllerReference(dbInstance, job, r.Scheme); err != nil {
+		return false, err
+	}
+	finished, succeeded, err := helpers.EnsureBackupCleanupJob(ctx, r.Client, job)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case !finished:
+		return false, helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "RemovingData", "数据卷已删除，正在清理备份 "+job.Name)
+	case !succeeded:
+		return false, helpers.MarkDatabaseInstanceDeleting(ctx, r.Client, dbInstance, "BackupCleanupFailed",
+			"备份清理 "+job.Name+" 执行失败，删除 Job 可重试，或将 deletionPolicy 改为 Retain 保留备份并继续删除")
+	}
+	return true, nil
+}
+
 // SetupWithManager 将控制器与 Manager 管理器进行配置和绑定
 // 通过这种配置，我们自定义的控制器 DatabaseInstanceReconciler 就能够获取到 DatabaseInstance 自定义资源的状态变化事件通知
 // 并根据这些通知执行 Reconcile 方法来调整资源的状态，完成调节的动作
+// 子资源被修改或删除时同样会触发所属实例的调和，备份 PVC 由多个实例共享，因此匹配所有 Owner
 func (r *DatabaseInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&databasev1.DatabaseInstance{}).
+		For(&databasev2.DatabaseInstance{}).
+		Owns(&k8sappsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&batchv1.CronJob{}).
+		Owns(&batchv1.Job{}).
+		Owns(&corev1.Secret{}).
+		Owns(&databasev2.DatabaseRestore{}).
+		Owns(&corev1.PersistentVolumeClaim{}, builder.MatchEveryOwner).
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(r.backupJobToInstance)).
 		Complete(r)
 }
+
+// backupJobToInstance 将 CronJob 创建的备份 Job 映射到所属的 DatabaseInstance，备份结果变化时更新 BackupHealthy 条件
+// Job 由 CronJob 控制，CronJob 再由 DatabaseInstance 控制，因此需要沿着两级 OwnerReference 查找
+func (r *DatabaseInstanceReconciler) backupJobToInstance(ctx context.Context, obj client.Object) []reconcile.Request {
+	jobOwner := metav1.GetControllerOf(obj)
+	if jobOwner == nil || jobOwner.Kind != "CronJob" {
+		return nil
+	}
+
+	var cronJob batchv1.CronJob
+	if err := r.Get(ctx, client.ObjectKey{Name: jobOwner.Name, Namespace: obj.GetNamespace()}, &cronJob); err != nil {
+		return nil
+	}
+
+	instanceOwner := metav1.GetControllerOf(&cronJob)
+	if instanceOwner == nil || instanceOwner.Kind != "DatabaseInstance" {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: client.ObjectKey{Name: instanceOwner.Name, Namespace: obj.GetNamespace()}},
+	}
+}
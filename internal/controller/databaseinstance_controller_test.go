@@ -18,16 +18,23 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	k8sappsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
 )
 
 var _ = Describe("DatabaseInstance Controller", func() {
@@ -40,20 +47,20 @@ var _ = Describe("DatabaseInstance Controller", func() {
 			Name:      resourceName,
 			Namespace: "default", // TODO(user):Modify as needed
 		}
-		databaseinstance := &appsv1.DatabaseInstance{}
+		databaseinstance := &appsv2.DatabaseInstance{}
 
 		BeforeEach(func() {
 			By("Creating the custom resource for the Kind DatabaseInstance")
 			err := k8sClient.Get(ctx, typeNamespacedName, databaseinstance)
 			if err != nil && errors.IsNotFound(err) {
-				resource := &appsv1.DatabaseInstance{
+				resource := &appsv2.DatabaseInstance{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      resourceName,
 						Namespace: "default",
 					},
 					// TODO(user): Specify other spec details if needed.
-					Spec: appsv1.DatabaseInstanceSpec{
-						DatabaseType: "mysql", // 设置为支持的数据库类型
+					Spec: appsv2.DatabaseInstanceSpec{
+						Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL}, // 设置为支持的数据库类型
 					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
@@ -62,12 +69,23 @@ var _ = Describe("DatabaseInstance Controller", func() {
 
 		AfterEach(func() {
 			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &appsv1.DatabaseInstance{}
+			resource := &appsv2.DatabaseInstance{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
 			By("Cleanup the specific resource instance DatabaseInstance")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			By("Running the deletion policy so that the finalizer is removed")
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))).To(BeTrue())
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
@@ -80,8 +98,909 @@ var _ = Describe("DatabaseInstance Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			By("Reporting the observed state instead of a fixed Running phase")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+			// envtest 中没有 StatefulSet 控制器，副本永远不会就绪
+			Expect(resource.Status.Phase).NotTo(Equal(appsv2.PhaseRunning))
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, appsv2.ConditionAvailable)).To(BeFalse())
+
+			By("Setting the DatabaseInstance as the controller of the generated children")
+			statefulSet := &k8sappsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			Expect(metav1.IsControlledBy(statefulSet, resource)).To(BeTrue())
+
+			service := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, service)).To(Succeed())
+			Expect(metav1.IsControlledBy(service, resource)).To(BeTrue())
+			Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+
+			By("Creating a per-instance Secret and wiring it into the database container")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-secret", Namespace: "default"}, secret)).To(Succeed())
+			Expect(metav1.IsControlledBy(secret, resource)).To(BeTrue())
+			Expect(string(secret.Data["mysql-user"])).To(Equal("root"))
+			Expect(string(secret.Data["mysql-password"])).To(MatchRegexp(`^[A-Za-z0-9]{24}$`))
+
+			env := statefulSet.Spec.Template.Spec.Containers[0].Env
+			Expect(env).To(ContainElement(HaveField("Name", "MYSQL_ROOT_PASSWORD")))
+			for _, e := range env {
+				if e.Name == "MYSQL_ROOT_PASSWORD" {
+					Expect(e.ValueFrom.SecretKeyRef.Name).To(Equal(secret.Name))
+				}
+			}
+
+			By("Adding the finalizer and defaulting the deletion policy to Retain")
+			Expect(resource.Finalizers).To(ContainElement(databaseInstanceFinalizer))
+			Expect(resource.Spec.DeletionPolicy).To(Equal(appsv2.DeletionPolicyRetain))
+		})
+	})
+
+	Context("When upgrading an instance that an older version deployed as a Deployment", func() {
+		const resourceName = "legacy"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating the Deployment of the older version and the DatabaseInstance")
+			labels := map[string]string{"app": resourceName}
+			deployment := &k8sappsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: k8sappsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: resourceName, Image: "mysql:8.0"}},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			deployment := &k8sappsv1.Deployment{}
+			if err := k8sClient.Get(ctx, typeNamespacedName, deployment); err == nil {
+				Expect(k8sClient.Delete(ctx, deployment)).To(Succeed())
+			}
+
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should keep the Deployment and its data until the user migrates", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(statusRequeueInterval))
+
+			By("Leaving the Deployment in place instead of replacing it with empty volumes")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &k8sappsv1.Deployment{})).To(Succeed())
+			err = k8sClient.Get(ctx, typeNamespacedName, &k8sappsv1.StatefulSet{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("Marking the instance with the MigrationRequired condition")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionMigrationRequired)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal("LegacyDeployment"))
+
+			By("Creating the StatefulSet once the user has deleted the Deployment")
+			deployment := &k8sappsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, deployment)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, deployment)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &k8sappsv1.StatefulSet{})).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionMigrationRequired)).To(BeNil())
+		})
+	})
+
+	Context("When deleting a resource", func() {
+		ctx := context.Background()
+
+		// createInstance 创建并调和一个使用 policy 删除策略的实例，envtest 中没有 StatefulSet 控制器，因此手动创建第一个副本的数据卷
+		createInstance := func(name string, policy appsv2.DeletionPolicy) (*DatabaseInstanceReconciler, types.NamespacedName) {
+			key := types.NamespacedName{Name: name, Namespace: "default"}
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine:         appsv2.EngineSpec{Type: appsv2.EngineMySQL},
+					DeletionPolicy: policy,
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "data-" + name + "-0",
+					Namespace: "default",
+					Labels:    map[string]string{"app": name},
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: k8sresource.MustParse("1Gi")},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pvc)).To(Succeed())
+
+			Expect(k8sClient.Get(ctx, key, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			return controllerReconciler, key
+		}
+
+		// dataVolumeDeleted 返回数据卷是否已被删除，envtest 中 PVC 带有 kubernetes.io/pvc-protection Finalizer 时只会被标记删除
+		dataVolumeDeleted := func(key types.NamespacedName) bool {
+			pvc := &corev1.PersistentVolumeClaim{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: "data-" + key.Name + "-0", Namespace: key.Namespace}, pvc)
+			return errors.IsNotFound(err) || !pvc.DeletionTimestamp.IsZero()
+		}
+
+		It("should wait for the final backup before removing the data with the Snapshot policy", func() {
+			controllerReconciler, key := createInstance("snapshot", appsv2.DeletionPolicySnapshot)
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Writing the final backup with the same script and layout as regular backups")
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-final-backup", Namespace: key.Namespace}, job)).To(Succeed())
+			script := job.Spec.Template.Spec.Containers[0].Command[2]
+			Expect(script).To(ContainSubstring("file=/backup/snapshot/snapshot-final.sql"))
+			Expect(script).To(ContainSubstring(`trap 'rm -f "$file.partial"' EXIT`))
+			Expect(script).To(ContainSubstring("/dev/termination-log"))
+
+			By("Keeping the finalizer and the data while the final backup runs")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, key, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(databaseInstanceFinalizer))
+			Expect(resource.Status.Phase).To(Equal(appsv2.PhaseDeleting))
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionTerminating).Reason).To(Equal("FinalBackupRunning"))
+			Expect(dataVolumeDeleted(key)).To(BeFalse())
+
+			By("Removing the data volumes once the final backup has succeeded")
+			now := metav1.Now()
+			job.Status.StartTime = &now
+			job.Status.CompletionTime = &now
+			job.Status.Succeeded = 1
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dataVolumeDeleted(key)).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &k8sappsv1.StatefulSet{}))).To(BeTrue())
+
+			By("Leaving the Secret to the garbage collector together with the instance")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-secret", Namespace: key.Namespace}, secret)).To(Succeed())
+			Expect(secret.OwnerReferences).NotTo(BeEmpty())
+			Expect(secret.Annotations).NotTo(HaveKey("apps.leqiutong.xyz/retained-from"))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, resource))).To(BeTrue())
+		})
+
+		It("should remove the data volumes and the backups with the Delete policy", func() {
+			controllerReconciler, key := createInstance("delete", appsv2.DeletionPolicyDelete)
+			backupVolume := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      key.Name + "-backup-pvc",
+					Namespace: key.Namespace,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: k8sresource.MustParse("1Gi")},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, backupVolume)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dataVolumeDeleted(key)).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &k8sappsv1.StatefulSet{}))).To(BeTrue())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-final-backup", Namespace: key.Namespace}, &batchv1.Job{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("Removing the instance's backup directory from the backup volume")
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-backup-cleanup", Namespace: key.Namespace}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"rm", "-rf", "/backup/delete"}))
+			Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("PersistentVolumeClaim.ClaimName", key.Name+"-backup-pvc")))
+
+			By("Keeping the finalizer until the backups are removed")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, key, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(databaseInstanceFinalizer))
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionTerminating).Reason).To(Equal("RemovingData"))
+
+			By("Reporting a failed cleanup instead of dropping the finalizer")
+			now := metav1.Now()
+			job.Status.StartTime = &now
+			job.Status.Failed = 3
+			job.Status.Conditions = []batchv1.JobCondition{
+				{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded},
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded},
+			}
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, key, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(databaseInstanceFinalizer))
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionTerminating).Reason).To(Equal("BackupCleanupFailed"))
+
+			By("Releasing the instance once a retried cleanup has succeeded")
+			Expect(k8sClient.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))).To(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(job), &batchv1.Job{}))
+			}).Should(BeTrue())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			job = &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-backup-cleanup", Namespace: key.Namespace}, job)).To(Succeed())
+			now = metav1.Now()
+			job.Status.StartTime = &now
+			job.Status.CompletionTime = &now
+			job.Status.Succeeded = 1
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &appsv2.DatabaseInstance{}))).To(BeTrue())
+		})
+
+		It("should detach the data volumes and the Secret with the Retain policy", func() {
+			controllerReconciler, key := createInstance("retain", appsv2.DeletionPolicyRetain)
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(dataVolumeDeleted(key)).To(BeFalse())
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: key.Name + "-secret", Namespace: key.Namespace}, secret)).To(Succeed())
+			Expect(secret.OwnerReferences).To(BeEmpty())
+			Expect(secret.Annotations).To(HaveKeyWithValue("apps.leqiutong.xyz/retained-from", key.Name))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &appsv2.DatabaseInstance{}))).To(BeTrue())
+		})
+	})
+
+	Context("When creating a resource with an unsupported engine type", func() {
+		const resourceName = "unsupported"
+
+		ctx := context.Background()
+
+		It("should be rejected by the API server instead of falling back to MySQL", func() {
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: "sqlite"},
+				},
+			}
+			err := k8sClient.Create(ctx, resource)
+			Expect(errors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.engine.type"))
+		})
+	})
+
+	Context("When reconciling a resource with an invalid credential rotation schedule", func() {
+		const resourceName = "invalid-rotation"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating the custom resource with an unparsable rotation schedule")
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL},
+					Credentials: appsv2.CredentialsSpec{
+						Rotation: &appsv2.CredentialRotation{
+							Schedule: "every ninety days",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should mark the resource as Failed without rotating anything", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(appsv2.PhaseFailed))
+			condition := meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionDegraded)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(Equal("InvalidCredentialRotation"))
+			Expect(resource.Status.Credentials).To(BeNil())
+		})
+	})
+
+	Context("When reconciling a resource with an existing credentials Secret", func() {
+		const resourceName = "byo-credentials"
+		const secretName = "byo-credentials-admin"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating the user provided Secret with custom key names")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: "default",
+				},
+				StringData: map[string]string{
+					"password": "s3cr3t-with-$pecial'chars",
+				},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL},
+					Credentials: appsv2.CredentialsSpec{
+						ExistingSecretRef: &appsv2.ExistingSecretRef{
+							Name:        secretName,
+							PasswordKey: "password",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Leaving the user provided Secret untouched")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: "default"}, secret)).To(Succeed())
+			Expect(secret.OwnerReferences).To(BeEmpty())
+			Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+		})
+
+		It("should use the referenced Secret instead of generating one", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			generated := &corev1.Secret{}
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-secret", Namespace: "default"}, generated)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			statefulSet := &k8sappsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			var rootPassword *corev1.EnvVar
+			for i, e := range statefulSet.Spec.Template.Spec.Containers[0].Env {
+				if e.Name == "MYSQL_ROOT_PASSWORD" {
+					rootPassword = &statefulSet.Spec.Template.Spec.Containers[0].Env[i]
+				}
+			}
+			Expect(rootPassword).NotTo(BeNil())
+			Expect(rootPassword.ValueFrom.SecretKeyRef.Name).To(Equal(secretName))
+			Expect(rootPassword.ValueFrom.SecretKeyRef.Key).To(Equal("password"))
+		})
+	})
+
+	Context("When verifying scheduled backups", func() {
+		const resourceName = "verified"
+		const location = "pvc://verified-backup-pvc/verified/verified-20260101T020000Z.sql"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating an instance whose last verification is overdue")
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL, Version: "8.0"},
+					Backup: appsv2.BackupSpec{
+						Enabled:  true,
+						Schedule: "0 2 * * *",
+						Verify: &appsv2.BackupVerification{
+							Schedule: "0 6 * * *",
+							Queries:  []string{"SELECT 1 FROM app.orders LIMIT 1"},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+
+			resource.Status.Backup = &appsv2.BackupStatus{
+				Artifacts: []appsv2.BackupArtifact{{
+					JobName:        resourceName + "-backup-29000000",
+					Location:       location,
+					Checksum:       "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+					CompletionTime: metav1.Now(),
+				}},
+				LastVerified: &appsv2.BackupVerificationResult{
+					Location:       "pvc://verified-backup-pvc/verified/verified-20251201T020000Z.sql",
+					Succeeded:      true,
+					CompletionTime: metav1.NewTime(time.Now().Add(-48 * time.Hour)),
+				},
+			}
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			// envtest 中没有垃圾回收，需要手动清理
+			background := client.PropagationPolicy(metav1.DeletePropagationBackground)
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-backup-verify", Namespace: "default"},
+			}, background))).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-backup-pvc", Namespace: "default"},
+			}))).To(Succeed())
+
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should restore the latest backup into a temporary database", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-backup-verify", Namespace: "default"}, job)).To(Succeed())
+			Expect(metav1.IsControlledBy(job, resource)).To(BeTrue())
+			Expect(job.Annotations).To(HaveKeyWithValue("apps.leqiutong.xyz/verified-location", location))
+
+			By("Running the database as a native sidecar on an emptyDir")
+			podSpec := job.Spec.Template.Spec
+			Expect(podSpec.InitContainers).To(HaveLen(1))
+			database := podSpec.InitContainers[0]
+			Expect(database.Image).To(Equal("registry.leqiutong.xyz/middleware/mysql:8.0"))
+			Expect(database.RestartPolicy).To(HaveValue(Equal(corev1.ContainerRestartPolicyAlways)))
+			Expect(podSpec.Volumes).To(ContainElement(HaveField("EmptyDir", Not(BeNil()))))
+
+			By("Restoring the backup and running the check queries")
+			container := podSpec.Containers[0]
+			Expect(container.Command[2]).To(ContainSubstring("test -f /backup/verified/verified-20260101T020000Z.sql"))
+			Expect(container.Command[2]).To(ContainSubstring("sha256sum -c"))
+			Expect(container.Command[2]).To(ContainSubstring("$VERIFY_QUERY_0"))
+			Expect(container.Command[2]).NotTo(ContainSubstring("app.orders"))
+			Expect(container.Env).To(ContainElement(HaveField("Value", "SELECT 1 FROM app.orders LIMIT 1")))
+			Expect(container.Env).To(ContainElement(HaveField("Value", "127.0.0.1")))
+
+			By("Keeping the last result until the verification finishes")
+			Expect(resource.Status.Backup.LastVerified).NotTo(BeNil())
+			Expect(resource.Status.Backup.LastVerified.Location).To(HaveSuffix("20251201T020000Z.sql"))
+			Expect(resource.Status.Backup.Artifacts).To(HaveLen(1))
+		})
+	})
+
+	Context("When archiving logs and recovering to a point in time", func() {
+		const resourceName = "recovered"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating a PostgreSQL instance that recovers from another instance's archive and keeps archiving")
+			targetTime := metav1.NewTime(time.Date(2026, 10, 17, 14, 4, 0, 0, time.UTC))
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EnginePostgres, Version: "16"},
+					Backup: appsv2.BackupSpec{
+						Destination: &appsv2.BackupDestination{S3: &appsv2.S3Destination{
+							Endpoint:             "http://minio.minio.svc:9000",
+							Bucket:               "backups",
+							Prefix:               "db",
+							CredentialsSecretRef: corev1.LocalObjectReference{Name: "minio-credentials"},
+						}},
+						Archive: &appsv2.BackupArchive{},
+					},
+					Bootstrap: &appsv2.BootstrapSpec{
+						PointInTime: &appsv2.PointInTimeSource{SourceInstance: "original", TargetTime: &targetTime},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should add the archiver sidecar and the recovery init container", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := &k8sappsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			podSpec := statefulSet.Spec.Template.Spec
+
+			By("Enabling WAL archiving in the database container")
+			Expect(podSpec.Containers).To(HaveLen(2))
+			Expect(podSpec.Containers[0].Args).To(ContainElements("archive_mode=on", "archive_timeout=300s"))
+			Expect(podSpec.Containers[0].VolumeMounts).To(ContainElement(HaveField("MountPath", "/archive")))
+
+			By("Uploading the archive of this instance to object storage")
+			archiver := podSpec.Containers[1]
+			Expect(archiver.Name).To(Equal("archiver"))
+			Expect(archiver.Command[2]).To(ContainSubstring("archive=dest/backups/db/recovered/archive"))
+			Expect(archiver.Command[2]).To(ContainSubstring("pg_basebackup"))
+			Expect(archiver.Env).To(ContainElement(HaveField("Name", "S3_SECRET_ACCESS_KEY")))
+			Expect(archiver.VolumeMounts).To(ContainElement(And(HaveField("MountPath", "/var/lib/postgresql/data"), HaveField("ReadOnly", true))))
+
+			By("Recovering from the source archive before the database starts")
+			Expect(podSpec.InitContainers).To(HaveLen(2))
+			Expect(podSpec.InitContainers[0].Name).To(Equal("mc"))
+			recovery := podSpec.InitContainers[1]
+			Expect(recovery.Name).To(Equal("point-in-time-recovery"))
+			Expect(recovery.Image).To(Equal(podSpec.Containers[0].Image))
+			Expect(recovery.Command[2]).To(ContainSubstring("archive=dest/backups/db/original/archive"))
+			Expect(recovery.Command[2]).To(ContainSubstring("recovery_target_time=2026-10-17 14:04:00+00"))
+
+			By("Reporting the recovery in the Bootstrapped condition")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionBootstrapped)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("Recovering"))
+		})
+	})
+
+	Context("When running MySQL with several replicas", func() {
+		const resourceName = "replicated"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating a MySQL instance with three replicas and semi-synchronous replication")
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL, Version: "8.0"},
+					Topology: appsv2.TopologySpec{
+						Replicas:    3,
+						Replication: &appsv2.ReplicationSpec{SemiSync: &appsv2.SemiSyncSpec{}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should enable GTID replication and route the Service to the primary", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+
+			By("Enabling GTIDs and the semi-synchronous plugins in the database container")
+			statefulSet := &k8sappsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			Expect(statefulSet.Spec.Template.Spec.Containers[0].Args).To(ContainElements(
+				"--gtid-mode=ON", "--enforce-gtid-consistency=ON", "--plugin-load-add=semisync_source.so"))
+
+			By("Selecting only the primary in the Service")
+			service := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, service)).To(Succeed())
+			Expect(service.Spec.Selector).To(HaveKeyWithValue("apps.leqiutong.xyz/role", "primary"))
+
+			By("Splitting reads and writes across the -rw and -ro Services")
+			readWrite := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-rw", Namespace: "default"}, readWrite)).To(Succeed())
+			Expect(readWrite.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+			Expect(readWrite.Spec.Selector).To(HaveKeyWithValue("apps.leqiutong.xyz/role", "primary"))
+			readOnly := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-ro", Namespace: "default"}, readOnly)).To(Succeed())
+			Expect(readOnly.Spec.Selector).To(HaveKeyWithValue("apps.leqiutong.xyz/role", "replica"))
+			Expect(readOnly.Spec.Selector).To(HaveKeyWithValue("apps.leqiutong.xyz/streaming", "true"))
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-r", Namespace: "default"}, &corev1.Service{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			By("Generating the password of the replication user")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-replication", Namespace: "default"}, secret)).To(Succeed())
+			Expect(secret.Data["password"]).NotTo(BeEmpty())
+
+			By("Waiting for the primary in the ReplicationHealthy condition")
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Replication).NotTo(BeNil())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, appsv2.ConditionReplicationHealthy)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+
+			By("Publishing the Service addresses in status")
+			Expect(resource.Status.Services).NotTo(BeNil())
+			Expect(resource.Status.Services.ReadWrite).To(Equal(resourceName + "-rw.default.svc"))
+			Expect(resource.Status.Services.ReadOnly).To(Equal(resourceName + "-ro.default.svc"))
+			Expect(resource.Status.Services.Read).To(BeEmpty())
+			Expect(resource.Status.Services.Port).To(Equal(int32(3306)))
+
+			By("Not treating a primary that has never been ready as lost")
+			Expect(resource.Status.CurrentPrimary).To(BeEmpty())
+			Expect(resource.Status.Replication.PrimaryUnavailableSince).To(BeNil())
+			Expect(resource.Status.Failovers).To(BeEmpty())
+		})
+	})
+
+	Context("When running PostgreSQL with several replicas", func() {
+		const resourceName = "streaming"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("Creating a PostgreSQL instance with a synchronous standby")
+			resource := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EnginePostgres, Version: "16"},
+					Topology: appsv2.TopologySpec{
+						Replicas:    2,
+						Replication: &appsv2.ReplicationSpec{SynchronousStandbyNames: "ANY 1 (*)"},
+					},
+					Networking: appsv2.NetworkingSpec{ReadService: true},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should seed the standbys from the primary with pg_basebackup", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := &k8sappsv1.StatefulSet{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, statefulSet)).To(Succeed())
+			podSpec := statefulSet.Spec.Template.Spec
+
+			By("Keeping the WAL needed by pg_rewind and hot standby feedback")
+			Expect(podSpec.Containers[0].Args).To(ContainElements("wal_log_hints=on", "hot_standby_feedback=on"))
+
+			By("Seeding empty data volumes through the primary Service before the database starts")
+			Expect(podSpec.InitContainers).To(HaveLen(1))
+			seed := podSpec.InitContainers[0]
+			Expect(seed.Name).To(Equal("seed-replica"))
+			Expect(seed.Command[2]).To(ContainSubstring("pg_basebackup"))
+			Expect(seed.Env).To(ContainElement(And(HaveField("Name", "PRIMARY_HOST"), HaveField("Value", "streaming.default.svc"))))
+			Expect(seed.Env).To(ContainElement(HaveField("ValueFrom.SecretKeyRef.Name", "streaming-replication")))
+
+			service := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, service)).To(Succeed())
+			Expect(service.Spec.Selector).To(HaveKeyWithValue("apps.leqiutong.xyz/role", "primary"))
+
+			By("Selecting every pod in the -r Service when it is enabled")
+			read := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-r", Namespace: "default"}, read)).To(Succeed())
+			Expect(read.Spec.Selector).To(Equal(map[string]string{"app": resourceName}))
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Services.Read).To(Equal(resourceName + "-r.default.svc"))
 		})
 	})
 })
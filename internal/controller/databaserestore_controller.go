@@ -0,0 +1,189 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers"
+)
+
+// DatabaseRestoreReconciler 负责将 DatabaseRestore 引用的备份导入实例，并将导入进度记录到状态中
+type DatabaseRestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaserestores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaserestores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databaserestores/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps.leqiutong.xyz,resources=databasebackups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile 在实例运行且备份可用后创建一次性的恢复 Job，并根据 Job 的执行情况更新恢复状态
+// 恢复进入 Completed 或 Failed 阶段后不再处理，导入不是幂等的，失败后需要检查数据再创建新的 DatabaseRestore
+func (r *DatabaseRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var restore databasev2.DatabaseRestore
+	if err := r.Get(ctx, req.NamespacedName, &restore); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "获取 DatabaseRestore 失败", "资源名称", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	if restore.Status.Phase == databasev2.RestorePhaseCompleted || restore.Status.Phase == databasev2.RestorePhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	// 获取导入数据的实例，实例可能稍后才创建，因此定期重试
+	var dbInstance databasev2.DatabaseInstance
+	instanceKey := client.ObjectKey{Name: restore.Spec.InstanceRef.Name, Namespace: restore.Namespace}
+	if err := r.Get(ctx, instanceKey, &dbInstance); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "获取 DatabaseInstance 失败", "资源名称", instanceKey)
+			return ctrl.Result{}, err
+		}
+		return r.markPending(ctx, &restore, "实例 "+instanceKey.Name+" 不存在")
+	}
+
+	// 恢复归属于实例，实例被删除时一并清理
+	if !metav1.IsControlledBy(&restore, &dbInstance) {
+		if err := ctrl.SetControllerReference(&dbInstance, &restore, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, &restore); err != nil {
+			logger.Error(err, "设置 DatabaseRestore 的 OwnerReference 失败")
+			return ctrl.Result{}, err
+		}
+	}
+
+	eng, err := engine.Get(string(dbInstance.Spec.Engine.Type))
+	if err != nil {
+		return r.markFailed(ctx, &restore, err.Error())
+	}
+
+	status := restore.Status
+	var job batchv1.Job
+	jobKey := client.ObjectKey{Name: helpers.RestoreJobName(restore.Name), Namespace: restore.Namespace}
+	if err := r.Get(ctx, jobKey, &job); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "获取恢复 Job 失败", "资源名称", jobKey)
+			return ctrl.Result{}, err
+		}
+
+		// 确定要导入的备份文件，引用的 DatabaseBackup 必须已经完成
+		source := restore.Spec.Source
+		location := helpers.BackupSourceLocation(&dbInstance, source.Path)
+		var checksum string
+		var encoding *databasev2.BackupEncoding
+		if source.BackupName != "" {
+			var backup databasev2.DatabaseBackup
+			if err := r.Get(ctx, client.ObjectKey{Name: source.BackupName, Namespace: restore.Namespace}, &backup); err != nil {
+				if !errors.IsNotFound(err) {
+					logger.Error(err, "获取 DatabaseBackup 失败", "DatabaseBackup.Name", source.BackupName)
+					return ctrl.Result{}, err
+				}
+				return r.markPending(ctx, &restore, "备份 "+source.BackupName+" 不存在")
+			}
+			switch backup.Status.Phase {
+			case databasev2.BackupPhaseCompleted:
+				location, checksum, encoding = backup.Status.Location, backup.Status.Checksum, backup.Status.Encoding
+			case databasev2.BackupPhaseFailed:
+				return r.markFailed(ctx, &restore, "备份 "+backup.Name+" 执行失败，无法用于恢复")
+			default:
+				return r.markPending(ctx, &restore, "等待备份 "+backup.Name+" 完成")
+			}
+		} else {
+			// 按路径恢复时从备份清单中查找编码方式，清单中没有记录时根据扩展名推断
+			if encoding, err = helpers.BackupEncodingOf(&dbInstance, location); err != nil {
+				return r.markFailed(ctx, &restore, err.Error())
+			}
+		}
+		resources, err := helpers.NewResourceRequirements(dbInstance.Spec.Resources)
+		if err != nil {
+			resources = corev1.ResourceRequirements{}
+		}
+		desired, err := helpers.NewRestoreJob(restore.Name, dbInstance.Name, restore.Namespace, helpers.BackupImageName(&dbInstance),
+			resources, helpers.CredentialsSecretRef(&dbInstance, eng), eng, location, checksum, encoding, helpers.BackupTargetOf(&dbInstance).S3)
+		if err != nil {
+			return r.markFailed(ctx, &restore, err.Error())
+		}
+
+		// 导入需要连接数据库，等待实例运行后再开始
+		if dbInstance.Status.Phase != databasev2.PhaseRunning {
+			return r.markPending(ctx, &restore, "等待实例 "+dbInstance.Name+" 进入 Running 阶段")
+		}
+
+		if err := ctrl.SetControllerReference(&restore, desired, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		created, err := helpers.EnsureRestoreJob(ctx, r.Client, desired)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		job = *created
+		status.Location = location
+	}
+
+	status, err = helpers.ObserveRestoreJob(ctx, r.Client, &job, status)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, helpers.UpdateDatabaseRestoreStatus(ctx, r.Client, &restore, &status)
+}
+
+// markPending 将恢复标记为 Pending 并定期重新调和
+func (r *DatabaseRestoreReconciler) markPending(ctx context.Context, restore *databasev2.DatabaseRestore, message string) (ctrl.Result, error) {
+	status := restore.Status
+	status.Phase = databasev2.RestorePhasePending
+	status.Message = message
+	if err := helpers.UpdateDatabaseRestoreStatus(ctx, r.Client, restore, &status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: statusRequeueInterval}, nil
+}
+
+// markFailed 将恢复标记为 Failed，用于无法通过重试恢复的错误
+func (r *DatabaseRestoreReconciler) markFailed(ctx context.Context, restore *databasev2.DatabaseRestore, message string) (ctrl.Result, error) {
+	status := restore.Status
+	status.Phase = databasev2.RestorePhaseFailed
+	status.Message = message
+	return ctrl.Result{}, helpers.UpdateDatabaseRestoreStatus(ctx, r.Client, restore, &status)
+}
+
+// SetupWithManager 将控制器与 Manager 管理器进行配置和绑定，恢复 Job 的状态变化会触发所属 DatabaseRestore 的调和
+func (r *DatabaseRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev2.DatabaseRestore{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+var _ = Describe("DatabaseRestore Controller", func() {
+	Context("When restoring a backup into an existing instance", func() {
+		const instanceName = "restore-target"
+		const restoreName = "from-nightly"
+
+		ctx := context.Background()
+
+		instanceKey := types.NamespacedName{Name: instanceName, Namespace: "default"}
+		restoreKey := types.NamespacedName{Name: restoreName, Namespace: "default"}
+
+		BeforeEach(func() {
+			By("Creating a running instance and a DatabaseRestore from the scheduled backup file")
+			instance := &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: "default"},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EngineMySQL, Version: "8.0"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+			instance.Status.Phase = appsv2.PhaseRunning
+			Expect(k8sClient.Status().Update(ctx, instance)).To(Succeed())
+
+			Expect(k8sClient.Create(ctx, &appsv2.DatabaseRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: restoreName, Namespace: "default"},
+				Spec: appsv2.DatabaseRestoreSpec{
+					InstanceRef: corev1.LocalObjectReference{Name: instanceName},
+					Source:      appsv2.BackupSource{Path: "mydb/mydb-20260101T020000Z.sql"},
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			// envtest 中没有垃圾回收，需要手动清理
+			By("Cleanup the restore, its Job and the instance")
+			background := client.PropagationPolicy(metav1.DeletePropagationBackground)
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: restoreName + "-restore", Namespace: "default"},
+			}, background))).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &appsv2.DatabaseRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: restoreName, Namespace: "default"},
+			})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: "default"},
+			})).To(Succeed())
+		})
+
+		It("should import the dump with the engine's client", func() {
+			controllerReconciler := &DatabaseRestoreReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: restoreKey})
+			Expect(err).NotTo(HaveOccurred())
+
+			instance := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, instance)).To(Succeed())
+			restore := &appsv2.DatabaseRestore{}
+			Expect(k8sClient.Get(ctx, restoreKey, restore)).To(Succeed())
+			Expect(metav1.IsControlledBy(restore, instance)).To(BeTrue())
+			Expect(restore.Status.Phase).To(Equal(appsv2.RestorePhaseRunning))
+			Expect(restore.Status.Location).To(Equal("pvc://" + instanceName + "-backup-pvc/mydb/mydb-20260101T020000Z.sql"))
+
+			job := &batchv1.Job{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: restoreName + "-restore", Namespace: "default"}, job)).To(Succeed())
+			Expect(metav1.IsControlledBy(job, restore)).To(BeTrue())
+			Expect(*job.Spec.BackoffLimit).To(Equal(int32(0)))
+			Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("mysql -h $DB_HOST"))
+			Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("< /backup/mydb/mydb-20260101T020000Z.sql"))
+		})
+
+		It("should reject a path outside of the backup volume", func() {
+			invalid := &appsv2.DatabaseRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "escape", Namespace: "default"},
+				Spec: appsv2.DatabaseRestoreSpec{
+					InstanceRef: corev1.LocalObjectReference{Name: instanceName},
+					Source:      appsv2.BackupSource{Path: "../etc/passwd"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, invalid)).NotTo(Succeed())
+		})
+	})
+
+	Context("When bootstrapping a new instance from a backup", func() {
+		const instanceName = "bootstrapped"
+
+		ctx := context.Background()
+
+		instanceKey := types.NamespacedName{Name: instanceName, Namespace: "default"}
+
+		BeforeEach(func() {
+			Expect(k8sClient.Create(ctx, &appsv2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: "default"},
+				Spec: appsv2.DatabaseInstanceSpec{
+					Engine: appsv2.EngineSpec{Type: appsv2.EnginePostgres, Version: "16"},
+					Bootstrap: &appsv2.BootstrapSpec{
+						FromBackup: &appsv2.BackupSource{BackupName: "before-migration"},
+					},
+				},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			By("Cleanup the bootstrap restore and the instance")
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &appsv2.DatabaseRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName + "-bootstrap", Namespace: "default"},
+			}))).To(Succeed())
+
+			resource := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, resource)).To(Succeed())
+			controllerutil.RemoveFinalizer(resource, databaseInstanceFinalizer)
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+		})
+
+		It("should create an owned DatabaseRestore and report progress in the Bootstrapped condition", func() {
+			controllerReconciler := &DatabaseInstanceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: instanceKey})
+			Expect(err).NotTo(HaveOccurred())
+
+			instance := &appsv2.DatabaseInstance{}
+			Expect(k8sClient.Get(ctx, instanceKey, instance)).To(Succeed())
+			restore := &appsv2.DatabaseRestore{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: instanceName + "-bootstrap", Namespace: "default"}, restore)).To(Succeed())
+			Expect(metav1.IsControlledBy(restore, instance)).To(BeTrue())
+			Expect(restore.Spec.Source.BackupName).To(Equal("before-migration"))
+
+			condition := meta.FindStatusCondition(instance.Status.Conditions, appsv2.ConditionBootstrapped)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("RestorePending"))
+
+			By("Waiting for the referenced backup before starting the import")
+			restoreReconciler := &DatabaseRestoreReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err = restoreReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(restore), restore)).To(Succeed())
+			Expect(restore.Status.Phase).To(Equal(appsv2.RestorePhasePending))
+			Expect(restore.Status.Message).To(ContainSubstring("before-migration"))
+		})
+	})
+})
@@ -34,6 +34,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -79,6 +80,8 @@ var _ = BeforeSuite(func() {
 
 	err = appsv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = appsv2.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
 
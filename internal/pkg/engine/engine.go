@@ -0,0 +1,317 @@
+// Package engine 定义了数据库引擎驱动接口及其注册表
+// 每种数据库类型（mysql、postgres、oceanbase-ce）都以一个独立的 Engine 实现注册到这里，
+// helpers 中构建 Deployment、Service、Secret、CronJob 时统一通过注册表获取引擎的各项配置
+package engine
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// SecretRef 描述了保存数据库凭据的 Secret 及其中用户名、密码所使用的键名
+type SecretRef struct {
+	// Name 是 Secret 的名称
+	Name string
+	// UsernameKey 是用户名在 Secret 中的键名
+	UsernameKey string
+	// PasswordKey 是密码在 Secret 中的键名
+	PasswordKey string
+}
+
+// Engine 是数据库引擎驱动接口，一种数据库类型的全部差异都收敛在它的实现中
+type Engine interface {
+	// Name 返回数据库类型名称，与 spec.engine.type 的取值一致
+	Name() string
+
+	// DefaultVersion 返回 spec.version 未设置时使用的数据库版本（镜像标签）
+	DefaultVersion() string
+
+	// Port 返回数据库监听的端口
+	Port() int32
+
+	// PortName 返回容器端口和 Service 端口使用的名称
+	PortName() string
+
+	// DataPath 返回数据库数据目录在容器内的路径
+	DataPath() string
+
+	// CredentialKeys 返回 Secret 中用户名和密码的默认键名
+	CredentialKeys() (userKey, passwordKey string)
+
+	// AdminUser 返回数据库初始化时创建的管理员用户名，数据库容器和客户端都以该用户连接，
+	// Operator 生成的 Secret 使用该用户名，用户提供的 Secret 中如果包含用户名也必须与之一致
+	AdminUser() string
+
+	// ServerEnv 返回数据库容器初始化所需的环境变量，管理员密码从 secret 中读取
+	ServerEnv(secret SecretRef) []corev1.EnvVar
+
+	// ClientEnv 返回客户端（例如备份任务）连接数据库所需的环境变量，
+	// 其中 DB_HOST、DB_PORT 指向数据库地址，用户名为 AdminUser，密码从 secret 中读取
+	ClientEnv(secret SecretRef, host string) []corev1.EnvVar
+
+	// BackupCommand 返回将数据库导出到 path 的 shell 命令，依赖 ClientEnv 注入的环境变量
+	BackupCommand(path string) string
+
+	// RestoreCommand 返回从 path 导入数据的 shell 命令，依赖 ClientEnv 注入的环境变量
+	RestoreCommand(path string) string
+
+	// QueryCommand 返回执行标准输入中的 SQL 并输出不带表头的查询结果的 shell 命令，SQL 执行出错时以非零状态退出，
+	// 依赖 ClientEnv 注入的环境变量
+	QueryCommand() string
+
+	// RotatePasswordCommand 返回在数据库容器内修改用户密码的命令，用户名、当前密码和新密码依次从标准输入的前三行读取，
+	// 密码不会出现在 exec 请求的命令行参数中；新密码已经生效时命令直接成功退出，便于失败后重试。
+	// 引擎支持双密码时，旧密码在执行 DiscardOldPasswordCommand 之前仍然有效
+	RotatePasswordCommand() []string
+
+	// DiscardOldPasswordCommand 返回废弃轮换前旧密码的命令，用户名和当前密码依次从标准输入读取，引擎不支持双密码时返回 nil
+	DiscardOldPasswordCommand() []string
+
+	// Tuning 根据容器可用内存推算引擎的内存参数，返回追加到数据库容器的启动参数和环境变量
+	Tuning(memory resource.Quantity) (args []string, env []corev1.EnvVar)
+
+	// LivenessProbe 返回数据库容器的存活探针
+	LivenessProbe() *corev1.Probe
+
+	// ReadinessProbe 返回数据库容器的就绪探针
+	ReadinessProbe() *corev1.Probe
+}
+
+// Archiver 是支持持续归档和按时间点恢复的引擎需要额外实现的接口（OceanBase-CE 没有实现）。
+// 归档由数据库 Pod 中的 archiver 边车容器完成，恢复由新实例的 init 容器在数据库首次启动之前完成；
+// 日志文件和基础备份的上传、下载、压缩、加密和清理由 helpers 负责，引擎只提供与数据库交互的 shell 函数
+type Archiver interface {
+	// ArchiveArgs 返回开启归档时追加到数据库容器的启动参数，stagingDir 是数据库容器与边车容器共享的 emptyDir，
+	// switchInterval 是强制切换日志文件的最长间隔
+	ArchiveArgs(stagingDir string, switchInterval time.Duration) []string
+
+	// ArchiveFunctions 返回边车容器中定义以下 shell 函数的脚本，依赖 ClientEnv 注入的环境变量（DB_HOST 为 127.0.0.1），
+	// 数据目录以只读方式挂载在 DataPath：
+	//   ready_logs     逐行输出已经写完、等待归档的日志文件路径，按产生的顺序排列
+	//   archived_log   参数中的日志文件已经上传，之后 ready_logs 不再输出它
+	//   switch_log     上一次切换之后有新的写入时切换到新的日志文件
+	//   base_position  输出基础备份需要的第一个日志文件名，更早的日志文件对该基础备份没有用处
+	//   base_backup    将基础备份写入标准输出
+	//   base_limit     在基础备份之后执行，输出此时数据库已经执行到的位置，交给 RecoveryFunctions 中的 usable_base 判断
+	ArchiveFunctions(stagingDir string) string
+
+	// BaseBackupExtension 返回基础备份文件的扩展名，不含压缩和加密的扩展名
+	BaseBackupExtension() string
+
+	// RecoveryFunctions 返回 init 容器中定义以下 shell 函数的脚本，target 中包含引擎不支持的目标时返回错误：
+	//   usable_base LIMIT      基础备份的 base_limit 为 LIMIT 时，它是否早于恢复目标
+	//   recover BASE POSITION  用解码后的基础备份文件 BASE 初始化 shell 变量 datadir 指向的空数据目录，
+	//                          并重放 POSITION 及之后的日志文件直到恢复目标，数据库在函数返回之前停止
+	// 脚本中可以使用 helpers 定义的 archived_logs POSITION（按顺序输出 POSITION 及之后已归档的日志文件名）和 fetch_log NAME OUT
+	// （下载并解码日志文件），数据库进程可以执行 <scratchDir>/fetch-log NAME OUT，日志文件不存在时它以非零状态退出
+	RecoveryFunctions(target RecoveryTarget, scratchDir string) (string, error)
+}
+
+// RecoveryTarget 是按时间点恢复的目标，最多只有一个字段不为空，全部为空时重放全部已归档的日志
+type RecoveryTarget struct {
+	// Time 表示恢复到该时间点（含）之前提交的事务
+	Time *time.Time
+	// GTID 表示恢复到该事务（不含）之前，格式为 <server_uuid>:<序号>
+	GTID string
+	// LSN 表示恢复到该 WAL 位置（不含）之前，格式为 X/X
+	LSN string
+}
+
+// Replicator 是支持主从复制的引擎需要额外实现的接口（MySQL、PostgreSQL 实现）。副本由 StatefulSet 创建，
+// Operator 在每次调和时通过在数据库容器中执行命令配置主库和副本，命令都是幂等的，用户名、密码等从标准输入逐行读取
+type Replicator interface {
+	// ReplicationArgs 返回开启复制时追加到数据库容器的启动参数
+	ReplicationArgs(options ReplicationOptions) []string
+
+	// ReplicationUser 返回副本连接主库使用的复制用户名
+	ReplicationUser() string
+
+	// ConfigurePrimaryCommand 返回将所在节点配置为可写主库的命令：停止它自身的复制、创建复制用户，并按照 options 配置同步复制。
+	// 标准输入依次为管理员用户名、管理员密码、复制用户密码和节点编号，之后每行一个副本的节点名称（Pod 名称）；
+	// 标准输出是主库的复制位置，原样交给 ConfigureReplicaCommand
+	ConfigurePrimaryCommand(options ReplicationOptions) []string
+
+	// ConfigureReplicaCommand 返回将所在节点配置为只读副本、从主库复制的命令，已经在从该主库复制时只输出状态；
+	// 副本缺少主库已经清理的变更时重新从主库初始化数据，可能需要重启数据库或者重建 Pod（见 ReplicaState.Recreate）。
+	// 标准输入依次为管理员用户名、管理员密码、复制用户密码、节点编号、主库地址和主库的复制位置，标准输出交给 ParseReplicaStatus
+	ConfigureReplicaCommand(options ReplicationOptions) []string
+
+	// ParseReplicaStatus 解析 ConfigureReplicaCommand 的标准输出
+	ParseReplicaStatus(output string) ReplicaState
+
+	// SeedFunctions 返回在数据库首次启动之前从主库初始化副本数据的 init 容器脚本，定义以下 shell 函数，
+	// 引擎可以在运行时从主库复制数据（例如 MySQL 的 clone）时返回空字符串：
+	//   primary_ready  主库是否可以连接
+	//   seed           从主库复制数据到 shell 变量 datadir 指向的空目录，并使它以副本的身份启动
+	// 脚本中可以使用环境变量 POD_NAME（节点名称）、PRIMARY_HOST（主库地址）和 REPLICATION_PASSWORD（复制用户密码）
+	SeedFunctions() string
+
+	// PositionCommand 返回输出副本复制进度的命令，标准输入依次为管理员用户名和管理员密码；节点从未作为副本复制过时输出为空
+	PositionCommand() []string
+
+	// ParsePosition 将 PositionCommand 的输出转换为可以比较的数值，数值越大的副本收到的变更越多
+	ParsePosition(output string) (int64, error)
+
+	// PromoteCommand 返回将副本提升为主库的命令：应用完已经收到的全部变更后停止复制并允许写入，标准输入同 PositionCommand
+	PromoteCommand() []string
+
+	// FenceCommand 返回尽力阻止旧主库继续接受写入的命令，标准输入同 PositionCommand；旧主库所在的节点不可用时无法执行，
+	// Operator 还会把它从实例的 Service 中移除并删除它的 Pod
+	FenceCommand() []string
+
+	// PrimaryPositionCommand 返回输出主库已经写入的最新位置的命令，标准输入同 PositionCommand；
+	// 计划内的主从切换在 FenceCommand 之后执行它，得到新主库需要追上的位置
+	PrimaryPositionCommand() []string
+
+	// CatchUpCommand 返回在副本中等待它应用完指定位置之前的全部变更的命令，标准输入依次为管理员用户名、管理员密码和
+	// PrimaryPositionCommand 输出的位置；超过 timeout 仍未追上时以非零状态退出
+	CatchUpCommand(timeout time.Duration) []string
+}
+
+// ReplicationOptions 是 spec.topology.replication 中与引擎相关的复制配置
+type ReplicationOptions struct {
+	// SemiSyncTimeout 大于 0 时开启 MySQL 的半同步复制，是主库等待副本确认的超时时间
+	SemiSyncTimeout time.Duration
+	// SynchronousStandbyNames 是 PostgreSQL 主库的 synchronous_standby_names，为空时使用异步复制
+	SynchronousStandbyNames string
+	// Demoted 表示副本是故障转移中被隔离的旧主库，ConfigureReplicaCommand 可以丢弃它与主库不一致的数据并从主库重新初始化
+	Demoted bool
+}
+
+// ReseedMarker 是副本需要重新从主库初始化数据时在数据目录中创建的文件，init 容器发现它后清空数据目录再执行 seed
+const ReseedMarker = "replica.reseed"
+
+// ReplicaState 是副本的复制状态
+type ReplicaState struct {
+	// Streaming 表示副本是否正在从主库接收并应用变更
+	Streaming bool
+	// LagSeconds 是副本落后主库的秒数，未知时为 nil
+	LagSeconds *int64
+	// Message 说明副本没有在复制的原因
+	Message string
+	// Recreate 表示副本已经在数据目录中创建了 ReseedMarker，需要删除 Pod，由 init 容器重新从主库初始化数据
+	Recreate bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Engine{}
+)
+
+// Register 将引擎注册到注册表中，重复注册同名引擎会直接 panic
+func Register(e Engine) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[e.Name()]; ok {
+		panic("engine: duplicate registration of database type " + e.Name())
+	}
+	registry[e.Name()] = e
+}
+
+// Get 根据数据库类型获取已注册的引擎，未注册的类型返回错误而不是回退到某个默认引擎
+func Get(databaseType string) (Engine, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	e, ok := registry[databaseType]
+	if !ok {
+		return nil, errors.New("unsupported database type: " + databaseType)
+	}
+	return e, nil
+}
+
+// Names 返回所有已注册的数据库类型，按名称排序
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// secretEnv 构造一个从 Secret 中读取值的环境变量
+func secretEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secretName,
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+// endpointEnv 构造指向数据库地址的 DB_HOST、DB_PORT 环境变量
+func endpointEnv(host string, port int32) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{
+			Name:  "DB_HOST",
+			Value: host,
+		},
+		{
+			Name:  "DB_PORT",
+			Value: strconv.Itoa(int(port)),
+		},
+	}
+}
+
+// shellCommand 将多行 shell 脚本包装为容器命令，任一步骤失败时立即退出
+func shellCommand(script string) []string {
+	return []string{"sh", "-c", "set -e\n" + script}
+}
+
+// sqlStringScript 返回将 shell 变量 name 的值转义为 SQL 单引号字符串内容的脚本，结果保存在 <name>_SQL 变量中。
+// 反斜杠写成两个反斜杠，单引号写成两个单引号，用户提供的用户名和密码因此可以包含任意字符，而不会改变 SQL 语句的结构
+func sqlStringScript(name string) string {
+	return name + `_SQL=$(printf '%s' "$` + name + `" | sed -e 's/\\/\\\\/g' -e "s/'/''/g")`
+}
+
+// execProbe 构造一个执行命令的探针
+func execProbe(command string, initialDelay, period int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"sh", "-c", command},
+			},
+		},
+		InitialDelaySeconds: initialDelay,
+		PeriodSeconds:       period,
+		TimeoutSeconds:      5,
+		FailureThreshold:    3,
+	}
+}
+
+// tcpProbe 构造一个检查 TCP 端口的探针
+func tcpProbe(port int32, initialDelay, period int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromInt32(port),
+			},
+		},
+		InitialDelaySeconds: initialDelay,
+		PeriodSeconds:       period,
+		TimeoutSeconds:      5,
+		FailureThreshold:    6,
+	}
+}
+
+// fraction 返回内存数量按百分比折算后的字节数
+func fraction(memory resource.Quantity, percent int64) int64 {
+	return memory.Value() / 100 * percent
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rotating passwords", func() {
+	DescribeTable("should escape user-supplied values before they reach SQL",
+		func(value, escaped string) {
+			script := "read -r VALUE\n" + sqlStringScript("VALUE") + "\nprintf '%s' \"$VALUE_SQL\""
+			cmd := exec.Command("sh", "-c", script)
+			cmd.Stdin = strings.NewReader(value + "\n")
+			output, err := cmd.Output()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(output)).To(Equal(escaped))
+		},
+		Entry("generated passwords stay unchanged", "Ab3dEf6hIj9k", "Ab3dEf6hIj9k"),
+		Entry("single quotes are doubled", "it's'); DROP USER root; --", "it''s''); DROP USER root; --"),
+		Entry("backslashes are doubled", `a\'b\\`, `a\\''b\\\\`),
+		Entry("shell metacharacters are left to SQL", "$(id)`id`\"", "$(id)`id`\""),
+	)
+
+	It("should not splice the new password into SQL unescaped", func() {
+		for _, name := range Names() {
+			eng, err := Get(name)
+			Expect(err).NotTo(HaveOccurred())
+			script := strings.Join(eng.RotatePasswordCommand(), " ")
+			Expect(script).NotTo(ContainSubstring("'$NEW_PASSWORD'"), name)
+			Expect(script).NotTo(ContainSubstring("''$NEW_PASSWORD''"), name)
+		}
+	})
+})
+
+var _ = Describe("OceanBase backups", func() {
+	var (
+		eng Engine
+		dir string
+		env []string
+	)
+
+	// tools 是替代 obclient、obdumper 和 obloader 的脚本，把收到的参数和标准输入记录到 $LOG；
+	// obdumper 与真实的工具一样把数据库导出到 <-f 目录>/data/<数据库名>/
+	tools := map[string]string{
+		"obclient": `echo "obclient $*" >> "$LOG"
+sed 's/^/  stdin: /' >> "$LOG"
+printf '%s\n' $DATABASES`,
+		"obdumper": `echo "obdumper $*" >> "$LOG"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -D) db=$2; shift ;;
+    -f) out=$2; shift ;;
+  esac
+  shift
+done
+test "$db" != "$FAIL_DATABASE" || exit 1
+mkdir -p "$out/data/$db/TABLE"
+echo "CREATE TABLE orders (id int);" > "$out/data/$db/TABLE/orders-schema.sql"`,
+		"obloader": `echo "obloader $*" >> "$LOG"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -D) db=$2; shift ;;
+    -f) in=$2; shift ;;
+  esac
+  shift
+done
+echo "  loaded: $(ls "$in/data/$db/TABLE")" >> "$LOG"`,
+	}
+
+	// run 在 sh 中执行 script，返回记录的调用和执行结果
+	run := func(script string) (string, error) {
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		log, _ := os.ReadFile(filepath.Join(dir, "calls.log"))
+		GinkgoWriter.Println(string(output))
+		return string(log), err
+	}
+
+	BeforeEach(func() {
+		var err error
+		eng, err = Get("oceanbase-ce")
+		Expect(err).NotTo(HaveOccurred())
+
+		dir = GinkgoT().TempDir()
+		bin := filepath.Join(dir, "bin")
+		Expect(os.Mkdir(bin, 0o755)).To(Succeed())
+		for name, script := range tools {
+			Expect(os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755)).To(Succeed())
+		}
+		env = []string{
+			"PATH=" + bin + ":" + os.Getenv("PATH"),
+			"LOG=" + filepath.Join(dir, "calls.log"),
+			"DATABASES=appdb shop",
+			"DB_HOST=mydb", "DB_PORT=2881", "OBD_PASSWORD=s3cret",
+		}
+	})
+
+	It("should export every business database of the tenant and skip the system databases", func() {
+		calls, err := run(eng.BackupCommand(filepath.Join(dir, "backup.tar")))
+		Expect(err).NotTo(HaveOccurred())
+
+		By("Listing databases in the business tenant without the system databases")
+		Expect(calls).To(ContainSubstring(`obclient -h mydb -P 2881 -uroot@test -ps3cret --batch --skip-column-names`))
+		for _, db := range []string{"oceanbase", "information_schema", "mysql", "__public", "__recyclebin"} {
+			Expect(calls).To(MatchRegexp(`stdin: SELECT schema_name FROM information_schema.schemata WHERE schema_name NOT IN \(.*'` + db + `'`))
+		}
+
+		By("Dumping each database with the tenant and sys credentials")
+		for _, db := range []string{"appdb", "shop"} {
+			Expect(calls).To(MatchRegexp(`obdumper -h mydb -P 2881 -u root -t test -p s3cret --sys-user root --sys-password s3cret -D ` + db + ` --all --sql --skip-check-dir -f \S+`))
+		}
+
+		By("Writing a tar of the data/<database> layout to the backup file")
+		listing, err := exec.Command("tar", "-tf", filepath.Join(dir, "backup.tar")).Output()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(listing)).To(ContainSubstring("./data/appdb/TABLE/orders-schema.sql"))
+		Expect(string(listing)).To(ContainSubstring("./data/shop/TABLE/orders-schema.sql"))
+	})
+
+	It("should restore every database from the layout the backup produced", func() {
+		backup := filepath.Join(dir, "backup.tar")
+		_, err := run(eng.BackupCommand(backup))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(filepath.Join(dir, "calls.log"))).To(Succeed())
+
+		calls, err := run(eng.RestoreCommand(backup))
+		Expect(err).NotTo(HaveOccurred())
+		for _, db := range []string{"appdb", "shop"} {
+			Expect(calls).To(ContainSubstring("stdin: CREATE DATABASE IF NOT EXISTS `" + db + "`;"))
+			Expect(calls).To(MatchRegexp(`obloader -h mydb -P 2881 -u root -t test -p s3cret --sys-user root --sys-password s3cret -D ` + db + ` --all --sql --replace-object -f \S+\n  loaded: orders-schema.sql`))
+		}
+	})
+
+	It("should fail the backup when a database cannot be exported", func() {
+		env = append(env, "FAIL_DATABASE=appdb")
+		calls, err := run(eng.BackupCommand(filepath.Join(dir, "backup.tar")))
+		Expect(err).To(HaveOccurred())
+		// set -e 在第一个失败的数据库处停止，不会生成缺少数据库的备份
+		Expect(calls).NotTo(ContainSubstring("-D shop"))
+	})
+
+	It("should read and write the backup through the given path", func() {
+		backup := eng.BackupCommand("/backup/mydb/mydb.sql")
+		Expect(backup).To(HavePrefix("(\n  set -e\n"))
+		Expect(backup).To(HaveSuffix(") > /backup/mydb/mydb.sql"))
+
+		restore := eng.RestoreCommand("/backup/mydb/mydb.sql")
+		Expect(restore).To(HavePrefix("(\n  set -e\n"))
+		Expect(restore).To(HaveSuffix(") < /backup/mydb/mydb.sql"))
+	})
+})
@@ -0,0 +1,440 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func init() {
+	Register(mysqlEngine{})
+}
+
+// gtidPattern 匹配单个事务的 GTID：<server_uuid>:<序号>
+var gtidPattern = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}):([1-9][0-9]{0,18})$`)
+
+// mysqlEngine 是 MySQL 的引擎实现
+type mysqlEngine struct{}
+
+// Name 返回数据库类型名称
+func (mysqlEngine) Name() string { return "mysql" }
+
+// DefaultVersion 返回默认的 MySQL 版本
+func (mysqlEngine) DefaultVersion() string { return "8.0" }
+
+// Port 返回 MySQL 默认端口
+func (mysqlEngine) Port() int32 { return 3306 }
+
+// PortName 返回端口名称
+func (mysqlEngine) PortName() string { return "mysql" }
+
+// DataPath 返回 MySQL 数据目录
+func (mysqlEngine) DataPath() string { return "/var/lib/mysql" }
+
+// CredentialKeys 返回 Secret 中用户名和密码的默认键名
+func (mysqlEngine) CredentialKeys() (string, string) {
+	return "mysql-user", "mysql-password"
+}
+
+// AdminUser 返回 MySQL 的管理员用户名
+func (mysqlEngine) AdminUser() string { return "root" }
+
+// ServerEnv 通过 MYSQL_ROOT_PASSWORD 设置 root 用户的初始密码
+func (mysqlEngine) ServerEnv(secret SecretRef) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		secretEnv("MYSQL_ROOT_PASSWORD", secret.Name, secret.PasswordKey),
+	}
+}
+
+// ClientEnv 返回 mysql 客户端连接所需的环境变量
+func (e mysqlEngine) ClientEnv(secret SecretRef, host string) []corev1.EnvVar {
+	return append([]corev1.EnvVar{
+		{Name: "MYSQL_USER", Value: e.AdminUser()},
+		secretEnv("MYSQL_PASSWORD", secret.Name, secret.PasswordKey),
+	}, endpointEnv(host, e.Port())...)
+}
+
+// BackupCommand 使用 mysqldump 导出全部数据库，导出文件不设置 GTID_PURGED，
+// 导入到已经执行过事务（例如开启了主从复制）的实例时作为新的事务执行并复制到副本
+func (mysqlEngine) BackupCommand(path string) string {
+	return "mysqldump -h $DB_HOST -P $DB_PORT -u\"$MYSQL_USER\" -p\"$MYSQL_PASSWORD\" --all-databases --set-gtid-purged=OFF > " + path
+}
+
+// RestoreCommand 使用 mysql 客户端导入 mysqldump 的导出文件
+func (mysqlEngine) RestoreCommand(path string) string {
+	return "mysql -h $DB_HOST -P $DB_PORT -u\"$MYSQL_USER\" -p\"$MYSQL_PASSWORD\" < " + path
+}
+
+// QueryCommand 使用 mysql 客户端的批处理模式执行查询
+func (mysqlEngine) QueryCommand() string {
+	return "mysql -h $DB_HOST -P $DB_PORT -u\"$MYSQL_USER\" -p\"$MYSQL_PASSWORD\" --batch --skip-column-names"
+}
+
+// RotatePasswordCommand 为该用户的所有账号（root@'%'、root@'localhost' 等）设置新密码，
+// RETAIN CURRENT PASSWORD 使旧密码作为第二密码继续有效，直到执行 DiscardOldPasswordCommand。
+// 新密码经过转义后保存在会话变量 @new_password 中，再由 QUOTE() 生成 ALTER USER 语句中的字符串，
+// 生成的语句以 --raw 输出，避免批处理模式再次转义其中的反斜杠
+func (mysqlEngine) RotatePasswordCommand() []string {
+	return shellCommand(`read -r DB_USER
+read -r CURRENT_PASSWORD
+read -r NEW_PASSWORD
+if MYSQL_PWD="$NEW_PASSWORD" mysql -u"$DB_USER" -e 'SELECT 1' >/dev/null 2>&1; then exit 0; fi
+` + sqlStringScript("DB_USER") + `
+` + sqlStringScript("NEW_PASSWORD") + `
+export MYSQL_PWD="$CURRENT_PASSWORD"
+STATEMENTS=$(mysql -u"$DB_USER" -N -B --raw -e "SET @new_password = '$NEW_PASSWORD_SQL'; SELECT CONCAT('ALTER USER ', QUOTE(user), '@', QUOTE(host), ' IDENTIFIED BY ', QUOTE(@new_password), ' RETAIN CURRENT PASSWORD;') FROM mysql.user WHERE user = '$DB_USER_SQL'")
+printf '%s\n' "$STATEMENTS" | mysql -u"$DB_USER"`)
+}
+
+// DiscardOldPasswordCommand 废弃该用户所有账号上保留的第二密码
+func (mysqlEngine) DiscardOldPasswordCommand() []string {
+	return shellCommand(`read -r DB_USER
+read -r PASSWORD
+` + sqlStringScript("DB_USER") + `
+export MYSQL_PWD="$PASSWORD"
+STATEMENTS=$(mysql -u"$DB_USER" -N -B --raw -e "SELECT CONCAT('ALTER USER ', QUOTE(user), '@', QUOTE(host), ' DISCARD OLD PASSWORD;') FROM mysql.user WHERE user = '$DB_USER_SQL'")
+printf '%s\n' "$STATEMENTS" | mysql -u"$DB_USER"`)
+}
+
+// Tuning 将 innodb_buffer_pool_size 设置为可用内存的 70%，按 128Mi（默认 innodb_buffer_pool_chunk_size）向下取整
+func (mysqlEngine) Tuning(memory resource.Quantity) ([]string, []corev1.EnvVar) {
+	const chunk = 128 << 20
+	bufferPool := fraction(memory, 70) / chunk * chunk
+	if bufferPool < chunk {
+		bufferPool = chunk
+	}
+	// mysql 镜像的入口脚本会把以 "-" 开头的参数追加到 mysqld 命令之后
+	return []string{"--innodb-buffer-pool-size=" + strconv.FormatInt(bufferPool, 10)}, nil
+}
+
+// LivenessProbe 使用 mysqladmin ping 检查 mysqld 是否存活，无需凭据
+func (mysqlEngine) LivenessProbe() *corev1.Probe {
+	return execProbe("mysqladmin ping -h 127.0.0.1", 30, 10)
+}
+
+// ReadinessProbe 使用 mysqladmin ping 检查 mysqld 是否可以接受连接
+func (mysqlEngine) ReadinessProbe() *corev1.Probe {
+	return execProbe("mysqladmin ping -h 127.0.0.1", 5, 5)
+}
+
+// ArchiveArgs 开启基于 GTID 的 binlog，binlog 文件位于数据目录，切换由边车容器通过 FLUSH BINARY LOGS 完成
+func (mysqlEngine) ArchiveArgs(string, time.Duration) []string {
+	return []string{"--log-bin=binlog", "--gtid-mode=ON", "--enforce-gtid-consistency=ON"}
+}
+
+// ArchiveFunctions 根据 binlog.index 找出写完的 binlog（除最后一个以外），已上传的 binlog 在 stagingDir 中留下标记；
+// 基础备份使用 mysqldump 的一致性快照，并记录 GTID_PURGED，重放时已经包含在快照中的事务会被跳过
+func (e mysqlEngine) ArchiveFunctions(stagingDir string) string {
+	return fmt.Sprintf(`ready_logs() {
+  test -f %[1]s/binlog.index || return 0
+  sed '$d' %[1]s/binlog.index | while read -r log; do
+    test -f "%[2]s/archived/${log##*/}" || echo "%[1]s/${log##*/}"
+  done
+}
+archived_log() {
+  mkdir -p %[2]s/archived && touch "%[2]s/archived/${1##*/}"
+}
+current_log() {
+  log=$(tail -n 1 %[1]s/binlog.index) && echo "${log##*/} $(wc -c < "%[1]s/${log##*/}")"
+}
+switch_log() {
+  test "$(current_log)" != "$switched_log" || return 0
+  echo 'FLUSH BINARY LOGS;' | %[3]s > /dev/null && switched_log=$(current_log)
+}
+base_position() {
+  echo 'FLUSH BINARY LOGS;' | %[3]s > /dev/null && switched_log=$(current_log) && echo "${switched_log%% *}"
+}
+base_backup() {
+  mysqldump -h $DB_HOST -P $DB_PORT -u"$MYSQL_USER" -p"$MYSQL_PASSWORD" --all-databases --single-transaction --source-data=2 --set-gtid-purged=ON --routines --events
+}
+base_limit() {
+  echo "SELECT REPLACE(@@GLOBAL.gtid_executed, '\n', '');" | %[3]s
+}`, e.DataPath(), stagingDir, e.QueryCommand())
+}
+
+// BaseBackupExtension 返回 mysqldump 导出文件的扩展名
+func (mysqlEngine) BaseBackupExtension() string { return ".sql" }
+
+// RecoveryFunctions 在 init 容器中启动只监听 Unix socket 的临时 mysqld，在同一个会话中导入基础备份并用 mysqlbinlog 重放 binlog
+// （导入会替换 mysql.user，新的连接需要使用源实例的密码）；
+// 按时间恢复时 --stop-datetime 不含边界，因此使用目标时间的下一秒，按 GTID 恢复时排除该事务及同一 server_uuid 之后的全部事务
+func (mysqlEngine) RecoveryFunctions(target RecoveryTarget, scratchDir string) (string, error) {
+	if target.LSN != "" {
+		return "", errors.New("MySQL does not support recovery to an LSN, use a GTID instead")
+	}
+
+	usable := "usable_base() { :; }"
+	var options []string
+	switch {
+	case target.Time != nil:
+		stop := target.Time.UTC().Truncate(time.Second).Add(time.Second)
+		options = append(options, "--stop-datetime='"+stop.Format(time.DateTime)+"'")
+	case target.GTID != "":
+		match := gtidPattern.FindStringSubmatch(target.GTID)
+		if match == nil {
+			return "", fmt.Errorf("invalid GTID %q, expected <server_uuid>:<number>", target.GTID)
+		}
+		uuid := strings.ToLower(match[1])
+		options = append(options, fmt.Sprintf("--exclude-gtids='%s:%s-9223372036854775806'", uuid, match[2]))
+		// 基础备份结束时已经执行了目标事务的，无法再恢复到它之前
+		usable = fmt.Sprintf(`usable_base() {
+  for interval in $(echo "$1" | tr ',' '\n' | tr -d ' ' | grep -i '^%[1]s:' | cut -d : -f 2- | tr ':' ' '); do
+    test %[2]s -ge "${interval%%%%-*}" && test %[2]s -le "${interval#*-}" && return 1
+  done
+  return 0
+}`, uuid, match[2])
+	}
+
+	socket := scratchDir + "/mysqld.sock"
+	return usable + fmt.Sprintf(`
+recover() {
+  chown mysql:mysql "$datadir"
+  mysqld --initialize-insecure --user=mysql --datadir="$datadir"
+  mysqld --daemonize --user=mysql --datadir="$datadir" --skip-networking --socket=%[1]s --pid-file=%[2]s/mysqld.pid \
+    --log-error=%[2]s/mysqld.log --log-bin=binlog --gtid-mode=ON --enforce-gtid-consistency=ON || { cat %[2]s/mysqld.log >&2; return 1; }
+  mkdir -p %[2]s/logs
+  logs=
+  for log in $(archived_logs "$2"); do
+    fetch_log "$log" "%[2]s/logs/$log"
+    logs="$logs %[2]s/logs/$log"
+  done
+  mkfifo %[2]s/replay
+  { cat "$1" && if test -n "$logs"; then TZ=UTC mysqlbinlog %[3]s $logs; fi; } > %[2]s/replay &
+  replay=$!
+  mysql -S %[1]s -uroot < %[2]s/replay
+  wait $replay
+  pid=$(cat %[2]s/mysqld.pid)
+  kill "$pid"
+  while kill -0 "$pid" 2> /dev/null; do sleep 1; done
+  rm -rf %[2]s/logs %[2]s/replay
+}`, socket, scratchDir, strings.Join(options, " ")), nil
+}
+
+// mysqlCloneError 是没有监控进程重启 mysqld 时 CLONE INSTANCE 返回的错误码，此时数据已经克隆完成，需要手动重启
+const mysqlCloneError = "ERROR 3707"
+
+// gtidTagPattern 匹配 GTID 集合中的标签
+var gtidTagPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+
+// mysqlSourcePurgedErrno 是主库无法提供副本需要的 binlog（例如已经被清理）时复制 IO 线程记录的错误码
+const mysqlSourcePurgedErrno = "13114"
+
+// ReplicationArgs 开启基于 GTID 的 binlog 并加载克隆插件，开启半同步复制时同时加载主库和副本两端的插件，
+// 角色切换时不需要重启；是否启用由 Operator 按照节点的角色设置
+func (mysqlEngine) ReplicationArgs(options ReplicationOptions) []string {
+	args := []string{"--log-bin=binlog", "--gtid-mode=ON", "--enforce-gtid-consistency=ON", "--plugin-load-add=mysql_clone.so"}
+	if options.SemiSyncTimeout > 0 {
+		args = append(args, "--plugin-load-add=semisync_source.so", "--plugin-load-add=semisync_replica.so")
+	}
+	return args
+}
+
+// ReplicationUser 返回复制用户名
+func (mysqlEngine) ReplicationUser() string { return "replicator" }
+
+// ConfigurePrimaryCommand 以 server_id 区分各个节点，关闭只读并清除节点自身的复制配置，复制用户的密码无法登录时重新设置；
+// 复制用户同时拥有 BACKUP_ADMIN 权限，副本可以以它从主库克隆数据。输出的两行分别是 gtid_executed 和 gtid_purged
+func (e mysqlEngine) ConfigurePrimaryCommand(options ReplicationOptions) []string {
+	semiSync := ""
+	if options.SemiSyncTimeout > 0 {
+		timeout := strconv.FormatInt(options.SemiSyncTimeout.Milliseconds(), 10)
+		semiSync = `
+test "$(sql "SELECT CONCAT_WS(',', @@GLOBAL.rpl_semi_sync_source_enabled, @@GLOBAL.rpl_semi_sync_replica_enabled, @@GLOBAL.rpl_semi_sync_source_timeout)")" = "1,0,` + timeout + `" ||
+  sql 'SET PERSIST rpl_semi_sync_replica_enabled = OFF, rpl_semi_sync_source_enabled = ON, rpl_semi_sync_source_timeout = ` + timeout + `'`
+	}
+	return shellCommand(mysqlReplicationPreamble + `
+if test -n "$(sql "SELECT HOST FROM performance_schema.replication_connection_configuration WHERE CHANNEL_NAME = ''")"; then
+  sql 'STOP REPLICA; RESET REPLICA ALL'
+fi
+test "$(sql "SELECT CONCAT_WS(',', @@GLOBAL.super_read_only, @@GLOBAL.read_only)")" = 0,0 || sql 'SET PERSIST super_read_only = OFF; SET PERSIST read_only = OFF'
+if ! MYSQL_PWD="$REPLICATION_PASSWORD" mysql -u` + e.ReplicationUser() + ` -h 127.0.0.1 -e 'SELECT 1' >/dev/null 2>&1; then
+  sql "CREATE USER IF NOT EXISTS '` + e.ReplicationUser() + `'@'%' IDENTIFIED BY '$REPLICATION_PASSWORD'; ALTER USER '` + e.ReplicationUser() + `'@'%' IDENTIFIED BY '$REPLICATION_PASSWORD'; GRANT REPLICATION SLAVE, BACKUP_ADMIN ON *.* TO '` + e.ReplicationUser() + `'@'%'"
+fi` + semiSync + `
+sql "SELECT REPLACE(@@GLOBAL.gtid_executed, '\n', ''), REPLACE(@@GLOBAL.gtid_purged, '\n', '')" | tr '\t' '\n'`)
+}
+
+// ConfigureReplicaCommand 将节点设为 super_read_only 并通过 GTID 自动定位从主库复制，复制已经正常进行时只输出状态。
+// 副本包含主库没有的事务时：从未配置过复制的新副本（例如入口脚本初始化时写入了本地事务）和被隔离的旧主库直接从主库克隆，
+// 其他配置过复制的副本可能包含误写入的数据，只报告错误；副本缺少主库已经清理的事务、或主库无法提供需要的 binlog 时从主库克隆。
+// 克隆完成后停止 mysqld，容器重启后使用克隆的数据，下一次调和时再开始复制。最后输出 SHOW REPLICA STATUS 以及说明原因的 Message 行
+func (e mysqlEngine) ConfigureReplicaCommand(options ReplicationOptions) []string {
+	demoted := ""
+	if options.Demoted {
+		demoted = "1"
+	}
+	restart := ""
+	if options.SemiSyncTimeout > 0 {
+		restart = `
+if test "$(sql "SELECT CONCAT_WS(',', @@GLOBAL.rpl_semi_sync_replica_enabled, @@GLOBAL.rpl_semi_sync_source_enabled)")" != 1,0; then
+  sql 'SET PERSIST rpl_semi_sync_source_enabled = OFF, rpl_semi_sync_replica_enabled = ON'
+  reconfigure=1
+fi`
+	}
+	return shellCommand(mysqlReplicationPreamble + `
+read -r PRIMARY_HOST
+read -r PRIMARY_EXECUTED
+read -r PRIMARY_PURGED
+report() {
+  test -z "$1" || echo "Message: $1"
+  sql 'SHOW REPLICA STATUS\G'
+}
+test "$(sql 'SELECT @@GLOBAL.super_read_only')" = 1 || sql 'SET PERSIST super_read_only = ON'
+demoted=` + demoted + `
+reconfigure=` + restart + `
+channel="FROM performance_schema.replication_connection_status WHERE CHANNEL_NAME = ''"
+source_host=$(sql "SELECT HOST FROM performance_schema.replication_connection_configuration WHERE CHANNEL_NAME = ''")
+receiver=$(sql "SELECT SERVICE_STATE $channel")
+applier=$(sql "SELECT SERVICE_STATE FROM performance_schema.replication_applier_status WHERE CHANNEL_NAME = ''")
+if test -z "$reconfigure" && test "$source_host" = "$PRIMARY_HOST" && test "$receiver" = ON && test "$applier" = ON; then
+  report
+  exit 0
+fi
+clone=
+if test "$(sql "SELECT GTID_SUBSET(@@GLOBAL.gtid_executed, '$PRIMARY_EXECUTED')")" != 1; then
+  if test -n "$source_host" && test -z "$demoted"; then
+    report 'the replica has transactions that the primary does not have, delete its data volume and pod to clone it again'
+    exit 0
+  fi
+  clone=1
+elif test "$(sql "SELECT GTID_SUBSET('$PRIMARY_PURGED', @@GLOBAL.gtid_executed)")" != 1 || test "$(sql "SELECT LAST_ERROR_NUMBER $channel")" = ` + mysqlSourcePurgedErrno + `; then
+  clone=1
+fi
+if test -n "$clone"; then
+  sql "STOP REPLICA; SET GLOBAL super_read_only = OFF; SET GLOBAL clone_valid_donor_list = '$PRIMARY_HOST:` + strconv.Itoa(int(e.Port())) + `'"
+  if ! out=$(sql "CLONE INSTANCE FROM '` + e.ReplicationUser() + `'@'$PRIMARY_HOST':` + strconv.Itoa(int(e.Port())) + ` IDENTIFIED BY '$REPLICATION_PASSWORD'" 2>&1); then
+    case "$out" in
+      *'` + mysqlCloneError + `'*) ;;
+      *) echo "$out" >&2; exit 1 ;;
+    esac
+  fi
+  (sleep 1; sql 'SHUTDOWN') >/dev/null 2>&1 &
+  echo 'Message: cloned the data of the primary, waiting for the replica to restart'
+  exit 0
+fi
+sql "STOP REPLICA; CHANGE REPLICATION SOURCE TO SOURCE_HOST = '$PRIMARY_HOST', SOURCE_PORT = ` + strconv.Itoa(int(e.Port())) + `, SOURCE_USER = '` + e.ReplicationUser() + `', SOURCE_PASSWORD = '$REPLICATION_PASSWORD', SOURCE_AUTO_POSITION = 1, GET_SOURCE_PUBLIC_KEY = 1, SOURCE_CONNECT_RETRY = 10; START REPLICA"
+report`)
+}
+
+// mysqlAdminPreamble 读取管理员用户名和密码，并定义通过本地 socket 以管理员身份执行 SQL 的函数 sql
+const mysqlAdminPreamble = `read -r DB_USER
+read -r DB_PASSWORD
+export MYSQL_PWD="$DB_PASSWORD"
+sql() { mysql -u"$DB_USER" --batch --skip-column-names -e "$1"; }`
+
+// mysqlReplicationPreamble 读取配置复制的命令共有的输入，并按节点编号设置 server_id
+const mysqlReplicationPreamble = mysqlAdminPreamble + `
+read -r REPLICATION_PASSWORD
+read -r SERVER_ID
+test "$(sql 'SELECT @@GLOBAL.server_id')" = "$SERVER_ID" || sql "SET PERSIST server_id = $SERVER_ID"`
+
+// ParseReplicaStatus 解析 SHOW REPLICA STATUS\G 的输出，IO 线程和 SQL 线程都在运行时副本正在复制
+func (mysqlEngine) ParseReplicaStatus(output string) ReplicaState {
+	fields := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+
+	state := ReplicaState{
+		Streaming: fields["Replica_IO_Running"] == "Yes" && fields["Replica_SQL_Running"] == "Yes",
+		Message:   fields["Message"],
+	}
+	if lag, err := strconv.ParseInt(fields["Seconds_Behind_Source"], 10, 64); err == nil {
+		state.LagSeconds = &lag
+	}
+	if state.Message == "" && !state.Streaming {
+		switch {
+		case fields["Last_IO_Error"] != "":
+			state.Message = fields["Last_IO_Error"]
+		case fields["Last_SQL_Error"] != "":
+			state.Message = fields["Last_SQL_Error"]
+		case len(fields) == 0:
+			state.Message = "replication is not configured"
+		default:
+			state.Message = fmt.Sprintf("IO thread: %s, SQL thread: %s", fields["Replica_IO_Running"], fields["Replica_SQL_Running"])
+		}
+	}
+	return state
+}
+
+// SeedFunctions 副本在运行时通过 clone 插件从主库复制数据，不需要 init 容器
+func (mysqlEngine) SeedFunctions() string { return "" }
+
+// PositionCommand 输出副本已经执行和已经收到的全部事务的 GTID 集合，没有配置过复制的节点输出为空
+func (mysqlEngine) PositionCommand() []string {
+	return shellCommand(mysqlAdminPreamble + `
+test -n "$(sql "SELECT HOST FROM performance_schema.replication_connection_configuration WHERE CHANNEL_NAME = ''")" || exit 0
+sql "SELECT REPLACE(GTID_SUBTRACT(CONCAT_WS(',', NULLIF(@@GLOBAL.gtid_executed, ''), NULLIF(RECEIVED_TRANSACTION_SET, '')), ''), '\n', '') FROM performance_schema.replication_connection_status WHERE CHANNEL_NAME = ''"`)
+}
+
+// ParsePosition 返回 GTID 集合中的事务数量，各个副本的事务都来自同一个主库，数量越多的副本越新；
+// 集合中的标签（MySQL 8.3 的 <uuid>:<tag>:<区间>）不计入数量
+func (mysqlEngine) ParsePosition(output string) (int64, error) {
+	var count int64
+	for _, set := range strings.Split(strings.TrimSpace(output), ",") {
+		parts := strings.Split(strings.TrimSpace(set), ":")
+		if len(parts) < 2 {
+			return 0, fmt.Errorf("invalid GTID set %q", output)
+		}
+		for _, interval := range parts[1:] {
+			start, end, found := strings.Cut(interval, "-")
+			first, err := strconv.ParseInt(start, 10, 64)
+			if err != nil {
+				if !gtidTagPattern.MatchString(interval) {
+					return 0, fmt.Errorf("invalid GTID set %q", output)
+				}
+				continue
+			}
+			last := first
+			if found {
+				if last, err = strconv.ParseInt(end, 10, 64); err != nil {
+					return 0, fmt.Errorf("invalid GTID set %q", output)
+				}
+			}
+			count += last - first + 1
+		}
+	}
+	return count, nil
+}
+
+// PromoteCommand 停止接收新的 binlog，等待副本应用完已经收到的事务后清除复制配置并关闭只读
+func (mysqlEngine) PromoteCommand() []string {
+	return shellCommand(mysqlAdminPreamble + `
+sql 'STOP REPLICA IO_THREAD'
+received=$(sql "SELECT REPLACE(RECEIVED_TRANSACTION_SET, '\n', '') FROM performance_schema.replication_connection_status WHERE CHANNEL_NAME = ''")
+if test "$(sql "SELECT WAIT_FOR_EXECUTED_GTID_SET('$received', 300)")" != 0; then
+  echo 'timed out waiting for the replica to apply the transactions it received' >&2
+  exit 1
+fi
+sql 'STOP REPLICA; RESET REPLICA ALL; SET PERSIST super_read_only = OFF; SET PERSIST read_only = OFF'`)
+}
+
+// FenceCommand 开启 super_read_only，之后包括管理员在内的所有连接都无法写入
+func (mysqlEngine) FenceCommand() []string {
+	return shellCommand(mysqlAdminPreamble + `
+sql 'SET GLOBAL super_read_only = ON'`)
+}
+
+// PrimaryPositionCommand 输出主库已经执行的全部事务的 GTID 集合
+func (mysqlEngine) PrimaryPositionCommand() []string {
+	return shellCommand(mysqlAdminPreamble + `
+sql "SELECT REPLACE(@@GLOBAL.gtid_executed, '\n', '')"`)
+}
+
+// CatchUpCommand 通过 WAIT_FOR_EXECUTED_GTID_SET 等待副本执行完主库的全部事务
+func (mysqlEngine) CatchUpCommand(timeout time.Duration) []string {
+	return shellCommand(mysqlAdminPreamble + `
+read -r TARGET
+if test "$(sql "SELECT WAIT_FOR_EXECUTED_GTID_SET('$TARGET', ` + strconv.Itoa(int(timeout.Seconds())) + `)")" != 0; then
+  echo 'timed out waiting for the replica to catch up with the primary' >&2
+  exit 1
+fi`)
+}
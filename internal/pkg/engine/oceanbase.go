@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func init() {
+	Register(oceanbaseEngine{})
+}
+
+// oceanbaseEngine 是 OceanBase 社区版的引擎实现
+type oceanbaseEngine struct{}
+
+const (
+	// oceanbaseTenant 是 OceanBase-CE 镜像初始化时创建的业务租户，其 root 用户的密码由 OB_TENANT_PASSWORD 设置
+	oceanbaseTenant = "test"
+
+	// oceanbaseSystemDatabases 是业务租户中由 OceanBase 维护的系统数据库，备份时跳过
+	oceanbaseSystemDatabases = "'oceanbase', 'information_schema', 'mysql', '__public', '__recyclebin'"
+
+	// oceanbaseTenantClient 是以业务租户 root 用户连接数据库的 obclient 命令，依赖 ClientEnv 注入的环境变量
+	oceanbaseTenantClient = `obclient -h $DB_HOST -P $DB_PORT -u"root@` + oceanbaseTenant + `" -p"$OBD_PASSWORD"`
+)
+
+// oceanbaseLoaderDumper 返回以业务租户 root 用户连接数据库的 obloader 或 obdumper 命令，sys 租户的 root 用户用于查询元数据
+func oceanbaseLoaderDumper(tool string) string {
+	return tool + ` -h $DB_HOST -P $DB_PORT -u root -t ` + oceanbaseTenant + ` -p "$OBD_PASSWORD" --sys-user root --sys-password "$OBD_PASSWORD"`
+}
+
+// Name 返回数据库类型名称
+func (oceanbaseEngine) Name() string { return "oceanbase-ce" }
+
+// DefaultVersion 返回默认的 OceanBase-CE 版本
+func (oceanbaseEngine) DefaultVersion() string { return "4.2.1" }
+
+// Port 返回 OceanBase-CE 默认的 MySQL 协议端口
+func (oceanbaseEngine) Port() int32 { return 2881 }
+
+// PortName 返回端口名称
+func (oceanbaseEngine) PortName() string { return "oceanbase-ce" }
+
+// DataPath 返回 OceanBase-CE 数据目录
+func (oceanbaseEngine) DataPath() string { return "/oceanbase/store" }
+
+// CredentialKeys 返回 Secret 中用户名和密码的默认键名
+func (oceanbaseEngine) CredentialKeys() (string, string) {
+	return "oceanbase-user", "oceanbase-password"
+}
+
+// AdminUser 返回 sys 租户的管理员用户名
+func (oceanbaseEngine) AdminUser() string { return "root@sys" }
+
+// ServerEnv 通过 OB_SYS_PASSWORD、OB_TENANT_PASSWORD 设置 sys 租户和业务租户 root 用户的初始密码
+func (oceanbaseEngine) ServerEnv(secret SecretRef) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		secretEnv("OB_SYS_PASSWORD", secret.Name, secret.PasswordKey),
+		secretEnv("OB_TENANT_PASSWORD", secret.Name, secret.PasswordKey),
+	}
+}
+
+// ClientEnv 返回 obclient 连接所需的环境变量
+func (e oceanbaseEngine) ClientEnv(secret SecretRef, host string) []corev1.EnvVar {
+	return append([]corev1.EnvVar{
+		{Name: "OBD_USER", Value: e.AdminUser()},
+		secretEnv("OBD_PASSWORD", secret.Name, secret.PasswordKey),
+	}, endpointEnv(host, e.Port())...)
+}
+
+// BackupCommand 使用 obdumper 逐个导出业务租户中的数据库（结构和数据），再将导出目录打包为 tar 写入 path。
+// obdumper 不支持导出 sys 租户，备份只包含业务租户的数据；先打开 path 再执行导出，path 为命名管道时读取端不会因导出失败而一直阻塞
+func (oceanbaseEngine) BackupCommand(path string) string {
+	return `(
+  set -e
+  work=$(mktemp -d)
+  trap 'rm -rf "$work"' EXIT
+  databases=$(printf '%s\n' "SELECT schema_name FROM information_schema.schemata WHERE schema_name NOT IN (` + oceanbaseSystemDatabases + `)" | ` + oceanbaseTenantClient + ` --batch --skip-column-names)
+  for db in $databases; do
+    ` + oceanbaseLoaderDumper("obdumper") + ` -D "$db" --all --sql --skip-check-dir -f "$work" >&2
+  done
+  tar -cf - -C "$work" .
+) > ` + path
+}
+
+// RestoreCommand 将 BackupCommand 打包的 tar 解包，逐个创建数据库并使用 obloader 导入，已存在的对象会被替换
+func (oceanbaseEngine) RestoreCommand(path string) string {
+	return `(
+  set -e
+  work=$(mktemp -d)
+  trap 'rm -rf "$work"' EXIT
+  tar -xf - -C "$work"
+  for dir in "$work"/data/*/; do
+    test -d "$dir" || continue
+    db=$(basename "$dir")
+    printf 'CREATE DATABASE IF NOT EXISTS ` + "`%s`" + `;\n' "$db" | ` + oceanbaseTenantClient + `
+    ` + oceanbaseLoaderDumper("obloader") + ` -D "$db" --all --sql --replace-object -f "$work"
+  done
+) < ` + path
+}
+
+// QueryCommand 使用 obclient 的批处理模式在业务租户中执行查询，业务数据位于业务租户而不是 sys 租户
+func (oceanbaseEngine) QueryCommand() string {
+	return oceanbaseTenantClient + " --batch --skip-column-names"
+}
+
+// RotatePasswordCommand 通过 SET PASSWORD 修改当前登录用户和业务租户 root 用户的密码，两者与 ServerEnv 中一样使用同一个密码，
+// 备份以业务租户 root 用户连接数据库。OceanBase 不支持双密码，新密码立即生效；业务租户不存在时只修改当前登录用户的密码。
+// 新密码经过转义后才拼接到 SET PASSWORD 语句中
+func (e oceanbaseEngine) RotatePasswordCommand() []string {
+	port := strconv.Itoa(int(e.Port()))
+	return shellCommand(`read -r DB_USER
+read -r CURRENT_PASSWORD
+read -r NEW_PASSWORD
+` + sqlStringScript("NEW_PASSWORD") + `
+for user in "$DB_USER" root@` + oceanbaseTenant + `; do
+  if obclient -h 127.0.0.1 -P ` + port + ` -u"$user" -p"$NEW_PASSWORD" -e 'SELECT 1' >/dev/null 2>&1; then continue; fi
+  if test "$user" != "$DB_USER" && ! obclient -h 127.0.0.1 -P ` + port + ` -u"$user" -p"$CURRENT_PASSWORD" -e 'SELECT 1' >/dev/null 2>&1; then continue; fi
+  obclient -h 127.0.0.1 -P ` + port + ` -u"$user" -p"$CURRENT_PASSWORD" -e "SET PASSWORD = PASSWORD('$NEW_PASSWORD_SQL')"
+done`)
+}
+
+// DiscardOldPasswordCommand OceanBase 不支持双密码，没有需要废弃的旧密码
+func (oceanbaseEngine) DiscardOldPasswordCommand() []string { return nil }
+
+// Tuning 通过 OB_MEMORY_LIMIT 将 observer 的 memory_limit 设置为可用内存的 80%，以 G 为单位向下取整
+func (oceanbaseEngine) Tuning(memory resource.Quantity) ([]string, []corev1.EnvVar) {
+	const gi = 1 << 30
+	limit := fraction(memory, 80) / gi
+	if limit < 1 {
+		return nil, nil
+	}
+	return nil, []corev1.EnvVar{
+		{
+			Name:  "OB_MEMORY_LIMIT",
+			Value: strconv.FormatInt(limit, 10) + "G",
+		},
+	}
+}
+
+// LivenessProbe 检查 observer 的 MySQL 协议端口，OceanBase 启动较慢，因此初始延迟较长
+func (e oceanbaseEngine) LivenessProbe() *corev1.Probe {
+	return tcpProbe(e.Port(), 180, 20)
+}
+
+// ReadinessProbe 检查 observer 的 MySQL 协议端口是否可以连接
+func (e oceanbaseEngine) ReadinessProbe() *corev1.Probe {
+	return tcpProbe(e.Port(), 30, 10)
+}
@@ -0,0 +1,413 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func init() {
+	Register(postgresEngine{})
+}
+
+// lsnPattern 匹配 WAL 位置：X/X
+var lsnPattern = regexp.MustCompile(`^[0-9A-Fa-f]{1,8}/[0-9A-Fa-f]{1,8}$`)
+
+// postgresEngine 是 PostgreSQL 的引擎实现
+type postgresEngine struct{}
+
+// Name 返回数据库类型名称
+func (postgresEngine) Name() string { return "postgres" }
+
+// DefaultVersion 返回默认的 PostgreSQL 版本
+func (postgresEngine) DefaultVersion() string { return "16" }
+
+// Port 返回 PostgreSQL 默认端口
+func (postgresEngine) Port() int32 { return 5432 }
+
+// PortName 返回端口名称
+func (postgresEngine) PortName() string { return "postgres" }
+
+// DataPath 返回 PostgreSQL 数据目录
+func (postgresEngine) DataPath() string { return "/var/lib/postgresql/data" }
+
+// CredentialKeys 返回 Secret 中用户名和密码的默认键名
+func (postgresEngine) CredentialKeys() (string, string) {
+	return "postgres-user", "postgres-password"
+}
+
+// AdminUser 返回 PostgreSQL 的超级用户名
+func (postgresEngine) AdminUser() string { return "postgres" }
+
+// ServerEnv 通过 POSTGRES_USER、POSTGRES_PASSWORD 设置 initdb 创建的超级用户及其密码
+func (postgresEngine) ServerEnv(secret SecretRef) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "POSTGRES_USER", Value: postgresEngine{}.AdminUser()},
+		secretEnv("POSTGRES_PASSWORD", secret.Name, secret.PasswordKey),
+	}
+}
+
+// ClientEnv 返回 psql/pg_dumpall 连接所需的环境变量，PGPASSWORD 由 libpq 直接读取
+func (e postgresEngine) ClientEnv(secret SecretRef, host string) []corev1.EnvVar {
+	return append([]corev1.EnvVar{
+		{Name: "POSTGRES_USER", Value: e.AdminUser()},
+		secretEnv("POSTGRES_PASSWORD", secret.Name, secret.PasswordKey),
+		secretEnv("PGPASSWORD", secret.Name, secret.PasswordKey),
+	}, endpointEnv(host, e.Port())...)
+}
+
+// BackupCommand 使用 pg_dumpall 导出整个集群
+func (postgresEngine) BackupCommand(path string) string {
+	return "pg_dumpall -h $DB_HOST -p $DB_PORT -U \"$POSTGRES_USER\" > " + path
+}
+
+// RestoreCommand 使用 psql 执行 pg_dumpall 导出的 SQL 脚本
+func (postgresEngine) RestoreCommand(path string) string {
+	return "psql -h $DB_HOST -p $DB_PORT -U \"$POSTGRES_USER\" -d postgres -f " + path
+}
+
+// QueryCommand 使用 psql 执行查询，ON_ERROR_STOP 使出错的 SQL 返回非零状态
+func (postgresEngine) QueryCommand() string {
+	return "psql -h $DB_HOST -p $DB_PORT -U \"$POSTGRES_USER\" -d postgres -v ON_ERROR_STOP=1 -q -A -t"
+}
+
+// RotatePasswordCommand 通过 ALTER ROLE 修改密码，PostgreSQL 不支持双密码，新密码立即生效
+// 容器内的 Unix socket 连接默认免密，因此通过 127.0.0.1 连接以验证密码
+func (postgresEngine) RotatePasswordCommand() []string {
+	return shellCommand(`read -r DB_USER
+read -r CURRENT_PASSWORD
+read -r NEW_PASSWORD
+if PGPASSWORD="$NEW_PASSWORD" psql -h 127.0.0.1 -U "$DB_USER" -d postgres -c 'SELECT 1' >/dev/null 2>&1; then exit 0; fi
+echo "ALTER ROLE :\"db_user\" PASSWORD :'new_password';" | PGPASSWORD="$CURRENT_PASSWORD" psql -h 127.0.0.1 -U "$DB_USER" -d postgres -v ON_ERROR_STOP=1 -v db_user="$DB_USER" -v new_password="$NEW_PASSWORD"`)
+}
+
+// DiscardOldPasswordCommand PostgreSQL 不支持双密码，没有需要废弃的旧密码
+func (postgresEngine) DiscardOldPasswordCommand() []string { return nil }
+
+// Tuning 将 shared_buffers 设置为可用内存的 25%，effective_cache_size 设置为 75%
+func (postgresEngine) Tuning(memory resource.Quantity) ([]string, []corev1.EnvVar) {
+	const mb = 1 << 20
+	sharedBuffers := fraction(memory, 25) / mb
+	if sharedBuffers < 128 {
+		sharedBuffers = 128
+	}
+	effectiveCacheSize := fraction(memory, 75) / mb
+	// postgres 镜像的入口脚本会把以 "-" 开头的参数追加到 postgres 命令之后
+	return []string{
+		"-c", "shared_buffers=" + strconv.FormatInt(sharedBuffers, 10) + "MB",
+		"-c", "effective_cache_size=" + strconv.FormatInt(effectiveCacheSize, 10) + "MB",
+	}, nil
+}
+
+// LivenessProbe 使用 pg_isready 检查 postgres 是否存活
+func (e postgresEngine) LivenessProbe() *corev1.Probe {
+	return execProbe("pg_isready -h 127.0.0.1 -p "+strconv.Itoa(int(e.Port())), 30, 10)
+}
+
+// ReadinessProbe 使用 pg_isready 检查 postgres 是否可以接受连接
+func (e postgresEngine) ReadinessProbe() *corev1.Probe {
+	return execProbe("pg_isready -h 127.0.0.1 -p "+strconv.Itoa(int(e.Port())), 5, 5)
+}
+
+// ArchiveArgs 开启 WAL 归档，archive_timeout 保证空闲时也会按时切换 WAL 文件；
+// archive_command 将写完的 WAL 文件复制到共享目录，等待边车容器上传后删除，边车容器没有及时处理时返回失败，由 PostgreSQL 稍后重试
+func (postgresEngine) ArchiveArgs(stagingDir string, switchInterval time.Duration) []string {
+	wal := stagingDir + "/wal"
+	command := fmt.Sprintf(`mkdir -p %[1]s && { test -f %[1]s/%%f || { cp %%p %[1]s/%%f.staging && mv %[1]s/%%f.staging %[1]s/%%f; }; } && `+
+		`i=0 && while test -f %[1]s/%%f; do test $i -lt 60 || exit 1; i=$((i + 1)); sleep 1; done`, wal)
+	return []string{
+		"-c", "archive_mode=on",
+		"-c", fmt.Sprintf("archive_timeout=%ds", int64(switchInterval.Seconds())),
+		"-c", "archive_command=" + command,
+	}
+}
+
+// ArchiveFunctions 上传 archive_command 复制到共享目录的 WAL 文件，上传后删除它以通知 archive_command 归档成功；
+// 基础备份使用 pg_basebackup 的 tar 格式，备份期间需要的 WAL 通过归档获取
+func (e postgresEngine) ArchiveFunctions(stagingDir string) string {
+	return fmt.Sprintf(`ready_logs() {
+  mkdir -p %[1]s/wal && ls %[1]s/wal | grep -v '\.staging$' | sed 's|^|%[1]s/wal/|'
+}
+archived_log() {
+  rm -f "$1"
+}
+switch_log() {
+  :
+}
+base_position() {
+  echo 'SELECT pg_walfile_name(pg_current_wal_lsn());' | %[2]s
+}
+base_backup() {
+  pg_basebackup -h $DB_HOST -p $DB_PORT -U "$POSTGRES_USER" -D - -F tar -X none --checkpoint=fast --no-manifest
+}
+base_limit() {
+  echo 'SELECT pg_current_wal_lsn();' | %[2]s
+}`, stagingDir, e.QueryCommand())
+}
+
+// BaseBackupExtension 返回 pg_basebackup tar 格式的扩展名
+func (postgresEngine) BaseBackupExtension() string { return ".tar" }
+
+// RecoveryFunctions 将基础备份解压到数据目录并创建 recovery.signal，再以不监听 TCP 的方式启动 postgres 完成归档恢复，
+// restore_command 按需下载 WAL 文件；pg_ctl 在恢复到目标、提升为主库并接受连接之后返回，未能到达目标时 postgres 退出并报错
+func (postgresEngine) RecoveryFunctions(target RecoveryTarget, scratchDir string) (string, error) {
+	if target.GTID != "" {
+		return "", errors.New("PostgreSQL does not support recovery to a GTID, use an LSN instead")
+	}
+
+	usable := "usable_base() { :; }"
+	options := fmt.Sprintf("-c listen_addresses= -c hot_standby=off -c archive_mode=off -c 'restore_command=sh %s/fetch-log %%f %%p' -c recovery_target_action=promote", scratchDir)
+	switch {
+	case target.Time != nil:
+		options += " -c 'recovery_target_time=" + target.Time.UTC().Format(time.DateTime) + "+00'"
+	case target.LSN != "":
+		if !lsnPattern.MatchString(target.LSN) {
+			return "", fmt.Errorf("invalid LSN %q, expected X/X", target.LSN)
+		}
+		options += " -c recovery_target_lsn=" + target.LSN + " -c recovery_target_inclusive=off"
+		// 基础备份结束时的位置已经超过目标的，无法再恢复到它之前
+		usable = fmt.Sprintf(`lsn_value() {
+  echo $(( (0x${1%%/*} << 32) + 0x${1#*/} ))
+}
+usable_base() {
+  test "$(lsn_value "$1")" -le "$(lsn_value '%s')"
+}`, target.LSN)
+	}
+
+	return usable + fmt.Sprintf(`
+recover() {
+  tar -xf "$1" -C "$datadir"
+  chown -R postgres:postgres "$datadir"
+  chmod 700 "$datadir"
+  touch "$datadir"/recovery.signal
+  gosu postgres pg_ctl -D "$datadir" -w -t 86400 -o "%[1]s" start
+  gosu postgres pg_ctl -D "$datadir" -m fast -w stop
+}`, options), nil
+}
+
+// postgresAdminPreamble 读取管理员用户名和密码，并定义通过本地 socket 以管理员身份执行 SQL 的函数 sql，
+// 需要 psql 变量的 SQL 从标准输入传给 sql
+const postgresAdminPreamble = `read -r DB_USER
+read -r DB_PASSWORD
+sql() { psql -U "$DB_USER" -d postgres -v ON_ERROR_STOP=1 -q -A -t "$@"; }`
+
+// postgresReplicationPreamble 读取配置复制的命令共有的输入，PostgreSQL 不需要节点编号
+const postgresReplicationPreamble = postgresAdminPreamble + `
+read -r REPLICATION_PASSWORD
+read -r NODE_ID`
+
+// ReplicationArgs 开启流复制需要的 WAL 级别，副本接受只读查询并向主库反馈，避免主库清理副本查询仍然需要的数据；
+// wal_log_hints 使旧的主库可以通过 pg_rewind 与新的主库重新同步
+func (postgresEngine) ReplicationArgs(ReplicationOptions) []string {
+	return []string{
+		"-c", "wal_level=replica",
+		"-c", "hot_standby=on",
+		"-c", "hot_standby_feedback=on",
+		"-c", "wal_log_hints=on",
+	}
+}
+
+// ReplicationUser 返回复制用户名
+func (postgresEngine) ReplicationUser() string { return "replicator" }
+
+// ConfigurePrimaryCommand 在 pg_hba.conf 中允许复制用户的流复制连接，复制用户的密码无法登录时重新设置；
+// 为每个副本创建立即保留 WAL 的物理复制槽（槽名为节点名称中的 - 替换为 _），删除本实例已经不存在的副本留下的未使用的复制槽。
+// synchronous_standby_names 在复制用户和复制槽就绪之后才设置，避免主库等待还无法连接的副本。
+// 127.0.0.1 的连接免密，因此通过 Pod 的地址验证复制用户的密码；故障转移中被 FenceCommand 设为只读的主库在这里恢复写入。
+// 没有需要交给副本的复制位置，标准输出为空
+func (e postgresEngine) ConfigurePrimaryCommand(options ReplicationOptions) []string {
+	user := e.ReplicationUser()
+	script := postgresReplicationPreamble + `
+if test "$(sql -c 'SELECT pg_is_in_recovery()')" = t; then
+  echo 'the primary is still a standby' >&2
+  exit 1
+fi
+reload=
+if test "$(sql -c 'SHOW default_transaction_read_only')" = on; then
+  sql -c 'ALTER SYSTEM RESET default_transaction_read_only'
+  reload=1
+fi
+hba=$(sql -c 'SHOW hba_file')
+if ! grep -q '^host replication ` + user + ` all ' "$hba"; then
+  echo 'host replication ` + user + ` all scram-sha-256' >> "$hba"
+  reload=1
+fi
+if ! PGPASSWORD="$REPLICATION_PASSWORD" psql -h "$(hostname)" -U ` + user + ` -d postgres -c 'SELECT 1' >/dev/null 2>&1; then
+  printf '%s\n' "SELECT 'CREATE ROLE ` + user + `' WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = '` + user + `') \gexec" \
+    "ALTER ROLE ` + user + ` WITH REPLICATION LOGIN PASSWORD :'password';" | sql -v password="$REPLICATION_PASSWORD" >/dev/null
+fi
+slots=
+while read -r standby; do
+  test -n "$standby" || continue
+  slot=$(echo "$standby" | tr - _)
+  slots="$slots $slot"
+  echo "SELECT pg_create_physical_replication_slot(:'slot', true) WHERE NOT EXISTS (SELECT FROM pg_replication_slots WHERE slot_name = :'slot');" | sql -v slot="$slot" >/dev/null
+done
+prefix=$(hostname | sed 's/-[0-9]*$//' | tr - _)_
+for slot in $(sql -c "SELECT slot_name FROM pg_replication_slots WHERE slot_type = 'physical' AND NOT active"); do
+  case "$slot" in "$prefix"*) ;; *) continue ;; esac
+  case "$slots " in *" $slot "*) continue ;; esac
+  echo "SELECT pg_drop_replication_slot(:'slot');" | sql -v slot="$slot" >/dev/null
+done
+if test "$(sql -c 'SHOW synchronous_standby_names')" != "$1"; then
+  if test -n "$1"; then
+    echo "ALTER SYSTEM SET synchronous_standby_names = :'names';" | sql -v names="$1"
+  else
+    sql -c 'ALTER SYSTEM RESET synchronous_standby_names'
+  fi
+  reload=1
+fi
+test -z "$reload" || sql -c 'SELECT pg_reload_conf()' >/dev/null`
+	return append(shellCommand(script), "sh", options.SynchronousStandbyNames)
+}
+
+// ConfigureReplicaCommand 将 primary_conninfo 和 primary_slot_name 指向主库，修改后重新加载配置，WAL 接收进程随之重新连接；
+// 没有处于恢复状态的节点是独立初始化的数据库（例如开启复制之前创建的副本）或者被隔离的旧主库，在数据目录中创建 ReseedMarker 请求重新初始化；
+// 其他配置过复制槽却不再处于恢复状态的节点曾经被提升为主库，可能包含主库没有的数据，只报告错误。
+// 最后输出 WAL 接收进程的状态（Status）、落后主库的秒数（Lag），以及说明原因的 Message 行
+func (e postgresEngine) ConfigureReplicaCommand(options ReplicationOptions) []string {
+	demoted := ""
+	if options.Demoted {
+		demoted = "1"
+	}
+	return shellCommand(postgresReplicationPreamble + `
+read -r PRIMARY_HOST
+demoted=` + demoted + `
+name=$(hostname)
+slot=$(echo "$name" | tr - _)
+conninfo="host=$PRIMARY_HOST port=` + strconv.Itoa(int(e.Port())) + ` user=` + e.ReplicationUser() + ` password=$REPLICATION_PASSWORD application_name=$name"
+if test "$(sql -c 'SELECT pg_is_in_recovery()')" != t; then
+  if test -z "$demoted" && test -n "$(sql -c 'SHOW primary_slot_name')"; then
+    echo 'Message: the replica was promoted and may have diverged from the primary, delete its data volume and pod to seed it again'
+    exit 0
+  fi
+  touch "$(sql -c 'SHOW data_directory')/` + ReseedMarker + `"
+  echo 'Recreate: yes'
+  echo 'Message: the replica is not a standby of the primary, seeding it from the primary again'
+  exit 0
+fi
+reload=
+if test "$(sql -c 'SHOW primary_conninfo')" != "$conninfo"; then
+  echo "ALTER SYSTEM SET primary_conninfo = :'conninfo';" | sql -v conninfo="$conninfo"
+  reload=1
+fi
+if test "$(sql -c 'SHOW primary_slot_name')" != "$slot"; then
+  echo "ALTER SYSTEM SET primary_slot_name = :'slot';" | sql -v slot="$slot"
+  reload=1
+fi
+if test -n "$reload"; then
+  sql -c 'SELECT pg_reload_conf()' >/dev/null
+  sleep 2
+fi
+echo "Status: $(sql -c 'SELECT status FROM pg_stat_wal_receiver')"
+echo "Lag: $(sql -c 'SELECT (CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END)::bigint')"`)
+}
+
+// ParseReplicaStatus 解析 ConfigureReplicaCommand 输出的 Key: Value 行，WAL 接收进程处于 streaming 状态时副本正在复制
+func (postgresEngine) ParseReplicaStatus(output string) ReplicaState {
+	fields := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+
+	state := ReplicaState{
+		Streaming: fields["Status"] == "streaming",
+		Message:   fields["Message"],
+		Recreate:  fields["Recreate"] == "yes",
+	}
+	if lag, err := strconv.ParseInt(fields["Lag"], 10, 64); err == nil {
+		state.LagSeconds = &lag
+	}
+	if state.Message == "" && !state.Streaming {
+		if fields["Status"] == "" {
+			state.Message = "the WAL receiver is not running, see the logs of the replica"
+		} else {
+			state.Message = "WAL receiver status: " + fields["Status"]
+		}
+	}
+	return state
+}
+
+// SeedFunctions 通过 pg_basebackup 以复制用户从主库复制数据目录，-R 写入 standby.signal 和连接主库的 primary_conninfo，
+// 使用副本自己的复制槽，主库在副本启动之前不会清理它需要的 WAL
+func (e postgresEngine) SeedFunctions() string {
+	port := strconv.Itoa(int(e.Port()))
+	return `primary_ready() {
+  pg_isready -q -h "$PRIMARY_HOST" -p ` + port + ` -t 5
+}
+seed() {
+  PGPASSWORD="$REPLICATION_PASSWORD" pg_basebackup -d "host=$PRIMARY_HOST port=` + port + ` user=` + e.ReplicationUser() + ` application_name=$POD_NAME" \
+    -D "$datadir" -X stream -R -S "$(echo "$POD_NAME" | tr - _)" --checkpoint=fast
+  chown -R postgres:postgres "$datadir"
+  chmod 700 "$datadir"
+}`
+}
+
+// PositionCommand 输出副本已经收到或者已经重放的最新 WAL 位置，不处于恢复状态的节点输出为空
+func (postgresEngine) PositionCommand() []string {
+	return shellCommand(postgresAdminPreamble + `
+test "$(sql -c 'SELECT pg_is_in_recovery()')" = t || exit 0
+sql -c 'SELECT GREATEST(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn())'`)
+}
+
+// ParsePosition 将 WAL 位置 X/X 转换为字节偏移量
+func (postgresEngine) ParsePosition(output string) (int64, error) {
+	lsn := strings.TrimSpace(output)
+	if !lsnPattern.MatchString(lsn) {
+		return 0, fmt.Errorf("invalid LSN %q, expected X/X", lsn)
+	}
+	high, low, _ := strings.Cut(lsn, "/")
+	h, err := strconv.ParseInt(high, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	l, err := strconv.ParseInt(low, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return h<<32 + l, nil
+}
+
+// PromoteCommand 通过 pg_promote 提升副本，PostgreSQL 在提升之前会重放已经收到的全部 WAL；节点已经不处于恢复状态时直接成功退出
+func (postgresEngine) PromoteCommand() []string {
+	return shellCommand(postgresAdminPreamble + `
+test "$(sql -c 'SELECT pg_is_in_recovery()')" = t || exit 0
+test "$(sql -c 'SELECT pg_promote(true, 300)')" = t`)
+}
+
+// FenceCommand 将新事务默认设为只读并断开现有的客户端连接；旧主库重新加入时数据目录会被替换，这项设置随之失效
+func (postgresEngine) FenceCommand() []string {
+	return shellCommand(postgresAdminPreamble + `
+sql -c 'ALTER SYSTEM SET default_transaction_read_only = on'
+sql -c 'SELECT pg_reload_conf()' >/dev/null
+sql -c "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE backend_type = 'client backend' AND pid <> pg_backend_pid()" >/dev/null`)
+}
+
+// PrimaryPositionCommand 输出主库当前的 WAL 写入位置
+func (postgresEngine) PrimaryPositionCommand() []string {
+	return shellCommand(postgresAdminPreamble + `
+sql -c 'SELECT pg_current_wal_lsn()'`)
+}
+
+// CatchUpCommand 每秒检查一次副本重放的 WAL 位置，直到它不小于主库的位置
+func (postgresEngine) CatchUpCommand(timeout time.Duration) []string {
+	return shellCommand(postgresAdminPreamble + `
+read -r TARGET
+deadline=$(($(date +%s) + ` + strconv.Itoa(int(timeout.Seconds())) + `))
+until test "$(echo "SELECT pg_last_wal_replay_lsn() >= :'target'::pg_lsn;" | sql -v target="$TARGET")" = t; do
+  if test "$(date +%s)" -ge "$deadline"; then
+    echo 'timed out waiting for the replica to catch up with the primary' >&2
+    exit 1
+  fi
+  sleep 1
+done`)
+}
@@ -0,0 +1,454 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// ArchiverContainerName 是数据库 Pod 中持续归档日志文件和制作基础备份的边车容器名称
+	ArchiverContainerName = "archiver"
+	// RecoveryContainerName 是新实例在数据库首次启动之前执行按时间点恢复的 init 容器名称
+	RecoveryContainerName = "point-in-time-recovery"
+
+	// archiveStagingDir 是数据库容器与 archiver 共享的 emptyDir，也存放 archiver 的临时文件
+	archiveStagingDir = "/archive"
+	// archiveCredentialsDir 是 archiver 挂载凭据 Secret 的目录，轮换后的密码会同步到这里
+	archiveCredentialsDir = "/credentials"
+	// recoveryScratchDir 是恢复时存放下载的基础备份、日志文件和临时数据库 socket 的 emptyDir
+	recoveryScratchDir = "/pitr"
+	// recoveryVolumeDir 是 init 容器挂载数据卷根目录的路径，恢复在旁边的目录中完成后才重命名为数据目录，
+	// 中途失败的恢复不会留下被数据库当作有效数据的目录
+	recoveryVolumeDir = "/data-volume"
+
+	// DefaultArchiveSwitchInterval 是未设置 spec.backup.archive.switchInterval 时强制切换日志文件的间隔
+	DefaultArchiveSwitchInterval = 5 * time.Minute
+	// DefaultBaseBackupInterval 是未设置 spec.backup.archive.baseBackupInterval 时制作基础备份的间隔
+	DefaultBaseBackupInterval = 24 * time.Hour
+	// DefaultBaseBackupRetention 是未设置 spec.backup.archive.baseBackupRetention 时保留的基础备份数量
+	DefaultBaseBackupRetention = 2
+
+	// minArchiveSwitchInterval 和 minBaseBackupInterval 避免过于频繁地切换日志文件和制作基础备份
+	minArchiveSwitchInterval = 10 * time.Second
+	minBaseBackupInterval    = time.Hour
+	// baseBackupRetryInterval 是基础备份失败之后重试的间隔
+	baseBackupRetryInterval = time.Minute
+	// archiveRetryInterval 是上传日志文件失败之后重试的间隔
+	archiveRetryInterval = 10 * time.Second
+)
+
+// ArchiveConfig 是从 spec.backup.archive 中解析出的持续归档配置
+type ArchiveConfig struct {
+	// SwitchInterval 是强制切换日志文件的最长间隔
+	SwitchInterval time.Duration
+	// BaseBackupInterval 是两次基础备份之间的间隔
+	BaseBackupInterval time.Duration
+	// BaseBackupRetention 是保留的基础备份数量
+	BaseBackupRetention int32
+}
+
+// NewArchiveConfig 从 spec.backup.archive 中解析持续归档配置，未开启归档时返回 nil，未设置的字段使用默认值
+func NewArchiveConfig(backup databasev2.BackupSpec) (*ArchiveConfig, error) {
+	archive := backup.Archive
+	if archive == nil {
+		return nil, nil
+	}
+	config := &ArchiveConfig{
+		SwitchInterval:      DefaultArchiveSwitchInterval,
+		BaseBackupInterval:  DefaultBaseBackupInterval,
+		BaseBackupRetention: DefaultBaseBackupRetention,
+	}
+	if archive.SwitchInterval != nil {
+		config.SwitchInterval = archive.SwitchInterval.Duration
+	}
+	if archive.BaseBackupInterval != nil {
+		config.BaseBackupInterval = archive.BaseBackupInterval.Duration
+	}
+	if archive.BaseBackupRetention != nil {
+		config.BaseBackupRetention = *archive.BaseBackupRetention
+	}
+
+	switch {
+	case config.SwitchInterval < minArchiveSwitchInterval:
+		return nil, fmt.Errorf("backup.archive.switchInterval %s is shorter than %s", config.SwitchInterval, minArchiveSwitchInterval)
+	case config.BaseBackupInterval < minBaseBackupInterval:
+		return nil, fmt.Errorf("backup.archive.baseBackupInterval %s is shorter than %s", config.BaseBackupInterval, minBaseBackupInterval)
+	case config.BaseBackupRetention < 1:
+		return nil, fmt.Errorf("backup.archive.baseBackupRetention must be at least 1, got %d", config.BaseBackupRetention)
+	}
+	return config, nil
+}
+
+// BackupDestinationRequired 判断实例是否需要访问备份位置：开启了定时备份、持续归档，或者从归档按时间点恢复
+func BackupDestinationRequired(dbInstance *databasev2.DatabaseInstance) bool {
+	return dbInstance.Spec.Backup.Enabled || dbInstance.Spec.Backup.Archive != nil || pointInTimeSourceOf(dbInstance) != nil
+}
+
+// RecoveryTargetOf 将 spec.bootstrap.pointInTime 转换为引擎的恢复目标
+func RecoveryTargetOf(source *databasev2.PointInTimeSource) engine.RecoveryTarget {
+	target := engine.RecoveryTarget{GTID: source.TargetGTID, LSN: source.TargetLSN}
+	if source.TargetTime != nil {
+		target.Time = ptr.To(source.TargetTime.Time)
+	}
+	return target
+}
+
+// archiverOf 返回引擎的归档实现，引擎不支持持续归档时返回错误
+func archiverOf(eng engine.Engine) (engine.Archiver, error) {
+	archiver, ok := eng.(engine.Archiver)
+	if !ok {
+		return nil, errors.New("continuous archiving and point-in-time recovery are not supported for " + eng.Name())
+	}
+	return archiver, nil
+}
+
+// pointInTimeSourceOf 返回实例的 spec.bootstrap.pointInTime，未设置时返回 nil
+func pointInTimeSourceOf(dbInstance *databasev2.DatabaseInstance) *databasev2.PointInTimeSource {
+	if dbInstance.Spec.Bootstrap == nil {
+		return nil
+	}
+	return dbInstance.Spec.Bootstrap.PointInTime
+}
+
+// UseArchiving 为 StatefulSet 开启持续归档：数据库容器追加引擎的归档参数，并与 archiver 边车容器共享 emptyDir；
+// archiver 以只读方式挂载数据卷，将写完的日志文件上传到备份位置的 <实例名>/archive/logs，
+// 按 BaseBackupInterval 将基础备份上传到 <实例名>/archive/base，并清理超出保留数量的基础备份和只有它们才需要的日志文件
+func UseArchiving(statefulSet *appsv1.StatefulSet, dbInstance *databasev2.DatabaseInstance, config ArchiveConfig, secret engine.SecretRef, eng engine.Engine) error {
+	archiver, err := archiverOf(eng)
+	if err != nil {
+		return err
+	}
+	if dbInstance.Spec.Topology.Replicas > 1 {
+		return errors.New("continuous archiving requires a single replica")
+	}
+
+	podSpec := &statefulSet.Spec.Template.Spec
+	staging := corev1.VolumeMount{Name: "archive", MountPath: archiveStagingDir}
+	podSpec.Volumes = append(podSpec.Volumes,
+		corev1.Volume{
+			Name:         staging.Name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name:         "credentials",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret.Name}},
+		},
+	)
+	database := &podSpec.Containers[0]
+	database.Args = append(database.Args, archiver.ArchiveArgs(archiveStagingDir, config.SwitchInterval)...)
+	database.VolumeMounts = append(database.VolumeMounts, staging)
+
+	codec := BackupCodecOf(dbInstance)
+	target := BackupTargetOf(dbInstance)
+	clientEnv := eng.ClientEnv(secret, "127.0.0.1")
+	sidecar := corev1.Container{
+		Name:    ArchiverContainerName,
+		Image:   BackupImageName(dbInstance),
+		Command: []string{"sh", "-c", archiverScript(archiver, codec, config, secret, clientEnv, archiveDir(target, dbInstance.Name), target.S3)},
+		Env:     append(clientEnv, codec.env()...),
+		VolumeMounts: []corev1.VolumeMount{
+			staging,
+			{
+				Name:      dataVolumeName,
+				MountPath: eng.DataPath(),
+				SubPath:   eng.Name(),
+				ReadOnly:  true,
+			},
+			{
+				Name:      "credentials",
+				MountPath: archiveCredentialsDir,
+				ReadOnly:  true,
+			},
+		},
+	}
+	useBackupTarget(podSpec, &sidecar, target)
+	podSpec.Containers = append(podSpec.Containers, sidecar)
+	return nil
+}
+
+// UsePointInTimeRecovery 为设置了 spec.bootstrap.pointInTime 的实例添加 init 容器：数据目录还不存在时，
+// 从源实例的归档中选择恢复目标之前最近的基础备份，用数据库镜像初始化数据目录并重放日志文件到恢复目标；
+// 数据目录已经存在时直接跳过，因此该 init 容器在实例的整个生命周期中都保留在 Pod 模板里
+func UsePointInTimeRecovery(statefulSet *appsv1.StatefulSet, dbInstance *databasev2.DatabaseInstance, resources corev1.ResourceRequirements, eng engine.Engine) error {
+	source := pointInTimeSourceOf(dbInstance)
+	if source == nil {
+		return nil
+	}
+	archiver, err := archiverOf(eng)
+	if err != nil {
+		return err
+	}
+	target := RecoveryTargetOf(source)
+	functions, err := archiver.RecoveryFunctions(target, recoveryScratchDir)
+	if err != nil {
+		return err
+	}
+
+	backupTarget := BackupTargetOf(dbInstance)
+	podSpec := &statefulSet.Spec.Template.Spec
+	scratch := corev1.VolumeMount{Name: "pitr", MountPath: recoveryScratchDir}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         scratch.Name,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	container := corev1.Container{
+		Name:      RecoveryContainerName,
+		Image:     podSpec.Containers[0].Image,
+		Command:   []string{"sh", "-c", recoveryScript(functions, target, eng, archiveDir(backupTarget, source.SourceInstance), backupTarget.S3)},
+		Env:       BackupCodecOf(dbInstance).env(),
+		Resources: resources,
+		VolumeMounts: []corev1.VolumeMount{
+			scratch,
+			{
+				Name:      dataVolumeName,
+				MountPath: recoveryVolumeDir,
+			},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	useBackupTarget(podSpec, &container, backupTarget)
+	podSpec.InitContainers = append(podSpec.InitContainers, container)
+	return nil
+}
+
+// useBackupTarget 为 Pod 中的 container 挂载备份卷，或者配置访问对象存储所需的 mc 客户端和访问密钥
+func useBackupTarget(podSpec *corev1.PodSpec, container *corev1.Container, target BackupTarget) {
+	if target.S3 != nil {
+		useS3Tools(podSpec, container, target.S3)
+		return
+	}
+	if !hasVolume(podSpec, "backup-volume") {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "backup-volume",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: target.ClaimName},
+			},
+		})
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "backup-volume", MountPath: "/backup"})
+}
+
+// archiveDir 返回 instanceName 的归档目录在脚本中的路径：备份卷中为 /backup/<实例名>/archive，
+// 对象存储中为 mc 使用的 dest/<存储桶>/<前缀>/<实例名>/archive
+func archiveDir(target BackupTarget, instanceName string) string {
+	if target.S3 != nil {
+		return "dest/" + target.S3.Bucket + "/" + S3ObjectKey(target.S3, instanceName+"/archive")
+	}
+	return "/backup/" + instanceName + "/archive"
+}
+
+// archiveStorageFunctions 返回访问归档目录 dir 的 shell 函数，名称均为相对于 dir 的路径：
+// list DIR 输出目录中的文件名，download NAME 将文件写入标准输出，put FILE NAME 上传本地文件，
+// put_stream NAME 上传标准输入，remove NAME 删除文件；备份卷中的文件先写入 .partial 再重命名，
+// 中断留下的 .partial 文件不会被恢复使用，由下一次清理删除
+func archiveStorageFunctions(dir string, s3 *databasev2.S3Destination) string {
+	if s3 != nil {
+		return fmt.Sprintf(`%s
+archive=%s
+list() { mc ls "$archive/$1/" 2> /dev/null | sed 's/.* //' || true; }
+download() { mc cat "$archive/$1"; }
+put() { mc cp "$1" "$archive/$2" > /dev/null; }
+put_stream() { mc pipe "$archive/$1" > /dev/null; }
+remove() { mc rm "$archive/$1" > /dev/null; }`, s3Preamble, dir)
+	}
+	return fmt.Sprintf(`archive=%s
+list() { ls "$archive/$1" 2> /dev/null || true; }
+download() { cat "$archive/$1"; }
+put() { mkdir -p "$(dirname "$archive/$2")" && cp "$1" "$archive/$2.partial" && mv "$archive/$2.partial" "$archive/$2"; }
+put_stream() { mkdir -p "$(dirname "$archive/$1")" && cat > "$archive/$1.partial" && mv "$archive/$1.partial" "$archive/$1"; }
+remove() { rm -f "$archive/$1"; }`, dir)
+}
+
+// credentialsRefreshFunction 返回 shell 函数 refresh_credentials，它从挂载的凭据 Secret 重新读取密码，
+// 更新 ClientEnv 中所有取自密码键的环境变量，使 archiver 在凭据轮换之后仍然可以连接数据库
+func credentialsRefreshFunction(env []corev1.EnvVar, secret engine.SecretRef) string {
+	var exports []string
+	for _, e := range env {
+		if e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil || e.ValueFrom.SecretKeyRef.Key != secret.PasswordKey {
+			continue
+		}
+		exports = append(exports, fmt.Sprintf("  %s=$(cat %s/%s) && export %s", e.Name, archiveCredentialsDir, secret.PasswordKey, e.Name))
+	}
+	if len(exports) == 0 {
+		return "refresh_credentials() { :; }"
+	}
+	return "refresh_credentials() {\n" + strings.Join(exports, " &&\n") + "\n}"
+}
+
+// archiverScript 返回 archiver 边车容器执行的 shell 脚本。脚本不使用 set -e：上传日志文件或基础备份失败时只记录错误并稍后重试，
+// 边车容器不会因为备份位置暂时不可用而重启。基础备份在后台执行，期间照常上传日志文件（PostgreSQL 的基础备份需要等待 WAL 归档）；
+// 基础备份成功后写入 <时间戳>.info，记录基础备份文件名、完成时间、需要的第一个日志文件和此时数据库的位置，恢复时据此选择基础备份
+func archiverScript(archiver engine.Archiver, codec BackupCodec, config ArchiveConfig, secret engine.SecretRef, clientEnv []corev1.EnvVar, dir string, s3 *databasev2.S3Destination) string {
+	suffix := codec.suffix()
+	produce := func(path string) string { return "base_backup > " + path }
+	return fmt.Sprintf(`trap 'exit 0' TERM
+%[1]s
+staging=%[2]s
+%[3]s
+%[4]s
+%[5]s
+info_field() { sed -n "s/^$1=//p" "$2"; }
+ship_logs() {
+  for log in $(ready_logs); do
+    name=${log##*/}%[6]s
+    if ! grep -qxF "$name" $staging/uploaded; then
+      encode "$log" $staging/upload && put $staging/upload "logs/$name" || return 1
+      rm -f $staging/upload
+      echo "$name" >> $staging/uploaded
+    fi
+    archived_log "$log" || return 1
+  done
+}
+take_base() {
+  set -e
+  rm -rf $staging/base
+  mkdir -p $staging/base
+  name=$(date -u +%%Y%%m%%dT%%H%%M%%SZ)
+  file=$name%[7]s%[6]s
+  position=$(base_position)
+  test -n "$position"
+  mkfifo $staging/base/out
+  put_stream "base/$file" < $staging/base/out &
+  upload=$!
+  trap 'kill $upload 2> /dev/null || true' EXIT
+  %[8]s
+  wait $upload
+  limit=$(base_limit)
+  printf 'file=%%s\nend=%%s\nposition=%%s\nlimit=%%s\n' "$file" "$(date +%%s)" "$position" "$limit" > $staging/base/info
+  put $staging/base/info "base/$name.info"
+  echo "base backup $file completed"
+  prune
+}
+prune() {
+  kept=
+  oldest=
+  for info in $(list base | grep '\.info$' | sort -r | head -n %[9]d); do
+    download "base/$info" > $staging/base/kept
+    kept="$kept $info $(info_field file $staging/base/kept)"
+    oldest=$(info_field position $staging/base/kept)
+  done
+  test -n "$oldest"
+  for name in $(list base); do
+    case " $kept " in *" $name "*) ;; *) remove "base/$name" ;; esac
+  done
+  for name in $(list logs); do
+    case "$name" in *.history*) continue ;; esac
+    if test "$name" \< "$oldest"; then remove "logs/$name"; fi
+  done
+}
+mkdir -p $staging
+list logs > $staging/uploaded
+next_base=0
+newest=$(list base | grep '\.info$' | sort | tail -n 1)
+if test -n "$newest" && download "base/$newest" > $staging/newest; then
+  next_base=$(( $(info_field end $staging/newest) + %[10]d ))
+fi
+next_switch=$(( $(date +%%s) + %[11]d ))
+base_running=
+while true; do
+  refresh_credentials
+  ship_logs || { echo 'failed to archive logs, retrying in %[12]ds' >&2; sleep %[12]d; }
+  now=$(date +%%s)
+  if test $now -ge $next_switch; then
+    switch_log || echo 'failed to switch to a new log file' >&2
+    next_switch=$((now + %[11]d))
+  fi
+  if test -f $staging/base.status; then
+    if test "$(cat $staging/base.status)" = 0; then
+      next_base=$((now + %[10]d))
+    else
+      echo 'base backup failed, retrying in %[13]ds' >&2
+      next_base=$((now + %[13]d))
+    fi
+    rm -f $staging/base.status
+    wait
+    base_running=
+  fi
+  if test -z "$base_running" && test $now -ge $next_base; then
+    { (take_base); echo $? > $staging/base.status; } &
+    base_running=1
+  fi
+  sleep 1
+done`,
+		archiveStorageFunctions(dir, s3), archiveStagingDir, credentialsRefreshFunction(clientEnv, secret),
+		archiver.ArchiveFunctions(archiveStagingDir), codec.encodeFunction(), suffix, archiver.BaseBackupExtension(),
+		strings.ReplaceAll(codec.encodeScript(archiveStagingDir+"/base", archiveStagingDir+"/base/out", produce), "\n", "\n  "),
+		config.BaseBackupRetention, int64(config.BaseBackupInterval.Seconds()), int64(config.SwitchInterval.Seconds()),
+		int64(archiveRetryInterval.Seconds()), int64(baseBackupRetryInterval.Seconds()))
+}
+
+// recoveryScript 返回按时间点恢复的 init 容器执行的 shell 脚本。访问归档的函数写入 <scratch>/archive.sh，
+// 数据库进程（PostgreSQL 的 restore_command）通过 <scratch>/fetch-log 按需下载日志文件；
+// 恢复在数据卷中的 <引擎名>.recovering 目录完成，成功后重命名为数据目录，之后 Pod 重启时直接跳过
+func recoveryScript(functions string, target engine.RecoveryTarget, eng engine.Engine, dir string, s3 *databasev2.S3Destination) string {
+	// 按时间恢复时只能使用在目标时间之前完成的基础备份
+	beforeTarget := ""
+	if target.Time != nil {
+		beforeTarget = fmt.Sprintf("\n  test \"$(info_field end %s/info)\" -le %d || continue", recoveryScratchDir, target.Time.Unix())
+	}
+	// 数据库进程以非 root 用户执行 fetch-log，也需要使用 mc 客户端及其配置
+	shareTools := ""
+	if s3 != nil {
+		shareTools = "\nchmod -R a+rwX " + s3ToolsDir
+	}
+	return fmt.Sprintf(`set -e
+datadir=%[1]s/%[2]s.recovering
+if test -d %[1]s/%[2]s; then
+  echo 'data directory already exists, skipping point-in-time recovery'
+  exit 0
+fi
+cat > %[3]s/archive.sh <<'EOF'
+%[4]s
+%[5]s
+fetch() {
+  download "$1" > "$2" && decode "$1" "$2"
+}
+fetch_log() {
+  for ext in '' .gz .zst .enc .gz.enc .zst.enc; do
+    if grep -qxF "$1$ext" %[3]s/logs; then
+      fetch "logs/$1$ext" "$2"
+      return
+    fi
+  done
+  return 1
+}
+archived_logs() {
+  grep -v '\.partial$' %[3]s/logs | sed -E 's/(\.gz|\.zst)?(\.enc)?$//' | sort -u | while read -r name; do
+    test "$name" \< "$1" || echo "$name"
+  done
+}
+info_field() { sed -n "s/^$1=//p" "$2"; }
+EOF
+printf '%%s\n' 'set -e' '. %[3]s/archive.sh' 'fetch_log "$1" "$2"' > %[3]s/fetch-log
+. %[3]s/archive.sh%[8]s
+list logs > %[3]s/logs
+%[6]s
+base=
+for info in $(list base | grep '\.info$' | sort -r); do
+  download "base/$info" > %[3]s/info%[7]s
+  if usable_base "$(info_field limit %[3]s/info)"; then
+    base=$(info_field file %[3]s/info)
+    position=$(info_field position %[3]s/info)
+    break
+  fi
+done
+test -n "$base" || { echo "no base backup in $archive/base precedes the recovery target" >&2; exit 1; }
+echo "recovering from base backup $base"
+fetch "base/$base" %[3]s/base
+rm -rf "$datadir"
+mkdir -p "$datadir"
+recover %[3]s/base "$position"
+rm -f %[3]s/base
+mv "$datadir" %[1]s/%[2]s
+echo 'point-in-time recovery completed'`,
+		recoveryVolumeDir, eng.Name(), recoveryScratchDir, archiveStorageFunctions(dir, s3), decodeFunction(), functions, beforeTarget, shareTools)
+}
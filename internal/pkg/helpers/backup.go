@@ -0,0 +1,172 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+// BackupResult 是备份任务执行成功后写入容器终止消息（/dev/termination-log）的 JSON
+type BackupResult struct {
+	// Path 是备份文件在备份卷中的路径，上传到对象存储时为 s3://<存储桶>/<对象键>
+	Path string `json:"path"`
+	// Size 是备份文件的字节数
+	Size int64 `json:"size"`
+	// Checksum 是备份文件的校验和，格式为 sha256:<十六进制摘要>
+	Checksum string `json:"checksum"`
+	// Encoding 是备份文件的压缩和加密方式，未压缩且未加密时为空
+	Encoding *databasev2.BackupEncoding `json:"encoding,omitempty"`
+}
+
+// BackupJobName 返回执行 DatabaseBackup 的 Job 名称
+func BackupJobName(backupName string) string {
+	return backupName + "-job"
+}
+
+// BackupImageName 返回实例的备份任务使用的镜像，未配置 spec.backup.image 时使用数据库镜像，其中自带 mysqldump、pg_dumpall 等导出工具
+func BackupImageName(dbInstance *databasev2.DatabaseInstance) string {
+	if dbInstance.Spec.Backup.Image != "" {
+		return dbInstance.Spec.Backup.Image
+	}
+	return GenerateImageName(dbInstance.Spec.Engine.Image, string(dbInstance.Spec.Engine.Type), dbInstance.Spec.Engine.Version)
+}
+
+// BackupLocation 返回备份卷 claimName 中的备份文件在 status 中记录的存储位置，格式为 pvc://<PVC 名称>/<卷内路径>；
+// 对象存储中的备份已经是 s3:// 位置，原样返回
+func BackupLocation(claimName, filePath string) string {
+	if strings.HasPrefix(filePath, "s3://") {
+		return filePath
+	}
+	return "pvc://" + claimName + "/" + strings.TrimPrefix(filePath, "/backup/")
+}
+
+// NewBackupJob 创建执行一次按需备份的 Job，备份文件写入备份卷的 /backup/<实例名>/<备份名>.sql，
+// 备份位置为对象存储时上传到 <prefix>/<实例名>/<备份名>.sql；配置了压缩或加密时追加 .gz、.zst、.enc 等扩展名
+// 导出完成后计算文件大小和 sha256 校验和，以 BackupResult 的形式写入容器的终止消息
+func NewBackupJob(backupName, instanceName, namespace, image string, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine, target BackupTarget, codec BackupCodec) *batchv1.Job {
+	name := BackupJobName(backupName)
+	labels := map[string]string{
+		"app": name,
+	}
+
+	fileName := backupName + codec.Extension()
+	command := []string{"sh", "-c", backupScript(eng, codec, "/backup/"+instanceName, fileName)}
+	if target.S3 != nil {
+		command = []string{"sh", "-c", s3BackupScript(eng, codec, target.S3.Bucket, S3ObjectKey(target.S3, instanceName), fileName)}
+	}
+	env := append(eng.ClientEnv(secret, instanceName), codec.env()...)
+	spec := newBackupJobSpec(name, image, target.ClaimName, command, env, resources)
+	spec.BackoffLimit = ptr.To[int32](2)
+	if target.S3 != nil {
+		useS3Destination(&spec, target.S3)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: spec,
+	}
+}
+
+// backupScript 返回导出数据库并上报备份结果的 shell 脚本，备份文件写入 dir 目录下的 fileName，fileName 可以包含 shell 展开（例如时间戳）
+// 先导出到临时文件，成功后再重命名，避免失败的备份留下不完整的文件；压缩和加密通过 /tmp 中的命名管道完成，大小和校验和按最终的文件计算
+func backupScript(eng engine.Engine, codec BackupCodec, dir, fileName string) string {
+	return fmt.Sprintf(`set -e
+mkdir -p %[1]s
+file=%[1]s/%[2]s
+trap 'rm -f "$file.partial"' EXIT
+%[3]s
+mv "$file.partial" "$file"
+size=$(wc -c < "$file")
+checksum=$(sha256sum "$file" | cut -d ' ' -f 1)
+test -n "$checksum"
+%[4]s
+printf '{"path":"%%s","size":%%d,"checksum":"sha256:%%s"%%s}' "$file" "$size" "$checksum" "$encoding" > /dev/termination-log`,
+		dir, fileName, codec.dumpScript(eng, "/tmp", "$file.partial"), codec.encodingScript())
+}
+
+// EnsureBackupJob 确保备份 Job 存在，返回集群中的 Job；备份卷由 EnsureBackupVolume 事先创建
+func EnsureBackupJob(ctx context.Context, c client.Client, desired *batchv1.Job) (*batchv1.Job, error) {
+	return ensureJob(ctx, c, desired)
+}
+
+// ObserveBackupJob 根据备份 Job 的执行情况计算 DatabaseBackup 的状态
+// 备份成功时从容器的终止消息中读取文件大小、位置和校验和，失败时将终止消息（日志末尾）记录到 message 中
+func ObserveBackupJob(ctx context.Context, c client.Client, job *batchv1.Job) (databasev2.DatabaseBackupStatus, error) {
+	status := databasev2.DatabaseBackupStatus{
+		Phase:          databasev2.BackupPhaseRunning,
+		Message:        "备份任务正在执行",
+		JobName:        job.Name,
+		StartTime:      job.Status.StartTime,
+		CompletionTime: job.Status.CompletionTime,
+	}
+
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return status, nil
+	}
+
+	message, failure, err := jobTerminationMessages(ctx, c, job)
+	if err != nil {
+		return status, err
+	}
+	result := &BackupResult{}
+	if succeeded && message != "" {
+		if err := json.Unmarshal([]byte(message), result); err != nil {
+			return status, fmt.Errorf("decode backup result of job %s: %w", job.Name, err)
+		}
+	}
+	if !succeeded || result.Path == "" {
+		status.Phase = databasev2.BackupPhaseFailed
+		status.Message = "备份任务执行失败"
+		if failure != "" {
+			status.Message += ": " + failure
+		}
+		if status.CompletionTime == nil {
+			status.CompletionTime = latestConditionTime(job)
+		}
+		return status, nil
+	}
+
+	status.Phase = databasev2.BackupPhaseCompleted
+	status.Message = "备份已完成"
+	status.Size = resource.NewQuantity(result.Size, resource.BinarySI)
+	status.Location = BackupLocation(backupClaimOf(&job.Spec.Template.Spec), result.Path)
+	status.Checksum = result.Checksum
+	status.Encoding = result.Encoding
+	return status, nil
+}
+
+// UpdateDatabaseBackupStatus 在状态发生变化时更新 DatabaseBackup 的状态
+func UpdateDatabaseBackupStatus(ctx context.Context, c client.Client, backup *databasev2.DatabaseBackup, status *databasev2.DatabaseBackupStatus) error {
+	logger := ctrl.FromContext(ctx)
+
+	if equality.Semantic.DeepEqual(backup.Status, *status) {
+		return nil
+	}
+	backup.Status = *status
+
+	if err := c.Status().Update(ctx, backup); err != nil {
+		logger.Error(err, "更新 DatabaseBackup 状态失败", "DatabaseBackup.Namespace", backup.Namespace, "DatabaseBackup.Name", backup.Name)
+		return err
+	}
+
+	logger.Info("成功更新 DatabaseBackup 状态", "DatabaseBackup.Namespace", backup.Namespace, "DatabaseBackup.Name", backup.Name, "phase", status.Phase)
+	return nil
+}
@@ -0,0 +1,232 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+// DefaultBackupVolumeSize 是 Operator 没有通过 --backup-volume-size 指定时备份卷的默认容量
+const DefaultBackupVolumeSize = "10Gi"
+
+// BackupVolumeDefaults 是 Operator 级别的备份卷默认配置，由命令行参数设置，spec.backup.destination.pvc 中的字段优先
+type BackupVolumeDefaults struct {
+	// StorageClassName 为空时使用集群默认的存储类
+	StorageClassName string
+	// Size 是备份卷的默认容量，为零时使用 DefaultBackupVolumeSize
+	Size resource.Quantity
+}
+
+// BackupTarget 是实例的备份实际写入的位置，ClaimName 和 S3 只有一个不为空
+type BackupTarget struct {
+	// ClaimName 是挂载到备份任务 /backup 目录的 PVC
+	ClaimName string
+	// Shared 表示 ClaimName 是通过 spec.backup.destination.pvc.claimName 指定的共享备份卷
+	Shared bool
+	// S3 是备份上传到的对象存储
+	S3 *databasev2.S3Destination
+}
+
+// BackupClaimName 返回实例专属的备份卷名称
+func BackupClaimName(instanceName string) string {
+	return instanceName + "-backup-pvc"
+}
+
+// BackupTargetOf 根据 spec.backup.destination 返回实例的备份位置，未配置时使用实例专属的备份卷
+func BackupTargetOf(dbInstance *databasev2.DatabaseInstance) BackupTarget {
+	destination := dbInstance.Spec.Backup.Destination
+	switch {
+	case destination != nil && destination.S3 != nil:
+		return BackupTarget{S3: destination.S3}
+	case destination != nil && destination.PVC != nil && destination.PVC.ClaimName != "":
+		return BackupTarget{ClaimName: destination.PVC.ClaimName, Shared: true}
+	}
+	return BackupTarget{ClaimName: BackupClaimName(dbInstance.Name)}
+}
+
+// NewBackupVolume 创建实例的备份卷，未在 spec.backup.destination.pvc 中设置的字段使用 defaults
+func NewBackupVolume(dbInstance *databasev2.DatabaseInstance, claimName string, defaults BackupVolumeDefaults) *corev1.PersistentVolumeClaim {
+	var spec databasev2.PVCDestination
+	if dbInstance.Spec.Backup.Destination != nil && dbInstance.Spec.Backup.Destination.PVC != nil {
+		spec = *dbInstance.Spec.Backup.Destination.PVC
+	}
+
+	size := defaults.Size
+	if size.IsZero() {
+		size = resource.MustParse(DefaultBackupVolumeSize)
+	}
+	if spec.Size != nil {
+		size = *spec.Size
+	}
+	storageClassName := spec.StorageClassName
+	if storageClassName == nil && defaults.StorageClassName != "" {
+		storageClassName = &defaults.StorageClassName
+	}
+	accessModes := spec.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: dbInstance.Namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: accessModes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+			StorageClassName: storageClassName,
+		},
+	}
+}
+
+// EnsureBackupVolume 确保备份写入 PVC 的实例拥有备份卷，不存在时创建，期望容量变大时扩容
+// 实例专属的备份卷由实例作为 controller 管理；共享的备份卷由多个实例共同拥有，只添加非 controller 的 OwnerReference，
+// 所有实例都被删除后才会被垃圾回收
+func EnsureBackupVolume(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, defaults BackupVolumeDefaults) error {
+	logger := ctrl.FromContext(ctx)
+
+	target := BackupTargetOf(dbInstance)
+	if target.ClaimName == "" {
+		return nil
+	}
+	desired := NewBackupVolume(dbInstance, target.ClaimName, defaults)
+	setOwner := func(pvc *corev1.PersistentVolumeClaim) error {
+		if target.Shared {
+			return controllerutil.SetOwnerReference(dbInstance, pvc, c.Scheme())
+		}
+		return controllerutil.SetControllerReference(dbInstance, pvc, c.Scheme())
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(desired), pvc)
+	if err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "获取备份卷失败")
+			return err
+		}
+		logger.Info("创建备份卷", "PVC.Namespace", desired.Namespace, "PVC.Name", desired.Name, "size", desired.Spec.Resources.Requests.Storage().String())
+		if err := setOwner(desired); err != nil {
+			logger.Error(err, "设置备份卷的 OwnerReference 失败")
+			return err
+		}
+		if err := c.Create(ctx, desired); err != nil {
+			logger.Error(err, "备份卷创建失败")
+			return err
+		}
+		return nil
+	}
+
+	changed := false
+	if !hasOwnerReference(pvc, dbInstance) {
+		if target.Shared || metav1.GetControllerOfNoCopy(pvc) != nil {
+			err = controllerutil.SetOwnerReference(dbInstance, pvc, c.Scheme())
+		} else {
+			err = setOwner(pvc)
+		}
+		if err != nil {
+			logger.Error(err, "设置备份卷的 OwnerReference 失败")
+			return err
+		}
+		changed = true
+	}
+	// 存储类和访问模式创建后不可修改，只在期望容量变大时扩容，需要存储类开启 allowVolumeExpansion
+	size := desired.Spec.Resources.Requests[corev1.ResourceStorage]
+	current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size.Cmp(current) > 0 {
+		logger.Info("扩容备份卷", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name, "from", current.String(), "to", size.String())
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := c.Update(ctx, pvc); err != nil {
+		logger.Error(err, "更新备份卷失败", "PVC.Name", pvc.Name)
+		return err
+	}
+	return nil
+}
+
+// observedBackupVolume 是计算 BackupVolumeReady 条件时观察到的备份卷及其存储类
+type observedBackupVolume struct {
+	claimName string
+	// pvc 为 nil 表示备份卷尚未创建
+	pvc *corev1.PersistentVolumeClaim
+	// storageClass 为 nil 且 storageClassMissing 为 false 表示 PVC 没有指定存储类
+	storageClass        *storagev1.StorageClass
+	storageClassMissing bool
+}
+
+// observeBackupVolume 获取实例的备份卷以及它使用的存储类，备份写入对象存储时返回 nil
+func observeBackupVolume(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) (*observedBackupVolume, error) {
+	logger := ctrl.FromContext(ctx)
+
+	target := BackupTargetOf(dbInstance)
+	if target.ClaimName == "" {
+		return nil, nil
+	}
+	observed := &observedBackupVolume{claimName: target.ClaimName}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := c.Get(ctx, client.ObjectKey{Name: target.ClaimName, Namespace: dbInstance.Namespace}, pvc); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "获取备份卷失败")
+			return nil, err
+		}
+		return observed, nil
+	}
+	observed.pvc = pvc
+
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return observed, nil
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := c.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, storageClass); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "获取存储类失败")
+			return nil, err
+		}
+		observed.storageClassMissing = true
+		return observed, nil
+	}
+	observed.storageClass = storageClass
+	return observed, nil
+}
+
+// backupVolumeCondition 根据备份卷的绑定情况返回 BackupVolumeReady 条件的状态、原因和消息
+// WaitForFirstConsumer 的存储类在第一个备份任务使用备份卷之前不会绑定，此时条件为 Unknown
+func backupVolumeCondition(observed *observedBackupVolume) (metav1.ConditionStatus, string, string) {
+	pvc := observed.pvc
+	switch {
+	case pvc == nil:
+		return metav1.ConditionFalse, "ClaimNotFound", fmt.Sprintf("备份卷 %s 尚未创建", observed.claimName)
+	case pvc.Status.Phase == corev1.ClaimBound:
+		return metav1.ConditionTrue, "Bound", fmt.Sprintf("备份卷 %s 已绑定", pvc.Name)
+	case pvc.Status.Phase == corev1.ClaimLost:
+		return metav1.ConditionFalse, "ClaimLost", fmt.Sprintf("备份卷 %s 绑定的 PV 已丢失", pvc.Name)
+	case observed.storageClassMissing:
+		return metav1.ConditionFalse, "StorageClassNotFound", fmt.Sprintf("备份卷 %s 使用的存储类 %s 不存在", pvc.Name, *pvc.Spec.StorageClassName)
+	case observed.storageClass != nil && observed.storageClass.VolumeBindingMode != nil &&
+		*observed.storageClass.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer &&
+		pvc.Annotations["volume.kubernetes.io/selected-node"] == "":
+		return metav1.ConditionUnknown, "WaitForFirstConsumer", fmt.Sprintf("备份卷 %s 将在第一个备份任务运行时绑定", pvc.Name)
+	}
+	return metav1.ConditionFalse, "ClaimPending", fmt.Sprintf("备份卷 %s 尚未绑定，请通过 kubectl describe pvc %s 查看原因", pvc.Name, pvc.Name)
+}
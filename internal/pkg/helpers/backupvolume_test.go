@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+var _ = Describe("Backup volume", func() {
+	ctx := context.Background()
+
+	// newInstance 返回一个使用 destination 作为备份位置的实例，UID 用于设置 OwnerReference
+	newInstance := func(name string, destination *databasev2.BackupDestination) *databasev2.DatabaseInstance {
+		return &databasev2.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				UID:       types.UID(name + "-uid"),
+			},
+			Spec: databasev2.DatabaseInstanceSpec{
+				Engine: databasev2.EngineSpec{Type: databasev2.EngineMySQL},
+				Backup: databasev2.BackupSpec{Destination: destination},
+			},
+		}
+	}
+
+	getVolume := func(c client.Client, claimName string) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: claimName, Namespace: "default"}, pvc)).To(Succeed())
+		return pvc
+	}
+
+	Context("When choosing the backup volume", func() {
+		It("should use the operator defaults and let the instance override them", func() {
+			defaults := BackupVolumeDefaults{StorageClassName: "standard", Size: resource.MustParse("20Gi")}
+
+			pvc := NewBackupVolume(newInstance("mydb", nil), BackupClaimName("mydb"), defaults)
+			Expect(pvc.Name).To(Equal("mydb-backup-pvc"))
+			Expect(pvc.Spec.StorageClassName).To(Equal(ptr.To("standard")))
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal("20Gi"))
+			Expect(pvc.Spec.AccessModes).To(Equal([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}))
+
+			pvc = NewBackupVolume(newInstance("mydb", &databasev2.BackupDestination{PVC: &databasev2.PVCDestination{
+				StorageClassName: ptr.To("nfs"),
+				Size:             ptr.To(resource.MustParse("50Gi")),
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			}}), BackupClaimName("mydb"), defaults)
+			Expect(pvc.Spec.StorageClassName).To(Equal(ptr.To("nfs")))
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal("50Gi"))
+			Expect(pvc.Spec.AccessModes).To(Equal([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}))
+		})
+
+		It("should fall back to the default size and the cluster default storage class", func() {
+			pvc := NewBackupVolume(newInstance("mydb", nil), BackupClaimName("mydb"), BackupVolumeDefaults{})
+			Expect(pvc.Spec.StorageClassName).To(BeNil())
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal(DefaultBackupVolumeSize))
+		})
+	})
+
+	Context("When ensuring the backup volume", func() {
+		It("should create a dedicated backup volume controlled by the instance", func() {
+			c := newFakeClient()
+			dbInstance := newInstance("mydb", nil)
+
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			pvc := getVolume(c, "mydb-backup-pvc")
+			Expect(metav1.IsControlledBy(pvc, dbInstance)).To(BeTrue())
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal(DefaultBackupVolumeSize))
+		})
+
+		It("should share a backup volume between instances without controlling it", func() {
+			c := newFakeClient()
+			destination := &databasev2.BackupDestination{PVC: &databasev2.PVCDestination{ClaimName: "shared-backups"}}
+			first := newInstance("first", destination)
+			second := newInstance("second", destination)
+
+			Expect(EnsureBackupVolume(ctx, c, first, BackupVolumeDefaults{})).To(Succeed())
+			Expect(EnsureBackupVolume(ctx, c, second, BackupVolumeDefaults{})).To(Succeed())
+			pvc := getVolume(c, "shared-backups")
+			Expect(metav1.GetControllerOf(pvc)).To(BeNil())
+			Expect(pvc.OwnerReferences).To(ConsistOf(
+				HaveField("UID", first.UID),
+				HaveField("UID", second.UID),
+			))
+		})
+
+		It("should adopt an existing backup volume instead of recreating it", func() {
+			existing := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "mydb-backup-pvc", Namespace: "default", Labels: map[string]string{"keep": "me"}},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+					},
+				},
+			}
+			c := newFakeClient(existing)
+			dbInstance := newInstance("mydb", nil)
+
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			pvc := getVolume(c, "mydb-backup-pvc")
+			Expect(metav1.IsControlledBy(pvc, dbInstance)).To(BeTrue())
+			Expect(pvc.Labels).To(HaveKeyWithValue("keep", "me"))
+
+			// 再次调和时备份卷没有变化，不会产生更新
+			version := pvc.ResourceVersion
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			Expect(getVolume(c, "mydb-backup-pvc").ResourceVersion).To(Equal(version))
+		})
+
+		It("should not take over a backup volume controlled by another owner", func() {
+			c := newFakeClient()
+			previous := newInstance("previous", nil)
+			dbInstance := newInstance("mydb", &databasev2.BackupDestination{PVC: &databasev2.PVCDestination{ClaimName: "previous-backup-pvc"}})
+			Expect(EnsureBackupVolume(ctx, c, previous, BackupVolumeDefaults{})).To(Succeed())
+
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			pvc := getVolume(c, "previous-backup-pvc")
+			Expect(metav1.IsControlledBy(pvc, previous)).To(BeTrue())
+			Expect(pvc.OwnerReferences).To(ContainElement(HaveField("UID", dbInstance.UID)))
+		})
+
+		It("should expand the backup volume but never shrink it or change immutable fields", func() {
+			c := newFakeClient()
+			dbInstance := newInstance("mydb", &databasev2.BackupDestination{PVC: &databasev2.PVCDestination{
+				StorageClassName: ptr.To("standard"),
+				Size:             ptr.To(resource.MustParse("10Gi")),
+			}})
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+
+			By("Expanding the volume when the requested size grows")
+			dbInstance.Spec.Backup.Destination.PVC.Size = ptr.To(resource.MustParse("30Gi"))
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			Expect(getVolume(c, "mydb-backup-pvc").Spec.Resources.Requests.Storage().String()).To(Equal("30Gi"))
+
+			By("Keeping the size when the requested size shrinks")
+			dbInstance.Spec.Backup.Destination.PVC.Size = ptr.To(resource.MustParse("5Gi"))
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			Expect(getVolume(c, "mydb-backup-pvc").Spec.Resources.Requests.Storage().String()).To(Equal("30Gi"))
+
+			By("Leaving the storage class and access modes as they were created")
+			dbInstance.Spec.Backup.Destination.PVC.StorageClassName = ptr.To("nfs")
+			dbInstance.Spec.Backup.Destination.PVC.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			pvc := getVolume(c, "mydb-backup-pvc")
+			Expect(pvc.Spec.StorageClassName).To(Equal(ptr.To("standard")))
+			Expect(pvc.Spec.AccessModes).To(Equal([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}))
+		})
+
+		It("should not create a backup volume for backups in object storage", func() {
+			c := newFakeClient()
+			dbInstance := newInstance("mydb", &databasev2.BackupDestination{S3: &databasev2.S3Destination{Bucket: "backups"}})
+
+			Expect(EnsureBackupVolume(ctx, c, dbInstance, BackupVolumeDefaults{})).To(Succeed())
+			var pvcs corev1.PersistentVolumeClaimList
+			Expect(c.List(ctx, &pvcs)).To(Succeed())
+			Expect(pvcs.Items).To(BeEmpty())
+		})
+	})
+
+	DescribeTable("should report whether the backup volume is ready",
+		func(observed *observedBackupVolume, status metav1.ConditionStatus, reason string) {
+			got, gotReason, _ := backupVolumeCondition(observed)
+			Expect(got).To(Equal(status))
+			Expect(gotReason).To(Equal(reason))
+		},
+		Entry("not created yet", &observedBackupVolume{claimName: "mydb-backup-pvc"},
+			metav1.ConditionFalse, "ClaimNotFound"),
+		Entry("bound", &observedBackupVolume{pvc: boundVolume(corev1.ClaimBound, "")},
+			metav1.ConditionTrue, "Bound"),
+		Entry("lost", &observedBackupVolume{pvc: boundVolume(corev1.ClaimLost, "")},
+			metav1.ConditionFalse, "ClaimLost"),
+		Entry("missing storage class", &observedBackupVolume{pvc: boundVolume(corev1.ClaimPending, "fast"), storageClassMissing: true},
+			metav1.ConditionFalse, "StorageClassNotFound"),
+		Entry("waiting for the first backup", &observedBackupVolume{
+			pvc:          boundVolume(corev1.ClaimPending, "local"),
+			storageClass: &storagev1.StorageClass{VolumeBindingMode: ptr.To(storagev1.VolumeBindingWaitForFirstConsumer)},
+		}, metav1.ConditionUnknown, "WaitForFirstConsumer"),
+		Entry("pending", &observedBackupVolume{
+			pvc:          boundVolume(corev1.ClaimPending, "standard"),
+			storageClass: &storagev1.StorageClass{VolumeBindingMode: ptr.To(storagev1.VolumeBindingImmediate)},
+		}, metav1.ConditionFalse, "ClaimPending"),
+	)
+})
+
+// boundVolume 返回处于 phase 阶段、使用 storageClassName 存储类的备份卷
+func boundVolume(phase corev1.PersistentVolumeClaimPhase, storageClassName string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb-backup-pvc", Namespace: "default"},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+	if storageClassName != "" {
+		pvc.Spec.StorageClassName = &storageClassName
+	}
+	return pvc
+}
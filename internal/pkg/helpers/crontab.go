@@ -6,131 +6,38 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
 )
 
-// getCronJobConfig 根据数据库类型获取命令和环境变量
-func getCronJobConfig(name, databaseType string) ([]string, []corev1.EnvVar) {
-	switch databaseType {
-	case "mysql":
-		return []string{"sh", "-c", "mysqldump -h $DB_HOST -P $DB_PORT -u$MYSQL_USER -p$MYSQL_PASSWORD --all-databases > /backup/db-backup.sql"},
-			[]corev1.EnvVar{
-				{
-					Name: "MYSQL_USER",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "mysql-secret",
-							},
-							Key: "mysql-user",
-						},
-					},
-				},
-				{
-					Name: "MYSQL_PASSWORD",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "mysql-secret",
-							},
-							Key: "mysql-password",
-						},
-					},
-				},
-				{
-					Name:  "DB_HOST",
-					Value: name,
-				},
-				{
-					Name:  "DB_PORT",
-					Value: "3306", // MySQL 默认端口
-				},
-			}
-	case "postgres":
-		return []string{"sh", "-c", "pg_dumpall -h $DB_HOST -p $DB_PORT -U $POSTGRES_USER > /backup/db-backup.sql"},
-			[]corev1.EnvVar{
-				{
-					Name: "POSTGRES_USER",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "postgres-secret",
-							},
-							Key: "postgres-user",
-						},
-					},
-				},
-				{
-					Name: "POSTGRES_PASSWORD",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "postgres-secret",
-							},
-							Key: "postgres-password",
-						},
-					},
-				},
-				{
-					Name:  "DB_HOST",
-					Value: name,
-				},
-				{
-					Name:  "DB_PORT",
-					Value: "5432", // PostgreSQL 默认端口
-				},
-			}
-	case "oceanbase-ce":
-		return []string{"sh", "-c", "obclient -h $DB_HOST -P $DB_PORT -u $OBD_USER -p$OBD_PASSWORD -e \"BACKUP DATABASE TO '/backup/backup.sql'\""},
-			[]corev1.EnvVar{
-				{
-					Name: "OBD_USER",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "oceanbase-ce-secret",
-							},
-							Key: "oceanbase-user",
-						},
-					},
-				},
-				{
-					Name: "OBD_PASSWORD",
-					ValueFrom: &corev1.EnvVarSource{
-						SecretKeyRef: &corev1.SecretKeySelector{
-							LocalObjectReference: corev1.LocalObjectReference{
-								Name: "oceanbase-ce-secret",
-							},
-							Key: "oceanbase-password",
-						},
-					},
-				},
-				{
-					Name:  "DB_HOST",
-					Value: name,
-				},
-				{
-					Name:  "DB_PORT",
-					Value: "2881", // OceanBase-CE 默认端口
-				},
-			}
-	default:
-		return []string{"sh", "-c", "echo 'Unsupported database type'"}, nil
-	}
+// BackupCronJobName 返回实例的定时备份 CronJob 名称，由它创建的 Job 也带有 app=<该名称> 标签
+func BackupCronJobName(instanceName string) string {
+	return instanceName + "-backup"
 }
 
-// NewCronJob 根据数据库类型创建 CronJob
-func NewCronJob(name, namespace, image, schedule, databaseType string) *batchv1.CronJob {
+// NewCronJob 根据数据库类型创建实例的定时备份 CronJob，每次备份写入备份卷中带时间戳的文件 /backup/<实例名>/<实例名>-<UTC 时间>.sql，
+// 备份位置为对象存储时以流式上传到 <prefix>/<实例名>/<实例名>-<UTC 时间>.sql；配置了压缩或加密时追加对应的扩展名
+func NewCronJob(instanceName, namespace, image, schedule string, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine, target BackupTarget, codec BackupCodec) *batchv1.CronJob {
+	name := BackupCronJobName(instanceName)
 	labels := map[string]string{
 		"app": name,
 	}
 
-	command, envVars := getCronJobConfig(name, databaseType)
+	// 备份任务通过实例的 Service 连接数据库，使用与数据库容器相同的凭据 Secret，并执行引擎的备份命令
+	fileName := instanceName + "-$(date -u +%Y%m%dT%H%M%SZ)" + codec.Extension()
+	command := []string{"sh", "-c", backupScript(eng, codec, "/backup/"+instanceName, fileName)}
+	if target.S3 != nil {
+		command = []string{"sh", "-c", s3BackupScript(eng, codec, target.S3.Bucket, S3ObjectKey(target.S3, instanceName), fileName)}
+	}
+	env := append(eng.ClientEnv(secret, instanceName), codec.env()...)
+	jobSpec := newBackupJobSpec(name, image, target.ClaimName, command, env, resources)
+	if target.S3 != nil {
+		useS3Destination(&jobSpec, target.S3)
+	}
 
 	// 定义 CronJob
 	return &batchv1.CronJob{
@@ -141,101 +48,84 @@ func NewCronJob(name, namespace, image, schedule, databaseType string) *batchv1.
 		},
 		Spec: batchv1.CronJobSpec{
 			Schedule: schedule,
+			// 同一时间只运行一个备份，避免并发的导出互相影响，也保证备份按完成时间依次记录到清单中
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
 			JobTemplate: batchv1.JobTemplateSpec{
-				Spec: batchv1.JobSpec{
-					Template: corev1.PodTemplateSpec{
-						ObjectMeta: metav1.ObjectMeta{
-							Labels: labels,
-						},
-						Spec: corev1.PodSpec{
-							Containers: []corev1.Container{
-								{
-									Name:    name,
-									Image:   image,
-									Command: command,
-									Env:     envVars,
-									VolumeMounts: []corev1.VolumeMount{
-										{
-											Name:      "backup-volume",
-											MountPath: "/backup",
-										},
-									},
-								},
-							},
-							RestartPolicy: corev1.RestartPolicyOnFailure,
-							Volumes: []corev1.Volume{
-								{
-									Name: "backup-volume",
-									VolumeSource: corev1.VolumeSource{
-										// 使用存储卷声明 PVC 来实现挂载，需事先完成 StorageClass 存储类的创建
-										PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-											ClaimName: "backup-pvc",
-										},
-									},
-								},
-							},
-						},
-					},
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
 				},
+				Spec: jobSpec,
 			},
 		},
 	}
 }
 
-// ensurePVC 确保 PVC 存在，如果不存在则创建
-func ensurePVC(ctx context.Context, c client.Client, name, namespace string) error {
-	logger := ctrl.FromContext(ctx)
+// newBackupJobSpec 创建将备份卷 claimName 挂载到 /backup 的 JobSpec，定时备份、按需备份、最终备份和恢复任务共用同一个模板
+// claimName 为空时不挂载备份卷，由 useS3Destination 配置对象存储
+func newBackupJobSpec(name, image, claimName string, command []string, envVars []corev1.EnvVar, resources corev1.ResourceRequirements) batchv1.JobSpec {
+	labels := map[string]string{
+		"app": name,
+	}
 
-	// 定义 PVC
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
+	spec := batchv1.JobSpec{
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labels,
 			},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse("1Gi"),
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:      name,
+						Image:     image,
+						Command:   command,
+						Env:       envVars,
+						Resources: resources,
+						// 失败时终止消息取自容器日志的末尾，便于在状态中说明失败原因
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					},
 				},
-				Limits: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse("10Gi"), // 请根据实际需求进行设置
+				RestartPolicy: corev1.RestartPolicyOnFailure,
+			},
+		},
+	}
+	if claimName == "" {
+		return spec
+	}
+
+	podSpec := &spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{
+			Name:      "backup-volume",
+			MountPath: "/backup",
+		},
+	}
+	podSpec.Volumes = []corev1.Volume{
+		{
+			Name: "backup-volume",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: claimName,
 				},
 			},
-			StorageClassName: ptr.To("your-actual-storage-class-name-that-has-been-pre-created"), // 替换为已预先创建好的实际存储类名称
 		},
 	}
+	return spec
+}
 
-	// 尝试获取 PVC
-	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, pvc)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// 如果 PVC 不存在，则创建
-			logger.Info("创建一个新的 PVC", "PVC.Namespace", namespace, "PVC.Name", name)
-			if err := c.Create(ctx, pvc); err != nil {
-				logger.Error(err, "新的 PVC 创建失败")
-				return err
-			}
-		} else {
-			// 如果出现其他错误，返回错误
-			logger.Error(err, "获取 PVC 失败")
-			return err
+// backupClaimOf 返回 Pod 挂载的备份卷名称，备份写入对象存储时返回空字符串
+func backupClaimOf(podSpec *corev1.PodSpec) string {
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == "backup-volume" && volume.PersistentVolumeClaim != nil {
+			return volume.PersistentVolumeClaim.ClaimName
 		}
 	}
-	return nil
+	return ""
 }
 
 // EnsureCronJob 确保 CronJob 资源存在，如果不存在则创建，如果存在则更新
 func EnsureCronJob(ctx context.Context, c client.Client, desired *batchv1.CronJob) error {
 	logger := ctrl.FromContext(ctx)
 
-	// 确保 PVC 存在
-	if err := ensurePVC(ctx, c, "backup-pvc", desired.Namespace); err != nil {
-		return err
-	}
-
 	var existing batchv1.CronJob
 	err := c.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, &existing)
 	if err != nil {
@@ -255,6 +145,7 @@ func EnsureCronJob(ctx context.Context, c client.Client, desired *batchv1.CronJo
 		// 如果 CronJob 已存在，则更新
 		logger.Info("更新已有的 CronJob", "CronJob.Namespace", desired.Namespace, "CronJob.Name", desired.Name)
 		existing.Spec = desired.Spec
+		mergeOwnerReferences(&existing, desired)
 		if err := c.Update(ctx, &existing); err != nil {
 			logger.Error(err, "更新 CronJob 失败")
 			return err
@@ -0,0 +1,217 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+// RetainedFromAnnotation 记录被保留下来的资源原先所属的实例，便于之后重新接管或手动清理
+const RetainedFromAnnotation = "apps.leqiutong.xyz/retained-from"
+
+// FinalBackupJobName 返回删除实例前执行最终备份的 Job 名称
+func FinalBackupJobName(instanceName string) string {
+	return instanceName + "-final-backup"
+}
+
+// NewFinalBackupJob 创建删除实例前执行的最终备份 Job，与按需备份使用相同的脚本和目录结构：备份文件写入备份卷的
+// /backup/<实例名>/<实例名>-final.sql，备份位置为对象存储时上传到 <prefix>/<实例名>/<实例名>-final.sql；配置了压缩或加密时追加对应的扩展名
+func NewFinalBackupJob(instanceName, namespace, image string, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine, target BackupTarget, codec BackupCodec) *batchv1.Job {
+	name := FinalBackupJobName(instanceName)
+	labels := map[string]string{
+		"app": name,
+	}
+
+	fileName := instanceName + "-final" + codec.Extension()
+	command := []string{"sh", "-c", backupScript(eng, codec, "/backup/"+instanceName, fileName)}
+	if target.S3 != nil {
+		command = []string{"sh", "-c", s3BackupScript(eng, codec, target.S3.Bucket, S3ObjectKey(target.S3, instanceName), fileName)}
+	}
+	env := append(eng.ClientEnv(secret, instanceName), codec.env()...)
+	spec := newBackupJobSpec(name, image, target.ClaimName, command, env, resources)
+	spec.BackoffLimit = ptr.To[int32](2)
+	if target.S3 != nil {
+		useS3Destination(&spec, target.S3)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: spec,
+	}
+}
+
+// EnsureFinalBackupJob 确保最终备份 Job 存在，返回 Job 是否已经执行结束以及是否成功
+func EnsureFinalBackupJob(ctx context.Context, c client.Client, desired *batchv1.Job) (bool, bool, error) {
+	logger := ctrl.FromContext(ctx)
+
+	found := &batchv1.Job{}
+	err := c.Get(ctx, client.ObjectKey{Name: desired.Name, Namespace: desired.Namespace}, found)
+	if err != nil && client.IgnoreNotFound(err) == nil {
+		logger.Info("创建最终备份 Job", "Job.Namespace", desired.Namespace, "Job.Name", desired.Name)
+		if err := c.Create(ctx, desired); err != nil {
+			logger.Error(err, "最终备份 Job 创建失败")
+			return false, false, err
+		}
+		return false, false, nil
+	} else if err != nil {
+		logger.Error(err, "获取最终备份 Job 失败")
+		return false, false, err
+	}
+
+	finished, succeeded := jobFinished(found)
+	return finished, succeeded, nil
+}
+
+// BackupCleanupJobName 返回按照 Delete 删除策略清理实例全部备份的 Job 名称
+func BackupCleanupJobName(instanceName string) string {
+	return instanceName + "-backup-cleanup"
+}
+
+// NewBackupCleanupJob 创建删除实例全部备份的 Job，包括定时备份、按需备份和持续归档的日志：
+// 在备份卷中删除 /backup/<实例名> 目录，备份位置为对象存储时删除 <prefix>/<实例名>/ 下的全部对象
+func NewBackupCleanupJob(instanceName, namespace, image string, target BackupTarget) (*batchv1.Job, error) {
+	name := BackupCleanupJobName(instanceName)
+	labels := map[string]string{
+		"app": name,
+	}
+
+	dir := "/backup/" + instanceName
+	if !safeBackupPath.MatchString(dir) || strings.Contains(dir, "..") {
+		return nil, fmt.Errorf("refusing to remove unexpected backup directory %q", dir)
+	}
+	command := []string{"rm", "-rf", dir}
+	if target.S3 != nil {
+		key := S3ObjectKey(target.S3, instanceName)
+		if !safeBackupPath.MatchString("/backup/"+key) || strings.Contains(key, "..") {
+			return nil, fmt.Errorf("refusing to remove unexpected backup prefix %q", key)
+		}
+		command = []string{"sh", "-c", s3CleanupScript("dest/" + target.S3.Bucket + "/" + key + "/")}
+	}
+	spec := newBackupJobSpec(name, image, target.ClaimName, command, nil, corev1.ResourceRequirements{})
+	spec.BackoffLimit = ptr.To[int32](2)
+	if target.S3 != nil {
+		useS3Destination(&spec, target.S3)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: spec,
+	}, nil
+}
+
+// EnsureBackupCleanupJob 确保备份清理 Job 存在，返回 Job 是否已经执行结束以及是否成功
+func EnsureBackupCleanupJob(ctx context.Context, c client.Client, desired *batchv1.Job) (bool, bool, error) {
+	job, err := ensureJob(ctx, c, desired)
+	if err != nil {
+		return false, false, err
+	}
+	finished, succeeded := jobFinished(job)
+	return finished, succeeded, nil
+}
+
+// DeleteDataVolumes 删除实例的 StatefulSet 以及每个副本的数据卷 PVC
+// volumeClaimTemplates 创建的 PVC 不属于任何对象，不会被垃圾回收，必须显式删除
+func DeleteDataVolumes(ctx context.Context, c client.Client, name, namespace string) error {
+	logger := ctrl.FromContext(ctx)
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if err := c.Delete(ctx, statefulSet); client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "删除 StatefulSet 失败")
+		return err
+	}
+
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := c.List(ctx, &pvcs, client.InNamespace(namespace), client.MatchingLabels{"app": name}); err != nil {
+		logger.Error(err, "获取数据卷 PVC 列表失败")
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !strings.HasPrefix(pvc.Name, dataVolumeName+"-"+name+"-") {
+			continue
+		}
+		logger.Info("删除数据卷 PVC", "PVC.Namespace", namespace, "PVC.Name", pvc.Name)
+		if err := c.Delete(ctx, pvc); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "删除数据卷 PVC 失败", "PVC.Name", pvc.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// DetachRetainedResources 移除 PVC（以及 includeSecrets 为 true 时的 Secret）上指向实例的 OwnerReference，
+// 使它们在实例被删除后不会被垃圾回收，并通过注解记录原先所属的实例
+func DetachRetainedResources(ctx context.Context, c client.Client, owner metav1.Object, includeSecrets bool) error {
+	logger := ctrl.FromContext(ctx)
+
+	var objects []client.Object
+
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := c.List(ctx, &pvcs, client.InNamespace(owner.GetNamespace())); err != nil {
+		logger.Error(err, "获取 PVC 列表失败")
+		return err
+	}
+	for i := range pvcs.Items {
+		objects = append(objects, &pvcs.Items[i])
+	}
+
+	if includeSecrets {
+		var secrets corev1.SecretList
+		if err := c.List(ctx, &secrets, client.InNamespace(owner.GetNamespace())); err != nil {
+			logger.Error(err, "获取 Secret 列表失败")
+			return err
+		}
+		for i := range secrets.Items {
+			objects = append(objects, &secrets.Items[i])
+		}
+	}
+
+	for _, obj := range objects {
+		if !hasOwnerReference(obj, owner) {
+			continue
+		}
+		if err := controllerutil.RemoveOwnerReference(owner, obj, c.Scheme()); err != nil {
+			logger.Error(err, "移除 OwnerReference 失败", "Name", obj.GetName())
+			return err
+		}
+		// 共享资源仍被其他实例引用时，不需要记录保留来源
+		if len(obj.GetOwnerReferences()) == 0 {
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[RetainedFromAnnotation] = owner.GetName()
+			obj.SetAnnotations(annotations)
+		}
+		logger.Info("保留资源并解除与实例的从属关系", "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		if err := c.Update(ctx, obj); err != nil {
+			logger.Error(err, "更新被保留的资源失败", "Name", obj.GetName())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+var _ = Describe("Final backup", func() {
+	var eng engine.Engine
+	var secret engine.SecretRef
+
+	BeforeEach(func() {
+		var err error
+		eng, err = engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+		secret = engine.SecretRef{Name: InstanceSecretName("mydb"), UsernameKey: "mysql-user", PasswordKey: "mysql-password"}
+	})
+
+	script := func(target BackupTarget) string {
+		job := NewFinalBackupJob("mydb", "default", "mysql:8.0", corev1.ResourceRequirements{}, secret, eng, target, BackupCodec{})
+		Expect(job.Name).To(Equal("mydb-final-backup"))
+		command := job.Spec.Template.Spec.Containers[0].Command
+		Expect(command).To(HaveLen(3))
+		return command[2]
+	}
+
+	It("should write into the directory of the instance like regular backups", func() {
+		target := BackupTarget{ClaimName: BackupClaimName("mydb")}
+
+		Expect(script(target)).To(Equal(backupScript(eng, BackupCodec{}, "/backup/mydb", "mydb-final.sql")))
+		// 与按需备份位于同一目录，保留策略和恢复可以按相同的方式找到它
+		regular := NewBackupJob("nightly", "mydb", "default", "mysql:8.0", corev1.ResourceRequirements{}, secret, eng, target, BackupCodec{})
+		Expect(regular.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("mkdir -p /backup/mydb\n"))
+		Expect(script(target)).To(ContainSubstring("mkdir -p /backup/mydb\n"))
+	})
+
+	It("should upload under the prefix of the instance in object storage", func() {
+		target := BackupTarget{S3: &databasev2.S3Destination{Bucket: "backups", Prefix: "prod"}}
+
+		Expect(script(target)).To(Equal(s3BackupScript(eng, BackupCodec{}, "backups", "prod/mydb", "mydb-final.sql")))
+	})
+})
+
+var _ = Describe("Backup cleanup", func() {
+	ctx := context.Background()
+
+	It("should remove the directory of the instance from the backup volume", func() {
+		target := BackupTarget{ClaimName: BackupClaimName("mydb")}
+		job, err := NewBackupCleanupJob("mydb", "default", "mysql:8.0", target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(job.Name).To(Equal("mydb-backup-cleanup"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"rm", "-rf", "/backup/mydb"}))
+		Expect(job.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("PersistentVolumeClaim.ClaimName", "mydb-backup-pvc")))
+	})
+
+	It("should remove every object under the prefix of the instance in object storage", func() {
+		target := BackupTarget{S3: &databasev2.S3Destination{Bucket: "backups", Prefix: "prod"}}
+		job, err := NewBackupCleanupJob("mydb", "default", "mysql:8.0", target)
+		Expect(err).NotTo(HaveOccurred())
+		// 前缀以 / 结尾，不会删除 mydb2 等名称以 mydb 开头的实例的备份
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"sh", "-c", s3CleanupScript("dest/backups/prod/mydb/")}))
+		Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring(`mc rm --recursive --force dest/backups/prod/mydb/`))
+		Expect(job.Spec.Template.Spec.InitContainers).NotTo(BeEmpty())
+	})
+
+	It("should refuse a prefix that leaves the backup destination", func() {
+		target := BackupTarget{S3: &databasev2.S3Destination{Bucket: "backups", Prefix: "$(reboot)"}}
+		_, err := NewBackupCleanupJob("mydb", "default", "mysql:8.0", target)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should report the result of the cleanup Job", func() {
+		c := newFakeClient()
+		job, err := NewBackupCleanupJob("mydb", "default", "mysql:8.0", BackupTarget{ClaimName: BackupClaimName("mydb")})
+		Expect(err).NotTo(HaveOccurred())
+
+		finished, _, err := EnsureBackupCleanupJob(ctx, c, job.DeepCopy())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(finished).To(BeFalse())
+
+		created := &batchv1.Job{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(job), created)).To(Succeed())
+		created.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, created)).To(Succeed())
+
+		finished, succeeded, err := EnsureBackupCleanupJob(ctx, c, job.DeepCopy())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(finished).To(BeTrue())
+		Expect(succeeded).To(BeFalse())
+	})
+})
@@ -0,0 +1,297 @@
+package helpers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// backupKeyEnv 是备份和恢复任务中保存加密密钥的环境变量，openssl 通过 -pass env: 读取，密钥不会出现在命令行参数中
+	backupKeyEnv = "BACKUP_ENCRYPTION_KEY"
+	// backupKeyIDEnv 是备份任务中保存密钥所在 Secret 和键的环境变量，与密钥摘要一起组成备份元数据中的 keyID
+	backupKeyIDEnv = "BACKUP_ENCRYPTION_KEY_ID"
+	// opensslCipher 是 openssl enc 加密和解密共用的参数
+	opensslCipher = "-aes-256-cbc -pbkdf2 -iter 100000"
+	// keyFingerprint 是计算密钥摘要的 shell 命令，摘要用于在恢复前确认使用的是加密时的密钥
+	keyFingerprint = `$(printf %s "$` + backupKeyEnv + `" | sha256sum | cut -c 1-16)`
+)
+
+// keyIDPattern 匹配备份元数据中的 keyID：<Secret 名称>/<键>@<密钥摘要>，摘要为空表示来自未记录元数据的备份
+var keyIDPattern = regexp.MustCompile(`^([a-z0-9][a-z0-9.-]*)/([-._a-zA-Z0-9]+)(?:@([0-9a-f]{16}))?$`)
+
+// BackupCodec 是备份任务对导出数据的压缩和加密配置，导出的数据先压缩再加密
+type BackupCodec struct {
+	Compression *databasev2.BackupCompression
+	Encryption  *databasev2.BackupEncryption
+}
+
+// BackupCodecOf 根据 spec.backup.compression 和 spec.backup.encryption 返回实例的备份编码配置
+func BackupCodecOf(dbInstance *databasev2.DatabaseInstance) BackupCodec {
+	return BackupCodec{
+		Compression: dbInstance.Spec.Backup.Compression,
+		Encryption:  dbInstance.Spec.Backup.Encryption,
+	}
+}
+
+// Extension 返回备份文件的扩展名，例如 .sql、.sql.gz、.sql.zst.enc
+func (codec BackupCodec) Extension() string {
+	return ".sql" + codec.suffix()
+}
+
+// suffix 返回压缩和加密追加到文件名的扩展名，例如 .gz、.zst.enc，未压缩且未加密时为空
+func (codec BackupCodec) suffix() string {
+	ext := ""
+	if codec.Compression != nil {
+		ext += compressionExtension(codec.Compression.Algorithm)
+	}
+	if codec.Encryption != nil {
+		ext += ".enc"
+	}
+	return ext
+}
+
+// env 返回加密备份时需要注入备份任务的环境变量
+func (codec BackupCodec) env() []corev1.EnvVar {
+	if codec.Encryption == nil {
+		return nil
+	}
+	ref := codec.Encryption.KeySecretRef
+	return []corev1.EnvVar{
+		{Name: backupKeyEnv, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()}},
+		{Name: backupKeyIDEnv, Value: ref.Name + "/" + ref.Key},
+	}
+}
+
+// encodeStages 返回依次处理导出数据的 shell 命令，每个命令从标准输入读取、向标准输出写入
+func (codec BackupCodec) encodeStages() []string {
+	var stages []string
+	if codec.Compression != nil {
+		level := ""
+		if codec.Compression.Level != nil {
+			level = fmt.Sprintf(" -%d", *codec.Compression.Level)
+		}
+		switch codec.Compression.Algorithm {
+		case databasev2.CompressionZstd:
+			stages = append(stages, "zstd -q -c"+level)
+		default:
+			stages = append(stages, "gzip -c"+level)
+		}
+	}
+	if codec.Encryption != nil {
+		stages = append(stages, "openssl enc "+opensslCipher+" -salt -pass env:"+backupKeyEnv)
+	}
+	return stages
+}
+
+// dumpScript 返回导出数据库、经过压缩和加密后写入 output 的 shell 脚本，fifoDir 是存放命名管道的可写目录
+func (codec BackupCodec) dumpScript(eng engine.Engine, fifoDir, output string) string {
+	return codec.encodeScript(fifoDir, output, eng.BackupCommand)
+}
+
+// encodeScript 返回将 produce 产生的数据经过压缩和加密后写入 output 的 shell 脚本，produce 返回将数据写入指定路径的 shell 命令
+func (codec BackupCodec) encodeScript(fifoDir, output string, produce func(path string) string) string {
+	stages := codec.encodeStages()
+	if len(stages) == 0 {
+		return produce(output)
+	}
+	start, wait := pipeStages(fifoDir, fifoDir+"/raw", output, stages)
+	return codec.keyCheck() + "mkfifo " + fifoDir + "/raw\n" + start + produce(fifoDir+"/raw") + "\n" + wait
+}
+
+// encodeFunction 返回定义 shell 函数 encode IN OUT 的脚本，它将文件 IN 压缩、加密后写入文件 OUT，任一步骤失败时返回非零状态
+func (codec BackupCodec) encodeFunction() string {
+	stages := codec.encodeStages()
+	if len(stages) == 0 {
+		return "encode() {\n  cp \"$1\" \"$2\"\n}"
+	}
+	var steps []string
+	if codec.Encryption != nil {
+		steps = append(steps, fmt.Sprintf("test -n \"$%s\"", backupKeyEnv))
+	}
+	from := `"$1"`
+	for i, stage := range stages {
+		to := `"$2"`
+		if i < len(stages)-1 {
+			to = fmt.Sprintf(`"$2.%d"`, i)
+		}
+		steps = append(steps, stage+" < "+from+" > "+to)
+		if i > 0 {
+			steps = append(steps, "rm -f "+from)
+		}
+		from = to
+	}
+	return "encode() {\n  " + strings.Join(steps, " &&\n  ") + "\n}"
+}
+
+// keyCheck 返回加密前确认密钥不为空的 shell 脚本，未加密时为空
+func (codec BackupCodec) keyCheck() string {
+	if codec.Encryption == nil {
+		return ""
+	}
+	return fmt.Sprintf("test -n \"$%s\" || { echo 'encryption key is empty' >&2; exit 1; }\n", backupKeyEnv)
+}
+
+// encodingScript 返回设置 shell 变量 encoding 的脚本，它是追加到备份结果 JSON 中的 "encoding" 字段，未压缩且未加密时为空
+func (codec BackupCodec) encodingScript() string {
+	var fields []string
+	if codec.Compression != nil {
+		fields = append(fields, fmt.Sprintf(`"compression":"%s"`, codec.Compression.Algorithm))
+	}
+	if codec.Encryption != nil {
+		fields = append(fields, fmt.Sprintf(`"encryption":"%s"`, databasev2.EncryptionAES256CBC),
+			`"keyID":"'"$`+backupKeyIDEnv+`@`+keyFingerprint+`"'"`)
+	}
+	if len(fields) == 0 {
+		return "encoding="
+	}
+	return `encoding=',"encoding":{` + strings.Join(fields, ",") + `}'`
+}
+
+// compressionExtension 返回压缩算法对应的文件扩展名
+func compressionExtension(algorithm databasev2.CompressionAlgorithm) string {
+	if algorithm == databasev2.CompressionZstd {
+		return ".zst"
+	}
+	return ".gz"
+}
+
+// pipeStages 返回将 input 依次经过 stages 处理后写入 output 的 shell 脚本，每个阶段在后台运行，相邻阶段通过 fifoDir 中的命名管道连接
+// 第二个返回值等待所有阶段结束，任一阶段失败时脚本（set -e）退出
+func pipeStages(fifoDir, input, output string, stages []string) (string, string) {
+	var start, wait strings.Builder
+	from := input
+	for i, stage := range stages {
+		to := output
+		if i < len(stages)-1 {
+			to = fmt.Sprintf("%s/stage%d", fifoDir, i)
+			fmt.Fprintf(&start, "mkfifo %s\n", to)
+		}
+		fmt.Fprintf(&start, "%s < %s > %s &\nstage%d=$!\n", stage, from, to, i)
+		fmt.Fprintf(&wait, "wait $stage%d\n", i)
+		from = to
+	}
+	return start.String(), strings.TrimSuffix(wait.String(), "\n")
+}
+
+// BackupEncodingOf 返回 location 处备份文件的编码方式：优先使用实例备份清单中记录的元数据，
+// 否则根据文件扩展名推断，此时加密的备份使用实例当前配置的密钥解密
+func BackupEncodingOf(dbInstance *databasev2.DatabaseInstance, location string) (*databasev2.BackupEncoding, error) {
+	if dbInstance.Status.Backup != nil {
+		for _, artifact := range dbInstance.Status.Backup.Artifacts {
+			if artifact.Location == location {
+				return artifact.Encoding, nil
+			}
+		}
+	}
+
+	encoding := &databasev2.BackupEncoding{}
+	name := location
+	if trimmed, ok := strings.CutSuffix(name, ".enc"); ok {
+		name = trimmed
+		encryption := dbInstance.Spec.Backup.Encryption
+		if encryption == nil {
+			return nil, fmt.Errorf("backup %q is encrypted but spec.backup.encryption is not set", location)
+		}
+		encoding.Encryption = databasev2.EncryptionAES256CBC
+		encoding.KeyID = encryption.KeySecretRef.Name + "/" + encryption.KeySecretRef.Key
+	}
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		encoding.Compression = databasev2.CompressionGzip
+	case strings.HasSuffix(name, ".zst"):
+		encoding.Compression = databasev2.CompressionZstd
+	}
+	if *encoding == (databasev2.BackupEncoding{}) {
+		return nil, nil
+	}
+	return encoding, nil
+}
+
+// restoreEnv 返回恢复加密备份时需要注入恢复任务的环境变量，密钥取自 keyID 记录的 Secret 和键
+func restoreEnv(encoding *databasev2.BackupEncoding) ([]corev1.EnvVar, error) {
+	if encoding == nil || encoding.Encryption == "" {
+		return nil, nil
+	}
+	match := keyIDPattern.FindStringSubmatch(encoding.KeyID)
+	if match == nil {
+		return nil, fmt.Errorf("unsupported encryption key id %q", encoding.KeyID)
+	}
+	return []corev1.EnvVar{
+		{
+			Name: backupKeyEnv,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: match[1]},
+					Key:                  match[2],
+				},
+			},
+		},
+	}, nil
+}
+
+// decodeFunction 返回定义 shell 函数 decode NAME FILE 的脚本，它根据文件名 NAME 的扩展名依次解密、解压文件 FILE，结果仍写回 FILE，
+// 加密的文件使用 BACKUP_ENCRYPTION_KEY 中的密钥解密
+func decodeFunction() string {
+	return fmt.Sprintf(`decode() {
+  name=$1
+  while :; do
+    case "$name" in
+      *.enc)
+        test -n "$%[1]s" || { echo "$1 is encrypted but no encryption key is configured" >&2; return 1; }
+        openssl enc -d %[2]s -pass env:%[1]s < "$2" > "$2.decoded" || return 1 ;;
+      *.zst) zstd -q -dc < "$2" > "$2.decoded" || return 1 ;;
+      *.gz) gzip -dc < "$2" > "$2.decoded" || return 1 ;;
+      *) return 0 ;;
+    esac
+    mv "$2.decoded" "$2" || return 1
+    name=${name%%.*}
+  done
+}`, backupKeyEnv, opensslCipher)
+}
+
+// loadScript 返回将 input 中的备份解密、解压后导入数据库的 shell 脚本，fifoDir 是存放命名管道的可写目录
+// 备份元数据记录了密钥摘要时，先确认密钥与加密时使用的一致，避免用错误的密钥导入
+func loadScript(eng engine.Engine, encoding *databasev2.BackupEncoding, fifoDir, input string) (string, error) {
+	if encoding == nil {
+		return eng.RestoreCommand(input), nil
+	}
+
+	script := ""
+	var stages []string
+	switch encoding.Encryption {
+	case "":
+	case databasev2.EncryptionAES256CBC:
+		match := keyIDPattern.FindStringSubmatch(encoding.KeyID)
+		if match == nil {
+			return "", fmt.Errorf("unsupported encryption key id %q", encoding.KeyID)
+		}
+		if match[3] != "" {
+			script += fmt.Sprintf("test \"%s\" = '%s' || { echo 'encryption key %s/%s does not match the key used by the backup' >&2; exit 1; }\n",
+				keyFingerprint, match[3], match[1], match[2])
+		}
+		stages = append(stages, "openssl enc -d "+opensslCipher+" -pass env:"+backupKeyEnv)
+	default:
+		return "", fmt.Errorf("unsupported encryption algorithm %q", encoding.Encryption)
+	}
+	switch encoding.Compression {
+	case "":
+	case databasev2.CompressionGzip:
+		stages = append(stages, "gzip -dc")
+	case databasev2.CompressionZstd:
+		stages = append(stages, "zstd -q -dc")
+	default:
+		return "", fmt.Errorf("unsupported compression algorithm %q", encoding.Compression)
+	}
+	if len(stages) == 0 {
+		return eng.RestoreCommand(input), nil
+	}
+
+	start, wait := pipeStages(fifoDir, input, fifoDir+"/sql", stages)
+	return script + "mkfifo " + fifoDir + "/sql\n" + start + eng.RestoreCommand(fifoDir+"/sql") + "\n" + wait, nil
+}
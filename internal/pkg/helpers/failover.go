@@ -0,0 +1,263 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// defaultFailoverDelay 是 spec.topology.replication.failoverDelay 未设置时主库持续不可用多久之后自动故障转移
+	defaultFailoverDelay = 30 * time.Second
+
+	// failoverCheckInterval 是主库不可用期间重新检查的间隔
+	failoverCheckInterval = 5 * time.Second
+
+	// fenceTimeout 是在旧主库中执行隔离命令的超时时间，旧主库所在的节点不可用时 exec 可能一直没有响应
+	fenceTimeout = 10 * time.Second
+
+	// maxFailoverRecords 是 status.failovers 保留的记录数量
+	maxFailoverRecords = 10
+
+	// FailoverReasonPrimaryUnavailable 表示主库持续不可用触发的自动故障转移
+	FailoverReasonPrimaryUnavailable = "PrimaryUnavailable"
+)
+
+// failoverDelay 返回主库持续不可用多久之后自动故障转移
+func failoverDelay(dbInstance *databasev2.DatabaseInstance) time.Duration {
+	replication := dbInstance.Spec.Topology.Replication
+	if replication != nil && replication.FailoverDelay != nil {
+		return replication.FailoverDelay.Duration
+	}
+	return defaultFailoverDelay
+}
+
+// recordEvent 在实例上记录 Kubernetes 事件，recorder 为 nil 时（例如测试中）不记录
+func recordEvent(recorder record.EventRecorder, dbInstance *databasev2.DatabaseInstance, eventType, reason, message string) {
+	if recorder != nil {
+		recorder.Event(dbInstance, eventType, reason, message)
+	}
+}
+
+// recordPrimaryChange 在 status 中记录新主库：旧主库加入 status.replication.demoted，
+// 这次切换追加到 status.failovers，只保留最近的 maxFailoverRecords 条
+func recordPrimaryChange(status *databasev2.DatabaseInstanceStatus, from, to, reason, message string) {
+	status.CurrentPrimary = to
+	status.Replication.PrimaryUnavailableSince = nil
+	if !slices.Contains(status.Replication.Demoted, from) {
+		status.Replication.Demoted = append(status.Replication.Demoted, from)
+	}
+	status.Replication.Demoted = slices.DeleteFunc(status.Replication.Demoted, func(name string) bool { return name == to })
+
+	status.Failovers = append(status.Failovers, databasev2.FailoverRecord{
+		Time:    metav1.Now(),
+		From:    from,
+		To:      to,
+		Reason:  reason,
+		Message: message,
+	})
+	if len(status.Failovers) > maxFailoverRecords {
+		status.Failovers = slices.Clone(status.Failovers[len(status.Failovers)-maxFailoverRecords:])
+	}
+}
+
+// execAdmin 在 Pod 的数据库容器中执行以管理员身份连接数据库的命令，标准输入为管理员用户名、管理员密码和 input，返回标准输出
+func execAdmin(ctx context.Context, executor PodExecutor, pod *corev1.Pod, command []string, credentials []string, input ...string) (string, error) {
+	stdin := strings.Join(append(slices.Clone(credentials[:2]), input...), "\n") + "\n"
+	stdout, stderr, err := executor.Exec(ctx, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name, command, strings.NewReader(stdin))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// selectCandidate 在已就绪的副本中选出复制进度最新的一个，进度相同时选择序号最小的；
+// 被隔离的旧主库和从未作为副本复制过的节点不会被选中，没有可以提升的副本时返回 nil
+func selectCandidate(ctx context.Context, executor PodExecutor, pods []corev1.Pod, primaryName string, demoted []string,
+	replicator engine.Replicator, credentials []string) *corev1.Pod {
+	logger := ctrl.FromContext(ctx)
+
+	var candidate *corev1.Pod
+	var best int64
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Name == primaryName || slices.Contains(demoted, pod.Name) || !podReady(pod) || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		output, err := execAdmin(ctx, executor, pod, replicator.PositionCommand(), credentials)
+		if err != nil {
+			logger.Error(err, "获取副本的复制进度失败", "Pod.Name", pod.Name)
+			continue
+		}
+		if strings.TrimSpace(output) == "" {
+			continue
+		}
+		position, err := replicator.ParsePosition(output)
+		if err != nil {
+			logger.Error(err, "无法解析副本的复制进度", "Pod.Name", pod.Name)
+			continue
+		}
+		if candidate == nil || position > best {
+			candidate, best = pod, position
+		}
+	}
+	return candidate
+}
+
+// failover 隔离不可用的旧主库并将复制进度最新的副本提升为主库，成功时返回新主库的名称并在 status 中记录这次切换。
+// 旧主库先从实例的 Service 中移除，确认它已经只读或者已经停止（见 stopPrimary）之后才提升新主库，确保任何时候都只有一个可以写入的主库；
+// 没有可以提升的副本、无法确认旧主库已经停止或者提升失败时返回空字符串，旧主库仍然是 status.currentPrimary，下一次检查时重试。
+// 提升成功后即使设置角色标签失败也返回新主库的名称，调用方需要保存 status
+func failover(ctx context.Context, c client.Client, executor PodExecutor, recorder record.EventRecorder, dbInstance *databasev2.DatabaseInstance,
+	status *databasev2.DatabaseInstanceStatus, pods []corev1.Pod, primaryName, reason string, replicator engine.Replicator, credentials []string) (string, error) {
+	logger := ctrl.FromContext(ctx)
+
+	candidate := selectCandidate(ctx, executor, pods, primaryName, status.Replication.Demoted, replicator, credentials)
+	if candidate == nil {
+		message := "主库 " + primaryName + " 不可用，但没有已就绪并且正在复制的副本可以提升"
+		logger.Info(message)
+		recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "FailoverBlocked", message)
+		return "", nil
+	}
+
+	logger.Info("开始故障转移", "from", primaryName, "to", candidate.Name)
+	recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "FailoverStarted",
+		fmt.Sprintf("主库 %s 不可用，隔离它并将 %s 提升为主库", primaryName, candidate.Name))
+
+	// 隔离旧主库：移出 Service 并确认它不会再接受写入之后才能提升新主库，节点恢复后旧主库以副本的身份重新加入
+	for i := range pods {
+		old := &pods[i]
+		if old.Name != primaryName {
+			continue
+		}
+		stopped, err := stopPrimary(ctx, c, executor, old, status.Replication.PrimaryUnavailableSince, replicator, credentials)
+		if err != nil {
+			return "", err
+		}
+		if !stopped {
+			message := fmt.Sprintf("无法将旧主库 %s 设为只读，等待它的 Pod 被删除之后再提升 %s", primaryName, candidate.Name)
+			logger.Info(message)
+			recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "FailoverBlocked", message)
+			return "", nil
+		}
+	}
+
+	if _, err := execAdmin(ctx, executor, candidate, replicator.PromoteCommand(), credentials); err != nil {
+		logger.Error(err, "提升副本失败", "Pod.Name", candidate.Name)
+		recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "FailoverFailed", fmt.Sprintf("提升 %s 失败: %v", candidate.Name, err))
+		return "", nil
+	}
+
+	message := fmt.Sprintf("已将 %s 提升为主库，原主库 %s 已被隔离", candidate.Name, primaryName)
+	logger.Info(message)
+	recordEvent(recorder, dbInstance, corev1.EventTypeNormal, "FailoverCompleted", message)
+	recordPrimaryChange(status, primaryName, candidate.Name, reason, message)
+	return candidate.Name, labelRole(ctx, c, candidate, RolePrimary)
+}
+
+// stopPrimary 将旧主库移出实例的 Service 并确认它不会再接受写入，返回是否可以提升新主库：
+//   - 隔离命令执行成功，旧主库已经只读，删除它的 Pod
+//   - 旧主库所在的节点不可用，强制删除它的 Pod
+//   - Pod 已经被删除，或者是主库不可用之后 StatefulSet 重新创建的 Pod，原来的数据库进程已经退出
+//
+// 其余情况下（例如节点正常但是隔离命令超时）只删除 Pod，由 kubelet 停止数据库进程。Pod 删除之前不能提升新主库，
+// 否则通过 Pod IP 连接旧主库的客户端仍然可以写入，出现两个可以写入的主库
+func stopPrimary(ctx context.Context, c client.Client, executor PodExecutor, old *corev1.Pod, unavailableSince *metav1.Time,
+	replicator engine.Replicator, credentials []string) (bool, error) {
+	logger := ctrl.FromContext(ctx)
+
+	if err := labelRole(ctx, c, old, RoleReplica); err != nil {
+		return false, err
+	}
+	if unavailableSince != nil && old.CreationTimestamp.After(unavailableSince.Time) {
+		return true, nil
+	}
+
+	if podReady(old) || old.Status.Phase == corev1.PodRunning {
+		fenceCtx, cancel := context.WithTimeout(ctx, fenceTimeout)
+		_, err := execAdmin(fenceCtx, executor, old, replicator.FenceCommand(), credentials)
+		cancel()
+		if err == nil {
+			return true, deletePod(ctx, c, old, false)
+		}
+		logger.Error(err, "无法将旧主库设为只读", "Pod.Name", old.Name)
+	}
+
+	available, err := nodeAvailable(ctx, c, old.Spec.NodeName)
+	if err != nil {
+		return false, err
+	}
+	if !available {
+		// 节点不可用时 kubelet 无法停止数据库进程，只能强制删除 Pod，与 StatefulSet 处理节点故障的方式相同
+		logger.Info("旧主库所在的节点不可用，强制删除它的 Pod", "Pod.Name", old.Name, "Node.Name", old.Spec.NodeName)
+		return true, deletePod(ctx, c, old, true)
+	}
+	if err := deletePod(ctx, c, old, false); err != nil {
+		return false, err
+	}
+	err = c.Get(ctx, client.ObjectKeyFromObject(old), &corev1.Pod{})
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	return err != nil, nil
+}
+
+// nodeAvailable 返回 Pod 所在的节点是否正常，Pod 还没有被调度时没有运行中的进程，返回 false
+func nodeAvailable(ctx context.Context, c client.Client, nodeName string) (bool, error) {
+	if nodeName == "" {
+		return false, nil
+	}
+	node := &corev1.Node{}
+	if err := c.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			ctrl.FromContext(ctx).Error(err, "获取节点失败", "Node.Name", nodeName)
+			return false, err
+		}
+		return false, nil
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+// deletePod 删除 Pod，force 为 true 时不等待 kubelet 停止容器
+func deletePod(ctx context.Context, c client.Client, pod *corev1.Pod, force bool) error {
+	var opts []client.DeleteOption
+	if force {
+		opts = append(opts, client.GracePeriodSeconds(0))
+	}
+	if err := c.Delete(ctx, pod, opts...); client.IgnoreNotFound(err) != nil {
+		ctrl.FromContext(ctx).Error(err, "删除旧主库的 Pod 失败", "Pod.Name", pod.Name)
+		return err
+	}
+	return nil
+}
+
+// savePrimaryChange 在新主库提升之后保存 status，ReplicationHealthy 条件为 False，直到其余副本开始从新主库复制
+func savePrimaryChange(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance,
+	status *databasev2.DatabaseInstanceStatus, reason string) error {
+	status.Replication.Replicas = nil
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               databasev2.ConditionReplicationHealthy,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             reason,
+		Message:            "已将 " + status.CurrentPrimary + " 提升为主库，等待其余副本从它复制",
+	})
+	return writeStatus(ctx, c, dbInstance, status)
+}
@@ -0,0 +1,345 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+var _ = Describe("Failover", func() {
+	const name = "mydb"
+
+	ctx := context.Background()
+	credentials := []string{"root", "pw", "replication-pw"}
+
+	var (
+		replicator engine.Replicator
+		dbInstance *databasev2.DatabaseInstance
+		status     *databasev2.DatabaseInstanceStatus
+		pods       []corev1.Pod
+		nodes      []*corev1.Node
+		executor   *fakeExecutor
+		recorder   *record.FakeRecorder
+		// positions 是每个副本 PositionCommand 的输出，fenceErr 和 promoteErr 是隔离和提升命令的结果
+		positions  map[string]string
+		fenceErr   error
+		promoteErr error
+	)
+
+	// step 返回 fakeExecutor 收到的命令对应的故障转移步骤
+	step := func(call execCall) string {
+		switch {
+		case reflect.DeepEqual(call.Command, replicator.PositionCommand()):
+			return "position"
+		case reflect.DeepEqual(call.Command, replicator.FenceCommand()):
+			return "fence"
+		case reflect.DeepEqual(call.Command, replicator.PromoteCommand()):
+			return "promote"
+		}
+		return "unknown"
+	}
+	steps := func() []string {
+		var result []string
+		for _, call := range executor.calls {
+			result = append(result, call.Pod+" "+step(call))
+		}
+		return result
+	}
+	// node 返回 Ready 条件为 ready 的节点
+	node := func(nodeName string, ready corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		eng, err := engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+		var ok bool
+		replicator, ok = eng.(engine.Replicator)
+		Expect(ok).To(BeTrue())
+
+		dbInstance = &databasev2.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: databasev2.DatabaseInstanceSpec{
+				Engine:   databasev2.EngineSpec{Type: databasev2.EngineMySQL},
+				Topology: databasev2.TopologySpec{Replicas: 3},
+			},
+		}
+		status = &databasev2.DatabaseInstanceStatus{
+			CurrentPrimary: name + "-0",
+			Replication: &databasev2.ReplicationStatus{
+				PrimaryUnavailableSince: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+			},
+		}
+
+		// 旧主库的进程仍在运行但是没有就绪，两个副本都已就绪
+		pods = nil
+		for i, role := range []string{RolePrimary, RoleReplica, RoleReplica} {
+			pod := readyPod(name, fmt.Sprintf("%s-%d", name, i))
+			pod.Labels[RoleLabel] = role
+			pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+			pod.Spec.NodeName = fmt.Sprintf("node-%d", i)
+			pod.Status.Phase = corev1.PodRunning
+			pods = append(pods, *pod)
+		}
+		pods[0].Status.Conditions[0].Status = corev1.ConditionFalse
+		nodes = []*corev1.Node{
+			node("node-0", corev1.ConditionTrue),
+			node("node-1", corev1.ConditionTrue),
+			node("node-2", corev1.ConditionTrue),
+		}
+
+		positions = map[string]string{
+			name + "-1": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-10\n",
+			name + "-2": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-12\n",
+		}
+		fenceErr, promoteErr = nil, nil
+		executor = &fakeExecutor{respond: func(call execCall) (string, error) {
+			switch step(call) {
+			case "position":
+				return positions[call.Pod], nil
+			case "fence":
+				return "", fenceErr
+			case "promote":
+				return "", promoteErr
+			}
+			return "", nil
+		}}
+		recorder = record.NewFakeRecorder(10)
+	})
+
+	newClient := func() client.Client {
+		objects := []client.Object{}
+		for i := range pods {
+			objects = append(objects, pods[i].DeepCopy())
+		}
+		for _, n := range nodes {
+			objects = append(objects, n)
+		}
+		return newFakeClient(objects...)
+	}
+	run := func(c client.Client) string {
+		promoted, err := failover(ctx, c, executor, recorder, dbInstance, status, pods, name+"-0", FailoverReasonPrimaryUnavailable, replicator, credentials)
+		Expect(err).NotTo(HaveOccurred())
+		return promoted
+	}
+	exists := func(c client.Client, podName string) bool {
+		err := c.Get(ctx, client.ObjectKey{Name: podName, Namespace: "default"}, &corev1.Pod{})
+		Expect(client.IgnoreNotFound(err)).NotTo(HaveOccurred())
+		return !apierrors.IsNotFound(err)
+	}
+
+	Context("When the old primary cannot be made read-only", func() {
+		BeforeEach(func() {
+			fenceErr = context.DeadlineExceeded
+		})
+
+		It("should not promote a replica while the old primary may still accept writes", func() {
+			// kubelet 停止容器之前 Pod 不会被删除
+			pods[0].Finalizers = []string{"example.com/wait-for-kubelet"}
+			c := newClient()
+
+			Expect(run(c)).To(BeEmpty())
+			Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-0 fence"}))
+			Expect(status.CurrentPrimary).To(Equal(name + "-0"))
+			Expect(status.Failovers).To(BeEmpty())
+			Expect(recorder.Events).To(Receive(ContainSubstring("FailoverStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("FailoverBlocked")))
+
+			By("Deleting the pod so that the kubelet stops the database")
+			pod := &corev1.Pod{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: name + "-0", Namespace: "default"}, pod)).To(Succeed())
+			Expect(pod.DeletionTimestamp).NotTo(BeNil())
+			Expect(pod.Labels[RoleLabel]).To(Equal(RoleReplica))
+
+			By("Promoting the replica once the old pod is gone")
+			pod.Finalizers = nil
+			Expect(c.Update(ctx, pod)).To(Succeed())
+			Expect(exists(c, name+"-0")).To(BeFalse())
+			pods = pods[1:]
+			executor.calls = nil
+			Expect(run(c)).To(Equal(name + "-2"))
+			Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-2 promote"}))
+		})
+
+		It("should force-delete the old primary on an unavailable node and promote a replica", func() {
+			nodes[0] = node("node-0", corev1.ConditionUnknown)
+			c := newClient()
+
+			Expect(run(c)).To(Equal(name + "-2"))
+			Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-0 fence", name + "-2 promote"}))
+			Expect(exists(c, name+"-0")).To(BeFalse())
+		})
+
+		It("should promote a replica once the StatefulSet has replaced the old primary's pod", func() {
+			pods[0].CreationTimestamp = metav1.Now()
+			c := newClient()
+
+			Expect(run(c)).To(Equal(name + "-2"))
+			Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-2 promote"}))
+		})
+	})
+
+	It("should promote a replica after deleting an old primary that was never scheduled", func() {
+		pods[0].Spec.NodeName = ""
+		pods[0].Status.Phase = corev1.PodPending
+		c := newClient()
+
+		// 没有被调度的 Pod 中没有运行中的数据库进程，删除之后即可提升
+		Expect(run(c)).To(Equal(name + "-2"))
+		Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-2 promote"}))
+		Expect(exists(c, name+"-0")).To(BeFalse())
+	})
+
+	Context("When choosing the replica to promote", func() {
+		selected := func() string {
+			candidate := selectCandidate(ctx, executor, pods, name+"-0", status.Replication.Demoted, replicator, credentials)
+			if candidate == nil {
+				return ""
+			}
+			return candidate.Name
+		}
+
+		It("should pick the replica that has received the most changes", func() {
+			Expect(selected()).To(Equal(name + "-2"))
+			// 旧主库不参与比较
+			Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position"}))
+		})
+
+		It("should pick the lower ordinal when replicas are equally up to date", func() {
+			positions[name+"-2"] = positions[name+"-1"]
+			Expect(selected()).To(Equal(name + "-1"))
+		})
+
+		It("should skip demoted, unready and never replicated pods", func() {
+			By("Skipping a demoted old primary that has not been reinitialized")
+			status.Replication.Demoted = []string{name + "-2"}
+			Expect(selected()).To(Equal(name + "-1"))
+
+			By("Skipping a replica that is not ready")
+			status.Replication.Demoted = nil
+			pods[2].Status.Conditions[0].Status = corev1.ConditionFalse
+			executor.calls = nil
+			Expect(selected()).To(Equal(name + "-1"))
+			Expect(steps()).To(Equal([]string{name + "-1 position"}))
+
+			By("Skipping a replica that has never replicated from a primary")
+			positions[name+"-1"] = ""
+			Expect(selected()).To(BeEmpty())
+		})
+	})
+
+	It("should remove the old primary from the Service, fence and delete it before promoting a replica", func() {
+		c := newClient()
+		// 记录执行隔离和提升命令时旧主库的状态
+		var roleWhileFencing string
+		var existsWhilePromoting bool
+		respond := executor.respond
+		executor.respond = func(call execCall) (string, error) {
+			switch step(call) {
+			case "fence":
+				pod := &corev1.Pod{}
+				Expect(c.Get(ctx, client.ObjectKey{Name: call.Pod, Namespace: "default"}, pod)).To(Succeed())
+				roleWhileFencing = pod.Labels[RoleLabel]
+			case "promote":
+				existsWhilePromoting = exists(c, name+"-0")
+			}
+			return respond(call)
+		}
+
+		Expect(run(c)).To(Equal(name + "-2"))
+		Expect(steps()).To(Equal([]string{name + "-1 position", name + "-2 position", name + "-0 fence", name + "-2 promote"}))
+		Expect(roleWhileFencing).To(Equal(RoleReplica))
+		Expect(existsWhilePromoting).To(BeFalse())
+
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: name + "-2", Namespace: "default"}, pod)).To(Succeed())
+		Expect(pod.Labels[RoleLabel]).To(Equal(RolePrimary))
+		Expect(status.CurrentPrimary).To(Equal(name + "-2"))
+		Expect(status.Replication.PrimaryUnavailableSince).To(BeNil())
+		Expect(status.Replication.Demoted).To(ConsistOf(name + "-0"))
+		Expect(status.Failovers).To(HaveLen(1))
+		Expect(status.Failovers[0]).To(And(
+			HaveField("From", name+"-0"),
+			HaveField("To", name+"-2"),
+			HaveField("Reason", FailoverReasonPrimaryUnavailable),
+		))
+		Expect(recorder.Events).To(Receive(ContainSubstring("FailoverStarted")))
+		Expect(recorder.Events).To(Receive(ContainSubstring("FailoverCompleted")))
+	})
+
+	It("should keep the current primary when the promotion fails", func() {
+		promoteErr = errors.New("command terminated with exit code 1")
+		c := newClient()
+
+		Expect(run(c)).To(BeEmpty())
+		Expect(status.CurrentPrimary).To(Equal(name + "-0"))
+		Expect(status.Failovers).To(BeEmpty())
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: name + "-2", Namespace: "default"}, pod)).To(Succeed())
+		Expect(pod.Labels[RoleLabel]).To(Equal(RoleReplica))
+		Expect(recorder.Events).To(Receive(ContainSubstring("FailoverStarted")))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("FailoverFailed"), ContainSubstring(name+"-2"))))
+	})
+
+	It("should keep only the most recent failovers in the status", func() {
+		for i := 0; i < maxFailoverRecords; i++ {
+			status.Failovers = append(status.Failovers, databasev2.FailoverRecord{From: "old", To: fmt.Sprintf("record-%d", i)})
+		}
+		c := newClient()
+
+		Expect(run(c)).To(Equal(name + "-2"))
+		Expect(status.Failovers).To(HaveLen(maxFailoverRecords))
+		Expect(status.Failovers[0].To).To(Equal("record-1"))
+		Expect(status.Failovers[maxFailoverRecords-1].To).To(Equal(name + "-2"))
+	})
+
+	It("should wait for the failover delay before promoting a replica", func() {
+		dbInstance.Spec.Topology.Replication = &databasev2.ReplicationSpec{FailoverDelay: &metav1.Duration{Duration: time.Minute}}
+		dbInstance.Status = databasev2.DatabaseInstanceStatus{CurrentPrimary: name + "-0"}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: InstanceSecretName(name), Namespace: "default"},
+			Data:       map[string][]byte{"mysql-user": []byte("root"), "mysql-password": []byte("pw")},
+		}
+		c := newClient()
+		Expect(c.Create(ctx, dbInstance)).To(Succeed())
+		Expect(c.Create(ctx, secret)).To(Succeed())
+		eng, err := engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+		secretRef := engine.SecretRef{Name: secret.Name, UsernameKey: "mysql-user", PasswordKey: "mysql-password"}
+
+		By("Recording when the primary became unavailable")
+		requeueAfter, err := EnsureReplication(ctx, c, executor, recorder, dbInstance, secretRef, eng)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeueAfter).To(Equal(failoverCheckInterval))
+		Expect(dbInstance.Status.Replication.PrimaryUnavailableSince).NotTo(BeNil())
+		Expect(dbInstance.Status.CurrentPrimary).To(Equal(name + "-0"))
+		Expect(steps()).NotTo(ContainElement(HaveSuffix("promote")))
+		Expect(recorder.Events).To(Receive(ContainSubstring("PrimaryUnavailable")))
+
+		By("Failing over once the primary has been unavailable for longer than the delay")
+		dbInstance.Status.Replication.PrimaryUnavailableSince = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+		Expect(c.Status().Update(ctx, dbInstance)).To(Succeed())
+		_, err = EnsureReplication(ctx, c, executor, recorder, dbInstance, secretRef, eng)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(steps()).To(ContainElement(name + "-2 promote"))
+		Expect(dbInstance.Status.CurrentPrimary).To(Equal(name + "-2"))
+	})
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helpers
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+// 这些测试不需要 envtest，Kubernetes 对象保存在 controller-runtime 的 fake client 中
+
+func TestHelpers(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Helpers Suite")
+}
+
+// newFakeClient 返回包含 objects 的 fake client，DatabaseInstance 的状态与 API Server 一样通过 status 子资源更新
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(databasev2.AddToScheme(scheme)).To(Succeed())
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&databasev2.DatabaseInstance{}).
+		Build()
+}
+
+// execCall 记录 fakeExecutor 收到的一条命令
+type execCall struct {
+	Pod     string
+	Command []string
+	Stdin   string
+}
+
+// fakeExecutor 记录在 Pod 中执行的命令而不真正执行，respond 不为 nil 时由它返回每条命令的标准输出和错误
+type fakeExecutor struct {
+	calls   []execCall
+	respond func(call execCall) (string, error)
+}
+
+func (e *fakeExecutor) Exec(_ context.Context, _, pod, _ string, command []string, stdin io.Reader) (string, string, error) {
+	call := execCall{Pod: pod, Command: command}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", "", err
+		}
+		call.Stdin = string(data)
+	}
+	e.calls = append(e.calls, call)
+	if e.respond == nil {
+		return "", "", nil
+	}
+	stdout, err := e.respond(call)
+	if err != nil {
+		return stdout, err.Error(), err
+	}
+	return stdout, "", nil
+}
+
+// readyPod 返回实例 instance 中已就绪的 Pod
+func readyPod(instance, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": instance},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: instance, Image: "image"}},
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package helpers
+
+import (
+	"context"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ensureJob 确保一次性的 Job 存在，不存在时创建；Job 的模板不可修改，已存在时直接返回集群中的 Job
+func ensureJob(ctx context.Context, c client.Client, desired *batchv1.Job) (*batchv1.Job, error) {
+	logger := ctrl.FromContext(ctx)
+
+	found := &batchv1.Job{}
+	err := c.Get(ctx, client.ObjectKey{Name: desired.Name, Namespace: desired.Namespace}, found)
+	if err != nil && client.IgnoreNotFound(err) == nil {
+		logger.Info("创建一个新的 Job", "Job.Namespace", desired.Namespace, "Job.Name", desired.Name)
+		if err := c.Create(ctx, desired); err != nil {
+			logger.Error(err, "新的 Job 创建失败")
+			return nil, err
+		}
+		return desired, nil
+	} else if err != nil {
+		logger.Error(err, "获取 Job 失败")
+		return nil, err
+	}
+	return found, nil
+}
+
+// jobTerminationMessages 从 Job 的 Pod 中读取容器的终止消息
+// 返回成功退出的容器的终止消息，以及最后一次失败的终止消息（设置了 FallbackToLogsOnError 时为日志末尾）
+func jobTerminationMessages(ctx context.Context, c client.Client, job *batchv1.Job) (string, string, error) {
+	logger := ctrl.FromContext(ctx)
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		logger.Error(err, "获取 Job 的 Pod 列表失败", "Job.Name", job.Name)
+		return "", "", err
+	}
+
+	var failure string
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+				terminated := state.Terminated
+				if terminated == nil {
+					continue
+				}
+				if terminated.ExitCode != 0 {
+					failure = strings.TrimSpace(terminated.Message)
+					continue
+				}
+				return terminated.Message, "", nil
+			}
+		}
+	}
+	return "", failure, nil
+}
+
+// latestConditionTime 返回 Job 最近一次状态条件变化的时间，失败的 Job 不会设置 completionTime
+func latestConditionTime(job *batchv1.Job) *metav1.Time {
+	var latest *metav1.Time
+	for i := range job.Status.Conditions {
+		t := job.Status.Conditions[i].LastTransitionTime
+		if latest == nil || latest.Before(&t) {
+			latest = &t
+		}
+	}
+	return latest
+}
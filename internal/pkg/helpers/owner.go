@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// mergeOwnerReferences 将 desired 上的 OwnerReference 合并到已存在的 found 中，
+// 使旧版本 Operator 创建的、没有 OwnerReference 的子资源也能被实例接管。
+// found 已经由其他对象控制时，不再追加第二个 controller 引用（API Server 不允许）
+func mergeOwnerReferences(found, desired metav1.Object) {
+	refs := found.GetOwnerReferences()
+	for _, ref := range desired.GetOwnerReferences() {
+		exists := false
+		for i := range refs {
+			if refs[i].UID == ref.UID {
+				refs[i] = ref
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		if ref.Controller != nil && *ref.Controller && metav1.GetControllerOfNoCopy(found) != nil {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	found.SetOwnerReferences(refs)
+}
+
+// hasOwnerReference 判断 obj 是否已经引用了 owner
+func hasOwnerReference(obj, owner metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
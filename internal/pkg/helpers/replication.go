@@ -0,0 +1,539 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// RoleLabel 是 Pod 上记录复制角色的标签，取值为 RolePrimary 或 RoleReplica
+	RoleLabel = "apps.leqiutong.xyz/role"
+	// RolePrimary 表示可写的主库
+	RolePrimary = "primary"
+	// RoleReplica 表示从主库复制的只读副本
+	RoleReplica = "replica"
+	// StreamingLabel 是副本上记录它是否正在从主库复制的标签，取值为 "true" 或 "false"，只读 Service 只选择正在复制的副本
+	StreamingLabel = "apps.leqiutong.xyz/streaming"
+
+	// SeedContainerName 是在数据库首次启动之前从主库初始化副本数据的 init 容器名称
+	SeedContainerName = "seed-replica"
+
+	// replicationPasswordKey 是复制用户的密码在复制 Secret 中的键名
+	replicationPasswordKey = "password"
+
+	// defaultSemiSyncTimeout 是 spec.topology.replication.semiSync.timeout 未设置时主库等待副本确认的时间
+	defaultSemiSyncTimeout = 10 * time.Second
+
+	// replicationCheckInterval 是复制正常时刷新副本状态（例如复制延迟）的间隔
+	replicationCheckInterval = time.Minute
+
+	// replicationRetryInterval 是主库尚未就绪或副本没有在复制时重新配置的间隔
+	replicationRetryInterval = 15 * time.Second
+)
+
+// ReplicationSecretName 返回保存复制用户密码的 Secret 名称
+func ReplicationSecretName(instanceName string) string {
+	return instanceName + "-replication"
+}
+
+// ReplicationEnabled 判断实例是否开启主从复制：副本数大于 1 且引擎支持复制
+func ReplicationEnabled(dbInstance *databasev2.DatabaseInstance, eng engine.Engine) bool {
+	_, ok := eng.(engine.Replicator)
+	return ok && dbInstance.Spec.Topology.Replicas > 1
+}
+
+// replicationOptions 将 spec.topology.replication 转换为引擎的复制配置，半同步复制未设置超时时间时使用默认值
+func replicationOptions(dbInstance *databasev2.DatabaseInstance) engine.ReplicationOptions {
+	replication := dbInstance.Spec.Topology.Replication
+	if replication == nil {
+		return engine.ReplicationOptions{}
+	}
+	options := engine.ReplicationOptions{SynchronousStandbyNames: replication.SynchronousStandbyNames}
+	if replication.SemiSync != nil {
+		options.SemiSyncTimeout = defaultSemiSyncTimeout
+		if replication.SemiSync.Timeout != nil && replication.SemiSync.Timeout.Duration > 0 {
+			options.SemiSyncTimeout = replication.SemiSync.Timeout.Duration
+		}
+	}
+	return options
+}
+
+// PrimaryPodName 返回当前主库的 Pod 名称，status.currentPrimary 中还没有记录时为第一个副本
+func PrimaryPodName(dbInstance *databasev2.DatabaseInstance) string {
+	if dbInstance.Status.CurrentPrimary != "" {
+		return dbInstance.Status.CurrentPrimary
+	}
+	return dbInstance.Name + "-0"
+}
+
+// podHost 返回副本通过 Headless Service 获得的稳定 DNS 名称
+func podHost(dbInstance *databasev2.DatabaseInstance, podName string) string {
+	return podName + "." + HeadlessServiceName(dbInstance.Name) + "." + dbInstance.Namespace + ".svc"
+}
+
+// podOrdinal 返回 StatefulSet 副本的序号
+func podOrdinal(podName string) (int, error) {
+	return strconv.Atoi(podName[strings.LastIndex(podName, "-")+1:])
+}
+
+// UseReplication 为 StatefulSet 的数据库容器追加复制需要的启动参数；引擎需要在数据库首次启动之前从主库复制数据时，
+// 在最前面添加 seed-replica init 容器，它在其他 init 容器（例如按时间点恢复）之前为副本准备好数据目录
+func UseReplication(statefulSet *appsv1.StatefulSet, dbInstance *databasev2.DatabaseInstance, eng engine.Engine) {
+	replicator, ok := eng.(engine.Replicator)
+	if !ok || !ReplicationEnabled(dbInstance, eng) {
+		return
+	}
+	podSpec := &statefulSet.Spec.Template.Spec
+	podSpec.Containers[0].Args = append(podSpec.Containers[0].Args, replicator.ReplicationArgs(replicationOptions(dbInstance))...)
+
+	functions := replicator.SeedFunctions()
+	if functions == "" {
+		return
+	}
+	seed := corev1.Container{
+		Name:    SeedContainerName,
+		Image:   podSpec.Containers[0].Image,
+		Command: []string{"sh", "-c", seedScript(functions, eng)},
+		Env: []corev1.EnvVar{
+			{
+				Name:      "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			},
+			{
+				Name:  "PRIMARY_HOST",
+				Value: dbInstance.Name + "." + dbInstance.Namespace + ".svc",
+			},
+			{
+				Name: "REPLICATION_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: ReplicationSecretName(dbInstance.Name)},
+					Key:                  replicationPasswordKey,
+				}},
+			},
+		},
+		Resources: podSpec.Containers[0].Resources,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      dataVolumeName,
+				MountPath: recoveryVolumeDir,
+			},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	podSpec.InitContainers = append([]corev1.Container{seed}, podSpec.InitContainers...)
+}
+
+// seedScript 返回 seed-replica init 容器执行的脚本：数据目录中有 ReseedMarker 时先清空它，数据目录为空时从主库复制数据。
+// 实例的 Service 只选择主库，因此通过它连接主库；没有可以连接的主库时，第一个副本作为新实例的主库由数据库镜像自行初始化，
+// 其余副本等待主库就绪。复制在数据卷的旁边目录中进行，成功后才成为数据目录
+func seedScript(functions string, eng engine.Engine) string {
+	return fmt.Sprintf(`set -e
+datadir=%[1]s/%[2]s.seeding
+if test -f %[1]s/%[2]s/%[3]s; then
+  echo 'the replica asked to be seeded again, removing its data directory'
+  rm -rf %[1]s/%[2]s
+fi
+if test -n "$(ls -A %[1]s/%[2]s 2> /dev/null)"; then
+  echo 'data directory already exists, skipping seeding'
+  exit 0
+fi
+%[4]s
+until primary_ready; do
+  if test "${POD_NAME##*-}" = 0; then
+    echo 'no primary is reachable, initializing a new database'
+    exit 0
+  fi
+  echo "waiting for the primary at $PRIMARY_HOST"
+  sleep 5
+done
+rm -rf "$datadir"
+mkdir -p "$datadir"
+seed
+rm -rf %[1]s/%[2]s
+mv "$datadir" %[1]s/%[2]s
+echo 'seeded the replica from the primary'`, recoveryVolumeDir, eng.Name(), engine.ReseedMarker, functions)
+}
+
+// UsePrimarySelector 使实例的 Service 只选择主库，开启复制后副本是只读的，写入只能发往主库
+func UsePrimarySelector(service *corev1.Service, dbInstance *databasev2.DatabaseInstance, eng engine.Engine) {
+	if ReplicationEnabled(dbInstance, eng) {
+		service.Spec.Selector[RoleLabel] = RolePrimary
+	}
+}
+
+// writablePods 返回修改数据需要执行命令的 Pod：开启复制时只有主库，修改通过复制同步到副本；否则每个副本都是独立的数据库
+func writablePods(dbInstance *databasev2.DatabaseInstance, eng engine.Engine, pods []corev1.Pod) []corev1.Pod {
+	if !ReplicationEnabled(dbInstance, eng) {
+		return pods
+	}
+	primary := PrimaryPodName(dbInstance)
+	for _, pod := range pods {
+		if pod.Name == primary {
+			return []corev1.Pod{pod}
+		}
+	}
+	return nil
+}
+
+// ensureReplicationSecret 获取或创建保存复制用户密码的 Secret，返回其中的密码；
+// 按 Retain 策略保留下来的 Secret 会被重新接管，数据卷中复制用户的密码与之一致
+func ensureReplicationSecret(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) (string, error) {
+	logger := ctrl.FromContext(ctx)
+
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Name: ReplicationSecretName(dbInstance.Name), Namespace: dbInstance.Namespace}, secret)
+	if client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "获取复制 Secret 失败")
+		return "", err
+	}
+	if err == nil && len(secret.Data[replicationPasswordKey]) > 0 && metav1.IsControlledBy(secret, dbInstance) {
+		return string(secret.Data[replicationPasswordKey]), nil
+	}
+
+	if err != nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ReplicationSecretName(dbInstance.Name),
+				Namespace: dbInstance.Namespace,
+				Labels:    map[string]string{"app": dbInstance.Name},
+			},
+		}
+	}
+	if len(secret.Data[replicationPasswordKey]) == 0 {
+		password, err := GenerateRandomPassword(PasswordLength(dbInstance.Spec.Credentials))
+		if err != nil {
+			logger.Error(err, "生成密码失败")
+			return "", err
+		}
+		secret.Data = map[string][]byte{replicationPasswordKey: []byte(password)}
+	}
+	if err := controllerutil.SetControllerReference(dbInstance, secret, c.Scheme()); err != nil {
+		logger.Error(err, "设置 Secret 的 OwnerReference 失败")
+		return "", err
+	}
+	delete(secret.Annotations, RetainedFromAnnotation)
+
+	if secret.ResourceVersion == "" {
+		logger.Info("创建复制 Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		err = c.Create(ctx, secret)
+	} else {
+		err = c.Update(ctx, secret)
+	}
+	if err != nil {
+		logger.Error(err, "保存复制 Secret 失败")
+		return "", err
+	}
+	return string(secret.Data[replicationPasswordKey]), nil
+}
+
+// labelRole 在 Pod 上设置复制角色标签
+func labelRole(ctx context.Context, c client.Client, pod *corev1.Pod, role string) error {
+	return labelPod(ctx, c, pod, RoleLabel, role)
+}
+
+// labelPod 在 Pod 上设置标签，已经是期望的取值时不做任何操作
+func labelPod(ctx context.Context, c client.Client, pod *corev1.Pod, key, value string) error {
+	if pod.Labels[key] == value {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[key] = value
+	if err := c.Patch(ctx, pod, patch); err != nil {
+		ctrl.FromContext(ctx).Error(err, "设置 Pod 的标签失败", "Pod.Name", pod.Name, "label", key, "value", value)
+		return err
+	}
+	return nil
+}
+
+// EnsureReplication 在副本数大于 1 时维护主从复制：为 Pod 设置角色标签，在主库中创建复制用户，
+// 将其余副本配置为从主库复制的只读副本，并把复制状态记录到 status.replication 和 ReplicationHealthy 条件中。
+// 配置主库同时是主库的健康检查：曾经正常工作的主库持续不可用超过 failoverDelay 后，隔离它并提升复制进度最新的副本；
+// spec.topology.primary 指定了其他副本时执行计划内的主从切换。
+// 返回距离下一次检查的时间，0 表示没有开启复制
+func EnsureReplication(ctx context.Context, c client.Client, executor PodExecutor, recorder record.EventRecorder,
+	dbInstance *databasev2.DatabaseInstance, secret engine.SecretRef, eng engine.Engine) (time.Duration, error) {
+	logger := ctrl.FromContext(ctx)
+
+	replicator, ok := eng.(engine.Replicator)
+	if !ok || !ReplicationEnabled(dbInstance, eng) {
+		if dbInstance.Status.Replication == nil && dbInstance.Status.CurrentPrimary == "" {
+			return 0, nil
+		}
+		// 缩容为单副本后不再记录复制状态，故障转移的历史记录保留
+		status := dbInstance.Status.DeepCopy()
+		status.Replication = nil
+		status.CurrentPrimary = ""
+		meta.RemoveStatusCondition(&status.Conditions, databasev2.ConditionReplicationHealthy)
+		return 0, writeStatus(ctx, c, dbInstance, status)
+	}
+
+	replicationPassword, err := ensureReplicationSecret(ctx, c, dbInstance)
+	if err != nil {
+		return 0, err
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(dbInstance.Namespace), client.MatchingLabels{"app": dbInstance.Name}); err != nil {
+		logger.Error(err, "获取 Pod 列表失败")
+		return 0, err
+	}
+	slices.SortFunc(pods.Items, func(a, b corev1.Pod) int { return strings.Compare(a.Name, b.Name) })
+
+	primaryName := PrimaryPodName(dbInstance)
+	var primary *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		role := RoleReplica
+		if pod.Name == primaryName {
+			role = RolePrimary
+			primary = pod
+		}
+		if err := labelRole(ctx, c, pod, role); err != nil {
+			return 0, err
+		}
+	}
+
+	current := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: dbInstance.Namespace}, current); err != nil {
+		logger.Error(err, "获取凭据 Secret 失败")
+		return 0, err
+	}
+	credentials := []string{eng.AdminUser(), string(current.Data[secret.PasswordKey]), replicationPassword}
+
+	status := dbInstance.Status.DeepCopy()
+	if status.Replication == nil {
+		status.Replication = &databasev2.ReplicationStatus{}
+	}
+	// 缩容后不存在的副本不再需要重新初始化
+	status.Replication.Demoted = slices.DeleteFunc(status.Replication.Demoted, func(name string) bool {
+		ordinal, err := podOrdinal(name)
+		return err != nil || int32(ordinal) >= dbInstance.Spec.Topology.Replicas
+	})
+	if len(status.Replication.Demoted) == 0 {
+		status.Replication.Demoted = nil
+	}
+
+	position, primaryMessage, err := configurePrimary(ctx, executor, dbInstance, primary, pods.Items, replicator, credentials)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case position != nil:
+		if status.Replication.PrimaryUnavailableSince != nil {
+			logger.Info("主库已恢复", "Pod.Name", primaryName)
+			recordEvent(recorder, dbInstance, corev1.EventTypeNormal, "PrimaryRecovered", "主库 "+primaryName+" 已恢复")
+		}
+		status.CurrentPrimary = primaryName
+		status.Replication.PrimaryUnavailableSince = nil
+	case status.CurrentPrimary == "":
+		// 新实例的主库还没有初始化完成，没有可以接替它的副本
+	default:
+		now := metav1.Now()
+		if status.Replication.PrimaryUnavailableSince == nil {
+			logger.Info("主库不可用", "Pod.Name", primaryName, "reason", primaryMessage)
+			recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "PrimaryUnavailable", primaryMessage)
+			status.Replication.PrimaryUnavailableSince = &now
+		}
+		if now.Sub(status.Replication.PrimaryUnavailableSince.Time) < failoverDelay(dbInstance) {
+			break
+		}
+		if executor == nil {
+			return 0, errors.New("failover requires a pod executor")
+		}
+		promoted, err := failover(ctx, c, executor, recorder, dbInstance, status, pods.Items, primaryName,
+			FailoverReasonPrimaryUnavailable, replicator, credentials)
+		if promoted != "" {
+			// 其余副本在下一次检查时指向新主库
+			return failoverCheckInterval, errors.Join(err, savePrimaryChange(ctx, c, dbInstance, status, "FailedOver"))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	status.Replication.Replicas = nil
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Name == primaryName {
+			continue
+		}
+		replica := databasev2.ReplicaStatus{Name: pod.Name}
+		switch {
+		case position == nil:
+			replica.Message = primaryMessage
+		case !podReady(pod):
+			replica.Message = "等待副本就绪"
+		default:
+			demoted := slices.Contains(status.Replication.Demoted, pod.Name)
+			replica = configureReplica(ctx, c, executor, dbInstance, pod, replicator, credentials, primaryName, position, demoted)
+			if demoted && replica.Streaming {
+				// 旧主库已经重新初始化并开始从新主库复制
+				status.Replication.Demoted = slices.DeleteFunc(status.Replication.Demoted, func(name string) bool { return name == pod.Name })
+			}
+			if err := labelPod(ctx, c, pod, StreamingLabel, strconv.FormatBool(replica.Streaming)); client.IgnoreNotFound(err) != nil {
+				return 0, err
+			}
+		}
+		status.Replication.Replicas = append(status.Replication.Replicas, replica)
+	}
+	if len(status.Replication.Demoted) == 0 {
+		status.Replication.Demoted = nil
+	}
+
+	// spec.topology.primary 指定了其他副本时，在主库正常并且该副本追上主库后执行计划内的主从切换
+	switchoverMessage := ""
+	if desired := dbInstance.Spec.Topology.Primary; desired != "" && desired != primaryName && position != nil {
+		var candidate *corev1.Pod
+		for i := range pods.Items {
+			if pods.Items[i].Name == desired {
+				candidate = &pods.Items[i]
+			}
+		}
+		switchoverMessage = switchoverBlocker(candidate, status.Replication.Replicas)
+		if switchoverMessage == "" {
+			promoted, message, err := switchover(ctx, c, executor, recorder, dbInstance, status, pods.Items, primary, candidate, replicator, credentials)
+			if promoted != "" {
+				return failoverCheckInterval, errors.Join(err, savePrimaryChange(ctx, c, dbInstance, status, "SwitchedOver"))
+			}
+			if err != nil {
+				return 0, err
+			}
+			switchoverMessage = message
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               databasev2.ConditionReplicationHealthy,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             "ReplicasStreaming",
+		Message:            "全部副本都在从主库 " + primaryName + " 复制",
+	}
+	var lagging []string
+	for _, replica := range status.Replication.Replicas {
+		if !replica.Streaming {
+			lagging = append(lagging, replica.Name)
+		}
+	}
+	replicas := status.Replication.Replicas
+	if len(lagging) > 0 || int32(len(replicas)) < dbInstance.Spec.Topology.Replicas-1 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReplicasNotStreaming"
+		condition.Message = fmt.Sprintf("%d/%d 个副本正在从主库 %s 复制", len(replicas)-len(lagging), dbInstance.Spec.Topology.Replicas-1, primaryName)
+		if len(lagging) > 0 {
+			condition.Message += "，没有在复制的副本：" + strings.Join(lagging, ", ")
+		}
+	}
+	if status.Replication.PrimaryUnavailableSince != nil {
+		condition.Reason = "PrimaryUnavailable"
+		condition.Message = primaryMessage
+	}
+	if switchoverMessage != "" {
+		condition.Message += "；" + switchoverMessage
+	}
+
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if err := writeStatus(ctx, c, dbInstance, status); err != nil {
+		return 0, err
+	}
+	switch {
+	case status.Replication.PrimaryUnavailableSince == nil && condition.Status == metav1.ConditionTrue && switchoverMessage == "":
+		return replicationCheckInterval, nil
+	case status.Replication.PrimaryUnavailableSince != nil && time.Since(status.Replication.PrimaryUnavailableSince.Time) < failoverDelay(dbInstance):
+		return failoverCheckInterval, nil
+	default:
+		return replicationRetryInterval, nil
+	}
+}
+
+// configurePrimary 在主库中执行 ConfigurePrimaryCommand，返回交给副本的复制位置；
+// 主库的 Pod 不存在、没有就绪或者命令执行失败时复制位置为 nil，并返回说明原因的消息
+func configurePrimary(ctx context.Context, executor PodExecutor, dbInstance *databasev2.DatabaseInstance, primary *corev1.Pod,
+	pods []corev1.Pod, replicator engine.Replicator, credentials []string) ([]string, string, error) {
+	logger := ctrl.FromContext(ctx)
+
+	if primary == nil || !podReady(primary) {
+		return nil, "等待主库 " + PrimaryPodName(dbInstance) + " 就绪", nil
+	}
+	if executor == nil {
+		return nil, "", errors.New("replication requires a pod executor")
+	}
+	ordinal, err := podOrdinal(primary.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	lines := append(slices.Clone(credentials), strconv.Itoa(ordinal+1))
+	for _, pod := range pods {
+		if pod.Name != primary.Name {
+			lines = append(lines, pod.Name)
+		}
+	}
+	command := replicator.ConfigurePrimaryCommand(replicationOptions(dbInstance))
+	stdin := strings.Join(lines, "\n") + "\n"
+	stdout, stderr, err := executor.Exec(ctx, primary.Namespace, primary.Name, primary.Spec.Containers[0].Name, command, strings.NewReader(stdin))
+	if err != nil {
+		logger.Error(err, "配置主库失败", "Pod.Name", primary.Name, "stderr", strings.TrimSpace(stderr))
+		return nil, fmt.Sprintf("配置主库 %s 失败: %v: %s", primary.Name, err, strings.TrimSpace(stderr)), nil
+	}
+	return strings.Split(strings.TrimSuffix(stdout, "\n"), "\n"), "", nil
+}
+
+// configureReplica 在副本中执行 ConfigureReplicaCommand，将它指向主库并返回复制状态，执行失败时记录在状态的 message 中；
+// demoted 表示副本是被隔离的旧主库。副本需要重新从主库初始化数据时删除它的 Pod
+func configureReplica(ctx context.Context, c client.Client, executor PodExecutor, dbInstance *databasev2.DatabaseInstance, pod *corev1.Pod,
+	replicator engine.Replicator, credentials []string, primaryName string, position []string, demoted bool) databasev2.ReplicaStatus {
+	logger := ctrl.FromContext(ctx)
+
+	replica := databasev2.ReplicaStatus{Name: pod.Name}
+	ordinal, err := podOrdinal(pod.Name)
+	if err != nil {
+		replica.Message = err.Error()
+		return replica
+	}
+
+	lines := append(slices.Clone(credentials), strconv.Itoa(ordinal+1), podHost(dbInstance, primaryName))
+	lines = append(lines, position...)
+	options := replicationOptions(dbInstance)
+	options.Demoted = demoted
+	command := replicator.ConfigureReplicaCommand(options)
+	stdout, stderr, err := executor.Exec(ctx, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name, command, strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		logger.Error(err, "配置副本失败", "Pod.Name", pod.Name, "stderr", strings.TrimSpace(stderr))
+		replica.Message = fmt.Sprintf("配置复制失败: %v: %s", err, strings.TrimSpace(stderr))
+		return replica
+	}
+
+	state := replicator.ParseReplicaStatus(stdout)
+	replica.Streaming = state.Streaming
+	replica.LagSeconds = state.LagSeconds
+	replica.Message = state.Message
+	if state.Recreate {
+		// 删除 Pod 后由 StatefulSet 重新创建，seed-replica init 容器清空数据目录并重新从主库复制数据
+		logger.Info("重建副本以重新从主库初始化数据", "Pod.Name", pod.Name, "reason", state.Message)
+		if err := c.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "删除副本 Pod 失败", "Pod.Name", pod.Name)
+			replica.Message = fmt.Sprintf("删除副本 Pod 失败: %v", err)
+		}
+	}
+	return replica
+}
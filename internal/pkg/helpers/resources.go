@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// NewResourceRequirements 校验 spec.resources，生成容器的资源请求和限制
+// 资源数量必须大于 0，同一种资源同时设置了请求和限制时，请求不能大于限制
+func NewResourceRequirements(res corev1.ResourceRequirements) (corev1.ResourceRequirements, error) {
+	if err := validateResourceList("requests", res.Requests); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	if err := validateResourceList("limits", res.Limits); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+
+	for name, request := range res.Requests {
+		if limit, ok := res.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("resources.requests.%s %s exceeds resources.limits.%s %s",
+				name, request.String(), name, limit.String())
+		}
+	}
+
+	requirements := corev1.ResourceRequirements{}
+	if len(res.Requests) > 0 {
+		requirements.Requests = res.Requests.DeepCopy()
+	}
+	if len(res.Limits) > 0 {
+		requirements.Limits = res.Limits.DeepCopy()
+	}
+	return requirements, nil
+}
+
+// validateResourceList 校验 ResourceList 中的资源数量都大于 0，field 用于错误信息
+func validateResourceList(field string, list corev1.ResourceList) error {
+	for name, quantity := range list {
+		if quantity.Sign() <= 0 {
+			return fmt.Errorf("invalid resources.%s.%s %q: must be greater than zero", field, name, quantity.String())
+		}
+	}
+	return nil
+}
+
+// memoryBudget 返回用于推算引擎内存参数的内存数量，优先使用内存限制，其次使用内存请求
+func memoryBudget(requirements corev1.ResourceRequirements) (resource.Quantity, bool) {
+	if memory, ok := requirements.Limits[corev1.ResourceMemory]; ok {
+		return memory, true
+	}
+	if memory, ok := requirements.Requests[corev1.ResourceMemory]; ok {
+		return memory, true
+	}
+	return resource.Quantity{}, false
+}
@@ -0,0 +1,262 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+// RestoreJobName 返回执行 DatabaseRestore 的 Job 名称
+func RestoreJobName(restoreName string) string {
+	return restoreName + "-restore"
+}
+
+// BootstrapRestoreName 返回实例通过 spec.bootstrap.fromBackup 导入初始数据时创建的 DatabaseRestore 名称
+func BootstrapRestoreName(instanceName string) string {
+	return instanceName + "-bootstrap"
+}
+
+// BackupFilePath 将 pvc:// 存储位置转换为备份卷名称，以及备份卷挂载到 /backup 后的文件路径
+func BackupFilePath(location string) (string, string, error) {
+	claimName, relPath, ok := strings.Cut(strings.TrimPrefix(location, "pvc://"), "/")
+	if !strings.HasPrefix(location, "pvc://") || !ok || claimName == "" {
+		return "", "", fmt.Errorf("unsupported backup location %q", location)
+	}
+	return claimName, "/backup/" + relPath, nil
+}
+
+// BackupSourceLocation 返回 spec.source.path 指向的备份的存储位置，
+// 实例的备份位置为对象存储时 path 是相对于 <存储桶>/<prefix> 的对象键，否则是实例当前备份卷中的相对路径
+func BackupSourceLocation(dbInstance *databasev2.DatabaseInstance, relPath string) string {
+	target := BackupTargetOf(dbInstance)
+	if target.S3 != nil {
+		return S3Location(target.S3.Bucket, S3ObjectKey(target.S3, relPath))
+	}
+	return BackupLocation(target.ClaimName, "/backup/"+relPath)
+}
+
+// NewRestoreJob 创建将位于 location 的备份导入实例的 Job，使用引擎的客户端工具（mysql、psql、obclient）执行导入
+// checksum 不为空时先校验备份文件的 sha256 摘要，避免导入损坏或被替换的文件；encoding 不为空时先解密、解压再导入；
+// pvc:// 位置的备份直接挂载它所在的备份卷，s3:// 位置的备份通过 s3 配置的对象存储下载，备份必须位于该存储桶中
+func NewRestoreJob(restoreName, instanceName, namespace, image string, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine, location, checksum string, encoding *databasev2.BackupEncoding, s3 *databasev2.S3Destination) (*batchv1.Job, error) {
+	name := RestoreJobName(restoreName)
+	labels := map[string]string{
+		"app": name,
+	}
+
+	script, claimName, err := restoreScript(eng, location, checksum, encoding, s3)
+	if err != nil {
+		return nil, err
+	}
+	keyEnv, err := restoreEnv(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"sh", "-c", "set -e\n" + script}
+	spec := newBackupJobSpec(name, image, claimName, command, append(eng.ClientEnv(secret, instanceName), keyEnv...), resources)
+	// 导入不是幂等的，失败后不自动重试，由用户检查后创建新的 DatabaseRestore
+	spec.BackoffLimit = ptr.To[int32](0)
+	spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if claimName == "" {
+		useS3Destination(&spec, s3)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: spec,
+	}, nil
+}
+
+// restoreScript 返回校验并导入 location 处备份的 shell 脚本（不含 set -e），以及需要挂载的备份卷名称；
+// 备份位于对象存储时备份卷名称为空，调用方需要通过 useS3Destination 配置对象存储
+func restoreScript(eng engine.Engine, location, checksum string, encoding *databasev2.BackupEncoding, s3 *databasev2.S3Destination) (string, string, error) {
+	if strings.HasPrefix(location, "s3://") {
+		object, err := s3ObjectOf(location, s3)
+		if err != nil {
+			return "", "", err
+		}
+		script, err := s3RestoreScript(eng, object, checksum, encoding)
+		return script, "", err
+	}
+
+	claimName, filePath, err := BackupFilePath(location)
+	if err != nil {
+		return "", "", err
+	}
+	load, err := loadScript(eng, encoding, "/tmp", filePath)
+	if err != nil {
+		return "", "", err
+	}
+	script := "test -f " + filePath + "\n"
+	if digest, ok := strings.CutPrefix(checksum, "sha256:"); ok {
+		script += fmt.Sprintf("echo '%s  %s' | sha256sum -c -\n", digest, filePath)
+	}
+	return script + load, claimName, nil
+}
+
+// EnsureRestoreJob 确保恢复 Job 存在，返回集群中的 Job
+func EnsureRestoreJob(ctx context.Context, c client.Client, desired *batchv1.Job) (*batchv1.Job, error) {
+	return ensureJob(ctx, c, desired)
+}
+
+// ObserveRestoreJob 根据恢复 Job 的执行情况计算 DatabaseRestore 的状态，失败时将终止消息（日志末尾）记录到 message 中
+func ObserveRestoreJob(ctx context.Context, c client.Client, job *batchv1.Job, status databasev2.DatabaseRestoreStatus) (databasev2.DatabaseRestoreStatus, error) {
+	status.Phase = databasev2.RestorePhaseRunning
+	status.Message = "恢复任务正在导入数据"
+	status.JobName = job.Name
+	status.StartTime = job.Status.StartTime
+	status.CompletionTime = job.Status.CompletionTime
+
+	finished, succeeded := jobFinished(job)
+	switch {
+	case !finished:
+		return status, nil
+	case succeeded:
+		status.Phase = databasev2.RestorePhaseCompleted
+		status.Message = "数据已导入"
+		return status, nil
+	}
+
+	_, failure, err := jobTerminationMessages(ctx, c, job)
+	if err != nil {
+		return status, err
+	}
+	status.Phase = databasev2.RestorePhaseFailed
+	status.Message = "恢复任务执行失败"
+	if failure != "" {
+		status.Message += ": " + failure
+	}
+	if status.CompletionTime == nil {
+		status.CompletionTime = latestConditionTime(job)
+	}
+	return status, nil
+}
+
+// UpdateDatabaseRestoreStatus 在状态发生变化时更新 DatabaseRestore 的状态
+func UpdateDatabaseRestoreStatus(ctx context.Context, c client.Client, restore *databasev2.DatabaseRestore, status *databasev2.DatabaseRestoreStatus) error {
+	logger := ctrl.FromContext(ctx)
+
+	if equality.Semantic.DeepEqual(restore.Status, *status) {
+		return nil
+	}
+	restore.Status = *status
+
+	if err := c.Status().Update(ctx, restore); err != nil {
+		logger.Error(err, "更新 DatabaseRestore 状态失败", "DatabaseRestore.Namespace", restore.Namespace, "DatabaseRestore.Name", restore.Name)
+		return err
+	}
+
+	logger.Info("成功更新 DatabaseRestore 状态", "DatabaseRestore.Namespace", restore.Namespace, "DatabaseRestore.Name", restore.Name, "phase", status.Phase)
+	return nil
+}
+
+// NewBootstrapRestore 创建导入 spec.bootstrap.fromBackup 的 DatabaseRestore
+func NewBootstrapRestore(dbInstance *databasev2.DatabaseInstance) *databasev2.DatabaseRestore {
+	return &databasev2.DatabaseRestore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      BootstrapRestoreName(dbInstance.Name),
+			Namespace: dbInstance.Namespace,
+		},
+		Spec: databasev2.DatabaseRestoreSpec{
+			InstanceRef: corev1.LocalObjectReference{Name: dbInstance.Name},
+			Source:      *dbInstance.Spec.Bootstrap.FromBackup,
+		},
+	}
+}
+
+// EnsureBootstrap 确保设置了 spec.bootstrap.fromBackup 的实例导入初始数据，并将进度记录到 Bootstrapped 条件中
+// 导入完成后条件变为 True，之后不再创建 DatabaseRestore，即使它被删除也不会重复导入；
+// spec.bootstrap.pointInTime 由 StatefulSet 的 init 容器在数据库启动之前完成，实例首次进入 Running 时条件变为 True
+func EnsureBootstrap(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) error {
+	logger := ctrl.FromContext(ctx)
+
+	if dbInstance.Spec.Bootstrap == nil {
+		return nil
+	}
+	if meta.IsStatusConditionTrue(dbInstance.Status.Conditions, databasev2.ConditionBootstrapped) {
+		return nil
+	}
+	if source := dbInstance.Spec.Bootstrap.PointInTime; source != nil {
+		condition := metav1.Condition{
+			Type:               databasev2.ConditionBootstrapped,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: dbInstance.Generation,
+			Reason:             "Recovering",
+			Message:            "正在从 " + source.SourceInstance + " 的归档按时间点恢复，进度见 " + RecoveryContainerName + " 容器的日志",
+		}
+		if dbInstance.Status.Phase == databasev2.PhaseRunning {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "RecoveryCompleted"
+			condition.Message = "已从 " + source.SourceInstance + " 的归档按时间点恢复"
+		}
+		status := dbInstance.Status.DeepCopy()
+		meta.SetStatusCondition(&status.Conditions, condition)
+		return writeStatus(ctx, c, dbInstance, status)
+	}
+	if dbInstance.Spec.Bootstrap.FromBackup == nil {
+		return nil
+	}
+
+	desired := NewBootstrapRestore(dbInstance)
+	if err := controllerutil.SetControllerReference(dbInstance, desired, c.Scheme()); err != nil {
+		return err
+	}
+	restore := &databasev2.DatabaseRestore{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(desired), restore)
+	if err != nil && client.IgnoreNotFound(err) == nil {
+		logger.Info("创建导入初始数据的 DatabaseRestore", "DatabaseRestore.Namespace", desired.Namespace, "DatabaseRestore.Name", desired.Name)
+		if err := c.Create(ctx, desired); err != nil {
+			logger.Error(err, "DatabaseRestore 创建失败")
+			return err
+		}
+		restore = desired
+	} else if err != nil {
+		logger.Error(err, "获取 DatabaseRestore 失败")
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               databasev2.ConditionBootstrapped,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             "Restoring",
+		Message:            "正在通过 " + restore.Name + " 导入初始数据",
+	}
+	switch restore.Status.Phase {
+	case databasev2.RestorePhaseCompleted:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "RestoreCompleted"
+		condition.Message = "已通过 " + restore.Name + " 导入初始数据"
+	case databasev2.RestorePhaseFailed:
+		condition.Reason = "RestoreFailed"
+		condition.Message = restore.Status.Message
+	case databasev2.RestorePhasePending, "":
+		condition.Reason = "RestorePending"
+		condition.Message = "等待 " + restore.Name + " 开始导入初始数据"
+		if restore.Status.Message != "" {
+			condition.Message = restore.Status.Message
+		}
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return writeStatus(ctx, c, dbInstance, status)
+}
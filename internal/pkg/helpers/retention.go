@@ -0,0 +1,397 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+// defaultKeepLast 是未设置 spec.backup.retention 时保留的定时备份数量
+const defaultKeepLast = 7
+
+// PrunedLocationsAnnotation 记录清理 Job 要删除的备份位置，Job 成功后从备份清单中移除这些备份
+const PrunedLocationsAnnotation = "apps.leqiutong.xyz/pruned-locations"
+
+// safeBackupPath 限制清理 Job 可以删除的文件，备份路径由备份任务生成，只包含这些字符
+var safeBackupPath = regexp.MustCompile(`^/backup/[A-Za-z0-9._/-]+$`)
+
+// scheduledBackupSuffix 匹配定时备份文件名中实例名之后的部分 -<UTC 时间>.<扩展名>，
+// 按需备份和最终备份的名称只包含小写字母，不会与之混淆
+var scheduledBackupSuffix = regexp.MustCompile(`^-[0-9]{8}T[0-9]{6}Z\.[a-z0-9.]+$`)
+
+// scheduledBackupGlob 是与 scheduledBackupSuffix 对应的 shell 模式
+const scheduledBackupGlob = "-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z.*"
+
+// PruneJobName 返回清理实例过期备份的 Job 名称
+func PruneJobName(instanceName string) string {
+	return instanceName + "-backup-prune"
+}
+
+// ExpiredBackups 按照保留策略返回应当删除的备份，artifacts 按完成时间从新到旧排列
+// 满足任一保留条件的备份都会被保留，最近一次成功的备份总会被保留
+func ExpiredBackups(artifacts []databasev2.BackupArtifact, retention *databasev2.BackupRetention, now time.Time) []databasev2.BackupArtifact {
+	if retention == nil {
+		retention = &databasev2.BackupRetention{KeepLast: ptr.To[int32](defaultKeepLast)}
+	}
+
+	keep := make([]bool, len(artifacts))
+	if len(artifacts) > 0 {
+		keep[0] = true
+	}
+	if retention.KeepLast != nil {
+		for i := 0; i < len(artifacts) && i < int(*retention.KeepLast); i++ {
+			keep[i] = true
+		}
+	}
+	if retention.MaxAge != nil {
+		for i, artifact := range artifacts {
+			if now.Sub(artifact.CompletionTime.Time) <= retention.MaxAge.Duration {
+				keep[i] = true
+			}
+		}
+	}
+	keepPerPeriod(artifacts, keep, retention.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPerPeriod(artifacts, keep, retention.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPerPeriod(artifacts, keep, retention.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	var expired []databasev2.BackupArtifact
+	for i, artifact := range artifacts {
+		if !keep[i] {
+			expired = append(expired, artifact)
+		}
+	}
+	return expired
+}
+
+// keepPerPeriod 为最近 count 个有备份的周期各保留该周期内最新的一个备份，周期由 period 按 UTC 时间计算
+func keepPerPeriod(artifacts []databasev2.BackupArtifact, keep []bool, count *int32, period func(time.Time) string) {
+	if count == nil {
+		return
+	}
+	seen := 0
+	last := ""
+	for i, artifact := range artifacts {
+		if seen >= int(*count) {
+			return
+		}
+		key := period(artifact.CompletionTime.UTC())
+		if key == last {
+			continue
+		}
+		last = key
+		keep[i] = true
+		seen++
+	}
+}
+
+// recordBackupArtifacts 将执行成功、尚未记录的定时备份 Job 上报的备份文件加入清单，返回从新到旧排列的清单
+// 只记录比清单中最新的备份更晚完成的 Job，已被清理的备份不会因为 Job 仍在历史记录中而被重新加入；
+// 升级前创建的 Job 没有上报备份结果，同样不会被记录；在记录之前已被 CronJob 删除的 Job 的备份由清理 Job 按目录清理，见 NewPruneJob
+func recordBackupArtifacts(ctx context.Context, c client.Client, artifacts []databasev2.BackupArtifact, jobs []batchv1.Job) ([]databasev2.BackupArtifact, error) {
+	logger := ctrl.FromContext(ctx)
+
+	var newest *metav1.Time
+	for i := range artifacts {
+		if newest == nil || newest.Before(&artifacts[i].CompletionTime) {
+			newest = &artifacts[i].CompletionTime
+		}
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if finished, succeeded := jobFinished(job); !finished || !succeeded {
+			continue
+		}
+		completionTime := job.CreationTimestamp
+		if job.Status.CompletionTime != nil {
+			completionTime = *job.Status.CompletionTime
+		}
+		if newest != nil && !newest.Before(&completionTime) {
+			continue
+		}
+
+		message, _, err := jobTerminationMessages(ctx, c, job)
+		if err != nil {
+			return nil, err
+		}
+		result := &BackupResult{}
+		if err := json.Unmarshal([]byte(message), result); err != nil || result.Path == "" {
+			logger.Info("备份 Job 没有上报备份结果，不记录到备份清单", "Job.Name", job.Name)
+			continue
+		}
+
+		artifacts = append(artifacts, databasev2.BackupArtifact{
+			JobName:        job.Name,
+			Location:       BackupLocation(backupClaimOf(&job.Spec.Template.Spec), result.Path),
+			Size:           resource.NewQuantity(result.Size, resource.BinarySI),
+			Checksum:       result.Checksum,
+			Encoding:       result.Encoding,
+			CompletionTime: completionTime,
+		})
+	}
+
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return artifacts[j].CompletionTime.Before(&artifacts[i].CompletionTime)
+	})
+	return artifacts, nil
+}
+
+// inDestination 判断备份是否位于实例当前的存储位置，清理 Job 只能删除当前存储位置中的备份
+func inDestination(location string, target BackupTarget) bool {
+	if target.S3 != nil {
+		return strings.HasPrefix(location, S3Location(target.S3.Bucket, ""))
+	}
+	return strings.HasPrefix(location, BackupLocation(target.ClaimName, ""))
+}
+
+// oldestScheduledBackup 返回 kept 中位于当前存储位置、最早完成的定时备份，没有时返回 nil
+func oldestScheduledBackup(instanceName string, kept []databasev2.BackupArtifact, target BackupTarget) *databasev2.BackupArtifact {
+	var oldest *databasev2.BackupArtifact
+	for i := range kept {
+		name := path.Base(kept[i].Location)
+		if !inDestination(kept[i].Location, target) || !strings.HasPrefix(name, instanceName) ||
+			!scheduledBackupSuffix.MatchString(strings.TrimPrefix(name, instanceName)) {
+			continue
+		}
+		if oldest == nil || kept[i].CompletionTime.Before(&oldest.CompletionTime) {
+			oldest = &kept[i]
+		}
+	}
+	return oldest
+}
+
+// orphanSweepScript 返回删除 oldest 所在目录或前缀中比它更早的定时备份的 shell 片段，
+// 定时备份的文件名以固定长度的 UTC 时间结尾，按文件名比较即按时间先后比较
+func orphanSweepScript(instanceName string, oldest *databasev2.BackupArtifact, s3 *databasev2.S3Destination) (string, error) {
+	var list, remove, filePath string
+	if s3 != nil {
+		object, err := s3ObjectOf(oldest.Location, s3)
+		if err != nil {
+			return "", err
+		}
+		filePath = object
+		list = fmt.Sprintf("mc ls %s | awk '{print $NF}'", path.Dir(object)+"/")
+		remove = fmt.Sprintf(`mc rm "%s/$file" > /dev/null`, path.Dir(object))
+	} else {
+		_, object, err := BackupFilePath(oldest.Location)
+		if err != nil {
+			return "", err
+		}
+		if !safeBackupPath.MatchString(object) || strings.Contains(object, "..") {
+			return "", fmt.Errorf("refusing to prune unexpected backup path %q", object)
+		}
+		filePath = object
+		list = fmt.Sprintf("ls %s 2> /dev/null", path.Dir(object))
+		remove = fmt.Sprintf(`rm -f "%s/$file"`, path.Dir(object))
+	}
+	// 截取到 UTC 时间为止，与 oldest 同一时间开始的备份不会被删除
+	cutoff := path.Base(filePath)[:len(instanceName)+len("-20060102T150405Z")]
+
+	return fmt.Sprintf(`for file in $(%s); do
+  case "$file" in
+    %s%s) ;;
+    *) continue ;;
+  esac
+  if expr "$file" \< %s > /dev/null; then
+    %s
+  fi
+done`, list, instanceName, scheduledBackupGlob, cutoff, remove), nil
+}
+
+// NewPruneJob 创建删除过期备份的 Job，要删除的备份位置同时记录在 PrunedLocationsAnnotation 注解中
+// 备份位置为对象存储时通过 mc 删除对象，否则在备份卷中删除文件
+// 定时备份 Job 在记录到清单之前可能已经被 CronJob 的历史记录上限删除，它们的备份不在清单中；
+// 因此 Job 还会列出 kept 中最早的定时备份所在的目录或前缀，删除其中比它更早、同样不会被保留的定时备份
+func NewPruneJob(instanceName, namespace, image string, expired, kept []databasev2.BackupArtifact, target BackupTarget) (*batchv1.Job, error) {
+	s3 := target.S3
+	name := PruneJobName(instanceName)
+	labels := map[string]string{
+		"app": name,
+	}
+
+	locations := make([]string, 0, len(expired))
+	paths := make([]string, 0, len(expired))
+	for _, artifact := range expired {
+		if s3 != nil {
+			object, err := s3ObjectOf(artifact.Location, s3)
+			if err != nil {
+				return nil, err
+			}
+			locations = append(locations, artifact.Location)
+			paths = append(paths, object)
+			continue
+		}
+		claimName, path, err := BackupFilePath(artifact.Location)
+		if err != nil {
+			return nil, err
+		}
+		if claimName != target.ClaimName {
+			return nil, fmt.Errorf("backup location %q is not in the backup volume %s", artifact.Location, target.ClaimName)
+		}
+		if !safeBackupPath.MatchString(path) || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("refusing to prune unexpected backup path %q", path)
+		}
+		locations = append(locations, artifact.Location)
+		paths = append(paths, path)
+	}
+	raw, err := json.Marshal(locations)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{"rm", "-f"}
+	command = append(command, paths...)
+	if s3 != nil {
+		command = []string{"sh", "-c", s3PruneScript(paths)}
+	}
+	if oldest := oldestScheduledBackup(instanceName, kept, target); oldest != nil {
+		sweep, err := orphanSweepScript(instanceName, oldest, s3)
+		if err != nil {
+			return nil, err
+		}
+		if s3 == nil {
+			command = []string{"sh", "-c", "set -e\n" + strings.Join(command, " ")}
+		}
+		command[2] += "\n" + sweep
+	}
+	spec := newBackupJobSpec(name, image, target.ClaimName, command, nil, corev1.ResourceRequirements{})
+	spec.BackoffLimit = ptr.To[int32](2)
+	if s3 != nil {
+		useS3Destination(&spec, s3)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{PrunedLocationsAnnotation: string(raw)},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// EnforceBackupRetention 记录定时备份生成的备份文件，并按照 spec.backup.retention 清理过期的备份
+// 清理由一次性的 Job 在备份卷或对象存储中删除备份，同一时间只运行一个；Job 成功后从 status.backup.artifacts 中移除被删除的备份
+// 切换 spec.backup.destination 之后，原存储位置中的备份仍然保留在清单中，但不会被清理
+func EnforceBackupRetention(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, image string) error {
+	logger := ctrl.FromContext(ctx)
+
+	var jobs batchv1.JobList
+	if err := c.List(ctx, &jobs, client.InNamespace(dbInstance.Namespace), client.MatchingLabels{"app": BackupCronJobName(dbInstance.Name)}); err != nil {
+		logger.Error(err, "获取备份 Job 列表失败")
+		return err
+	}
+
+	var artifacts []databasev2.BackupArtifact
+	if dbInstance.Status.Backup != nil {
+		artifacts = append(artifacts, dbInstance.Status.Backup.Artifacts...)
+	}
+	artifacts, err := recordBackupArtifacts(ctx, c, artifacts, jobs.Items)
+	if err != nil {
+		return err
+	}
+
+	pruneJob := &batchv1.Job{}
+	err = c.Get(ctx, client.ObjectKey{Name: PruneJobName(dbInstance.Name), Namespace: dbInstance.Namespace}, pruneJob)
+	switch {
+	case err == nil:
+		finished, succeeded := jobFinished(pruneJob)
+		if !finished {
+			break
+		}
+		if succeeded {
+			var pruned []string
+			if err := json.Unmarshal([]byte(pruneJob.Annotations[PrunedLocationsAnnotation]), &pruned); err != nil {
+				return fmt.Errorf("decode annotation %s: %w", PrunedLocationsAnnotation, err)
+			}
+			artifacts = withoutLocations(artifacts, pruned)
+			logger.Info("已清理过期的备份", "count", len(pruned))
+		} else {
+			logger.Info("清理过期备份的 Job 执行失败，稍后重试", "Job.Name", pruneJob.Name)
+		}
+		// 删除执行结束的 Job，下一次调和时按照最新的清单重新计算
+		if err := c.Delete(ctx, pruneJob, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "删除清理 Job 失败")
+			return err
+		}
+	case client.IgnoreNotFound(err) == nil:
+		// 正在验证的备份暂不清理，下一次调和时再处理
+		verifying, err := verifyingLocation(ctx, c, dbInstance)
+		if err != nil {
+			logger.Error(err, "获取备份验证 Job 失败")
+			return err
+		}
+		target := BackupTargetOf(dbInstance)
+		var expired []databasev2.BackupArtifact
+		var locations []string
+		for _, artifact := range ExpiredBackups(artifacts, dbInstance.Spec.Backup.Retention, time.Now()) {
+			if inDestination(artifact.Location, target) && artifact.Location != verifying {
+				expired = append(expired, artifact)
+				locations = append(locations, artifact.Location)
+			}
+		}
+		if len(expired) == 0 {
+			break
+		}
+		desired, err := NewPruneJob(dbInstance.Name, dbInstance.Namespace, image, expired, withoutLocations(artifacts, locations), target)
+		if err != nil {
+			return err
+		}
+		if err := controllerutil.SetControllerReference(dbInstance, desired, c.Scheme()); err != nil {
+			return err
+		}
+		logger.Info("创建清理过期备份的 Job", "Job.Name", desired.Name, "count", len(expired))
+		if err := c.Create(ctx, desired); err != nil {
+			logger.Error(err, "清理 Job 创建失败")
+			return err
+		}
+	default:
+		logger.Error(err, "获取清理 Job 失败")
+		return err
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	if status.Backup == nil {
+		status.Backup = &databasev2.BackupStatus{}
+	}
+	status.Backup.Artifacts = artifacts
+	if len(artifacts) == 0 && status.Backup.LastVerified == nil {
+		status.Backup = nil
+	}
+	return writeStatus(ctx, c, dbInstance, status)
+}
+
+// withoutLocations 返回移除了指定位置之后的备份清单
+func withoutLocations(artifacts []databasev2.BackupArtifact, locations []string) []databasev2.BackupArtifact {
+	removed := map[string]bool{}
+	for _, location := range locations {
+		removed[location] = true
+	}
+	kept := artifacts[:0:0]
+	for _, artifact := range artifacts {
+		if !removed[artifact.Location] {
+			kept = append(kept, artifact)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,315 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+var _ = Describe("Backup retention", func() {
+	const name = "mydb"
+
+	// now 是 2026-03-15（星期日）的中午，所在的 ISO 周从 2026-03-09 开始
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	// backups 按照给出的顺序（从新到旧）返回在这些时间完成的备份，时间不带时区时按 UTC 解析
+	backups := func(completionTimes ...string) []databasev2.BackupArtifact {
+		artifacts := make([]databasev2.BackupArtifact, 0, len(completionTimes))
+		for i, value := range completionTimes {
+			completionTime, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				completionTime, err = time.Parse("2006-01-02T15:04", value)
+			}
+			Expect(err).NotTo(HaveOccurred())
+			artifacts = append(artifacts, databasev2.BackupArtifact{
+				JobName:        name + "-backup-" + value,
+				Location:       BackupLocation(BackupClaimName(name), "/backup/"+name+"/"+name+"-"+completionTime.UTC().Format("20060102T1504")+"-"+string(rune('a'+i))+".sql"),
+				CompletionTime: metav1.NewTime(completionTime),
+			})
+		}
+		return artifacts
+	}
+
+	DescribeTable("should expire the backups that no retention rule keeps",
+		func(retention *databasev2.BackupRetention, artifacts []databasev2.BackupArtifact, expired ...int) {
+			var expected []databasev2.BackupArtifact
+			for _, i := range expired {
+				expected = append(expected, artifacts[i])
+			}
+			Expect(ExpiredBackups(artifacts, retention, now)).To(Equal(expected))
+		},
+		Entry("nothing to expire without backups", &databasev2.BackupRetention{KeepLast: ptr.To[int32](1)}, nil),
+		Entry("the default keeps the last seven backups", nil,
+			backups("2026-03-15T02:00", "2026-03-14T02:00", "2026-03-13T02:00", "2026-03-12T02:00", "2026-03-11T02:00",
+				"2026-03-10T02:00", "2026-03-09T02:00", "2026-03-08T02:00", "2026-03-07T02:00"), 7, 8),
+		Entry("keepLast keeps that many of the newest backups", &databasev2.BackupRetention{KeepLast: ptr.To[int32](2)},
+			backups("2026-03-15T02:00", "2026-03-14T02:00", "2026-03-13T02:00", "2026-03-12T02:00"), 2, 3),
+		Entry("maxAge keeps the backups completed within that age", &databasev2.BackupRetention{MaxAge: &metav1.Duration{Duration: 48 * time.Hour}},
+			backups("2026-03-15T11:00", "2026-03-13T13:00", "2026-03-13T12:00", "2026-03-13T11:00", "2026-03-10T12:00"), 3, 4),
+		Entry("keepDaily keeps the newest backup of each of the last days", &databasev2.BackupRetention{KeepDaily: ptr.To[int32](2)},
+			backups("2026-03-15T10:00", "2026-03-15T02:00", "2026-03-14T10:00", "2026-03-14T02:00", "2026-03-13T10:00"), 1, 3, 4),
+		Entry("keepDaily groups the days in UTC", &databasev2.BackupRetention{KeepDaily: ptr.To[int32](2)},
+			backups("2026-03-15T01:00:00+08:00", "2026-03-14T10:00", "2026-03-13T10:00"), 1),
+		Entry("keepDaily skips days without backups", &databasev2.BackupRetention{KeepDaily: ptr.To[int32](2)},
+			backups("2026-03-15T02:00", "2026-03-10T02:00", "2026-03-01T02:00"), 2),
+		Entry("keepWeekly keeps the newest backup of each of the last ISO weeks", &databasev2.BackupRetention{KeepWeekly: ptr.To[int32](2)},
+			backups("2026-03-15T02:00", "2026-03-09T02:00", "2026-03-08T02:00", "2026-03-02T02:00", "2026-02-28T02:00"), 1, 3, 4),
+		Entry("keepMonthly keeps the newest backup of each of the last months", &databasev2.BackupRetention{KeepMonthly: ptr.To[int32](2)},
+			backups("2026-03-15T02:00", "2026-03-01T02:00", "2026-02-20T02:00", "2026-02-01T02:00", "2026-01-31T02:00"), 1, 3, 4),
+		Entry("grandfather-father-son keeps the union of the daily, weekly and monthly backups",
+			&databasev2.BackupRetention{KeepDaily: ptr.To[int32](2), KeepWeekly: ptr.To[int32](2), KeepMonthly: ptr.To[int32](3)},
+			backups("2026-03-15T02:00", "2026-03-14T02:00", "2026-03-13T02:00", "2026-03-07T02:00", "2026-03-06T02:00",
+				"2026-02-27T02:00", "2026-01-30T02:00", "2026-01-02T02:00", "2025-12-31T02:00"), 2, 4, 7, 8),
+		Entry("ties keep only the backup listed first", &databasev2.BackupRetention{KeepLast: ptr.To[int32](1), KeepDaily: ptr.To[int32](1)},
+			backups("2026-03-15T02:00", "2026-03-15T02:00", "2026-03-14T02:00"), 1, 2),
+		Entry("ties within maxAge are all kept", &databasev2.BackupRetention{MaxAge: &metav1.Duration{Duration: time.Hour}},
+			backups("2026-03-15T11:30", "2026-03-15T11:30", "2026-03-15T10:00"), 2),
+		Entry("the newest backup is kept even with keepLast 0", &databasev2.BackupRetention{KeepLast: ptr.To[int32](0)},
+			backups("2026-03-15T02:00", "2026-03-14T02:00"), 1),
+		Entry("the newest backup is kept even when it is older than maxAge", &databasev2.BackupRetention{MaxAge: &metav1.Duration{Duration: time.Hour}},
+			backups("2026-03-01T02:00", "2026-02-01T02:00"), 1),
+	)
+
+	Context("When building the prune Job", func() {
+		It("should delete the expired files from the backup volume", func() {
+			expired := backups("2026-03-14T02:00", "2026-03-13T02:00")
+			job, err := NewPruneJob(name, "default", "busybox", expired, nil, BackupTarget{ClaimName: BackupClaimName(name)})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(job.Name).To(Equal(name + "-backup-prune"))
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"rm", "-f",
+				"/backup/mydb/mydb-20260314T0200-a.sql", "/backup/mydb/mydb-20260313T0200-b.sql"}))
+			var locations []string
+			Expect(json.Unmarshal([]byte(job.Annotations[PrunedLocationsAnnotation]), &locations)).To(Succeed())
+			Expect(locations).To(Equal([]string{expired[0].Location, expired[1].Location}))
+		})
+
+		It("should delete the expired objects from object storage", func() {
+			s3 := &databasev2.S3Destination{Bucket: "backups", Prefix: "prod"}
+			expired := []databasev2.BackupArtifact{{Location: S3Location("backups", "prod/mydb/mydb-20260314T0200.sql")}}
+			job, err := NewPruneJob(name, "default", "busybox", expired, nil, BackupTarget{S3: s3})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"sh", "-c",
+				s3PruneScript([]string{"dest/backups/prod/mydb/mydb-20260314T0200.sql"})}))
+		})
+
+		Context("When scheduled backups were never recorded", func() {
+			// scheduled 返回在 stamp 开始的定时备份，location 决定它在备份卷还是对象存储中
+			scheduled := func(location func(string) string, stamp string) databasev2.BackupArtifact {
+				startTime, err := time.Parse("20060102T150405Z", stamp)
+				Expect(err).NotTo(HaveOccurred())
+				return databasev2.BackupArtifact{
+					Location:       location(name + "-" + stamp + ".sql.gz"),
+					CompletionTime: metav1.NewTime(startTime.Add(5 * time.Minute)),
+				}
+			}
+			inVolume := func(file string) string {
+				return BackupLocation(BackupClaimName(name), "/backup/"+name+"/"+file)
+			}
+			inBucket := func(file string) string {
+				return S3Location("backups", "prod/"+name+"/"+file)
+			}
+
+			// run 在 PATH 中放入记录参数的 tool 和列出 files 的 list 之后执行清理脚本，返回 tool 收到的参数
+			run := func(script, tool, list string, files ...string) []string {
+				dir := GinkgoT().TempDir()
+				log := filepath.Join(dir, "calls")
+				Expect(os.WriteFile(filepath.Join(dir, tool), []byte(fmt.Sprintf(`#!/bin/sh
+if [ "$1" = %s ]; then
+  printf '%%s\n' %s
+  exit 0
+fi
+echo "$*" >> %s
+`, list, strings.Join(files, " "), log)), 0o755)).To(Succeed())
+				if list != tool {
+					Expect(os.WriteFile(filepath.Join(dir, list), []byte(fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' %s\n", strings.Join(files, " "))), 0o755)).To(Succeed())
+				}
+				cmd := exec.Command("sh", "-c", script)
+				cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+				output, err := cmd.CombinedOutput()
+				Expect(err).NotTo(HaveOccurred(), string(output))
+				calls, err := os.ReadFile(log)
+				Expect(err).NotTo(HaveOccurred())
+				return strings.Split(strings.TrimSpace(string(calls)), "\n")
+			}
+
+			files := []string{
+				name + "-20260312T020000Z.sql.gz",
+				name + "-20260313T020000Z.sql.gz",
+				name + "-20260314T020000Z.sql.gz",
+				name + "-20260315T020000Z.sql.gz",
+				name + "-20260316T020000Z.sql.gz.partial",
+				name + "-final.sql",
+				"nightly.sql",
+				"other-20260301T020000Z.sql",
+			}
+
+			It("should also delete the unrecorded backups older than the oldest kept one from the backup volume", func() {
+				expired := []databasev2.BackupArtifact{scheduled(inVolume, "20260313T020000Z")}
+				kept := []databasev2.BackupArtifact{scheduled(inVolume, "20260315T020000Z"), scheduled(inVolume, "20260314T020000Z")}
+				job, err := NewPruneJob(name, "default", "busybox", expired, kept, BackupTarget{ClaimName: BackupClaimName(name)})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.Annotations[PrunedLocationsAnnotation]).To(Equal(`["` + expired[0].Location + `"]`))
+
+				command := job.Spec.Template.Spec.Containers[0].Command
+				Expect(command[:2]).To(Equal([]string{"sh", "-c"}))
+				Expect(run(command[2], "rm", "ls", files...)).To(Equal([]string{
+					"-f /backup/mydb/mydb-20260313T020000Z.sql.gz",
+					"-f /backup/mydb/mydb-20260312T020000Z.sql.gz",
+					"-f /backup/mydb/mydb-20260313T020000Z.sql.gz",
+				}))
+			})
+
+			It("should also delete the unrecorded backups older than the oldest kept one from object storage", func() {
+				s3 := &databasev2.S3Destination{Bucket: "backups", Prefix: "prod"}
+				expired := []databasev2.BackupArtifact{scheduled(inBucket, "20260313T020000Z")}
+				kept := []databasev2.BackupArtifact{scheduled(inBucket, "20260314T020000Z")}
+				job, err := NewPruneJob(name, "default", "busybox", expired, kept, BackupTarget{S3: s3})
+				Expect(err).NotTo(HaveOccurred())
+
+				// 脚本中的 mc 是调用 /tools/mc 的函数，去掉它之后使用 PATH 中记录参数的 mc
+				script := strings.Replace(job.Spec.Template.Spec.Containers[0].Command[2], s3Preamble, "", 1)
+				Expect(run(script, "mc", "ls", files...)).To(Equal([]string{
+					"stat dest/backups/prod/mydb/mydb-20260313T020000Z.sql.gz",
+					"rm dest/backups/prod/mydb/mydb-20260313T020000Z.sql.gz",
+					"rm dest/backups/prod/mydb/mydb-20260312T020000Z.sql.gz",
+					"rm dest/backups/prod/mydb/mydb-20260313T020000Z.sql.gz",
+				}))
+			})
+
+			It("should leave the directory alone when no scheduled backup is kept there", func() {
+				expired := []databasev2.BackupArtifact{scheduled(inVolume, "20260313T020000Z")}
+				kept := []databasev2.BackupArtifact{{Location: inVolume("nightly.sql")}, {Location: BackupLocation("other-backup-pvc", "/backup/mydb/mydb-20260314T020000Z.sql.gz")}}
+				job, err := NewPruneJob(name, "default", "busybox", expired, kept, BackupTarget{ClaimName: BackupClaimName(name)})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"rm", "-f", "/backup/mydb/mydb-20260313T020000Z.sql.gz"}))
+			})
+		})
+
+		DescribeTable("should refuse to delete anything outside the backup destination",
+			func(location string) {
+				expired := []databasev2.BackupArtifact{{Location: location}}
+				_, err := NewPruneJob(name, "default", "busybox", expired, nil, BackupTarget{ClaimName: BackupClaimName(name)})
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("another backup volume", "pvc://other-backup-pvc/mydb/mydb.sql"),
+			Entry("a path leaving the backup volume", "pvc://mydb-backup-pvc/mydb/../../etc/passwd"),
+			Entry("a path with shell metacharacters", "pvc://mydb-backup-pvc/mydb/$(reboot).sql"),
+			Entry("an object storage location", "s3://backups/mydb/mydb.sql"),
+		)
+	})
+
+	Context("When enforcing the retention of an instance", func() {
+		ctx := context.Background()
+
+		var dbInstance *databasev2.DatabaseInstance
+
+		BeforeEach(func() {
+			dbInstance = &databasev2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: "mydb-uid"},
+				Spec: databasev2.DatabaseInstanceSpec{
+					Engine: databasev2.EngineSpec{Type: databasev2.EngineMySQL},
+					Backup: databasev2.BackupSpec{
+						Enabled:   true,
+						Schedule:  "0 2 * * *",
+						Retention: &databasev2.BackupRetention{KeepLast: ptr.To[int32](2)},
+					},
+				},
+				Status: databasev2.DatabaseInstanceStatus{
+					Backup: &databasev2.BackupStatus{
+						Artifacts: backups("2026-03-15T02:00", "2026-03-14T02:00", "2026-03-13T02:00", "2026-03-12T02:00"),
+					},
+				},
+			}
+		})
+
+		It("should prune the expired backups and then drop them from status", func() {
+			c := newFakeClient(dbInstance)
+			artifacts := dbInstance.Status.Backup.Artifacts
+
+			By("Creating one prune Job for the expired backups")
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			job := &batchv1.Job{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: PruneJobName(name), Namespace: "default"}, job)).To(Succeed())
+			Expect(metav1.IsControlledBy(job, dbInstance)).To(BeTrue())
+			var locations []string
+			Expect(json.Unmarshal([]byte(job.Annotations[PrunedLocationsAnnotation]), &locations)).To(Succeed())
+			Expect(locations).To(Equal([]string{artifacts[2].Location, artifacts[3].Location}))
+
+			By("Keeping the backups in status while the Job runs")
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			Expect(dbInstance.Status.Backup.Artifacts).To(HaveLen(4))
+
+			By("Removing the pruned backups once the Job has succeeded")
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			Expect(c.Status().Update(ctx, job)).To(Succeed())
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			Expect(dbInstance.Status.Backup.Artifacts).To(HaveLen(2))
+			Expect(dbInstance.Status.Backup.Artifacts[0].Location).To(Equal(artifacts[0].Location))
+			Expect(dbInstance.Status.Backup.Artifacts[1].Location).To(Equal(artifacts[1].Location))
+			err := c.Get(ctx, client.ObjectKey{Name: PruneJobName(name), Namespace: "default"}, &batchv1.Job{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should hand the kept backups to the prune Job so it can find the unrecorded ones", func() {
+			dbInstance.Status.Backup.Artifacts = nil
+			for _, day := range []int{15, 14, 13, 12} {
+				dbInstance.Status.Backup.Artifacts = append(dbInstance.Status.Backup.Artifacts, databasev2.BackupArtifact{
+					Location:       BackupLocation(BackupClaimName(name), fmt.Sprintf("/backup/mydb/mydb-202603%dT020000Z.sql", day)),
+					CompletionTime: metav1.NewTime(time.Date(2026, 3, day, 2, 5, 0, 0, time.UTC)),
+				})
+			}
+			c := newFakeClient(dbInstance)
+
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			job := &batchv1.Job{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: PruneJobName(name), Namespace: "default"}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(And(
+				HavePrefix("set -e\nrm -f /backup/mydb/mydb-20260313T020000Z.sql /backup/mydb/mydb-20260312T020000Z.sql\n"),
+				ContainSubstring(`expr "$file" \< mydb-20260314T020000Z`),
+			))
+		})
+
+		It("should keep the backups when the prune Job fails", func() {
+			c := newFakeClient(dbInstance)
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+
+			job := &batchv1.Job{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: PruneJobName(name), Namespace: "default"}, job)).To(Succeed())
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+			Expect(c.Status().Update(ctx, job)).To(Succeed())
+
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			Expect(dbInstance.Status.Backup.Artifacts).To(HaveLen(4))
+		})
+
+		It("should not prune backups in a previous destination", func() {
+			dbInstance.Spec.Backup.Destination = &databasev2.BackupDestination{
+				S3: &databasev2.S3Destination{Bucket: "backups"},
+			}
+			c := newFakeClient(dbInstance)
+
+			Expect(EnforceBackupRetention(ctx, c, dbInstance, "busybox")).To(Succeed())
+			err := c.Get(ctx, client.ObjectKey{Name: PruneJobName(name), Namespace: "default"}, &batchv1.Job{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(dbInstance.Status.Backup.Artifacts).To(HaveLen(4))
+		})
+	})
+})
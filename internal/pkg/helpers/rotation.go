@@ -0,0 +1,276 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// pendingPasswordSuffix 是轮换过程中暂存新密码的键名后缀，新密码在数据库中生效之后才会替换 Secret 中的密码，
+	// 暂存下来的新密码保证在数据库已修改、Secret 尚未更新时 Operator 重启也不会丢失新密码
+	pendingPasswordSuffix = "-pending"
+
+	// defaultRotationGracePeriod 是 spec.credentials.rotation.gracePeriod 未设置时旧密码继续有效的时间
+	defaultRotationGracePeriod = time.Hour
+
+	// rotationRetryInterval 是副本未全部就绪、暂时无法轮换时重新尝试的间隔
+	rotationRetryInterval = 30 * time.Second
+)
+
+// RotationSchedule 描述了管理员密码的轮换计划
+type RotationSchedule struct {
+	interval    time.Duration
+	schedule    cron.Schedule
+	gracePeriod time.Duration
+}
+
+// NewRotationSchedule 从 spec.credentials 中解析密码轮换计划，未配置轮换时返回 nil
+func NewRotationSchedule(credentials databasev2.CredentialsSpec) (*RotationSchedule, error) {
+	rotation := credentials.Rotation
+	if rotation == nil {
+		return nil, nil
+	}
+
+	s := &RotationSchedule{gracePeriod: defaultRotationGracePeriod}
+	if rotation.GracePeriod != nil {
+		if rotation.GracePeriod.Duration < 0 {
+			return nil, fmt.Errorf("invalid credentials.rotation.gracePeriod %q: must not be negative", rotation.GracePeriod.Duration)
+		}
+		s.gracePeriod = rotation.GracePeriod.Duration
+	}
+
+	switch {
+	case rotation.Schedule != "":
+		schedule, err := cron.ParseStandard(rotation.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials.rotation.schedule %q: %w", rotation.Schedule, err)
+		}
+		s.schedule = schedule
+	case rotation.Interval != nil:
+		if rotation.Interval.Duration <= 0 {
+			return nil, fmt.Errorf("invalid credentials.rotation.interval %q: must be greater than zero", rotation.Interval.Duration)
+		}
+		s.interval = rotation.Interval.Duration
+	default:
+		return nil, errors.New("invalid credentials.rotation: one of interval or schedule must be set")
+	}
+	return s, nil
+}
+
+// Next 返回上一次轮换时间 last 之后的下一次轮换时间
+func (s *RotationSchedule) Next(last time.Time) time.Time {
+	if s.schedule != nil {
+		return s.schedule.Next(last)
+	}
+	return last.Add(s.interval)
+}
+
+// RotateCredentials 按照轮换计划在数据库内修改管理员密码，数据库中修改成功后才更新实例的 Secret，
+// 并在引擎支持双密码时于宽限期结束后废弃旧密码。返回距离下一次需要处理的时间，0 表示没有待处理的轮换
+// schedule 为 nil 时只处理上一次轮换遗留的旧密码
+func RotateCredentials(ctx context.Context, c client.Client, executor PodExecutor, dbInstance *databasev2.DatabaseInstance,
+	schedule *RotationSchedule, secret engine.SecretRef, eng engine.Engine) (time.Duration, error) {
+	logger := ctrl.FromContext(ctx)
+
+	// existingSecretRef 引用的 Secret 属于用户，Operator 不会写入它，校验 Webhook 拒绝与之同时设置的轮换计划；
+	// 未启用 Webhook 时同样不轮换，只处理之前遗留的旧密码
+	if dbInstance.Spec.Credentials.ExistingSecretRef != nil {
+		schedule = nil
+	}
+
+	now := time.Now()
+	credentials := &databasev2.CredentialsStatus{}
+	if dbInstance.Status.Credentials != nil {
+		credentials = dbInstance.Status.Credentials.DeepCopy()
+	}
+
+	// 计算下一次需要处理的时间：旧密码的废弃时间或下一次轮换时间，取较早者
+	var next time.Time
+	if credentials.OldPasswordExpiresAt != nil {
+		next = credentials.OldPasswordExpiresAt.Time
+	}
+	if schedule != nil {
+		if due := schedule.Next(lastRotated(dbInstance, credentials)); next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if next.IsZero() {
+		return 0, nil
+	}
+	if now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	// 副本数为 0 时没有可以修改密码的数据库，扩容后 spec 的变化会触发下一次调和，届时再轮换
+	if dbInstance.Spec.Topology.Replicas == 0 {
+		logger.Info("实例没有副本，跳过凭据轮换")
+		return 0, nil
+	}
+
+	if executor == nil {
+		return 0, errors.New("credential rotation requires a pod executor")
+	}
+
+	// 没有开启复制时每个副本都是独立的数据库，所有副本就绪后才能在它们之中一致地修改密码
+	pods, ready, err := readyInstancePods(ctx, c, dbInstance)
+	if err != nil {
+		return 0, err
+	}
+	if !ready {
+		logger.Info("副本尚未全部就绪，稍后再轮换凭据")
+		return rotationRetryInterval, nil
+	}
+	// 开启复制时只在主库中修改密码，修改通过复制同步到副本
+	pods = writablePods(dbInstance, eng, pods)
+
+	current := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: dbInstance.Namespace}, current); err != nil {
+		logger.Error(err, "获取凭据 Secret 失败")
+		return 0, err
+	}
+	user := eng.AdminUser()
+	password := string(current.Data[secret.PasswordKey])
+
+	// 旧密码的宽限期已经结束，先废弃旧密码
+	if credentials.OldPasswordExpiresAt != nil && !now.Before(credentials.OldPasswordExpiresAt.Time) {
+		if err := execOnPods(ctx, executor, pods, eng.DiscardOldPasswordCommand(), user, password); err != nil {
+			return 0, fmt.Errorf("discard old password: %w", err)
+		}
+		logger.Info("已废弃轮换前的旧密码")
+		credentials.OldPasswordExpiresAt = nil
+	}
+
+	if schedule != nil && credentials.OldPasswordExpiresAt == nil {
+		if !now.Before(schedule.Next(lastRotated(dbInstance, credentials))) {
+			passwordLength := PasswordLength(dbInstance.Spec.Credentials)
+			if err := rotatePassword(ctx, c, executor, pods, current, secret, eng, user, password, passwordLength); err != nil {
+				return 0, err
+			}
+			credentials.LastRotated = &metav1.Time{Time: now}
+			if eng.DiscardOldPasswordCommand() != nil {
+				credentials.OldPasswordExpiresAt = &metav1.Time{Time: now.Add(schedule.gracePeriod)}
+			}
+		}
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	status.Credentials = credentials
+	if err := writeStatus(ctx, c, dbInstance, status); err != nil {
+		return 0, err
+	}
+
+	// 宽限期为 0 时立即在下一次调和中废弃旧密码
+	switch {
+	case credentials.OldPasswordExpiresAt != nil:
+		return max(time.Until(credentials.OldPasswordExpiresAt.Time), time.Second), nil
+	case schedule != nil:
+		return time.Until(schedule.Next(lastRotated(dbInstance, credentials))), nil
+	}
+	return 0, nil
+}
+
+// lastRotated 返回上一次轮换的时间，从未轮换过时以实例的创建时间为准
+func lastRotated(dbInstance *databasev2.DatabaseInstance, credentials *databasev2.CredentialsStatus) time.Time {
+	if credentials.LastRotated != nil {
+		return credentials.LastRotated.Time
+	}
+	return dbInstance.CreationTimestamp.Time
+}
+
+// rotatePassword 先把新密码暂存到 Secret 中，在所有副本上修改密码后再用新密码替换 Secret 中的密码
+func rotatePassword(ctx context.Context, c client.Client, executor PodExecutor, pods []corev1.Pod, current *corev1.Secret,
+	secret engine.SecretRef, eng engine.Engine, user, password string, passwordLength int) error {
+	logger := ctrl.FromContext(ctx)
+
+	pendingKey := secret.PasswordKey + pendingPasswordSuffix
+	newPassword := string(current.Data[pendingKey])
+	if newPassword == "" {
+		generated, err := GenerateRandomPassword(passwordLength)
+		if err != nil {
+			logger.Error(err, "生成密码失败")
+			return err
+		}
+		newPassword = generated
+		current.Data[pendingKey] = []byte(newPassword)
+		if err := c.Update(ctx, current); err != nil {
+			logger.Error(err, "暂存新密码失败")
+			return err
+		}
+	}
+
+	if err := execOnPods(ctx, executor, pods, eng.RotatePasswordCommand(), user, password, newPassword); err != nil {
+		return fmt.Errorf("rotate password: %w", err)
+	}
+
+	current.Data[secret.PasswordKey] = []byte(newPassword)
+	delete(current.Data, pendingKey)
+	if err := c.Update(ctx, current); err != nil {
+		logger.Error(err, "更新凭据 Secret 失败")
+		return err
+	}
+	logger.Info("已轮换管理员密码", "Secret.Name", secret.Name)
+	return nil
+}
+
+// execOnPods 在每个副本的数据库容器中依次执行命令，lines 按行写入命令的标准输入
+func execOnPods(ctx context.Context, executor PodExecutor, pods []corev1.Pod, command []string, lines ...string) error {
+	if command == nil {
+		return nil
+	}
+	stdin := strings.Join(lines, "\n") + "\n"
+	for _, pod := range pods {
+		_, stderr, err := executor.Exec(ctx, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name, command, strings.NewReader(stdin))
+		if err != nil {
+			return fmt.Errorf("pod %s: %w: %s", pod.Name, err, strings.TrimSpace(stderr))
+		}
+	}
+	return nil
+}
+
+// readyInstancePods 返回实例的全部 Pod，并判断期望数量的副本是否都已就绪
+func readyInstancePods(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) ([]corev1.Pod, bool, error) {
+	logger := ctrl.FromContext(ctx)
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(dbInstance.Namespace), client.MatchingLabels{"app": dbInstance.Name}); err != nil {
+		logger.Error(err, "获取 Pod 列表失败")
+		return nil, false, err
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	if len(pods.Items) == 0 || int32(len(pods.Items)) != dbInstance.Spec.Topology.Replicas {
+		return pods.Items, false, nil
+	}
+	for i := range pods.Items {
+		if !podReady(&pods.Items[i]) {
+			return pods.Items, false, nil
+		}
+	}
+	return pods.Items, true, nil
+}
+
+// podReady 判断 Pod 是否处于 Ready 状态
+func podReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
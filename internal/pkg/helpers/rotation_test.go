@@ -0,0 +1,108 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+var _ = Describe("Credential rotation", func() {
+	const name = "rotating"
+
+	ctx := context.Background()
+
+	var (
+		eng        engine.Engine
+		dbInstance *databasev2.DatabaseInstance
+		secret     *corev1.Secret
+		executor   *fakeExecutor
+		schedule   *RotationSchedule
+	)
+
+	BeforeEach(func() {
+		var err error
+		eng, err = engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+
+		// 实例创建于两天前，每天轮换一次，因此已经到了轮换时间
+		dbInstance = &databasev2.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-48 * time.Hour)),
+			},
+			Spec: databasev2.DatabaseInstanceSpec{
+				Engine:   databasev2.EngineSpec{Type: databasev2.EngineMySQL},
+				Topology: databasev2.TopologySpec{Replicas: 1},
+				Credentials: databasev2.CredentialsSpec{
+					PasswordLength: 24,
+					Rotation:       &databasev2.CredentialRotation{Interval: &metav1.Duration{Duration: 24 * time.Hour}},
+				},
+			},
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: InstanceSecretName(name), Namespace: "default"},
+			Data:       map[string][]byte{"mysql-user": []byte("root"), "mysql-password": []byte("old")},
+		}
+		executor = &fakeExecutor{}
+		schedule, err = NewRotationSchedule(dbInstance.Spec.Credentials)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	rotate := func(c client.Client) (time.Duration, error) {
+		return RotateCredentials(ctx, c, executor, dbInstance, schedule, CredentialsSecretRef(dbInstance, eng), eng)
+	}
+
+	passwordOf := func(c client.Client, secretName string) map[string][]byte {
+		found := &corev1.Secret{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: secretName, Namespace: "default"}, found)).To(Succeed())
+		return found.Data
+	}
+
+	It("should change the password in the database before updating the Secret", func() {
+		c := newFakeClient(dbInstance, secret, readyPod(name, name+"-0"))
+
+		requeue, err := rotate(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeNumerically(">", 0))
+
+		Expect(executor.calls).To(HaveLen(1))
+		Expect(executor.calls[0].Command).To(Equal(eng.RotatePasswordCommand()))
+		data := passwordOf(c, secret.Name)
+		Expect(executor.calls[0].Stdin).To(Equal("root\nold\n" + string(data["mysql-password"]) + "\n"))
+		Expect(data["mysql-password"]).To(HaveLen(24))
+		Expect(data).NotTo(HaveKey("mysql-password-pending"))
+		Expect(dbInstance.Status.Credentials.LastRotated).NotTo(BeNil())
+	})
+
+	It("should skip the rotation instead of retrying while the instance has no replicas", func() {
+		dbInstance.Spec.Topology.Replicas = 0
+		c := newFakeClient(dbInstance, secret)
+
+		requeue, err := rotate(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(executor.calls).To(BeEmpty())
+		Expect(passwordOf(c, secret.Name)).To(Equal(secret.Data))
+	})
+
+	It("should never write to a user-provided Secret", func() {
+		secret.Name = "my-credentials"
+		dbInstance.Spec.Credentials.ExistingSecretRef = &databasev2.ExistingSecretRef{Name: secret.Name}
+		c := newFakeClient(dbInstance, secret, readyPod(name, name+"-0"))
+
+		requeue, err := rotate(c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(executor.calls).To(BeEmpty())
+		Expect(passwordOf(c, secret.Name)).To(Equal(secret.Data))
+	})
+})
@@ -0,0 +1,177 @@
+package helpers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+// DefaultS3ClientImage 是未设置 spec.backup.destination.s3.clientImage 时使用的 MinIO 客户端镜像
+const DefaultS3ClientImage = "quay.io/minio/mc:RELEASE.2024-11-21T17-21-54Z"
+
+// s3ToolsDir 是 mc 客户端、命名管道和 mc 配置所在的 emptyDir 目录
+const s3ToolsDir = "/tools"
+
+// s3Preamble 定义 mc 函数并根据环境变量配置名为 dest 的对象存储别名，配置写入 emptyDir，不依赖镜像中可写的 HOME
+const s3Preamble = `mc() { /tools/mc --config-dir /tools/.mc --quiet "$@"; }
+mc alias set dest "$S3_ENDPOINT" "$S3_ACCESS_KEY_ID" "$S3_SECRET_ACCESS_KEY" > /dev/null`
+
+// S3Location 返回对象存储中的备份在 status 中记录的存储位置，格式为 s3://<存储桶>/<对象键>
+func S3Location(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+// S3ObjectKey 返回备份在存储桶中的对象键，name 是相对于前缀的路径
+func S3ObjectKey(s3 *databasev2.S3Destination, name string) string {
+	return strings.TrimPrefix(path.Join(s3.Prefix, name), "/")
+}
+
+// s3ObjectOf 将 s3:// 存储位置转换为 mc 使用的对象路径 dest/<存储桶>/<对象键>，
+// 备份必须位于 s3 配置的存储桶中，对象键会被拼接到 shell 命令中，因此同样只允许安全的字符
+func s3ObjectOf(location string, s3 *databasev2.S3Destination) (string, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !strings.HasPrefix(location, "s3://") || !ok {
+		return "", fmt.Errorf("unsupported backup location %q", location)
+	}
+	if s3 == nil || s3.Bucket != bucket {
+		return "", fmt.Errorf("backup location %q is not in the bucket configured in spec.backup.destination.s3", location)
+	}
+	if !safeBackupPath.MatchString("/backup/"+key) || strings.Contains(key, "..") {
+		return "", fmt.Errorf("refusing to use unexpected object key %q", key)
+	}
+	return "dest/" + bucket + "/" + key, nil
+}
+
+// s3BackupScript 返回导出数据库并以流式上传到对象存储的 shell 脚本，对象键为 keyDir/fileName，fileName 可以包含 shell 展开（例如时间戳）
+// 导出（以及压缩、加密之后）的数据经过命名管道同时交给 mc pipe、sha256sum 和 wc，不落本地磁盘；导出或上传失败时删除已上传的不完整对象
+func s3BackupScript(eng engine.Engine, codec BackupCodec, bucket, keyDir, fileName string) string {
+	return fmt.Sprintf(`set -e
+%[1]s
+key=%[3]s/%[4]s
+object="dest/%[2]s/$key"
+mkfifo /tools/dump /tools/digest /tools/count
+trap 'test -n "$uploaded" || { wait; mc rm "$object" > /dev/null 2>&1; }' EXIT
+sha256sum < /tools/digest | cut -d ' ' -f 1 > /tools/checksum &
+digest=$!
+wc -c < /tools/count > /tools/size &
+count=$!
+tee /tools/digest /tools/count < /tools/dump | mc pipe "$object" > /dev/null &
+upload=$!
+%[5]s
+wait $upload
+wait $digest
+wait $count
+uploaded=1
+checksum=$(cat /tools/checksum)
+test -n "$checksum"
+%[6]s
+printf '{"path":"%%s","size":%%d,"checksum":"sha256:%%s"%%s}' "s3://%[2]s/$key" "$(cat /tools/size)" "$checksum" "$encoding" > /dev/termination-log`,
+		s3Preamble, bucket, keyDir, fileName, codec.dumpScript(eng, "/tools", "/tools/dump"), codec.encodingScript())
+}
+
+// s3RestoreScript 返回从对象存储下载备份并导入的 shell 脚本（不含 set -e），备份通过命名管道解密、解压后交给引擎的客户端，不落本地磁盘
+// checksum 不为空时先完整读取一遍对象校验 sha256 摘要，校验通过后再导入
+func s3RestoreScript(eng engine.Engine, object, checksum string, encoding *databasev2.BackupEncoding) (string, error) {
+	script := s3Preamble + "\n"
+	script += fmt.Sprintf("mc stat %s > /dev/null\n", object)
+	if digest, ok := strings.CutPrefix(checksum, "sha256:"); ok {
+		script += fmt.Sprintf("test \"$(mc cat %s | sha256sum | cut -d ' ' -f 1)\" = '%s' || { echo 'checksum mismatch: %s' >&2; exit 1; }\n", object, digest, object)
+	}
+	load, err := loadScript(eng, encoding, "/tools", "/tools/restore")
+	if err != nil {
+		return "", err
+	}
+	script += fmt.Sprintf("mkfifo /tools/restore\nmc cat %s > /tools/restore &\ndownload=$!\n", object)
+	script += load + "\nwait $download"
+	return script, nil
+}
+
+// s3PruneScript 返回删除对象存储中过期备份的 shell 脚本，已经不存在的对象直接跳过
+func s3PruneScript(objects []string) string {
+	return fmt.Sprintf(`set -e
+%s
+for object in %s; do
+  mc stat "$object" > /dev/null 2>&1 || continue
+  mc rm "$object" > /dev/null
+done`, s3Preamble, strings.Join(objects, " "))
+}
+
+// s3CleanupScript 返回删除对象存储中某个前缀下全部备份的 shell 脚本，前缀下没有对象时直接跳过
+// prefix 以 / 结尾，避免误删名称以相同字符开头的其他实例的备份
+func s3CleanupScript(prefix string) string {
+	return fmt.Sprintf(`set -e
+%s
+if [ -n "$(mc ls --recursive %s | head -n 1)" ]; then
+  mc rm --recursive --force %s > /dev/null
+fi`, s3Preamble, prefix, prefix)
+}
+
+// useS3Destination 配置没有挂载备份卷的 JobSpec 访问对象存储：
+// 由 init 容器将 mc 客户端复制到共享的 emptyDir，并从凭据 Secret 注入访问密钥
+func useS3Destination(spec *batchv1.JobSpec, s3 *databasev2.S3Destination) {
+	podSpec := &spec.Template.Spec
+	podSpec.Volumes = nil
+	podSpec.InitContainers = nil
+	podSpec.Containers[0].VolumeMounts = nil
+	useS3Tools(podSpec, &podSpec.Containers[0], s3)
+}
+
+// useS3Tools 为 Pod 中的 container 配置访问对象存储所需的 mc 客户端和访问密钥，
+// 复制 mc 的 init 容器和 emptyDir 在同一个 Pod 中只添加一次，并排在其他 init 容器之前
+func useS3Tools(podSpec *corev1.PodSpec, container *corev1.Container, s3 *databasev2.S3Destination) {
+	image := s3.ClientImage
+	if image == "" {
+		image = DefaultS3ClientImage
+	}
+	tools := corev1.VolumeMount{Name: "tools", MountPath: s3ToolsDir}
+
+	if !hasVolume(podSpec, tools.Name) {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         tools.Name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		podSpec.InitContainers = append([]corev1.Container{
+			{
+				Name:         "mc",
+				Image:        image,
+				Command:      []string{"cp", "/usr/bin/mc", s3ToolsDir + "/mc"},
+				VolumeMounts: []corev1.VolumeMount{tools},
+			},
+		}, podSpec.InitContainers...)
+	}
+	container.VolumeMounts = append(container.VolumeMounts, tools)
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "S3_ENDPOINT", Value: s3.Endpoint},
+		s3SecretEnv("S3_ACCESS_KEY_ID", s3.CredentialsSecretRef.Name, "AWS_ACCESS_KEY_ID"),
+		s3SecretEnv("S3_SECRET_ACCESS_KEY", s3.CredentialsSecretRef.Name, "AWS_SECRET_ACCESS_KEY"),
+	)
+}
+
+// hasVolume 判断 Pod 中是否已经定义了名为 name 的卷
+func hasVolume(podSpec *corev1.PodSpec, name string) bool {
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// s3SecretEnv 返回从对象存储凭据 Secret 读取的环境变量
+func s3SecretEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
@@ -4,53 +4,108 @@ package helpers
 import (
 	"context"
 	"crypto/rand"
-	"encoding/base64"
 	"errors"
+	"fmt"
+	"math/big"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// passwordAlphabet 是生成密码使用的字符集，只包含字母和数字，密码出现在 shell 命令和 SQL 语句中时无需转义
+	passwordAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	// defaultPasswordLength 是 spec.credentials.passwordLength 未设置时生成的密码长度
+	defaultPasswordLength = 24
 )
 
-// GenerateRandomPassword 生成随机密码
+// ErrInvalidCredentials 表示用户通过 spec.credentials.existingSecretRef 提供的凭据不可用
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// GenerateRandomPassword 生成由字母和数字组成的指定长度的随机密码
 func GenerateRandomPassword(length int) (string, error) {
-	bytes := make([]byte, length)
-	_, err := rand.Read(bytes)
-	if err != nil {
-		return "", err
+	password := make([]byte, length)
+	size := big.NewInt(int64(len(passwordAlphabet)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordAlphabet[n.Int64()]
 	}
-	return base64.StdEncoding.EncodeToString(bytes), nil
+	return string(password), nil
 }
 
-// getSecretKeys 根据数据库类型获取用户名和密码的键名
-func getSecretKeys(ctx context.Context, databaseType string) (string, string, error) {
-	logger := log.FromContext(ctx)
-	switch databaseType {
-	case "postgres":
-		return "postgres-user", "postgres-password", nil
-	case "oceanbase-ce":
-		return "oceanbase-user", "oceanbase-password", nil
-	case "mysql":
-		return "mysql-user", "mysql-password", nil
-	default:
-		err := errors.New("unsupported database type: " + databaseType)
-		logger.Error(err, "不支持的数据库类型", "databaseType", databaseType)
-		return "", "", err
+// PasswordLength 返回 Operator 生成密码时使用的长度
+func PasswordLength(credentials databasev2.CredentialsSpec) int {
+	if credentials.PasswordLength <= 0 {
+		return defaultPasswordLength
+	}
+	return int(credentials.PasswordLength)
+}
+
+// InstanceSecretName 返回实例专属的凭据 Secret 名称
+func InstanceSecretName(instanceName string) string {
+	return instanceName + "-secret"
+}
+
+// CredentialsSecretRef 返回实例凭据 Secret 的引用，数据库容器和备份任务都从这里读取凭据
+// 设置了 spec.credentials.existingSecretRef 时使用用户提供的 Secret，否则使用 Operator 生成的 <实例名>-secret
+func CredentialsSecretRef(dbInstance *databasev2.DatabaseInstance, eng engine.Engine) engine.SecretRef {
+	userKey, passwordKey := eng.CredentialKeys()
+	secret := engine.SecretRef{
+		Name:        InstanceSecretName(dbInstance.Name),
+		UsernameKey: userKey,
+		PasswordKey: passwordKey,
 	}
+
+	if existing := dbInstance.Spec.Credentials.ExistingSecretRef; existing != nil {
+		secret.Name = existing.Name
+		if existing.UsernameKey != "" {
+			secret.UsernameKey = existing.UsernameKey
+		}
+		if existing.PasswordKey != "" {
+			secret.PasswordKey = existing.PasswordKey
+		}
+	}
+	return secret
 }
 
-// createNewSecret 创建新的 Secret
-func createNewSecret(ctx context.Context, c client.Client, name, namespace, secretName, userKey, passwordKey string) (*corev1.Secret, error) {
+// ValidateExistingSecret 校验用户提供的凭据 Secret：密码必须存在且不为空，用户名可以省略，存在时必须是引擎的管理员用户
+// 配置问题返回包装了 ErrInvalidCredentials 的错误，Operator 不会修改或接管该 Secret
+func ValidateExistingSecret(ctx context.Context, c client.Client, namespace string, secret engine.SecretRef, eng engine.Engine) error {
 	logger := log.FromContext(ctx)
 
-	user, err := GenerateRandomPassword(8) // 生成 8 字节的随机用户名
-	if err != nil {
-		logger.Error(err, "生成用户名失败")
-		return nil, err
+	existingSecret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: namespace}, existingSecret); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return fmt.Errorf("%w: secret %q not found", ErrInvalidCredentials, secret.Name)
+		}
+		logger.Error(err, "获取 Secret 失败")
+		return err
 	}
 
-	password, err := GenerateRandomPassword(16) // 生成 16 字节的随机密码
+	if len(existingSecret.Data[secret.PasswordKey]) == 0 {
+		return fmt.Errorf("%w: secret %q has no %q key", ErrInvalidCredentials, secret.Name, secret.PasswordKey)
+	}
+	if user, ok := existingSecret.Data[secret.UsernameKey]; ok && string(user) != eng.AdminUser() {
+		return fmt.Errorf("%w: username in secret %q must be %q", ErrInvalidCredentials, secret.Name, eng.AdminUser())
+	}
+	return nil
+}
+
+// createNewSecret 创建新的 Secret，用户名为引擎的管理员用户，密码随机生成
+func createNewSecret(ctx context.Context, c client.Client, owner metav1.Object, namespace string, secret engine.SecretRef, eng engine.Engine, passwordLength int) (*corev1.Secret, error) {
+	logger := log.FromContext(ctx)
+
+	password, err := GenerateRandomPassword(passwordLength)
 	if err != nil {
 		logger.Error(err, "生成密码失败")
 		return nil, err
@@ -58,15 +113,20 @@ func createNewSecret(ctx context.Context, c client.Client, name, namespace, secr
 
 	newSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      secretName,
+			Name:      secret.Name,
 			Namespace: namespace,
-			Labels:    map[string]string{"app": name},
+			Labels:    map[string]string{"app": owner.GetName()},
 		},
 		Data: map[string][]byte{
-			userKey:     []byte(user),
-			passwordKey: []byte(password),
+			secret.UsernameKey: []byte(eng.AdminUser()),
+			secret.PasswordKey: []byte(password),
 		},
 	}
+	if err := controllerutil.SetControllerReference(owner, newSecret, c.Scheme()); err != nil {
+		logger.Error(err, "设置 Secret 的 OwnerReference 失败")
+		return nil, err
+	}
+	logger.Info("创建实例凭据 Secret", "Secret.Namespace", namespace, "Secret.Name", secret.Name)
 	if err := c.Create(ctx, newSecret); err != nil {
 		logger.Error(err, "创建 Secret 失败")
 		return nil, err
@@ -74,20 +134,14 @@ func createNewSecret(ctx context.Context, c client.Client, name, namespace, secr
 	return newSecret, nil
 }
 
-// GetOrCreateSecret 获取或创建 Secret
-func GetOrCreateSecret(ctx context.Context, c client.Client, name, namespace, databaseType string) (*corev1.Secret, error) {
+// GetOrCreateSecret 获取或创建实例专属的凭据 Secret，实例是它的 controller，实例删除时随之回收
+// 已存在的 Secret（例如按 Retain 策略保留下来的）会被重新接管，缺失的键按照新建时的规则补全
+func GetOrCreateSecret(ctx context.Context, c client.Client, owner metav1.Object, namespace string, secret engine.SecretRef, eng engine.Engine, passwordLength int) (*corev1.Secret, error) {
 	logger := log.FromContext(ctx)
 
-	userKey, passwordKey, err := getSecretKeys(ctx, databaseType)
-	if err != nil {
-		return nil, err
-	}
-
-	secretName := databaseType + "-secret"
-
 	// 尝试获取现有 Secret
 	existingSecret := &corev1.Secret{}
-	err = c.Get(ctx, client.ObjectKey{Name: secretName, Namespace: namespace}, existingSecret)
+	err := c.Get(ctx, client.ObjectKey{Name: secret.Name, Namespace: namespace}, existingSecret)
 	if err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			logger.Error(err, "获取 Secret 失败")
@@ -95,30 +149,42 @@ func GetOrCreateSecret(ctx context.Context, c client.Client, name, namespace, da
 		}
 
 		// Secret 不存在，创建新的 Secret
-		return createNewSecret(ctx, c, name, namespace, secretName, userKey, passwordKey)
+		return createNewSecret(ctx, c, owner, namespace, secret, eng, passwordLength)
 	}
 
-	// Secret 已存在，只更新其他字段
-	if _, ok := existingSecret.Data[userKey]; !ok {
-		// 如果 Secret 中没有对应的用户名字段，生成并添加
-		user, err := GenerateRandomPassword(8) // 生成 8 字节的随机用户名
-		if err != nil {
-			logger.Error(err, "生成用户名失败")
-			return nil, err
-		}
-		existingSecret.Data[userKey] = []byte(user)
+	changed := false
+	if existingSecret.Data == nil {
+		existingSecret.Data = map[string][]byte{}
+	}
+	if _, ok := existingSecret.Data[secret.UsernameKey]; !ok {
+		// 如果 Secret 中没有对应的用户名字段，使用引擎的管理员用户
+		existingSecret.Data[secret.UsernameKey] = []byte(eng.AdminUser())
+		changed = true
 	}
 
-	if _, ok := existingSecret.Data[passwordKey]; !ok {
+	if _, ok := existingSecret.Data[secret.PasswordKey]; !ok {
 		// 如果 Secret 中没有对应的密码字段，生成并添加
-		password, err := GenerateRandomPassword(16) // 生成 16 字节的随机密码
+		password, err := GenerateRandomPassword(passwordLength)
 		if err != nil {
 			logger.Error(err, "生成密码失败")
 			return nil, err
 		}
-		existingSecret.Data[passwordKey] = []byte(password)
+		existingSecret.Data[secret.PasswordKey] = []byte(password)
+		changed = true
 	}
 
+	if !metav1.IsControlledBy(existingSecret, owner) {
+		if err := controllerutil.SetControllerReference(owner, existingSecret, c.Scheme()); err != nil {
+			logger.Error(err, "设置 Secret 的 OwnerReference 失败")
+			return nil, err
+		}
+		delete(existingSecret.Annotations, RetainedFromAnnotation)
+		changed = true
+	}
+
+	if !changed {
+		return existingSecret, nil
+	}
 	if err := c.Update(ctx, existingSecret); err != nil {
 		logger.Error(err, "更新 Secret 失败")
 		return nil, err
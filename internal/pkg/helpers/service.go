@@ -7,50 +7,185 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
-)
 
-// getServiceConfig 根据数据库类型获取服务端口和端口名称
-func getServiceConfig(databaseType string) (int32, string) {
-	switch databaseType {
-	case "mysql":
-		return 3306, "mysql"
-	case "postgres":
-		return 5432, "postgres"
-	case "oceanbase-ce":
-		return 2881, "oceanbase-ce"
-	default:
-		return 3306, "mysql" // 默认值
-	}
-}
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
 
-// NewService 创建一个新的 Service 对象
-func NewService(name, namespace, databaseType string) *corev1.Service {
+// NewService 创建一个新的 Service 对象，类型和注解取自 spec.networking
+func NewService(name, namespace string, networking databasev2.NetworkingSpec, eng engine.Engine) *corev1.Service {
 	labels := map[string]string{
 		"app": name,
 	}
 
-	servicePort, portName := getServiceConfig(databaseType)
+	servicePort := eng.Port()
+
+	serviceType := networking.ServiceType
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
 
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: networking.ServiceAnnotations,
 		},
 		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
 			Selector: labels,
 			Ports: []corev1.ServicePort{
 				{
 					Port:       servicePort,
 					TargetPort: intstr.FromInt(int(servicePort)),
-					Name:       portName,
+					Name:       eng.PortName(),
+				},
+			},
+		},
+	}
+}
+
+// NewHeadlessService 创建 StatefulSet 使用的 Headless Service，为每个副本提供稳定的 DNS 名称
+// 例如 <实例名>-0.<实例名>-headless.<命名空间>.svc
+func NewHeadlessService(name, namespace string, eng engine.Engine) *corev1.Service {
+	labels := map[string]string{
+		"app": name,
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      HeadlessServiceName(name),
+			Namespace: namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  labels,
+			// 副本之间（例如复制、选主）需要在就绪之前互相解析
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{
+				{
+					Port:       eng.Port(),
+					TargetPort: intstr.FromInt(int(eng.Port())),
+					Name:       eng.PortName(),
 				},
 			},
 		},
 	}
 }
 
+// ReadWriteServiceName 返回只选择主库的读写 Service 名称
+func ReadWriteServiceName(name string) string {
+	return name + "-rw"
+}
+
+// ReadOnlyServiceName 返回只选择正在复制的副本的只读 Service 名称
+func ReadOnlyServiceName(name string) string {
+	return name + "-ro"
+}
+
+// ReadServiceName 返回选择全部副本的 Service 名称
+func ReadServiceName(name string) string {
+	return name + "-r"
+}
+
+// serviceHost 返回 Service 在集群内的 DNS 名称
+func serviceHost(name, namespace string) string {
+	return name + "." + namespace + ".svc"
+}
+
+// NewRoleServices 创建按角色划分的 ClusterIP Service：<实例名>-rw 选择主库（未开启复制时与实例的 Service 相同），
+// 开启复制时 <实例名>-ro 选择正在从主库复制的副本，spec.networking.readService 为 true 时 <实例名>-r 选择全部副本。
+// 第二个返回值是实例当前不需要的 Service 名称，需要删除
+func NewRoleServices(dbInstance *databasev2.DatabaseInstance, eng engine.Engine) ([]*corev1.Service, []string) {
+	name := dbInstance.Name
+	newService := func(serviceName string, selector map[string]string) *corev1.Service {
+		service := NewService(name, dbInstance.Namespace, databasev2.NetworkingSpec{}, eng)
+		service.Name = serviceName
+		for key, value := range selector {
+			service.Spec.Selector[key] = value
+		}
+		return service
+	}
+
+	readWrite := newService(ReadWriteServiceName(name), nil)
+	UsePrimarySelector(readWrite, dbInstance, eng)
+	services := []*corev1.Service{readWrite}
+	var unwanted []string
+
+	if ReplicationEnabled(dbInstance, eng) {
+		services = append(services, newService(ReadOnlyServiceName(name), map[string]string{RoleLabel: RoleReplica, StreamingLabel: "true"}))
+	} else {
+		unwanted = append(unwanted, ReadOnlyServiceName(name))
+	}
+	if dbInstance.Spec.Networking.ReadService {
+		services = append(services, newService(ReadServiceName(name), nil))
+	} else {
+		unwanted = append(unwanted, ReadServiceName(name))
+	}
+	return services, unwanted
+}
+
+// EnsureRoleServices 创建或更新按角色划分的 Service，删除不再需要的，并将它们的地址记录到 status.services
+func EnsureRoleServices(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, eng engine.Engine) error {
+	services, unwanted := NewRoleServices(dbInstance, eng)
+	addresses := &databasev2.ServicesStatus{Port: eng.Port()}
+	for _, service := range services {
+		if err := controllerutil.SetControllerReference(dbInstance, service, c.Scheme()); err != nil {
+			ctrl.FromContext(ctx).Error(err, "设置 Service 的 OwnerReference 失败")
+			return err
+		}
+		if err := EnsureService(ctx, c, service); err != nil {
+			return err
+		}
+		host := serviceHost(service.Name, service.Namespace)
+		switch service.Name {
+		case ReadWriteServiceName(dbInstance.Name):
+			addresses.ReadWrite = host
+		case ReadOnlyServiceName(dbInstance.Name):
+			addresses.ReadOnly = host
+		case ReadServiceName(dbInstance.Name):
+			addresses.Read = host
+		}
+	}
+	for _, name := range unwanted {
+		if err := deleteOwnedService(ctx, c, dbInstance, name); err != nil {
+			return err
+		}
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	status.Services = addresses
+	return writeStatus(ctx, c, dbInstance, status)
+}
+
+// deleteOwnedService 删除实例创建的 Service，Service 不存在或者不属于该实例时不做任何操作
+func deleteOwnedService(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, name string) error {
+	logger := ctrl.FromContext(ctx)
+
+	service := &corev1.Service{}
+	err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: dbInstance.Namespace}, service)
+	if err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "获取 Service 失败", "Service.Name", name)
+			return err
+		}
+		return nil
+	}
+	if !metav1.IsControlledBy(service, dbInstance) {
+		return nil
+	}
+	logger.Info("删除不再需要的 Service", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
+	if err := c.Delete(ctx, service); client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "删除 Service 失败", "Service.Name", name)
+		return err
+	}
+	return nil
+}
+
 // EnsureService 确保 Service 存在并更新
+// 注解只会合并，不会删除其他组件（例如云厂商的负载均衡器控制器）添加的注解
 func EnsureService(ctx context.Context, c client.Client, service *corev1.Service) error {
 	logger := ctrl.FromContext(ctx)
 
@@ -69,8 +204,19 @@ func EnsureService(ctx context.Context, c client.Client, service *corev1.Service
 	} else {
 		// Service 存在，更新它
 		updatedService := found.DeepCopy()
-		updatedService.Spec.Ports = service.Spec.Ports
+		if service.Spec.Type != "" {
+			updatedService.Spec.Type = service.Spec.Type
+		}
+		updatedService.Spec.Ports = preserveNodePorts(service.Spec.Ports, found.Spec.Ports, updatedService.Spec.Type)
+		for key, value := range service.Annotations {
+			if updatedService.Annotations == nil {
+				updatedService.Annotations = map[string]string{}
+			}
+			updatedService.Annotations[key] = value
+		}
 		updatedService.Spec.Selector = service.Spec.Selector
+		updatedService.Spec.PublishNotReadyAddresses = service.Spec.PublishNotReadyAddresses
+		mergeOwnerReferences(updatedService, service)
 
 		logger.Info("更新 Service", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
 		if err := c.Update(ctx, updatedService); err != nil {
@@ -80,3 +226,20 @@ func EnsureService(ctx context.Context, c client.Client, service *corev1.Service
 	}
 	return nil
 }
+
+// preserveNodePorts 保留已分配的 NodePort，避免每次更新都重新分配端口；Service 类型为 ClusterIP 时必须清空 NodePort
+func preserveNodePorts(desired, existing []corev1.ServicePort, serviceType corev1.ServiceType) []corev1.ServicePort {
+	if serviceType != corev1.ServiceTypeNodePort && serviceType != corev1.ServiceTypeLoadBalancer {
+		return desired
+	}
+	ports := make([]corev1.ServicePort, len(desired))
+	copy(ports, desired)
+	for i := range ports {
+		for _, port := range existing {
+			if port.Name == ports[i].Name && ports[i].NodePort == 0 {
+				ports[i].NodePort = port.NodePort
+			}
+		}
+	}
+	return ports
+}
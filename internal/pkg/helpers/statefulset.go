@@ -0,0 +1,268 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// dataVolumeName 是 volumeClaimTemplates 中数据卷的名称，每个副本的 PVC 名称为 data-<实例名>-<序号>
+	dataVolumeName = "data"
+
+	// defaultStorageSize 是 spec.storage.size 未设置时每个副本的数据卷大小
+	defaultStorageSize = "1Gi"
+)
+
+// GenerateImageName 生成完整的镜像名称
+func GenerateImageName(baseImage, databaseType, version string) string {
+	const imagePrefix = "registry.leqiutong.xyz/middleware/"
+	image := baseImage
+	if image == "" {
+		image = fmt.Sprintf("%s:%s", databaseType, version)
+	}
+	return fmt.Sprintf("%s%s", imagePrefix, image)
+}
+
+// StorageConfig 描述了每个副本独占的数据卷配置
+type StorageConfig struct {
+	// Size 是每个副本数据卷的容量
+	Size resource.Quantity
+	// StorageClassName 是数据卷使用的存储类，为空时使用集群默认存储类
+	StorageClassName string
+	// AccessModes 是数据卷的访问模式
+	AccessModes []corev1.PersistentVolumeAccessMode
+}
+
+// NewStorageConfig 从 DatabaseInstance 的 spec 中解析数据卷配置
+func NewStorageConfig(spec databasev2.DatabaseInstanceSpec) (StorageConfig, error) {
+	size := resource.MustParse(defaultStorageSize)
+	if spec.Storage.Size != nil {
+		size = spec.Storage.Size.DeepCopy()
+	}
+	if size.Sign() <= 0 {
+		return StorageConfig{}, fmt.Errorf("invalid storage.size %q: must be greater than zero", size.String())
+	}
+
+	accessModes := spec.Storage.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	return StorageConfig{
+		Size:             size,
+		StorageClassName: spec.Storage.StorageClassName,
+		AccessModes:      accessModes,
+	}, nil
+}
+
+// HeadlessServiceName 返回 StatefulSet 使用的 Headless Service 名称
+func HeadlessServiceName(name string) string {
+	return name + "-headless"
+}
+
+// NewStatefulSet 创建一个新的 StatefulSet 对象，每个副本通过 volumeClaimTemplates 获得独立的数据卷
+// 数据库容器从实例的凭据 Secret 中读取管理员密码完成初始化，设置了内存请求或限制时，由引擎据此推算内存相关的参数
+func NewStatefulSet(name, namespace, image string, replicas int32, storage StorageConfig, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine) *appsv1.StatefulSet {
+	labels := map[string]string{
+		"app": name,
+	}
+
+	var args []string
+	env := eng.ServerEnv(secret)
+	if memory, ok := memoryBudget(resources); ok {
+		var tuningEnv []corev1.EnvVar
+		args, tuningEnv = eng.Tuning(memory)
+		env = append(env, tuningEnv...)
+	}
+
+	// 数据卷挂载到引擎的数据目录，使用子目录可以避开文件系统根目录下的 lost+found
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      dataVolumeName,
+			MountPath: eng.DataPath(),
+			SubPath:   eng.Name(),
+		},
+	}
+
+	volumeClaimTemplate := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   dataVolumeName,
+			Labels: labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: storage.AccessModes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: storage.Size,
+				},
+			},
+		},
+	}
+	if storage.StorageClassName != "" {
+		volumeClaimTemplate.Spec.StorageClassName = ptr.To(storage.StorageClassName)
+	}
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: HeadlessServiceName(name),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:      name,
+							Image:     image,
+							Args:      args,
+							Env:       env,
+							Resources: resources,
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: eng.Port(),
+									Name:          eng.PortName(),
+								},
+							},
+							LivenessProbe:  eng.LivenessProbe(),
+							ReadinessProbe: eng.ReadinessProbe(),
+							VolumeMounts:   volumeMounts,
+						},
+					},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{volumeClaimTemplate},
+		},
+	}
+}
+
+// EnsureStatefulSet 确保 StatefulSet 存在并更新
+// volumeClaimTemplates 创建后不可修改，因此更新时只同步可变字段，容量变大时直接扩容已有的 PVC
+func EnsureStatefulSet(ctx context.Context, c client.Client, statefulSet *appsv1.StatefulSet) error {
+	logger := ctrl.FromContext(ctx)
+
+	found := &appsv1.StatefulSet{}
+	err := c.Get(ctx, client.ObjectKey{Name: statefulSet.Name, Namespace: statefulSet.Namespace}, found)
+	if err != nil && client.IgnoreNotFound(err) == nil {
+		// StatefulSet 不存在，创建它
+		logger.Info("创建一个新的 StatefulSet", "StatefulSet.Namespace", statefulSet.Namespace, "StatefulSet.Name", statefulSet.Name)
+		if err := c.Create(ctx, statefulSet); err != nil {
+			logger.Error(err, "新的 StatefulSet 创建失败")
+			return err
+		}
+		return nil
+	} else if err != nil {
+		logger.Error(err, "获取 StatefulSet 失败")
+		return err
+	}
+
+	// StatefulSet 存在，更新可变字段
+	logger.Info("更新已有的 StatefulSet", "StatefulSet.Namespace", statefulSet.Namespace, "StatefulSet.Name", statefulSet.Name)
+	found.Spec.Replicas = statefulSet.Spec.Replicas
+	found.Spec.Template = statefulSet.Spec.Template
+	found.Spec.UpdateStrategy = statefulSet.Spec.UpdateStrategy
+	mergeOwnerReferences(found, statefulSet)
+	if err := c.Update(ctx, found); err != nil {
+		logger.Error(err, "更新 StatefulSet 失败")
+		return err
+	}
+
+	return expandDataVolumes(ctx, c, found, statefulSet)
+}
+
+// expandDataVolumes 在期望容量大于现有 PVC 容量时扩容每个副本的数据卷，需要存储类开启 allowVolumeExpansion
+func expandDataVolumes(ctx context.Context, c client.Client, found, desired *appsv1.StatefulSet) error {
+	logger := ctrl.FromContext(ctx)
+
+	if len(desired.Spec.VolumeClaimTemplates) == 0 {
+		return nil
+	}
+	size := desired.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]
+
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := c.List(ctx, &pvcs, client.InNamespace(found.Namespace), client.MatchingLabels(found.Spec.Selector.MatchLabels)); err != nil {
+		logger.Error(err, "获取数据卷 PVC 列表失败")
+		return err
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if !strings.HasPrefix(pvc.Name, dataVolumeName+"-"+found.Name+"-") {
+			continue
+		}
+		current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if size.Cmp(current) <= 0 {
+			continue
+		}
+		logger.Info("扩容数据卷 PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name, "from", current.String(), "to", size.String())
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		if err := c.Update(ctx, pvc); err != nil {
+			logger.Error(err, "扩容数据卷 PVC 失败", "PVC.Name", pvc.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// LegacyDeploymentActive 检查旧版本 Operator 以 Deployment 形式创建的同名工作负载是否仍然存在。
+// 旧版 Deployment 的数据保存在同类型实例共享的 NFS 目录中，无法自动迁移到 StatefulSet 的数据卷，
+// 因此 Operator 保留 Deployment、不创建 StatefulSet，并设置 MigrationRequired 条件，直到用户迁移数据后手动删除它；
+// Deployment 不存在时清除该条件
+func LegacyDeploymentActive(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) (bool, error) {
+	logger := ctrl.FromContext(ctx)
+
+	deployment := &appsv1.Deployment{}
+	err := c.Get(ctx, client.ObjectKey{Name: dbInstance.Name, Namespace: dbInstance.Namespace}, deployment)
+	if err != nil && client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "获取旧版 Deployment 失败")
+		return false, err
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	if err != nil {
+		if meta.FindStatusCondition(status.Conditions, databasev2.ConditionMigrationRequired) == nil {
+			return false, nil
+		}
+		meta.RemoveStatusCondition(&status.Conditions, databasev2.ConditionMigrationRequired)
+		return false, writeStatus(ctx, c, dbInstance, status)
+	}
+
+	message := "实例仍由旧版 Deployment " + deployment.Name + " 运行，迁移数据并删除该 Deployment 后才会创建 StatefulSet"
+	logger.Info(message, "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
+	// 旧版实例就绪时仍然是 Running，可以通过 DatabaseBackup 导出数据
+	status.Phase = databasev2.PhaseProvisioning
+	if deployment.Status.ReadyReplicas > 0 {
+		status.Phase = databasev2.PhaseRunning
+	}
+	status.Message = message
+	status.ObservedGeneration = dbInstance.Generation
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               databasev2.ConditionMigrationRequired,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             "LegacyDeployment",
+		Message:            message,
+	})
+	return true, writeStatus(ctx, c, dbInstance, status)
+}
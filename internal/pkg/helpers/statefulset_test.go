@@ -0,0 +1,248 @@
+package helpers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+var _ = Describe("StatefulSet", func() {
+	const (
+		name      = "mysql"
+		namespace = "default"
+	)
+
+	ctx := context.Background()
+
+	var eng engine.Engine
+	var secret engine.SecretRef
+
+	BeforeEach(func() {
+		var err error
+		eng, err = engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+		secret = engine.SecretRef{Name: InstanceSecretName(name), UsernameKey: "mysql-user", PasswordKey: "mysql-password"}
+	})
+
+	newStorage := func(size string) StorageConfig {
+		return StorageConfig{
+			Size:             resource.MustParse(size),
+			StorageClassName: "fast",
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		}
+	}
+
+	dataVolume := func(claim, instance, size string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      claim,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": instance},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+				},
+			},
+		}
+	}
+
+	storageOf := func(c client.Client, claim string) string {
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: claim, Namespace: namespace}, pvc)).To(Succeed())
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		return size.String()
+	}
+
+	Context("When building the StatefulSet", func() {
+		It("should give every replica its own data volume", func() {
+			statefulSet := NewStatefulSet(name, namespace, "mysql:8.0", 3, newStorage("10Gi"), corev1.ResourceRequirements{}, secret, eng)
+
+			Expect(*statefulSet.Spec.Replicas).To(Equal(int32(3)))
+			Expect(statefulSet.Spec.ServiceName).To(Equal(HeadlessServiceName(name)))
+			Expect(statefulSet.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": name}))
+
+			Expect(statefulSet.Spec.VolumeClaimTemplates).To(HaveLen(1))
+			claim := statefulSet.Spec.VolumeClaimTemplates[0]
+			Expect(claim.Name).To(Equal("data"))
+			Expect(claim.Spec.StorageClassName).To(Equal(ptr.To("fast")))
+			Expect(claim.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+			Expect(claim.Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("10Gi")))
+
+			container := statefulSet.Spec.Template.Spec.Containers[0]
+			Expect(container.Image).To(Equal("mysql:8.0"))
+			Expect(container.Ports).To(ConsistOf(corev1.ContainerPort{ContainerPort: 3306, Name: "mysql"}))
+			Expect(container.VolumeMounts).To(ConsistOf(corev1.VolumeMount{Name: "data", MountPath: "/var/lib/mysql", SubPath: "mysql"}))
+			Expect(container.Env).To(ContainElement(And(
+				HaveField("Name", "MYSQL_ROOT_PASSWORD"),
+				HaveField("ValueFrom.SecretKeyRef.Name", secret.Name),
+			)))
+		})
+
+		It("should use the default storage class when none is set", func() {
+			storage := newStorage("1Gi")
+			storage.StorageClassName = ""
+			statefulSet := NewStatefulSet(name, namespace, "mysql:8.0", 1, storage, corev1.ResourceRequirements{}, secret, eng)
+
+			Expect(statefulSet.Spec.VolumeClaimTemplates[0].Spec.StorageClassName).To(BeNil())
+		})
+	})
+
+	Context("When resources are set", func() {
+		memory := func(requests, limits string) corev1.ResourceRequirements {
+			resources := corev1.ResourceRequirements{}
+			if requests != "" {
+				resources.Requests = corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse(requests),
+				}
+			}
+			if limits != "" {
+				resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(limits)}
+			}
+			return resources
+		}
+
+		DescribeTable("should pass the resources and the derived tuning parameters to the database container",
+			func(databaseType string, resources corev1.ResourceRequirements, args []string, env []corev1.EnvVar) {
+				eng, err := engine.Get(databaseType)
+				Expect(err).NotTo(HaveOccurred())
+
+				statefulSet := NewStatefulSet(name, namespace, "image", 1, newStorage("1Gi"), resources, secret, eng)
+
+				container := statefulSet.Spec.Template.Spec.Containers[0]
+				Expect(container.Resources).To(Equal(resources))
+				if args == nil {
+					Expect(container.Args).To(BeEmpty())
+				} else {
+					Expect(container.Args).To(Equal(args))
+				}
+				for _, e := range env {
+					Expect(container.Env).To(ContainElement(e))
+				}
+			},
+			Entry("MySQL sizes the buffer pool from the memory limit", "mysql", memory("1Gi", "2Gi"),
+				[]string{"--innodb-buffer-pool-size=1476395008"}, nil),
+			Entry("MySQL falls back to the memory request", "mysql", memory("1Gi", ""),
+				[]string{"--innodb-buffer-pool-size=671088640"}, nil),
+			Entry("MySQL keeps the image defaults without a memory budget", "mysql", corev1.ResourceRequirements{}, nil, nil),
+			Entry("PostgreSQL sizes shared_buffers and effective_cache_size", "postgres", memory("", "2Gi"),
+				[]string{"-c", "shared_buffers=511MB", "-c", "effective_cache_size=1535MB"}, nil),
+			Entry("OceanBase-CE sets memory_limit through the environment", "oceanbase-ce", memory("", "8Gi"),
+				nil, []corev1.EnvVar{{Name: "OB_MEMORY_LIMIT", Value: "6G"}}),
+		)
+	})
+
+	Context("When ensuring the StatefulSet", func() {
+		It("should create the StatefulSet when it does not exist", func() {
+			c := newFakeClient()
+			desired := NewStatefulSet(name, namespace, "mysql:8.0", 1, newStorage("1Gi"), corev1.ResourceRequirements{}, secret, eng)
+
+			Expect(EnsureStatefulSet(ctx, c, desired)).To(Succeed())
+
+			found := &appsv1.StatefulSet{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, found)).To(Succeed())
+			Expect(found.Spec.VolumeClaimTemplates).To(HaveLen(1))
+		})
+
+		It("should update the mutable fields and keep the volume claim templates", func() {
+			c := newFakeClient(NewStatefulSet(name, namespace, "mysql:8.0", 1, newStorage("1Gi"), corev1.ResourceRequirements{}, secret, eng))
+			desired := NewStatefulSet(name, namespace, "mysql:8.4", 3, newStorage("5Gi"), corev1.ResourceRequirements{}, secret, eng)
+
+			Expect(EnsureStatefulSet(ctx, c, desired)).To(Succeed())
+
+			found := &appsv1.StatefulSet{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, found)).To(Succeed())
+			Expect(*found.Spec.Replicas).To(Equal(int32(3)))
+			Expect(found.Spec.Template.Spec.Containers[0].Image).To(Equal("mysql:8.4"))
+			// volumeClaimTemplates 不可修改，容量通过扩容已有的 PVC 生效
+			Expect(found.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("1Gi")))
+		})
+
+		It("should expand the data volumes of the instance but never shrink them", func() {
+			c := newFakeClient(
+				NewStatefulSet(name, namespace, "mysql:8.0", 2, newStorage("1Gi"), corev1.ResourceRequirements{}, secret, eng),
+				dataVolume("data-mysql-0", name, "1Gi"),
+				dataVolume("data-mysql-1", name, "8Gi"),
+				// 备份卷等同一实例的其他 PVC 不是数据卷，不会被扩容
+				dataVolume("mysql-backup", name, "1Gi"),
+			)
+			desired := NewStatefulSet(name, namespace, "mysql:8.0", 2, newStorage("5Gi"), corev1.ResourceRequirements{}, secret, eng)
+
+			Expect(EnsureStatefulSet(ctx, c, desired)).To(Succeed())
+
+			Expect(storageOf(c, "data-mysql-0")).To(Equal("5Gi"))
+			Expect(storageOf(c, "data-mysql-1")).To(Equal("8Gi"))
+			Expect(storageOf(c, "mysql-backup")).To(Equal("1Gi"))
+		})
+	})
+
+	Context("When an older version deployed the instance as a Deployment", func() {
+		var dbInstance *databasev2.DatabaseInstance
+		var deployment *appsv1.Deployment
+
+		BeforeEach(func() {
+			dbInstance = &databasev2.DatabaseInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Generation: 1},
+				Spec:       databasev2.DatabaseInstanceSpec{Engine: databasev2.EngineSpec{Type: databasev2.EngineMySQL}},
+			}
+			deployment = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Status:     appsv1.DeploymentStatus{ReadyReplicas: 1},
+			}
+		})
+
+		It("should keep the Deployment and require a migration", func() {
+			c := newFakeClient(dbInstance, deployment)
+
+			legacy, err := LegacyDeploymentActive(ctx, c, dbInstance)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeTrue())
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(deployment), &appsv1.Deployment{})).To(Succeed())
+
+			found := &databasev2.DatabaseInstance{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(dbInstance), found)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(found.Status.Conditions, databasev2.ConditionMigrationRequired)).To(BeTrue())
+			// 旧实例仍然可以提供服务，按需备份需要实例处于 Running 阶段
+			Expect(found.Status.Phase).To(Equal(databasev2.PhaseRunning))
+		})
+
+		It("should report Provisioning while the Deployment has no ready replicas", func() {
+			deployment.Status.ReadyReplicas = 0
+			c := newFakeClient(dbInstance, deployment)
+
+			legacy, err := LegacyDeploymentActive(ctx, c, dbInstance)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeTrue())
+			Expect(dbInstance.Status.Phase).To(Equal(databasev2.PhaseProvisioning))
+		})
+
+		It("should clear the condition after the user deletes the Deployment", func() {
+			c := newFakeClient(dbInstance, deployment)
+			_, err := LegacyDeploymentActive(ctx, c, dbInstance)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(c.Delete(ctx, deployment)).To(Succeed())
+			legacy, err := LegacyDeploymentActive(ctx, c, dbInstance)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(legacy).To(BeFalse())
+
+			found := &databasev2.DatabaseInstance{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(dbInstance), found)).To(Succeed())
+			Expect(meta.FindStatusCondition(found.Status.Conditions, databasev2.ConditionMigrationRequired)).To(BeNil())
+			Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(deployment), &appsv1.Deployment{}))).To(BeTrue())
+		})
+	})
+})
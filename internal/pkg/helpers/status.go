@@ -2,43 +2,322 @@ package helpers
 
 import (
 	"context"
+	"fmt"
 
-	databasev1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
 )
 
-// createDatabaseInstanceCondition 创建 DatabaseInstanceCondition
-func createDatabaseInstanceCondition() databasev1.DatabaseInstanceCondition {
-	return databasev1.DatabaseInstanceCondition{
-		Type:               "Ready",
-		Status:             "True",
-		LastTransitionTime: metav1.Now(),
-		Reason:             "Deployment completed successfully",
-		Message:            "数据库实例已成功部署完成",
+// podFailureReasons 是容器处于等待状态时表示无法自行恢复的原因
+var podFailureReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// observedChildren 是计算状态时观察到的子资源
+type observedChildren struct {
+	// statefulSet 为 nil 表示工作负载尚未创建
+	statefulSet *appsv1.StatefulSet
+	pods        []corev1.Pod
+	backupJobs  []batchv1.Job
+	// backupVolume 为 nil 表示备份写入对象存储
+	backupVolume *observedBackupVolume
+}
+
+// observeChildren 获取 DatabaseInstance 的工作负载、Pod、备份任务和备份卷
+func observeChildren(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) (observedChildren, error) {
+	logger := ctrl.FromContext(ctx)
+	observed := observedChildren{}
+
+	statefulSet := &appsv1.StatefulSet{}
+	err := c.Get(ctx, client.ObjectKey{Name: dbInstance.Name, Namespace: dbInstance.Namespace}, statefulSet)
+	if err == nil {
+		observed.statefulSet = statefulSet
+	} else if client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "获取 StatefulSet 失败")
+		return observed, err
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(dbInstance.Namespace), client.MatchingLabels{"app": dbInstance.Name}); err != nil {
+		logger.Error(err, "获取 Pod 列表失败")
+		return observed, err
+	}
+	observed.pods = pods.Items
+
+	if dbInstance.Spec.Backup.Enabled {
+		var jobs batchv1.JobList
+		if err := c.List(ctx, &jobs, client.InNamespace(dbInstance.Namespace), client.MatchingLabels{"app": BackupCronJobName(dbInstance.Name)}); err != nil {
+			logger.Error(err, "获取备份 Job 列表失败")
+			return observed, err
+		}
+		observed.backupJobs = jobs.Items
+	}
+
+	backupVolume, err := observeBackupVolume(ctx, c, dbInstance)
+	if err != nil {
+		return observed, err
+	}
+	observed.backupVolume = backupVolume
+
+	return observed, nil
+}
+
+// failingPod 返回第一个处于不可自行恢复状态的 Pod 及原因
+func failingPod(pods []corev1.Pod) (string, string, bool) {
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodFailed {
+			return pod.Name, "PodFailed", true
+		}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting != nil && podFailureReasons[status.State.Waiting.Reason] {
+				return pod.Name, status.State.Waiting.Reason, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// anyPodScheduled 判断是否至少有一个 Pod 已被调度到节点上
+func anyPodScheduled(pods []corev1.Pod) bool {
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// rolloutComplete 判断 StatefulSet 是否已将全部副本更新到最新版本
+func rolloutComplete(statefulSet *appsv1.StatefulSet, desired int32) bool {
+	return statefulSet.Status.ObservedGeneration >= statefulSet.Generation &&
+		statefulSet.Status.UpdatedReplicas == desired &&
+		(statefulSet.Status.UpdateRevision == "" || statefulSet.Status.UpdateRevision == statefulSet.Status.CurrentRevision)
+}
+
+// jobFinished 判断 Job 是否已经执行结束以及是否成功
+func jobFinished(job *batchv1.Job) (bool, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// latestFinishedJob 返回最近一次执行结束的备份任务以及是否成功
+func latestFinishedJob(jobs []batchv1.Job) (*batchv1.Job, bool) {
+	var latest *batchv1.Job
+	var succeeded bool
+	for i := range jobs {
+		job := &jobs[i]
+		finished, ok := jobFinished(job)
+		if !finished {
+			continue
+		}
+		if latest == nil || job.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = job
+			succeeded = ok
+		}
 	}
+	return latest, succeeded
 }
 
-// UpdateDatabaseInstanceStatus 更新 DatabaseInstance 的状态
-func UpdateDatabaseInstanceStatus(ctx context.Context, c client.Client, dbInstance *databasev1.DatabaseInstance) error {
+// computeStatus 根据观察到的子资源计算 DatabaseInstance 的阶段和条件
+func computeStatus(status *databasev2.DatabaseInstanceStatus, dbInstance *databasev2.DatabaseInstance, observed observedChildren) {
+	generation := dbInstance.Generation
+	status.ObservedGeneration = generation
+	wasAvailable := meta.IsStatusConditionTrue(status.Conditions, databasev2.ConditionAvailable)
+
+	setCondition := func(conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			ObservedGeneration: generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	statefulSet := observed.statefulSet
+	if statefulSet == nil {
+		status.Phase = databasev2.PhasePending
+		status.Message = "等待创建 StatefulSet"
+		status.Replicas = dbInstance.Spec.Topology.Replicas
+		status.ReadyReplicas = 0
+		setCondition(databasev2.ConditionAvailable, metav1.ConditionFalse, "WorkloadNotFound", "StatefulSet 尚未创建")
+		setCondition(databasev2.ConditionProgressing, metav1.ConditionTrue, "WorkloadPending", "等待创建 StatefulSet")
+		setCondition(databasev2.ConditionDegraded, metav1.ConditionFalse, "WorkloadPending", "StatefulSet 尚未创建")
+	} else {
+		desired := int32(1)
+		if statefulSet.Spec.Replicas != nil {
+			desired = *statefulSet.Spec.Replicas
+		}
+		ready := statefulSet.Status.ReadyReplicas
+		status.Replicas = desired
+		status.ReadyReplicas = ready
+
+		complete := rolloutComplete(statefulSet, desired)
+		podName, failureReason, failing := failingPod(observed.pods)
+
+		switch {
+		case failing && ready == 0:
+			status.Phase = databasev2.PhaseFailed
+			status.Message = fmt.Sprintf("没有可用的副本，Pod %s 处于 %s 状态", podName, failureReason)
+		case ready >= desired && complete:
+			status.Phase = databasev2.PhaseRunning
+			status.Message = "数据库实例正在运行中"
+		case failing || (wasAvailable && ready < desired && complete):
+			status.Phase = databasev2.PhaseDegraded
+			status.Message = fmt.Sprintf("%d/%d 个副本就绪", ready, desired)
+			if failing {
+				status.Message += fmt.Sprintf("，Pod %s 处于 %s 状态", podName, failureReason)
+			}
+		case ready == 0 && !anyPodScheduled(observed.pods):
+			status.Phase = databasev2.PhasePending
+			status.Message = "等待副本被调度"
+		default:
+			status.Phase = databasev2.PhaseProvisioning
+			status.Message = fmt.Sprintf("%d/%d 个副本就绪", ready, desired)
+		}
+
+		if ready > 0 {
+			setCondition(databasev2.ConditionAvailable, metav1.ConditionTrue, "MinimumReplicasAvailable", fmt.Sprintf("%d/%d 个副本就绪", ready, desired))
+		} else {
+			setCondition(databasev2.ConditionAvailable, metav1.ConditionFalse, "NoReplicasAvailable", "没有就绪的副本")
+		}
+
+		if ready >= desired && complete {
+			setCondition(databasev2.ConditionProgressing, metav1.ConditionFalse, "RolloutComplete", "全部副本均已就绪且为最新版本")
+		} else if !complete {
+			setCondition(databasev2.ConditionProgressing, metav1.ConditionTrue, "RollingUpdate", fmt.Sprintf("%d/%d 个副本已更新", statefulSet.Status.UpdatedReplicas, desired))
+		} else {
+			setCondition(databasev2.ConditionProgressing, metav1.ConditionTrue, "ReplicasStarting", fmt.Sprintf("%d/%d 个副本就绪", ready, desired))
+		}
+
+		switch status.Phase {
+		case databasev2.PhaseFailed, databasev2.PhaseDegraded:
+			reason := "ReplicasUnavailable"
+			if failing {
+				reason = failureReason
+			}
+			setCondition(databasev2.ConditionDegraded, metav1.ConditionTrue, reason, status.Message)
+		default:
+			setCondition(databasev2.ConditionDegraded, metav1.ConditionFalse, "AsExpected", "没有异常的副本")
+		}
+	}
+
+	// 只有启用了定时备份，或按需备份、最终备份已经创建了备份卷时才关心备份卷的绑定情况
+	if volume := observed.backupVolume; volume != nil && (dbInstance.Spec.Backup.Enabled || volume.pvc != nil) {
+		conditionStatus, reason, message := backupVolumeCondition(volume)
+		setCondition(databasev2.ConditionBackupVolumeReady, conditionStatus, reason, message)
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, databasev2.ConditionBackupVolumeReady)
+	}
+
+	if !dbInstance.Spec.Backup.Enabled {
+		meta.RemoveStatusCondition(&status.Conditions, databasev2.ConditionBackupHealthy)
+		return
+	}
+	job, succeeded := latestFinishedJob(observed.backupJobs)
+	switch {
+	case job == nil:
+		setCondition(databasev2.ConditionBackupHealthy, metav1.ConditionUnknown, "NoBackupYet", "尚未有执行结束的备份任务")
+	case succeeded:
+		setCondition(databasev2.ConditionBackupHealthy, metav1.ConditionTrue, "LastBackupSucceeded", fmt.Sprintf("备份任务 %s 执行成功", job.Name))
+	default:
+		setCondition(databasev2.ConditionBackupHealthy, metav1.ConditionFalse, "LastBackupFailed", fmt.Sprintf("备份任务 %s 执行失败", job.Name))
+	}
+}
+
+// writeStatus 仅在状态发生变化时写回 DatabaseInstance 的状态，避免每次调和都触发新的更新事件
+func writeStatus(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, status *databasev2.DatabaseInstanceStatus) error {
 	logger := ctrl.FromContext(ctx)
 
-	dbInstance.Status = databasev1.DatabaseInstanceStatus{
-		Phase:         "Running",    // 根据实际情况设置
-		Message:       "数据库实例正在运行中", // 根据实际情况设置
-		ReadyReplicas: 1,            // 根据实际情况设置
-		LastUpdated:   metav1.Now(), // 设置为当前时间
-		Conditions: []databasev1.DatabaseInstanceCondition{
-			createDatabaseInstanceCondition(),
-		},
+	status.LastUpdated = dbInstance.Status.LastUpdated
+	if equality.Semantic.DeepEqual(dbInstance.Status, *status) {
+		return nil
 	}
+	status.LastUpdated = metav1.Now()
+	dbInstance.Status = *status
 
 	if err := c.Status().Update(ctx, dbInstance); err != nil {
 		logger.Error(err, "更新 DatabaseInstance 状态失败", "DatabaseInstance.Namespace", dbInstance.Namespace, "DatabaseInstance.Name", dbInstance.Name)
 		return err
 	}
 
-	logger.Info("成功更新 DatabaseInstance 状态", "DatabaseInstance.Namespace", dbInstance.Namespace, "DatabaseInstance.Name", dbInstance.Name)
+	logger.Info("成功更新 DatabaseInstance 状态", "DatabaseInstance.Namespace", dbInstance.Namespace, "DatabaseInstance.Name", dbInstance.Name, "phase", status.Phase)
 	return nil
 }
+
+// UpdateDatabaseInstanceStatus 根据实际部署的 StatefulSet、Pod 和备份任务计算并更新 DatabaseInstance 的状态
+func UpdateDatabaseInstanceStatus(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) error {
+	observed, err := observeChildren(ctx, c, dbInstance)
+	if err != nil {
+		return err
+	}
+
+	status := dbInstance.Status.DeepCopy()
+	computeStatus(status, dbInstance, observed)
+	return writeStatus(ctx, c, dbInstance, status)
+}
+
+// MarkDatabaseInstanceFailed 将 DatabaseInstance 标记为 Failed，用于无法继续调和的配置错误（例如不支持的数据库类型）
+func MarkDatabaseInstanceFailed(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, reason, message string) error {
+	status := dbInstance.Status.DeepCopy()
+	status.Phase = databasev2.PhaseFailed
+	status.Message = message
+	status.ObservedGeneration = dbInstance.Generation
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               databasev2.ConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               databasev2.ConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             reason,
+		Message:            "spec 配置无效，等待修正",
+	})
+
+	return writeStatus(ctx, c, dbInstance, status)
+}
+
+// MarkDatabaseInstanceDeleting 记录实例删除的进度，reason 对应删除流程当前所处的步骤
+func MarkDatabaseInstanceDeleting(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, reason, message string) error {
+	status := dbInstance.Status.DeepCopy()
+	status.Phase = databasev2.PhaseDeleting
+	status.Message = message
+	status.ObservedGeneration = dbInstance.Generation
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               databasev2.ConditionTerminating,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: dbInstance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+
+	return writeStatus(ctx, c, dbInstance, status)
+}
@@ -0,0 +1,100 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// FailoverReasonSwitchover 表示 spec.topology.primary 请求的计划内主从切换
+	FailoverReasonSwitchover = "Switchover"
+
+	// switchoverMaxLagSeconds 是开始切换之前候选副本最多落后主库的秒数，落后越多，停止写入的时间越长
+	switchoverMaxLagSeconds = 10
+
+	// switchoverTimeout 是停止旧主库的写入之后等待候选副本追上主库的时间
+	switchoverTimeout = time.Minute
+)
+
+// switchoverBlocker 返回 spec.topology.primary 指定的副本还不能被切换为主库的原因，可以切换时返回空字符串
+func switchoverBlocker(candidate *corev1.Pod, replicas []databasev2.ReplicaStatus) string {
+	if candidate == nil || !podReady(candidate) {
+		return "等待 spec.topology.primary 指定的副本就绪"
+	}
+	for _, replica := range replicas {
+		if replica.Name != candidate.Name {
+			continue
+		}
+		if !replica.Streaming {
+			return "等待 " + candidate.Name + " 开始从主库复制后切换"
+		}
+		if replica.LagSeconds != nil && *replica.LagSeconds > switchoverMaxLagSeconds {
+			return fmt.Sprintf("等待 %s 的复制延迟降到 %d 秒以内后切换", candidate.Name, switchoverMaxLagSeconds)
+		}
+		return ""
+	}
+	return "等待 " + candidate.Name + " 开始从主库复制后切换"
+}
+
+// switchover 执行计划内的主从切换：将旧主库设为只读，等待候选副本应用完旧主库的全部变更，再提升候选副本并把实例的 Service 指向它，
+// 切换过程中不会丢失已经提交的数据。成功时返回新主库的名称并在 status 中记录这次切换；
+// 失败时恢复旧主库的写入，返回空字符串和说明原因的消息，下一次检查时重试
+func switchover(ctx context.Context, c client.Client, executor PodExecutor, recorder record.EventRecorder, dbInstance *databasev2.DatabaseInstance,
+	status *databasev2.DatabaseInstanceStatus, pods []corev1.Pod, primary, candidate *corev1.Pod, replicator engine.Replicator,
+	credentials []string) (string, string, error) {
+	logger := ctrl.FromContext(ctx)
+
+	logger.Info("开始主从切换", "from", primary.Name, "to", candidate.Name)
+	recordEvent(recorder, dbInstance, corev1.EventTypeNormal, "SwitchoverStarted",
+		fmt.Sprintf("停止 %s 的写入，等待 %s 追上后将它提升为主库", primary.Name, candidate.Name))
+
+	abort := func(message string, err error) (string, string, error) {
+		message = fmt.Sprintf("%s: %v", message, err)
+		logger.Error(err, "主从切换失败", "from", primary.Name, "to", candidate.Name)
+		recordEvent(recorder, dbInstance, corev1.EventTypeWarning, "SwitchoverFailed", message)
+		// 重新配置旧主库会恢复它的写入
+		if _, restoreMessage, err := configurePrimary(ctx, executor, dbInstance, primary, pods, replicator, credentials); err != nil || restoreMessage != "" {
+			logger.Error(err, "恢复旧主库的写入失败", "Pod.Name", primary.Name, "reason", restoreMessage)
+		}
+		return "", message, nil
+	}
+
+	if _, err := execAdmin(ctx, executor, primary, replicator.FenceCommand(), credentials); err != nil {
+		return abort("停止 "+primary.Name+" 的写入失败", err)
+	}
+	target, err := execAdmin(ctx, executor, primary, replicator.PrimaryPositionCommand(), credentials)
+	if err != nil {
+		return abort("获取 "+primary.Name+" 的复制位置失败", err)
+	}
+	target = strings.TrimSpace(target)
+	if _, err := execAdmin(ctx, executor, candidate, replicator.CatchUpCommand(switchoverTimeout), credentials, target); err != nil {
+		return abort("等待 "+candidate.Name+" 追上主库失败", err)
+	}
+
+	// 候选副本已经包含旧主库的全部变更，旧主库此时不再接受写入，先把它移出实例的 Service 再提升候选副本
+	if err := labelRole(ctx, c, primary, RoleReplica); err != nil {
+		return "", "", err
+	}
+	if _, err := execAdmin(ctx, executor, candidate, replicator.PromoteCommand(), credentials); err != nil {
+		if labelErr := labelRole(ctx, c, primary, RolePrimary); labelErr != nil {
+			return "", "", labelErr
+		}
+		return abort("提升 "+candidate.Name+" 失败", err)
+	}
+
+	message := fmt.Sprintf("已将主库从 %s 切换到 %s", primary.Name, candidate.Name)
+	logger.Info(message)
+	recordEvent(recorder, dbInstance, corev1.EventTypeNormal, "SwitchoverCompleted", message)
+	recordPrimaryChange(status, primary.Name, candidate.Name, FailoverReasonSwitchover, message)
+	return candidate.Name, "", labelRole(ctx, c, candidate, RolePrimary)
+}
@@ -0,0 +1,213 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+var _ = Describe("Switchover", func() {
+	const (
+		name = "mydb"
+		// gtidSet 是旧主库停止写入之后已经执行的全部事务
+		gtidSet = "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42"
+	)
+
+	ctx := context.Background()
+	credentials := []string{"root", "pw", "replication-pw"}
+
+	var (
+		replicator engine.Replicator
+		dbInstance *databasev2.DatabaseInstance
+		status     *databasev2.DatabaseInstanceStatus
+		pods       []corev1.Pod
+		c          client.Client
+		executor   *fakeExecutor
+		recorder   *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		eng, err := engine.Get("mysql")
+		Expect(err).NotTo(HaveOccurred())
+		var ok bool
+		replicator, ok = eng.(engine.Replicator)
+		Expect(ok).To(BeTrue())
+
+		dbInstance = &databasev2.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: databasev2.DatabaseInstanceSpec{
+				Engine:   databasev2.EngineSpec{Type: databasev2.EngineMySQL},
+				Topology: databasev2.TopologySpec{Replicas: 3, Primary: name + "-1"},
+			},
+		}
+		status = &databasev2.DatabaseInstanceStatus{
+			CurrentPrimary: name + "-0",
+			Replication:    &databasev2.ReplicationStatus{},
+		}
+
+		pods = nil
+		objects := []client.Object{}
+		for i, role := range []string{RolePrimary, RoleReplica, RoleReplica} {
+			pod := readyPod(name, fmt.Sprintf("%s-%d", name, i))
+			pod.Labels[RoleLabel] = role
+			pods = append(pods, *pod)
+			objects = append(objects, pod)
+		}
+		c = newFakeClient(objects...)
+		executor = &fakeExecutor{}
+		recorder = record.NewFakeRecorder(10)
+	})
+
+	// step 返回 fakeExecutor 收到的命令对应的切换步骤
+	step := func(call execCall) string {
+		switch {
+		case reflect.DeepEqual(call.Command, replicator.FenceCommand()):
+			return "fence"
+		case reflect.DeepEqual(call.Command, replicator.PrimaryPositionCommand()):
+			return "position"
+		case reflect.DeepEqual(call.Command, replicator.CatchUpCommand(switchoverTimeout)):
+			return "catch-up"
+		case reflect.DeepEqual(call.Command, replicator.PromoteCommand()):
+			return "promote"
+		case reflect.DeepEqual(call.Command, replicator.ConfigurePrimaryCommand(replicationOptions(dbInstance))):
+			return "configure-primary"
+		}
+		return "unknown"
+	}
+	steps := func() []string {
+		var result []string
+		for _, call := range executor.calls {
+			result = append(result, call.Pod+" "+step(call))
+		}
+		return result
+	}
+	role := func(podName string) string {
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: podName, Namespace: "default"}, pod)).To(Succeed())
+		return pod.Labels[RoleLabel]
+	}
+	// failAt 让 failing 步骤执行失败，旧主库的复制位置固定为 gtidSet
+	failAt := func(failing string) func(call execCall) (string, error) {
+		return func(call execCall) (string, error) {
+			switch step(call) {
+			case failing:
+				return "", errors.New("command terminated with exit code 1")
+			case "position":
+				return gtidSet + "\n", nil
+			}
+			return "", nil
+		}
+	}
+	run := func() (string, string) {
+		primary, candidate := &pods[0], &pods[1]
+		newPrimary, message, err := switchover(ctx, c, executor, recorder, dbInstance, status, pods, primary, candidate, replicator, credentials)
+		Expect(err).NotTo(HaveOccurred())
+		return newPrimary, message
+	}
+
+	It("should fence the primary and wait for the candidate to catch up before promoting it", func() {
+		// 提升候选副本时记录旧主库的角色，确认它已经被移出实例的 Service
+		var roleWhilePromoting string
+		executor.respond = func(call execCall) (string, error) {
+			if step(call) == "promote" {
+				roleWhilePromoting = role(name + "-0")
+			}
+			return failAt("")(call)
+		}
+
+		newPrimary, message := run()
+		Expect(message).To(BeEmpty())
+		Expect(newPrimary).To(Equal(name + "-1"))
+		Expect(steps()).To(Equal([]string{
+			name + "-0 fence",
+			name + "-0 position",
+			name + "-1 catch-up",
+			name + "-1 promote",
+		}))
+
+		By("Waiting for exactly the transactions the fenced primary had executed")
+		Expect(executor.calls[2].Stdin).To(Equal("root\npw\n" + gtidSet + "\n"))
+		Expect(roleWhilePromoting).To(Equal(RoleReplica))
+
+		By("Pointing the Service at the new primary and recording the switchover")
+		Expect(role(name + "-0")).To(Equal(RoleReplica))
+		Expect(role(name + "-1")).To(Equal(RolePrimary))
+		Expect(status.CurrentPrimary).To(Equal(name + "-1"))
+		Expect(status.Replication.Demoted).To(ConsistOf(name + "-0"))
+		Expect(status.Failovers).To(HaveLen(1))
+		Expect(status.Failovers[0]).To(And(
+			HaveField("From", name+"-0"),
+			HaveField("To", name+"-1"),
+			HaveField("Reason", FailoverReasonSwitchover),
+		))
+		Expect(recorder.Events).To(Receive(ContainSubstring("SwitchoverStarted")))
+		Expect(recorder.Events).To(Receive(ContainSubstring("SwitchoverCompleted")))
+	})
+
+	DescribeTable("should give the primary its writes back when any step of the switchover fails",
+		func(failing string, expected []string) {
+			executor.respond = failAt(failing)
+
+			newPrimary, message := run()
+			Expect(newPrimary).To(BeEmpty())
+			Expect(message).To(ContainSubstring("exit code 1"))
+			Expect(steps()).To(Equal(expected))
+
+			Expect(role(name + "-0")).To(Equal(RolePrimary))
+			Expect(role(name + "-1")).To(Equal(RoleReplica))
+			Expect(status.CurrentPrimary).To(Equal(name + "-0"))
+			Expect(status.Failovers).To(BeEmpty())
+			Expect(recorder.Events).To(Receive(ContainSubstring("SwitchoverStarted")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("SwitchoverFailed")))
+		},
+		Entry("fencing the primary", "fence", []string{
+			name + "-0 fence",
+			name + "-0 configure-primary",
+		}),
+		Entry("reading the position of the primary", "position", []string{
+			name + "-0 fence",
+			name + "-0 position",
+			name + "-0 configure-primary",
+		}),
+		// 候选副本没有在超时之前追上时不会被提升，否则会丢失旧主库已经提交的事务
+		Entry("waiting for the candidate to catch up", "catch-up", []string{
+			name + "-0 fence",
+			name + "-0 position",
+			name + "-1 catch-up",
+			name + "-0 configure-primary",
+		}),
+		Entry("promoting the candidate", "promote", []string{
+			name + "-0 fence",
+			name + "-0 position",
+			name + "-1 catch-up",
+			name + "-1 promote",
+			name + "-0 configure-primary",
+		}),
+	)
+
+	DescribeTable("should only switch over to a ready replica that keeps up with the primary",
+		func(candidate *corev1.Pod, replicas []databasev2.ReplicaStatus, blocked bool) {
+			Expect(switchoverBlocker(candidate, replicas) != "").To(Equal(blocked))
+		},
+		Entry("missing candidate", nil, nil, true),
+		Entry("candidate not ready", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "mydb-1"}}, nil, true),
+		Entry("candidate not replicating yet", readyPod(name, "mydb-1"), []databasev2.ReplicaStatus{{Name: "mydb-2", Streaming: true}}, true),
+		Entry("candidate not streaming", readyPod(name, "mydb-1"), []databasev2.ReplicaStatus{{Name: "mydb-1"}}, true),
+		Entry("candidate lagging behind", readyPod(name, "mydb-1"),
+			[]databasev2.ReplicaStatus{{Name: "mydb-1", Streaming: true, LagSeconds: ptr.To[int64](switchoverMaxLagSeconds + 1)}}, true),
+		Entry("candidate keeping up", readyPod(name, "mydb-1"),
+			[]databasev2.ReplicaStatus{{Name: "mydb-1", Streaming: true, LagSeconds: ptr.To[int64](1)}}, false),
+	)
+})
@@ -0,0 +1,266 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrl "sigs.k8s.io/controller-runtime/pkg/log"
+
+	databasev2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+)
+
+const (
+	// VerifiedLocationAnnotation 记录验证 Job 导入的备份位置
+	VerifiedLocationAnnotation = "apps.leqiutong.xyz/verified-location"
+
+	// verifyTimeout 是一次验证的最长执行时间，超时的验证记为失败，避免卡住的验证阻塞之后的验证
+	verifyTimeout = 6 * time.Hour
+
+	// verifyReadyAttempts 是等待临时数据库接受连接的最大尝试次数，每次间隔 2 秒
+	verifyReadyAttempts = 300
+)
+
+// BackupVerifyJobName 返回验证实例备份的 Job 名称
+func BackupVerifyJobName(instanceName string) string {
+	return instanceName + "-backup-verify"
+}
+
+// NewVerifySchedule 从 spec.backup.verify 中解析备份验证计划，未配置验证时返回 nil
+func NewVerifySchedule(backup databasev2.BackupSpec) (cron.Schedule, error) {
+	if backup.Verify == nil {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(backup.Verify.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup.verify.schedule %q: %w", backup.Verify.Schedule, err)
+	}
+	return schedule, nil
+}
+
+// NewVerifyJob 创建验证备份的 Job：临时数据库以原生 sidecar（restartPolicy 为 Always 的 init 容器）运行实例的数据库镜像，数据写入 emptyDir；
+// 验证容器使用备份镜像，等待临时数据库就绪后导入 artifact，再依次执行 queries。验证容器退出后临时数据库随 Pod 一起结束
+func NewVerifyJob(dbInstance *databasev2.DatabaseInstance, resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine, artifact databasev2.BackupArtifact) (*batchv1.Job, error) {
+	name := BackupVerifyJobName(dbInstance.Name)
+	labels := map[string]string{
+		"app": name,
+	}
+	s3 := BackupTargetOf(dbInstance).S3
+
+	restore, claimName, err := restoreScript(eng, artifact.Location, artifact.Checksum, artifact.Encoding, s3)
+	if err != nil {
+		return nil, err
+	}
+	keyEnv, err := restoreEnv(artifact.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	var queries []string
+	if dbInstance.Spec.Backup.Verify != nil {
+		queries = dbInstance.Spec.Backup.Verify.Queries
+	}
+
+	// 临时数据库与验证容器位于同一个 Pod，通过 127.0.0.1 连接；检查查询通过环境变量传入，不会被拼接到 shell 命令中
+	env := append(eng.ClientEnv(secret, "127.0.0.1"), keyEnv...)
+	for i, query := range queries {
+		env = append(env, corev1.EnvVar{Name: fmt.Sprintf("VERIFY_QUERY_%d", i), Value: query})
+	}
+	command := []string{"sh", "-c", verifyScript(eng, restore, len(queries))}
+	spec := newBackupJobSpec(name, BackupImageName(dbInstance), claimName, command, env, corev1.ResourceRequirements{})
+	spec.BackoffLimit = ptr.To[int32](0)
+	spec.ActiveDeadlineSeconds = ptr.To(int64(verifyTimeout.Seconds()))
+	spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	if claimName == "" {
+		useS3Destination(&spec, s3)
+	}
+
+	podSpec := &spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         "verify-data",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:          "database",
+		Image:         GenerateImageName(dbInstance.Spec.Engine.Image, string(dbInstance.Spec.Engine.Type), dbInstance.Spec.Engine.Version),
+		Env:           eng.ServerEnv(secret),
+		Resources:     resources,
+		RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "verify-data",
+				MountPath: eng.DataPath(),
+			},
+		},
+	})
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   dbInstance.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{VerifiedLocationAnnotation: artifact.Location},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// verifyScript 返回验证容器执行的 shell 脚本：等待临时数据库就绪，执行 restore 导入备份，再依次执行 VERIFY_QUERY_<序号> 中的检查查询
+func verifyScript(eng engine.Engine, restore string, queries int) string {
+	script := fmt.Sprintf(`set -e
+attempt=0
+until printf 'SELECT 1;\n' | %s > /dev/null 2>&1; do
+  attempt=$((attempt + 1))
+  test $attempt -lt %d || { echo 'temporary database did not become ready' >&2; exit 1; }
+  sleep 2
+done
+%s
+`, eng.QueryCommand(), verifyReadyAttempts, restore)
+	for i := 0; i < queries; i++ {
+		script += fmt.Sprintf(`out=$(printf '%%s\n' "$VERIFY_QUERY_%[1]d" | %[2]s) || { echo "check query %[1]d failed: $VERIFY_QUERY_%[1]d" >&2; exit 1; }
+test -n "$out" || { echo "check query %[1]d returned no rows: $VERIFY_QUERY_%[1]d" >&2; exit 1; }
+`, i, eng.QueryCommand())
+	}
+	return strings.TrimSuffix(script, "\n")
+}
+
+// VerifyBackups 按照 spec.backup.verify 的计划验证最新的定时备份，并将结果记录到 status.backup.lastVerified；
+// 验证结束后删除验证 Job，临时数据库随之删除。返回距离下一次验证的时间，0 表示没有待处理的验证
+func VerifyBackups(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance, schedule cron.Schedule,
+	resources corev1.ResourceRequirements, secret engine.SecretRef, eng engine.Engine) (time.Duration, error) {
+	logger := ctrl.FromContext(ctx)
+
+	job := &batchv1.Job{}
+	err := c.Get(ctx, client.ObjectKey{Name: BackupVerifyJobName(dbInstance.Name), Namespace: dbInstance.Namespace}, job)
+	if client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "获取备份验证 Job 失败")
+		return 0, err
+	}
+	found := err == nil
+
+	if schedule == nil {
+		// 关闭验证后删除仍在执行的验证
+		if found {
+			if err := c.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				logger.Error(err, "删除备份验证 Job 失败")
+				return 0, err
+			}
+		}
+		return 0, nil
+	}
+
+	if found {
+		finished, succeeded := jobFinished(job)
+		if !finished {
+			return 0, nil
+		}
+		result, err := verificationResult(ctx, c, job, succeeded)
+		if err != nil {
+			return 0, err
+		}
+		logger.Info("备份验证执行结束", "location", result.Location, "succeeded", result.Succeeded)
+
+		status := dbInstance.Status.DeepCopy()
+		if status.Backup == nil {
+			status.Backup = &databasev2.BackupStatus{}
+		}
+		status.Backup.LastVerified = result
+		if err := writeStatus(ctx, c, dbInstance, status); err != nil {
+			return 0, err
+		}
+		// 结果记录之后删除验证 Job，连同其中的临时数据库
+		if err := c.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "删除备份验证 Job 失败")
+			return 0, err
+		}
+	}
+
+	last := dbInstance.CreationTimestamp.Time
+	if dbInstance.Status.Backup != nil && dbInstance.Status.Backup.LastVerified != nil {
+		last = dbInstance.Status.Backup.LastVerified.CompletionTime.Time
+	}
+	now := time.Now()
+	if next := schedule.Next(last); now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	// 到期时还没有成功的定时备份，等第一个备份记录到清单后再验证
+	if dbInstance.Status.Backup == nil || len(dbInstance.Status.Backup.Artifacts) == 0 {
+		return 0, nil
+	}
+	desired, err := NewVerifyJob(dbInstance, resources, secret, eng, dbInstance.Status.Backup.Artifacts[0])
+	if err != nil {
+		return 0, err
+	}
+	if err := controllerutil.SetControllerReference(dbInstance, desired, c.Scheme()); err != nil {
+		return 0, err
+	}
+	logger.Info("创建备份验证 Job", "Job.Name", desired.Name, "location", dbInstance.Status.Backup.Artifacts[0].Location)
+	if err := c.Create(ctx, desired); err != nil {
+		logger.Error(err, "备份验证 Job 创建失败")
+		return 0, err
+	}
+	return 0, nil
+}
+
+// verificationResult 根据执行结束的验证 Job 计算验证结果，失败时将终止消息（日志末尾）记录到 message 中
+func verificationResult(ctx context.Context, c client.Client, job *batchv1.Job, succeeded bool) (*databasev2.BackupVerificationResult, error) {
+	result := &databasev2.BackupVerificationResult{
+		Location:  job.Annotations[VerifiedLocationAnnotation],
+		Succeeded: succeeded,
+		StartTime: job.Status.StartTime,
+	}
+	if job.Status.CompletionTime != nil {
+		result.CompletionTime = *job.Status.CompletionTime
+	} else if t := latestConditionTime(job); t != nil {
+		result.CompletionTime = *t
+	} else {
+		result.CompletionTime = metav1.Now()
+	}
+
+	if succeeded {
+		result.Message = "备份已完整导入临时实例"
+		if queries := countVerifyQueries(job); queries > 0 {
+			result.Message = fmt.Sprintf("备份已完整导入临时实例，%d 条检查查询均已通过", queries)
+		}
+		return result, nil
+	}
+
+	_, failure, err := jobTerminationMessages(ctx, c, job)
+	if err != nil {
+		return nil, err
+	}
+	result.Message = "备份验证失败"
+	if failure != "" {
+		result.Message += ": " + failure
+	}
+	return result, nil
+}
+
+// countVerifyQueries 返回验证 Job 执行的检查查询数量
+func countVerifyQueries(job *batchv1.Job) int {
+	count := 0
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		if strings.HasPrefix(e.Name, "VERIFY_QUERY_") {
+			count++
+		}
+	}
+	return count
+}
+
+// verifyingLocation 返回正在验证的备份位置，没有正在执行的验证时返回空字符串
+func verifyingLocation(ctx context.Context, c client.Client, dbInstance *databasev2.DatabaseInstance) (string, error) {
+	job := &batchv1.Job{}
+	if err := c.Get(ctx, client.ObjectKey{Name: BackupVerifyJobName(dbInstance.Name), Namespace: dbInstance.Namespace}, job); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return job.Annotations[VerifiedLocationAnnotation], nil
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+)
+
+// SetupDatabaseInstanceWebhookWithManager 将 v1 DatabaseInstance 注册到 Manager，由 /convert 在 v1 与中心版本 v2 之间转换
+// v1 的请求经 matchPolicy=Equivalent 转换为 v2 后，由 v2 的默认值和校验 Webhook 处理
+func SetupDatabaseInstanceWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&appsv1.DatabaseInstance{}).
+		Complete()
+}
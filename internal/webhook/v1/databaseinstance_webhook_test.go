@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+var _ = Describe("DatabaseInstance Webhook", func() {
+	var obj *appsv1.DatabaseInstance
+
+	BeforeEach(func() {
+		obj = &appsv1.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "conversion-sample", Namespace: "default"},
+			Spec: appsv1.DatabaseInstanceSpec{
+				DatabaseType:     "mysql",
+				Version:          "8.0",
+				Image:            "mysql:8.0",
+				Storage:          "10Gi",
+				StorageClassName: "nfs",
+				Replicas:         2,
+				Resources: appsv1.Resources{
+					Requests: appsv1.ResourceRequests{Memory: "1Gi", CPU: "500m"},
+					Limits:   appsv1.ResourceRequests{Memory: "2Gi"},
+				},
+				BackupPolicy: appsv1.BackupPolicy{
+					Enabled:     true,
+					Schedule:    "0 2 * * *",
+					Retention:   "7d",
+					BackupImage: "registry.leqiutong.xyz/middleware/mysql:8.0",
+				},
+				DeletionPolicy: appsv1.DeletionPolicySnapshot,
+			},
+		}
+	})
+
+	Context("When converting DatabaseInstance under Conversion Webhook", func() {
+		It("Should convert the v1 spec into the structured v2 spec", func() {
+			hub := &appsv2.DatabaseInstance{}
+			Expect(obj.ConvertTo(hub)).To(Succeed())
+
+			Expect(hub.Spec.Engine).To(Equal(appsv2.EngineSpec{Type: appsv2.EngineMySQL, Version: "8.0", Image: "mysql:8.0"}))
+			Expect(hub.Spec.Storage.Size.String()).To(Equal("10Gi"))
+			Expect(hub.Spec.Storage.StorageClassName).To(Equal("nfs"))
+			Expect(hub.Spec.Topology.Replicas).To(Equal(int32(2)))
+			Expect(hub.Spec.Resources.Requests.Memory().String()).To(Equal("1Gi"))
+			Expect(hub.Spec.Resources.Requests.Cpu().String()).To(Equal("500m"))
+			Expect(hub.Spec.Resources.Limits.Memory().String()).To(Equal("2Gi"))
+			Expect(hub.Spec.Backup.Image).To(Equal("registry.leqiutong.xyz/middleware/mysql:8.0"))
+			Expect(hub.Spec.Backup.Retention.MaxAge).To(Equal(&metav1.Duration{Duration: 7 * 24 * time.Hour}))
+			Expect(hub.Spec.DeletionPolicy).To(Equal(appsv2.DeletionPolicySnapshot))
+		})
+
+		It("Should round-trip v1 values that v2 cannot represent", func() {
+			obj.Spec.Storage = "ten gigabytes"
+			obj.Spec.BackupPolicy.Retention = "one week"
+
+			hub := &appsv2.DatabaseInstance{}
+			Expect(obj.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Spec.Storage.Size).To(BeNil())
+			Expect(hub.Spec.Backup.Retention).To(BeNil())
+			Expect(hub.Annotations).To(HaveKey(appsv1.V1SpecAnnotation))
+
+			back := &appsv1.DatabaseInstance{}
+			Expect(back.ConvertFrom(hub)).To(Succeed())
+			Expect(back.Spec.Storage).To(Equal("ten gigabytes"))
+			Expect(back.Spec.BackupPolicy.Retention).To(Equal("one week"))
+			Expect(back.Annotations).NotTo(HaveKey(appsv1.V1SpecAnnotation))
+		})
+
+		It("Should round-trip v2 fields that v1 cannot represent", func() {
+			hub := &appsv2.DatabaseInstance{}
+			Expect(obj.ConvertTo(hub)).To(Succeed())
+			hub.Spec.Networking.ServiceType = corev1.ServiceTypeLoadBalancer
+			hub.Spec.Backup.Retention = &appsv2.BackupRetention{KeepLast: ptr.To(int32(7))}
+			hub.Spec.Resources.Limits[corev1.ResourceName("hugepages-2Mi")] = resource.MustParse("128Mi")
+
+			spoke := &appsv1.DatabaseInstance{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+			Expect(spoke.Annotations).To(HaveKey(appsv1.V2SpecAnnotation))
+
+			By("Letting a v1 client change a field it knows about")
+			spoke.Spec.Replicas = 3
+
+			restored := &appsv2.DatabaseInstance{}
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+			Expect(restored.Spec.Topology.Replicas).To(Equal(int32(3)))
+			Expect(restored.Spec.Networking.ServiceType).To(Equal(corev1.ServiceTypeLoadBalancer))
+			Expect(restored.Spec.Backup.Retention.KeepLast).To(Equal(ptr.To(int32(7))))
+			Expect(restored.Spec.Resources.Limits).To(HaveKey(corev1.ResourceName("hugepages-2Mi")))
+			Expect(restored.Annotations).NotTo(HaveKey(appsv1.V2SpecAnnotation))
+		})
+	})
+})
@@ -0,0 +1,386 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/engine"
+	"github.com/cmjzzx/k8s-database-operator/internal/pkg/helpers"
+)
+
+// databaseinstancelog 是本包的日志记录器
+var databaseinstancelog = logf.Log.WithName("databaseinstance-resource")
+
+// SetupDatabaseInstanceWebhookWithManager 将 DatabaseInstance 的默认值和校验 Webhook 注册到 Manager
+// Webhook 的 matchPolicy 为 Equivalent，v1 的请求会先转换为 v2 再交给这里处理
+func SetupDatabaseInstanceWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&appsv2.DatabaseInstance{}).
+		WithValidator(&DatabaseInstanceCustomValidator{}).
+		WithDefaulter(&DatabaseInstanceCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-apps-leqiutong-xyz-v2-databaseinstance,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.leqiutong.xyz,resources=databaseinstances,verbs=create;update,versions=v2,name=mdatabaseinstance-v2.kb.io,admissionReviewVersions=v1,matchPolicy=Equivalent
+
+// DatabaseInstanceCustomDefaulter 在创建和更新 DatabaseInstance 时按数据库类型填充默认值
+type DatabaseInstanceCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &DatabaseInstanceCustomDefaulter{}
+
+// Default 填充版本、镜像、副本数以及启用备份时的备份镜像，不支持的数据库类型留给校验 Webhook 拒绝
+func (d *DatabaseInstanceCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	dbInstance, ok := obj.(*appsv2.DatabaseInstance)
+	if !ok {
+		return fmt.Errorf("expected a DatabaseInstance object but got %T", obj)
+	}
+	databaseinstancelog.Info("填充默认值", "name", dbInstance.GetName())
+
+	eng, err := engine.Get(string(dbInstance.Spec.Engine.Type))
+	if err != nil {
+		return nil
+	}
+
+	spec := &dbInstance.Spec
+	if spec.Engine.Version == "" {
+		spec.Engine.Version = eng.DefaultVersion()
+	}
+	if spec.Engine.Image == "" {
+		spec.Engine.Image = defaultImage(spec.Engine)
+	}
+	if spec.Topology.Replicas == 0 {
+		spec.Topology.Replicas = 1
+	}
+	// 数据库镜像自带 mysqldump、pg_dumpall 等导出工具，可以直接用于备份；OceanBase-CE 镜像中没有 obdumper、obloader，需要用户指定
+	if spec.Backup.Enabled && spec.Backup.Image == "" && spec.Engine.Type != appsv2.EngineOceanBase {
+		spec.Backup.Image = helpers.GenerateImageName(spec.Engine.Image, string(spec.Engine.Type), spec.Engine.Version)
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-apps-leqiutong-xyz-v2-databaseinstance,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.leqiutong.xyz,resources=databaseinstances,verbs=create;update,versions=v2,name=vdatabaseinstance-v2.kb.io,admissionReviewVersions=v1,matchPolicy=Equivalent
+
+// DatabaseInstanceCustomValidator 在创建和更新 DatabaseInstance 时校验 spec，
+// 让原本要到调和时才会暴露的配置错误在提交时就被拒绝
+type DatabaseInstanceCustomValidator struct{}
+
+var _ webhook.CustomValidator = &DatabaseInstanceCustomValidator{}
+
+// ValidateCreate 校验新建的 DatabaseInstance
+func (v *DatabaseInstanceCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	dbInstance, ok := obj.(*appsv2.DatabaseInstance)
+	if !ok {
+		return nil, fmt.Errorf("expected a DatabaseInstance object but got %T", obj)
+	}
+	databaseinstancelog.Info("校验创建", "name", dbInstance.GetName())
+
+	return warningsFor(dbInstance), toInvalid(dbInstance, validateSpec(dbInstance))
+}
+
+// ValidateUpdate 校验更新后的 DatabaseInstance，数据库类型和初始数据来源不可修改，数据卷只能扩容不能缩容，缩容不能移除当前的主库
+func (v *DatabaseInstanceCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	dbInstance, ok := newObj.(*appsv2.DatabaseInstance)
+	if !ok {
+		return nil, fmt.Errorf("expected a DatabaseInstance object for the newObj but got %T", newObj)
+	}
+	oldInstance, ok := oldObj.(*appsv2.DatabaseInstance)
+	if !ok {
+		return nil, fmt.Errorf("expected a DatabaseInstance object for the oldObj but got %T", oldObj)
+	}
+	databaseinstancelog.Info("校验更新", "name", dbInstance.GetName())
+
+	// 删除过程中只会移除 Finalizer，不再校验 spec，避免无效的旧对象无法被删除
+	if !dbInstance.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	allErrs := validateSpec(dbInstance)
+	specPath := field.NewPath("spec")
+
+	if dbInstance.Spec.Engine.Type != oldInstance.Spec.Engine.Type {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("engine", "type"), "engine.type is immutable"))
+	}
+
+	oldStorage, oldErr := helpers.NewStorageConfig(oldInstance.Spec)
+	newStorage, newErr := helpers.NewStorageConfig(dbInstance.Spec)
+	if oldErr == nil && newErr == nil && newStorage.Size.Cmp(oldStorage.Size) < 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("storage", "size"),
+			fmt.Sprintf("storage cannot be shrunk from %s to %s", oldStorage.Size.String(), newStorage.Size.String())))
+	}
+	if dbInstance.Spec.Storage.StorageClassName != oldInstance.Spec.Storage.StorageClassName {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("storage", "storageClassName"), "storageClassName is immutable"))
+	}
+	// 自动故障转移之后主库可能是序号较大的 Pod，缩容不能删除正在运行的主库，否则会再次触发故障转移并可能丢失未复制的写入；
+	// 停止整个实例（replicas 为 0）时不会发生故障转移，不受限制
+	if ordinal, ok := podOrdinal(oldInstance.Name, oldInstance.Status.CurrentPrimary); ok &&
+		dbInstance.Spec.Topology.Replicas > 0 && dbInstance.Spec.Topology.Replicas <= int32(ordinal) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("topology", "replicas"),
+			fmt.Sprintf("scaling down to %d replicas would remove the current primary %s; switch over to one of %s-0 to %s-%d with topology.primary first",
+				dbInstance.Spec.Topology.Replicas, oldInstance.Status.CurrentPrimary, dbInstance.Name, dbInstance.Name, dbInstance.Spec.Topology.Replicas-1)))
+	}
+	if !equality.Semantic.DeepEqual(dbInstance.Spec.Bootstrap, oldInstance.Spec.Bootstrap) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("bootstrap"), "bootstrap is immutable; create a DatabaseRestore to load a backup into an existing instance"))
+	}
+
+	warnings := warningsFor(dbInstance)
+	oldEngine, newEngine := oldInstance.Spec.Engine, dbInstance.Spec.Engine
+	if newEngine.Version != oldEngine.Version && newEngine.Image == oldEngine.Image && oldEngine.Image == defaultImage(oldEngine) {
+		warnings = append(warnings, "spec.engine.image was defaulted from spec.engine.version and still points to "+oldEngine.Image+
+			"; update spec.engine.image as well to change the running version")
+	}
+	return warnings, toInvalid(dbInstance, allErrs)
+}
+
+// ValidateDelete 删除时不需要校验
+func (v *DatabaseInstanceCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// podOrdinal 返回实例 Pod 名称 <实例名>-<序号> 中的序号，不是实例的 Pod 名称时返回 false
+func podOrdinal(instanceName, podName string) (int, bool) {
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(podName, instanceName+"-"))
+	if err != nil || ordinal < 0 || podName != fmt.Sprintf("%s-%d", instanceName, ordinal) {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// quantityPattern 是 Kubernetes 资源数量的格式，与 resource.ParseQuantity 的报错信息保持一致
+const quantityPattern = `^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$`
+
+// defaultImage 返回未指定镜像时按数据库类型和版本生成的镜像
+func defaultImage(spec appsv2.EngineSpec) string {
+	return fmt.Sprintf("%s:%s", spec.Type, spec.Version)
+}
+
+// validateSpec 校验创建和更新时都必须满足的约束，与控制器调和时的解析逻辑保持一致
+func validateSpec(dbInstance *appsv2.DatabaseInstance) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := dbInstance.Spec
+	specPath := field.NewPath("spec")
+
+	eng, err := engine.Get(string(spec.Engine.Type))
+	if err != nil {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("engine", "type"), spec.Engine.Type, engine.Names()))
+	}
+
+	if spec.Topology.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("topology", "replicas"), spec.Topology.Replicas, "must be greater than or equal to 0"))
+	}
+	// 半同步复制是 MySQL 的功能，synchronous_standby_names 是 PostgreSQL 的配置
+	if replication := spec.Topology.Replication; replication != nil {
+		replicationPath := specPath.Child("topology", "replication")
+		if replication.SemiSync != nil && spec.Engine.Type != appsv2.EngineMySQL {
+			allErrs = append(allErrs, field.Forbidden(replicationPath.Child("semiSync"), "semi-synchronous replication is only supported for mysql"))
+		}
+		if replication.SynchronousStandbyNames != "" && spec.Engine.Type != appsv2.EnginePostgres {
+			allErrs = append(allErrs, field.Forbidden(replicationPath.Child("synchronousStandbyNames"), "synchronousStandbyNames is only supported for postgres"))
+		}
+		if replication.FailoverDelay != nil && replication.FailoverDelay.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(replicationPath.Child("failoverDelay"), replication.FailoverDelay.Duration.String(), "must be greater than 0"))
+		}
+	}
+	// 期望的主库必须是实例现有的 Pod，缩容时也不能移除它
+	if primary := spec.Topology.Primary; primary != "" {
+		if ordinal, ok := podOrdinal(dbInstance.Name, primary); !ok || int32(ordinal) >= spec.Topology.Replicas {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("topology", "primary"), primary,
+				fmt.Sprintf("must name one of the instance's pods, %s-0 to %s-%d", dbInstance.Name, dbInstance.Name, spec.Topology.Replicas-1)))
+		}
+	}
+
+	if _, err := helpers.NewStorageConfig(spec); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("storage", "size"), spec.Storage.Size, err.Error()))
+	}
+	// 通过 v1 提交的无法解析的资源数量不会出现在 v2 的 spec 中，只保留在注解里，不能让它们静默地回退为默认值
+	lost, err := appsv1.UnconvertibleFieldsOf(dbInstance)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(appsv1.V1SpecAnnotation), "", err.Error()))
+	}
+	if lost.Storage != "" {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("storage"), lost.Storage, "quantities must match the regular expression '"+quantityPattern+"'"))
+	}
+	if lost.Resources != (appsv1.Resources{}) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("resources"), lost.Resources, "quantities must match the regular expression '"+quantityPattern+"'"))
+	}
+
+	if _, err := helpers.NewResourceRequirements(spec.Resources); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("resources"), spec.Resources, err.Error()))
+	}
+
+	if spec.Backup.Enabled {
+		schedulePath := specPath.Child("backup", "schedule")
+		if spec.Backup.Schedule == "" {
+			allErrs = append(allErrs, field.Required(schedulePath, "schedule is required when backup is enabled"))
+		} else if _, err := cron.ParseStandard(spec.Backup.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(schedulePath, spec.Backup.Schedule, err.Error()))
+		}
+	}
+
+	// OceanBase 的备份和导入使用 obdumper、obloader，数据库镜像中没有这两个工具
+	if spec.Engine.Type == appsv2.EngineOceanBase && spec.Backup.Image == "" && usesBackupImage(spec) {
+		allErrs = append(allErrs, field.Required(specPath.Child("backup", "image"),
+			"oceanbase-ce backups and restores require an image with obdumper, obloader and obclient"))
+	}
+
+	if spec.Backup.Verify != nil {
+		verifyPath := specPath.Child("backup", "verify")
+		if _, err := helpers.NewVerifySchedule(spec.Backup); err != nil {
+			allErrs = append(allErrs, field.Invalid(verifyPath.Child("schedule"), spec.Backup.Verify.Schedule, err.Error()))
+		}
+	}
+
+	// 轮换会把新密码写入凭据 Secret，用户提供的 Secret 不由 Operator 修改
+	rotationPath := specPath.Child("credentials", "rotation")
+	if spec.Credentials.Rotation != nil && spec.Credentials.ExistingSecretRef != nil {
+		allErrs = append(allErrs, field.Forbidden(rotationPath,
+			"credentials.rotation cannot be used with credentials.existingSecretRef; rotate the password in the referenced Secret and the database yourself"))
+	} else if _, err := helpers.NewRotationSchedule(spec.Credentials); err != nil {
+		allErrs = append(allErrs, field.Invalid(rotationPath, spec.Credentials.Rotation, err.Error()))
+	}
+
+	if eng != nil {
+		allErrs = append(allErrs, validateArchive(dbInstance, eng)...)
+	}
+
+	return allErrs
+}
+
+// validateArchive 校验持续归档和按时间点恢复：只有实现了 engine.Archiver 的引擎支持，归档只支持单副本实例，
+// 恢复目标的类型必须受引擎支持，并且归档需要从与源实例共享的备份位置读取
+func validateArchive(dbInstance *appsv2.DatabaseInstance, eng engine.Engine) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := dbInstance.Spec
+	archivePath := field.NewPath("spec", "backup", "archive")
+	pointInTimePath := field.NewPath("spec", "bootstrap", "pointInTime")
+	archiver, supported := eng.(engine.Archiver)
+	target := helpers.BackupTargetOf(dbInstance)
+
+	if spec.Backup.Archive != nil {
+		if _, err := helpers.NewArchiveConfig(spec.Backup); err != nil {
+			allErrs = append(allErrs, field.Invalid(archivePath, spec.Backup.Archive, err.Error()))
+		}
+		if !supported {
+			allErrs = append(allErrs, field.Forbidden(archivePath, "continuous archiving is not supported for "+eng.Name()))
+		}
+		if spec.Topology.Replicas > 1 {
+			allErrs = append(allErrs, field.Forbidden(archivePath, "continuous archiving requires topology.replicas to be at most 1"))
+		}
+		// 数据库 Pod 一直挂载实例专属的备份卷，定时备份的 Pod 可能被调度到其他节点
+		if spec.Backup.Enabled && target.ClaimName != "" && !target.Shared && !readWriteMany(spec.Backup.Destination) {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "backup", "destination", "pvc", "accessModes"),
+				"scheduled backups and continuous archiving both mount the backup volume; use ReadWriteMany or an object storage destination"))
+		}
+	}
+
+	bootstrap := dbInstance.Spec.Bootstrap
+	if bootstrap == nil || bootstrap.PointInTime == nil {
+		return allErrs
+	}
+	pointInTime := bootstrap.PointInTime
+	if !supported {
+		return append(allErrs, field.Forbidden(pointInTimePath, "point-in-time recovery is not supported for "+eng.Name()))
+	}
+	if _, err := archiver.RecoveryFunctions(helpers.RecoveryTargetOf(pointInTime), ""); err != nil {
+		path := pointInTimePath
+		switch {
+		case pointInTime.TargetGTID != "":
+			path = path.Child("targetGTID")
+		case pointInTime.TargetLSN != "":
+			path = path.Child("targetLSN")
+		}
+		allErrs = append(allErrs, field.Invalid(path, pointInTime, err.Error()))
+	}
+	if target.ClaimName != "" && !target.Shared {
+		allErrs = append(allErrs, field.Required(field.NewPath("spec", "backup", "destination"),
+			"point-in-time recovery reads the archive of "+pointInTime.SourceInstance+" from the backup destination; "+
+				"set destination.pvc.claimName or destination.s3 to the destination of the source instance"))
+	}
+	if spec.Backup.Archive != nil && pointInTime.SourceInstance == dbInstance.Name {
+		allErrs = append(allErrs, field.Invalid(pointInTimePath.Child("sourceInstance"), pointInTime.SourceInstance,
+			"must differ from the instance name while backup.archive is set, the new archive would be written into the source archive"))
+	}
+	return allErrs
+}
+
+// readWriteMany 判断 spec.backup.destination.pvc.accessModes 是否包含 ReadWriteMany
+func readWriteMany(destination *appsv2.BackupDestination) bool {
+	if destination == nil || destination.PVC == nil {
+		return false
+	}
+	for _, mode := range destination.PVC.AccessModes {
+		if mode == corev1.ReadWriteMany {
+			return true
+		}
+	}
+	return false
+}
+
+// warningsFor 返回不影响提交、但需要提醒用户的配置问题
+func warningsFor(dbInstance *appsv2.DatabaseInstance) admission.Warnings {
+	var warnings admission.Warnings
+	spec := dbInstance.Spec
+
+	if lost, err := appsv1.UnconvertibleFieldsOf(dbInstance); err == nil && lost.Retention != "" {
+		warnings = append(warnings, fmt.Sprintf("spec.backupPolicy.retention %q cannot be parsed and has no effect; "+
+			"use a number of backups (7), days (7d) or a duration (168h), or spec.backup.retention in apps.leqiutong.xyz/v2", lost.Retention))
+	}
+	if spec.Backup.Verify != nil && !spec.Backup.Enabled {
+		warnings = append(warnings, "spec.backup.verify has no effect while scheduled backups are disabled")
+	}
+	if spec.Backup.Archive != nil && helpers.BackupTargetOf(dbInstance).Shared {
+		warnings = append(warnings, "the database pod mounts the shared backup volume for continuous archiving; "+
+			"it must support ReadWriteMany if other instances or backup jobs use it")
+	}
+	if spec.Topology.Replication != nil || spec.Topology.Primary != "" {
+		if eng, err := engine.Get(string(spec.Engine.Type)); err == nil && !helpers.ReplicationEnabled(dbInstance, eng) {
+			warnings = append(warnings, "spec.topology.replication and spec.topology.primary have no effect unless spec.topology.replicas "+
+				"is greater than 1 and "+eng.Name()+" supports replication")
+		}
+	}
+	return warnings
+}
+
+// usesBackupImage 判断实例的定时备份、备份验证、导入或最终备份是否会以备份镜像运行
+func usesBackupImage(spec appsv2.DatabaseInstanceSpec) bool {
+	return spec.Backup.Enabled || spec.Backup.Verify != nil ||
+		(spec.Bootstrap != nil && spec.Bootstrap.FromBackup != nil) ||
+		spec.DeletionPolicy == appsv2.DeletionPolicySnapshot
+}
+
+// toInvalid 将字段错误列表转换为 API Server 可以直接返回给用户的 Invalid 错误
+func toInvalid(dbInstance *appsv2.DatabaseInstance, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(appsv2.GroupVersion.WithKind("DatabaseInstance").GroupKind(), dbInstance.Name, allErrs)
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	appsv1 "github.com/cmjzzx/k8s-database-operator/api/v1"
+	appsv2 "github.com/cmjzzx/k8s-database-operator/api/v2"
+)
+
+var _ = Describe("DatabaseInstance Webhook", func() {
+	var (
+		obj       *appsv2.DatabaseInstance
+		oldObj    *appsv2.DatabaseInstance
+		validator DatabaseInstanceCustomValidator
+		defaulter DatabaseInstanceCustomDefaulter
+	)
+
+	BeforeEach(func() {
+		obj = &appsv2.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-sample", Namespace: "default"},
+			Spec: appsv2.DatabaseInstanceSpec{
+				Engine:  appsv2.EngineSpec{Type: appsv2.EngineMySQL},
+				Storage: appsv2.StorageSpec{Size: resource.NewQuantity(10<<30, resource.BinarySI)},
+			},
+		}
+		oldObj = obj.DeepCopy()
+		validator = DatabaseInstanceCustomValidator{}
+		defaulter = DatabaseInstanceCustomDefaulter{}
+	})
+
+	Context("When creating DatabaseInstance under Defaulting Webhook", func() {
+		It("Should fill in version, image, replicas and backup image for the engine", func() {
+			obj.Spec.Backup.Enabled = true
+			obj.Spec.Backup.Schedule = "0 2 * * *"
+
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Engine.Version).To(Equal("8.0"))
+			Expect(obj.Spec.Engine.Image).To(Equal("mysql:8.0"))
+			Expect(obj.Spec.Topology.Replicas).To(Equal(int32(1)))
+			Expect(obj.Spec.Backup.Image).To(Equal("registry.leqiutong.xyz/middleware/mysql:8.0"))
+		})
+
+		It("Should leave the backup image of oceanbase-ce to the user", func() {
+			obj.Spec.Engine.Type = appsv2.EngineOceanBase
+			obj.Spec.Backup.Enabled = true
+			obj.Spec.Backup.Schedule = "0 2 * * *"
+
+			// 数据库镜像中没有 obdumper 和 obloader，不能按数据库镜像生成备份镜像
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Engine.Version).To(Equal("4.2.1"))
+			Expect(obj.Spec.Engine.Image).To(Equal("oceanbase-ce:4.2.1"))
+			Expect(obj.Spec.Backup.Image).To(BeEmpty())
+
+			obj.Spec.Backup.Image = "registry.example.com/oceanbase-tools:4.2"
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Backup.Image).To(Equal("registry.example.com/oceanbase-tools:4.2"))
+		})
+
+		It("Should keep values that are already set", func() {
+			obj.Spec.Engine.Version = "8.4"
+			obj.Spec.Engine.Image = "custom/mysql:8.4"
+			obj.Spec.Topology.Replicas = 3
+
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Engine.Version).To(Equal("8.4"))
+			Expect(obj.Spec.Engine.Image).To(Equal("custom/mysql:8.4"))
+			Expect(obj.Spec.Topology.Replicas).To(Equal(int32(3)))
+		})
+	})
+
+	Context("When creating or updating DatabaseInstance under Validating Webhook", func() {
+		It("Should deny creation with an unsupported engine type", func() {
+			obj.Spec.Engine.Type = "sqlite"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.engine.type")))
+		})
+
+		It("Should deny creation with a non-positive storage size", func() {
+			obj.Spec.Storage.Size = resource.NewQuantity(0, resource.BinarySI)
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.storage.size")))
+		})
+
+		It("Should deny creation with an unparsable storage submitted through v1", func() {
+			obj.Annotations = map[string]string{appsv1.V1SpecAnnotation: `{"storage":"ten gigabytes"}`}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("ten gigabytes")))
+		})
+
+		It("Should deny creation with requests exceeding limits", func() {
+			obj.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")}
+			obj.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.resources")))
+		})
+
+		It("Should deny creation with an invalid backup schedule", func() {
+			obj.Spec.Backup.Enabled = true
+			obj.Spec.Backup.Schedule = "every night"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.backup.schedule")))
+		})
+
+		It("Should deny creation with negative replicas", func() {
+			obj.Spec.Topology.Replicas = -1
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replicas")))
+		})
+
+		It("Should require a backup image with the OceanBase tools for oceanbase-ce backups", func() {
+			obj.Spec.Engine.Type = appsv2.EngineOceanBase
+			obj.Spec.Backup.Enabled = true
+			obj.Spec.Backup.Schedule = "0 2 * * *"
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Backup.Image).To(BeEmpty())
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.backup.image")))
+
+			By("accepting an object storage destination and verification once the image is set")
+			obj.Spec.Backup.Image = "registry.example.com/oceanbase-tools:4.2"
+			obj.Spec.Backup.Destination = &appsv2.BackupDestination{S3: &appsv2.S3Destination{
+				Endpoint:             "http://minio.minio.svc:9000",
+				Bucket:               "backups",
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: "minio-credentials"},
+			}}
+			obj.Spec.Backup.Verify = &appsv2.BackupVerification{Schedule: "0 6 * * 0"}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should deny an invalid backup verification schedule", func() {
+			obj.Spec.Backup.Enabled = true
+			obj.Spec.Backup.Schedule = "0 2 * * *"
+			obj.Spec.Backup.Verify = &appsv2.BackupVerification{Schedule: "weekly please"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.backup.verify.schedule")))
+		})
+
+		It("Should deny continuous archiving for oceanbase-ce and for multiple replicas", func() {
+			obj.Spec.Backup.Archive = &appsv2.BackupArchive{}
+			obj.Spec.Topology.Replicas = 3
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("topology.replicas to be at most 1")))
+
+			obj.Spec.Engine.Type = appsv2.EngineOceanBase
+			obj.Spec.Topology.Replicas = 1
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("continuous archiving is not supported for oceanbase-ce")))
+		})
+
+		It("Should deny a switch interval that is too short", func() {
+			obj.Spec.Backup.Archive = &appsv2.BackupArchive{SwitchInterval: &metav1.Duration{Duration: time.Second}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.backup.archive")))
+		})
+
+		It("Should deny recovery targets the engine does not support", func() {
+			obj.Spec.Backup.Destination = &appsv2.BackupDestination{PVC: &appsv2.PVCDestination{ClaimName: "shared-backups"}}
+			obj.Spec.Bootstrap = &appsv2.BootstrapSpec{PointInTime: &appsv2.PointInTimeSource{SourceInstance: "original", TargetLSN: "0/3000060"}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.bootstrap.pointInTime.targetLSN")))
+
+			obj.Spec.Bootstrap.PointInTime = &appsv2.PointInTimeSource{SourceInstance: "original", TargetGTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should require a destination shared with the source instance for point-in-time recovery", func() {
+			obj.Spec.Bootstrap = &appsv2.BootstrapSpec{PointInTime: &appsv2.PointInTimeSource{SourceInstance: "original"}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.backup.destination")))
+		})
+
+		It("Should warn about backup verification without scheduled backups", func() {
+			obj.Spec.Backup.Verify = &appsv2.BackupVerification{Schedule: "0 6 * * 0"}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring("spec.backup.verify")))
+		})
+
+		It("Should warn about replication settings on a single replica", func() {
+			obj.Spec.Topology.Replication = &appsv2.ReplicationSpec{SemiSync: &appsv2.SemiSyncSpec{}}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring("spec.topology.replication")))
+
+			obj.Spec.Topology.Replicas = 3
+			warnings, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should deny replication settings of another engine", func() {
+			obj.Spec.Topology.Replicas = 3
+			obj.Spec.Topology.Replication = &appsv2.ReplicationSpec{SynchronousStandbyNames: "ANY 1 (*)"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replication.synchronousStandbyNames")))
+
+			obj.Spec.Engine.Type = appsv2.EnginePostgres
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			obj.Spec.Topology.Replication.SemiSync = &appsv2.SemiSyncSpec{}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replication.semiSync")))
+		})
+
+		It("Should only admit a desired primary that is one of the instance's pods", func() {
+			obj.Spec.Topology.Replicas = 3
+			obj.Spec.Topology.Primary = "webhook-sample-2"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, primary := range []string{"webhook-sample-3", "webhook-sample-02", "other-1"} {
+				obj.Spec.Topology.Primary = primary
+				_, err = validator.ValidateCreate(ctx, obj)
+				Expect(err).To(MatchError(ContainSubstring("spec.topology.primary")))
+			}
+		})
+
+		It("Should deny a failover delay that is not positive", func() {
+			obj.Spec.Topology.Replicas = 3
+			obj.Spec.Topology.Replication = &appsv2.ReplicationSpec{FailoverDelay: &metav1.Duration{}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replication.failoverDelay")))
+		})
+
+		It("Should deny credential rotation together with a user-provided Secret", func() {
+			obj.Spec.Credentials.Rotation = &appsv2.CredentialRotation{Interval: &metav1.Duration{Duration: 2160 * time.Hour}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			obj.Spec.Credentials.ExistingSecretRef = &appsv2.ExistingSecretRef{Name: "my-credentials"}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.credentials.rotation")))
+			Expect(err).To(MatchError(ContainSubstring("existingSecretRef")))
+		})
+
+		It("Should admit creation with a valid spec and retention without warnings", func() {
+			obj.Spec.Backup.Retention = &appsv2.BackupRetention{KeepLast: ptr.To(int32(7)), KeepDaily: ptr.To(int32(7))}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should warn about an unparsable v1 retention", func() {
+			obj.Annotations = map[string]string{appsv1.V1SpecAnnotation: `{"retention":"one week"}`}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(ContainSubstring("spec.backupPolicy.retention")))
+		})
+
+		It("Should deny changing the engine type", func() {
+			obj.Spec.Engine.Type = appsv2.EnginePostgres
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("engine.type is immutable")))
+		})
+
+		It("Should deny adding a bootstrap source to an existing instance", func() {
+			obj.Spec.Bootstrap = &appsv2.BootstrapSpec{FromBackup: &appsv2.BackupSource{Path: "db-backup.sql"}}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("bootstrap is immutable")))
+		})
+
+		It("Should deny scaling down below the current primary after a failover", func() {
+			oldObj.Spec.Topology.Replicas = 3
+			oldObj.Status.CurrentPrimary = "webhook-sample-2"
+			obj.Spec.Topology.Replicas = 2
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replicas")))
+			Expect(err).To(MatchError(ContainSubstring("webhook-sample-2")))
+
+			// 指定新的主库不会立即生效，切换完成之前仍然不能缩容
+			obj.Spec.Topology.Primary = "webhook-sample-0"
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.topology.replicas")))
+
+			By("Allowing the scale-down once the switchover has completed")
+			oldObj.Status.CurrentPrimary = "webhook-sample-0"
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Allowing the whole instance to be stopped")
+			oldObj.Status.CurrentPrimary = "webhook-sample-2"
+			obj.Spec.Topology.Primary = ""
+			obj.Spec.Topology.Replicas = 0
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny shrinking the storage but allow growing it", func() {
+			obj.Spec.Storage.Size = resource.NewQuantity(5<<30, resource.BinarySI)
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("cannot be shrunk")))
+
+			obj.Spec.Storage.Size = resource.NewQuantity(20<<30, resource.BinarySI)
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})